@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+var (
+	checkLineRe    = regexp.MustCompile(`line (\d+)`)
+	checkTargetRe  = regexp.MustCompile(`(?:target|record:) (\S+)`)
+	checkIncludeRe = regexp.MustCompile(`^include (\S+): `)
+	checkOwnersRe  = regexp.MustCompile(`is defined in (\S+) and (\S+)$`)
+)
+
+// runConfigCheck runs the reload parsing and validation of the configuration and resolves the target hosts without starting any probe
+// The errors are printed with the lines of the configuration they refer to, the exit code is 1 on failure
+func runConfigCheck(resolve bool) int {
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Config check failed: %s\n", err)
+		printContext(err.Error())
+		return 1
+	}
+
+	failed := 0
+	if resolve {
+		resolver = getResolver()
+		for _, t := range sc.Cfg.Targets {
+			host := checkHost(t.Type, t.Host, t.DNSServer)
+			if host == "" || net.ParseIP(host) != nil {
+				continue
+			}
+			if _, err := common.DestAddrsMode(context.Background(), host, t.Resolve, resolver.Resolver, resolver.HostsFile, resolver.Breaker, resolver.Timeout); err != nil {
+				msg := fmt.Sprintf("target %s host %s doesn't resolve: %s", t.Name, host, err)
+				fmt.Fprintf(os.Stderr, "Config check failed: %s\n", msg)
+				printContext(msg)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "Config check failed: %d of %d targets don't resolve\n", failed, len(sc.Cfg.Targets))
+		return 1
+	}
+
+	fmt.Printf("Config OK: %d targets\n", len(sc.Cfg.Targets))
+	return 0
+}
+
+// checkHost returns the name resolved by the probes of a target, the DNS targets query their host through the dns-server
+func checkHost(typ string, host string, dnsServer string) string {
+	switch typ {
+	case "DNS":
+		host = dnsServer
+	case "HTTPGet":
+		u, err := url.Parse(host)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// printContext prints the lines of the configuration an error refers to, by line number or by target name
+// The lines are looked up in the files named by the error (include, duplicated records), the main configuration otherwise
+func printContext(msg string) {
+	files := []string{*configFile}
+	if m := checkOwnersRe.FindStringSubmatch(msg); m != nil {
+		files = m[1:]
+	} else if m := checkIncludeRe.FindStringSubmatch(msg); m != nil {
+		files = m[1:]
+	}
+	for _, f := range files {
+		printFileContext(f, msg)
+	}
+}
+
+// printFileContext prints the lines of a configuration file matching the line numbers or the target name of the error
+func printFileContext(file string, msg string) {
+	var b []byte
+	if *configEnv != "" && file == *configFile {
+		b = []byte(os.Getenv(*configEnv))
+	} else {
+		var err error
+		if b, err = os.ReadFile(file); err != nil {
+			return
+		}
+	}
+	lines := strings.Split(string(b), "\n")
+
+	found := []int{}
+	for _, m := range checkLineRe.FindAllStringSubmatch(msg, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > 0 && n <= len(lines) {
+			found = append(found, n)
+		}
+	}
+	if len(found) == 0 {
+		if m := checkTargetRe.FindStringSubmatch(msg); m != nil {
+			nameRe := regexp.MustCompile(`^\s*-?\s*name:\s*["']?` + regexp.QuoteMeta(m[1]) + `["']?\s*(#.*)?$`)
+			for i, l := range lines {
+				if nameRe.MatchString(l) {
+					found = append(found, i+1)
+				}
+			}
+		}
+	}
+
+	for _, n := range found {
+		fmt.Fprintf(os.Stderr, "  %s:\n", file)
+		for i := n - 2; i <= n+1; i++ {
+			if i < 1 || i > len(lines) {
+				continue
+			}
+			marker := " "
+			if i == n {
+				marker = ">"
+			}
+			fmt.Fprintf(os.Stderr, "  %s %4d | %s\n", marker, i, lines[i-1])
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/pkg/arp"
+)
+
+var (
+	arpLabelNames  = []string{"name", "target", "target_ip", "source_ip", "device"}
+	arpRttDesc     = prometheus.NewDesc("arp_rtt_seconds", "ARP/NDP reply time in seconds", arpLabelNames, nil)
+	arpStatusDesc  = prometheus.NewDesc("arp_status", "Presence of the target on the local segment (ARP reply or NDP advertisement received)", arpLabelNames, nil)
+	arpTargetsDesc = prometheus.NewDesc("arp_targets", "Number of active targets", nil, nil)
+	arpStateDesc   = prometheus.NewDesc("arp_up", "Exporter state", nil, nil)
+	arpMutex       = &sync.Mutex{}
+)
+
+// ARP prom
+type ARP struct {
+	SC      *config.SafeConfig
+	Monitor *monitor.ARP
+	metrics map[string]*arp.ARPReturn
+	labels  map[string]map[string]string
+}
+
+// Describe prom
+func (p *ARP) Describe(ch chan<- *prometheus.Desc) {
+	ch <- arpRttDesc
+	ch <- arpStatusDesc
+	ch <- arpTargetsDesc
+	ch <- arpStateDesc
+}
+
+// Collect prom
+func (p *ARP) Collect(ch chan<- prometheus.Metric) {
+	arpMutex.Lock()
+	defer arpMutex.Unlock()
+
+	p.metrics = p.Monitor.ExportMetrics()
+	p.labels = p.Monitor.ExportLabels()
+
+	if len(p.metrics) > 0 {
+		ch <- prometheus.MustNewConstMetric(arpStateDesc, prometheus.GaugeValue, 1)
+	} else {
+		ch <- prometheus.MustNewConstMetric(arpStateDesc, prometheus.GaugeValue, 0)
+	}
+
+	p.SC.RLock()
+	allow := p.SC.Cfg.ARP.Labels
+	p.SC.RUnlock()
+
+	targets := []string{}
+	for target, metric := range p.metrics {
+		targets = append(targets, target)
+		names, l, l2 := filterLabels(allow, identityLabels, arpLabelNames, []string{target, metric.DestAddr, metric.DestIp, metric.SrcIp, metric.Device}, p.labels[target])
+
+		arpRttDesc = prometheus.NewDesc("arp_rtt_seconds", "ARP/NDP reply time in seconds", names, l2)
+		arpStatusDesc = prometheus.NewDesc("arp_status", "Presence of the target on the local segment (ARP reply or NDP advertisement received)", names, l2)
+
+		ch <- prometheus.MustNewConstMetric(arpRttDesc, prometheus.GaugeValue, metric.Rtt.Seconds(), l...)
+		ch <- prometheus.MustNewConstMetric(arpStatusDesc, prometheus.GaugeValue, bool2Float(metric.Success), l...)
+	}
+	ch <- prometheus.MustNewConstMetric(arpTargetsDesc, prometheus.GaugeValue, float64(len(targets)))
+}
@@ -0,0 +1,205 @@
+package collector
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/monitor"
+)
+
+// bool2Float converts a boolean state into a gauge value
+func bool2Float(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unixTime converts a timestamp into seconds since epoch (0 if unset)
+func unixTime(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// identityLabels Labels telling apart the series of the addresses of a target name, always kept by the allowlists
+var identityLabels = []string{"name", "target_ip", "ip_version"}
+
+// filterLabels keeps the required labels and the optional/target labels present in the allowlist (nil keeps all)
+func filterLabels(allow []string, required []string, names []string, values []string, labels map[string]string) ([]string, []string, prometheus.Labels) {
+	if allow == nil {
+		return names, values, prometheus.Labels(labels)
+	}
+
+	keep := map[string]bool{}
+	for _, k := range required {
+		keep[k] = true
+	}
+	for _, k := range allow {
+		keep[k] = true
+	}
+
+	n := []string{}
+	v := []string{}
+	for i, k := range names {
+		if keep[k] {
+			n = append(n, k)
+			v = append(v, values[i])
+		}
+	}
+	l := prometheus.Labels{}
+	for k, val := range labels {
+		if keep[k] {
+			l[k] = val
+		}
+	}
+	// Clipped so the appends of the callers never share the backing arrays
+	return n[:len(n):len(n)], v[:len(v):len(v)], l
+}
+
+// point probe result of a target shared by the line outputs (InfluxDB, Graphite)
+type point struct {
+	measurement string
+	tags        map[string]string
+	labels      map[string]string
+	fields      []pointField
+}
+
+type pointField struct {
+	key   string
+	value float64
+}
+
+// probePoints flattens the current probe results of the monitors
+func probePoints(ping *monitor.PING, mtr *monitor.MTR, tcp *monitor.TCPPort, udp *monitor.UDP, httpGet *monitor.HTTPGet, arp *monitor.ARP, owd *monitor.OWD, dns *monitor.DNS) []point {
+	points := []point{}
+
+	// The ICMP/MTR/TCP/UDP target keys are "name ip", the ip is set before the first result
+
+	labels := ping.ExportLabels()
+	for target, metric := range ping.ExportMetrics() {
+		l := strings.SplitN(target, " ", 2)
+		tags := map[string]string{"name": l[0], "target": metric.DestAddr, "target_ip": l[len(l)-1], "type": "ICMP"}
+		fields := []pointField{
+			{"status", bool2Float(metric.Success)},
+			{"rtt_best_seconds", metric.BestTime.Seconds()},
+			{"rtt_mean_seconds", metric.AvgTime.Seconds()},
+			{"rtt_worst_seconds", metric.WorstTime.Seconds()},
+			{"rtt_sd_seconds", metric.SquaredDeviationTime.Seconds()},
+			{"rtt_usd_seconds", metric.UncorrectedSDTime.Seconds()},
+			{"rtt_csd_seconds", metric.CorrectedSDTime.Seconds()},
+			{"rtt_range_seconds", metric.RangeTime.Seconds()},
+			{"snt_count", float64(metric.SntSummary)},
+			{"snt_fail_count", float64(metric.SntFailSummary)},
+			{"loss_percent", metric.DropRate},
+			{"jitter_seconds", metric.Jitter.Seconds()},
+		}
+		if metric.Burst {
+			fields = append(fields, pointField{"out_of_order_packets", float64(metric.OutOfOrder)})
+		}
+		if metric.PathMTU > 0 {
+			fields = append(fields, pointField{"path_mtu_bytes", float64(metric.PathMTU)})
+		}
+		points = append(points, point{"ping", tags, labels[target], fields})
+	}
+
+	labels = mtr.ExportLabels()
+	for target, metric := range mtr.ExportMetrics() {
+		name := strings.SplitN(target, " ", 2)[0]
+		points = append(points, point{"mtr", map[string]string{"name": name, "target": metric.DestAddr, "protocol": metric.Protocol, "type": "MTR"}, labels[target], []pointField{
+			{"hops", float64(len(metric.Hops))},
+		}})
+		for _, hop := range metric.Hops {
+			tags := map[string]string{"name": name, "target": metric.DestAddr, "protocol": metric.Protocol, "type": "MTR", "ttl": strconv.Itoa(hop.TTL), "path": hop.AddressTo}
+			points = append(points, point{"mtr_hop", tags, labels[target], []pointField{
+				{"rtt_last_seconds", hop.LastTime.Seconds()},
+				{"rtt_best_seconds", hop.BestTime.Seconds()},
+				{"rtt_mean_seconds", hop.AvgTime.Seconds()},
+				{"rtt_worst_seconds", hop.WorstTime.Seconds()},
+				{"rtt_sd_seconds", hop.SquaredDeviationTime.Seconds()},
+				{"loss_percent", hop.Loss},
+			}})
+		}
+	}
+
+	labels = tcp.ExportLabels()
+	for target, metric := range tcp.ExportMetrics() {
+		l := strings.SplitN(target, " ", 2)
+		tags := map[string]string{"name": l[0], "target": metric.DestAddr, "target_ip": metric.DestIp, "port": metric.DestPort, "type": "TCP"}
+		fields := []pointField{
+			{"status", bool2Float(metric.Success)},
+			{"connection_seconds", metric.ConTime.Seconds()},
+		}
+		if t := metric.TLS; t != nil {
+			fields = append(fields, pointField{"tls_handshake_seconds", t.Handshake.Seconds()}, pointField{"tls_verified", bool2Float(t.Verified)})
+			if !t.NotAfter.IsZero() {
+				fields = append(fields, pointField{"tls_cert_not_after", float64(t.NotAfter.Unix())})
+			}
+		}
+		points = append(points, point{"tcp", tags, labels[target], fields})
+	}
+
+	labels = udp.ExportLabels()
+	for target, metric := range udp.ExportMetrics() {
+		tags := map[string]string{"name": strings.SplitN(target, " ", 2)[0], "target": metric.DestAddr, "target_ip": metric.DestIp, "port": metric.DestPort, "type": "UDP"}
+		points = append(points, point{"udp", tags, labels[target], []pointField{
+			{"status", bool2Float(metric.Success)},
+			{"rtt_seconds", metric.Rtt.Seconds()},
+			{"response_bytes", float64(metric.ResponseBytes)},
+		}})
+	}
+
+	labels = httpGet.ExportLabels()
+	for target, metric := range httpGet.ExportMetrics() {
+		tags := map[string]string{"name": target, "target": metric.DestAddr, "type": "HTTPGet"}
+		points = append(points, point{"http_get", tags, labels[target], []pointField{
+			{"status", float64(metric.Status)},
+			{"success", bool2Float(metric.Success)},
+			{"content_bytes", float64(metric.ContentLength)},
+			{"dns_lookup_seconds", metric.DNSLookup.Seconds()},
+			{"tcp_connection_seconds", metric.TCPConnection.Seconds()},
+			{"tls_handshake_seconds", metric.TLSHandshake.Seconds()},
+			{"server_processing_seconds", metric.ServerProcessing.Seconds()},
+			{"content_transfer_seconds", metric.ContentTransfer.Seconds()},
+			{"total_seconds", metric.Total.Seconds()},
+		}})
+	}
+
+	labels = arp.ExportLabels()
+	for target, metric := range arp.ExportMetrics() {
+		tags := map[string]string{"name": target, "target": metric.DestAddr, "target_ip": metric.DestIp, "device": metric.Device, "type": "ARP"}
+		points = append(points, point{"arp", tags, labels[target], []pointField{
+			{"status", bool2Float(metric.Success)},
+			{"rtt_seconds", metric.Rtt.Seconds()},
+		}})
+	}
+
+	labels = owd.ExportLabels()
+	for target, metric := range owd.ExportMetrics() {
+		tags := map[string]string{"name": target, "target": metric.DestAddr, "target_ip": metric.DestIp, "port": metric.DestPort, "type": "OWD"}
+		points = append(points, point{"owd", tags, labels[target], []pointField{
+			{"status", bool2Float(metric.Success)},
+			{"loss_percent", metric.DropRate},
+			{"forward_seconds", metric.ForwardAvg.Seconds()},
+			{"reverse_seconds", metric.ReverseAvg.Seconds()},
+			{"rtt_seconds", metric.RttAvg.Seconds()},
+			{"clock_offset_seconds", metric.ClockOffset.Seconds()},
+		}})
+	}
+
+	labels = dns.ExportLabels()
+	for target, metric := range dns.ExportMetrics() {
+		tags := map[string]string{"name": target, "target": metric.DestAddr, "server": metric.Server, "record_type": metric.QType, "type": "DNS"}
+		points = append(points, point{"dns", tags, labels[target], []pointField{
+			{"status", bool2Float(metric.Success)},
+			{"query_seconds", metric.Rtt.Seconds()},
+			{"rcode", float64(metric.Rcode)},
+			{"answers", float64(metric.Answers)},
+		}})
+	}
+
+	return points
+}
@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/pkg/dns"
+)
+
+var (
+	dnsLabelNames  = []string{"name", "target", "server", "source_ip", "record_type"}
+	dnsStatusDesc  = prometheus.NewDesc("dns_status", "Query status (NOERROR with at least one answer)", dnsLabelNames, nil)
+	dnsRttDesc     = prometheus.NewDesc("dns_query_seconds", "Query time in seconds", dnsLabelNames, nil)
+	dnsRcodeDesc   = prometheus.NewDesc("dns_rcode", "Response code of the query (-1 without response)", dnsLabelNames, nil)
+	dnsAnswersDesc = prometheus.NewDesc("dns_answers", "Number of answer records of the queried type", dnsLabelNames, nil)
+	dnsTargetsDesc = prometheus.NewDesc("dns_targets", "Number of active targets", nil, nil)
+	dnsStateDesc   = prometheus.NewDesc("dns_up", "Exporter state", nil, nil)
+	dnsMutex       = &sync.Mutex{}
+)
+
+// DNS prom
+type DNS struct {
+	SC      *config.SafeConfig
+	Monitor *monitor.DNS
+	metrics map[string]*dns.DNSReturn
+	labels  map[string]map[string]string
+}
+
+// Describe prom
+func (p *DNS) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dnsStatusDesc
+	ch <- dnsRttDesc
+	ch <- dnsRcodeDesc
+	ch <- dnsAnswersDesc
+	ch <- dnsTargetsDesc
+	ch <- dnsStateDesc
+}
+
+// Collect prom
+func (p *DNS) Collect(ch chan<- prometheus.Metric) {
+	dnsMutex.Lock()
+	defer dnsMutex.Unlock()
+
+	p.metrics = p.Monitor.ExportMetrics()
+	p.labels = p.Monitor.ExportLabels()
+
+	if len(p.metrics) > 0 {
+		ch <- prometheus.MustNewConstMetric(dnsStateDesc, prometheus.GaugeValue, 1)
+	} else {
+		ch <- prometheus.MustNewConstMetric(dnsStateDesc, prometheus.GaugeValue, 0)
+	}
+
+	p.SC.RLock()
+	allow := p.SC.Cfg.DNS.Labels
+	p.SC.RUnlock()
+
+	targets := []string{}
+	for target, metric := range p.metrics {
+		targets = append(targets, target)
+		names, l, l2 := filterLabels(allow, identityLabels, dnsLabelNames, []string{target, metric.DestAddr, metric.Server, metric.SrcIp, metric.QType}, p.labels[target])
+
+		dnsStatusDesc = prometheus.NewDesc("dns_status", "Query status (NOERROR with at least one answer)", names, l2)
+		dnsRttDesc = prometheus.NewDesc("dns_query_seconds", "Query time in seconds", names, l2)
+		dnsRcodeDesc = prometheus.NewDesc("dns_rcode", "Response code of the query (-1 without response)", names, l2)
+		dnsAnswersDesc = prometheus.NewDesc("dns_answers", "Number of answer records of the queried type", names, l2)
+
+		ch <- prometheus.MustNewConstMetric(dnsStatusDesc, prometheus.GaugeValue, bool2Float(metric.Success), l...)
+		ch <- prometheus.MustNewConstMetric(dnsRttDesc, prometheus.GaugeValue, metric.Rtt.Seconds(), l...)
+		ch <- prometheus.MustNewConstMetric(dnsRcodeDesc, prometheus.GaugeValue, float64(metric.Rcode), l...)
+		ch <- prometheus.MustNewConstMetric(dnsAnswersDesc, prometheus.GaugeValue, float64(metric.Answers), l...)
+	}
+	ch <- prometheus.MustNewConstMetric(dnsTargetsDesc, prometheus.GaugeValue, float64(len(targets)))
+}
@@ -0,0 +1,367 @@
+package collector
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/owd"
+	"github.com/syepes/network_exporter/target"
+)
+
+var (
+	exporterLabelNames   = []string{"name", "target", "target_ip", "type"}
+	probeWaitDesc        = prometheus.NewDesc("network_exporter_probe_wait_seconds", "Time the last probe cycle waited for a free concurrency slot", exporterLabelNames, nil)
+	probeSkippedDesc     = prometheus.NewDesc("network_exporter_probe_overlap_skipped_total", "Probe cycles skipped because the previous cycle of the target was still running", exporterLabelNames, nil)
+	probeShedDesc        = prometheus.NewDesc("network_exporter_probe_load_shed_total", "Probe cycles of the target skipped by the load shedding", exporterLabelNames, nil)
+	consecFailDesc       = prometheus.NewDesc("network_exporter_target_consecutive_failures", "Consecutive failed probe cycles (reset on success)", exporterLabelNames, nil)
+	lastProbeDesc        = prometheus.NewDesc("network_exporter_target_last_probe_timestamp_seconds", "Timestamp of the start of the last probe cycle (0 if never probed)", exporterLabelNames, nil)
+	nextProbeDesc        = prometheus.NewDesc("network_exporter_target_next_probe_timestamp_seconds", "Timestamp the next probe cycle is due at (interval with jitter, startup delay)", exporterLabelNames, nil)
+	lastSuccessDesc      = prometheus.NewDesc("network_exporter_target_last_success_timestamp_seconds", "Timestamp of the last successful probe cycle (0 if never succeeded)", exporterLabelNames, nil)
+	resolvedIpDesc       = prometheus.NewDesc("network_exporter_target_resolved_ip", "Address the target host currently resolves to", exporterLabelNames, nil)
+	familyUnavailDesc    = prometheus.NewDesc("network_exporter_target_family_unavailable", "The address family of the target is not usable on this host (never probed)", exporterLabelNames, nil)
+	depSkippedDesc       = prometheus.NewDesc("network_exporter_target_skipped_dependency", "The last probe cycle was skipped because the depends-on target is down", append(exporterLabelNames, "depends_on"), nil)
+	suppressedDesc       = prometheus.NewDesc("network_exporter_probe_suppressed", "The last probe cycle was suppressed by a maintenance window of the target (disable_schedule)", exporterLabelNames, nil)
+	recheckDesc          = prometheus.NewDesc("network_exporter_probe_recheck", "The target is probed at the icmp.recheck-interval after a lossy cycle until the loss recovers (recheck-loss)", exporterLabelNames, nil)
+	replyDSCPDesc        = prometheus.NewDesc("network_exporter_icmp_reply_dscp", "DSCP of the last echo reply (targets with dscp, remarking detection)", []string{"name", "target", "target_ip"}, nil)
+	replyTTLDesc         = prometheus.NewDesc("network_exporter_icmp_reply_ttl", "TTL/hop limit of the last echo reply (targets with expected-hops)", []string{"name", "target", "target_ip"}, nil)
+	hopDeviationDesc     = prometheus.NewDesc("network_exporter_icmp_hop_deviation", "Hops derived from the reply TTL minus the expected-hops (0 when the route length is unchanged)", []string{"name", "target", "target_ip", "expected_hops"}, nil)
+	unexpectedSrcDesc    = prometheus.NewDesc("network_exporter_icmp_unexpected_source_total", "Echo replies discarded because they didn't come from the target address", []string{"name", "target", "target_ip"}, nil)
+	lastRttDesc          = prometheus.NewDesc("network_exporter_icmp_last_rtt_seconds", "Round Trip Time of the most recent echo of the last cycle (NaN when it was lost)", []string{"name", "target", "target_ip"}, nil)
+	effTimeoutDesc       = prometheus.NewDesc("network_exporter_icmp_effective_timeout_seconds", "Echo timeout of the last cycle of the targets with adaptive-timeout", []string{"name", "target", "target_ip"}, nil)
+	clockOffsetDesc      = prometheus.NewDesc("network_exporter_icmp_clock_offset_ms", "Clock offset of the target in milliseconds from the ICMP timestamp replies (icmp-mode: timestamp)", []string{"name", "target", "target_ip"}, nil)
+	tlsInfoLabelNames    = []string{"name", "target", "version", "cipher", "server_name"}
+	tlsInfoTCPLabelNames = []string{"name", "target", "version", "cipher", "server_name", "target_ip", "port"}
+	tlsInfoDesc          = prometheus.NewDesc("network_exporter_tls_info", "TLS version and cipher negotiated by the last probe", tlsInfoLabelNames, nil)
+	qualityDesc          = prometheus.NewDesc("network_exporter_path_quality_score", "Path quality score (0-100) of the last cycle weighting the loss, the latency vs slo-rtt and the jitter (conf.quality-score)", []string{"name", "target", "target_ip"}, nil)
+	sloRttDesc           = prometheus.NewDesc("network_exporter_target_slo_rtt_seconds", "Latency SLO configured for the target (slo-rtt)", []string{"name", "target", "type"}, nil)
+	breakerStateDesc     = prometheus.NewDesc("network_exporter_resolver_circuit_state", "State of the name resolution circuit breaker (0: closed, 1: open, 2: half-open)", nil, nil)
+	breakerRejectDesc    = prometheus.NewDesc("network_exporter_resolver_circuit_rejected_total", "Lookups short-circuited by the name resolution circuit breaker", nil, nil)
+	resolveErrDesc       = prometheus.NewDesc("network_exporter_resolve_errors_total", "Failed target resolutions by reason (not_found, no_address, cname_loop, timeout, circuit_open, error)", []string{"reason"}, nil)
+	resultsSentDesc      = prometheus.NewDesc("network_exporter_results_http_sent_total", "Probe results posted to --results.http-url", nil, nil)
+	resultsDropDesc      = prometheus.NewDesc("network_exporter_results_http_dropped_total", "Probe results dropped (queue full or failed post) by the --results.http-url sink", nil, nil)
+	resultsFailDesc      = prometheus.NewDesc("network_exporter_results_http_failures_total", "Failed posts of the probe results to --results.http-url", nil, nil)
+	resolveChangeDesc    = prometheus.NewDesc("network_exporter_resolve_changes_total", "Changes of the addresses a target host resolves to (the targets are re-created on the new addresses)", []string{"host"}, nil)
+	sendRateDesc         = prometheus.NewDesc("network_exporter_icmp_packets_per_second", "ICMP/MTR packets sent per second (average of the last 10s, paced by conf.max-pps)", nil, nil)
+	sentDesc             = prometheus.NewDesc("network_exporter_icmp_packets_sent_total", "ICMP/MTR packets sent", nil, nil)
+	loadShedDesc         = prometheus.NewDesc("network_exporter_load_shed_total", "Probe cycles skipped by the load shedding (conf.load-shed-lag)", nil, nil)
+	loadSheddingDesc     = prometheus.NewDesc("network_exporter_load_shedding", "The low priority probe cycles are currently shed", nil, nil)
+	selfLatencyDesc      = prometheus.NewDesc("network_exporter_self_latency_seconds", "Latency of the probe box itself (timer: oversleep of a 10ms timer, icmp: loopback echo RTT), spikes reflect CPU/scheduling pressure", []string{"probe"}, nil)
+	poolActiveDesc       = prometheus.NewDesc("network_exporter_pool_active_probes", "Probe cycles holding a slot of the concurrency pool (global: conf.max-concurrency)", []string{"pool"}, nil)
+	poolQueuedDesc       = prometheus.NewDesc("network_exporter_pool_queued_probes", "Probe cycles waiting for a slot of the concurrency pool (global: conf.max-concurrency)", []string{"pool"}, nil)
+	scheduleLagDesc      = prometheus.NewDesc("network_exporter_schedule_lag_seconds", "Averaged delay between the due time of the probe cycles and their start", nil, nil)
+	cfgTargetsDesc       = prometheus.NewDesc("network_exporter_config_targets", "Number of configured targets after filtering", nil, nil)
+	cfgMaxTargetsDesc    = prometheus.NewDesc("network_exporter_config_max_targets_exceeded", "The number of configured targets exceeds conf.max-targets", nil, nil)
+	cfgReloadDesc        = prometheus.NewDesc("network_exporter_config_reload_duration_seconds", "Duration of the last successful configuration reload", nil, nil)
+	cfgAddedDesc         = prometheus.NewDesc("network_exporter_config_targets_added", "Targets added by the configuration reloads", nil, nil)
+	cfgRemovedDesc       = prometheus.NewDesc("network_exporter_config_targets_removed", "Targets removed by the configuration reloads", nil, nil)
+	cfgSuccessDesc       = prometheus.NewDesc("network_exporter_config_last_reload_successful", "Whether the last configuration reload attempt was successful", nil, nil)
+	cfgSuccessTsDesc     = prometheus.NewDesc("network_exporter_config_last_reload_success_timestamp_seconds", "Timestamp of the last successful configuration reload", nil, nil)
+	discoveredDesc       = prometheus.NewDesc("network_exporter_discovered_targets", "Number of targets found by the service discovery (before filtering)", []string{"source"}, nil)
+	discoveryErrDesc     = prometheus.NewDesc("network_exporter_discovery_errors_total", "Failed refreshes of the service discovery", []string{"source"}, nil)
+	owdResponderDesc     = prometheus.NewDesc("network_exporter_owd_responder_requests_total", "One-way delay probes of the peers answered by the responder (owd.listen)", nil, nil)
+	exporterMutex        = &sync.Mutex{}
+)
+
+// Exporter prom (probe scheduling metrics shared by all the probe types)
+type Exporter struct {
+	SC       *config.SafeConfig
+	PING     *monitor.PING
+	MTR      *monitor.MTR
+	TCP      *monitor.TCPPort
+	UDP      *monitor.UDP
+	HTTPGet  *monitor.HTTPGet
+	ARP      *monitor.ARP
+	OWD      *monitor.OWD
+	DNS      *monitor.DNS
+	Breaker  *common.Breaker
+	Resolver *config.Resolver
+	Results  *common.HTTPSink
+
+	RateLimiter *common.RateLimiter
+	LoadShedder *common.LoadShedder
+	Self        *target.Self
+	Sem         *common.Semaphore
+	Pools       *common.Pools
+	Responder   *owd.Responder
+}
+
+// Describe prom
+func (p *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- probeWaitDesc
+	ch <- probeSkippedDesc
+	ch <- probeShedDesc
+	ch <- lastProbeDesc
+	ch <- nextProbeDesc
+	ch <- lastSuccessDesc
+	ch <- consecFailDesc
+	ch <- resolvedIpDesc
+	ch <- depSkippedDesc
+	ch <- suppressedDesc
+	ch <- recheckDesc
+	ch <- familyUnavailDesc
+	ch <- replyDSCPDesc
+	ch <- replyTTLDesc
+	ch <- hopDeviationDesc
+	ch <- clockOffsetDesc
+	ch <- effTimeoutDesc
+	ch <- lastRttDesc
+	ch <- unexpectedSrcDesc
+	ch <- tlsInfoDesc
+	ch <- sloRttDesc
+	ch <- breakerStateDesc
+	ch <- breakerRejectDesc
+	ch <- resolveErrDesc
+	ch <- resolveChangeDesc
+	ch <- resultsSentDesc
+	ch <- resultsDropDesc
+	ch <- resultsFailDesc
+	ch <- sendRateDesc
+	ch <- sentDesc
+	ch <- loadShedDesc
+	ch <- loadSheddingDesc
+	ch <- scheduleLagDesc
+	ch <- selfLatencyDesc
+	ch <- qualityDesc
+	ch <- poolActiveDesc
+	ch <- poolQueuedDesc
+	ch <- cfgTargetsDesc
+	ch <- cfgMaxTargetsDesc
+	ch <- cfgReloadDesc
+	ch <- cfgAddedDesc
+	ch <- cfgRemovedDesc
+	ch <- cfgSuccessDesc
+	ch <- cfgSuccessTsDesc
+	ch <- discoveredDesc
+	ch <- discoveryErrDesc
+	ch <- owdResponderDesc
+}
+
+// Collect prom
+func (p *Exporter) Collect(ch chan<- prometheus.Metric) {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+
+	p.SC.RLock()
+	cfg := p.SC.Cfg
+	p.SC.RUnlock()
+	ch <- prometheus.MustNewConstMetric(cfgTargetsDesc, prometheus.GaugeValue, float64(len(cfg.Targets)))
+	ch <- prometheus.MustNewConstMetric(cfgMaxTargetsDesc, prometheus.GaugeValue, bool2Float(cfg.MaxTargetsExceeded()))
+	reload, added, removed := p.SC.ReloadStats()
+	ch <- prometheus.MustNewConstMetric(cfgReloadDesc, prometheus.GaugeValue, reload.Seconds())
+	ch <- prometheus.MustNewConstMetric(cfgAddedDesc, prometheus.CounterValue, float64(added))
+	ch <- prometheus.MustNewConstMetric(cfgRemovedDesc, prometheus.CounterValue, float64(removed))
+	success, last := p.SC.ReloadStatus()
+	ch <- prometheus.MustNewConstMetric(cfgSuccessDesc, prometheus.GaugeValue, bool2Float(success))
+	ch <- prometheus.MustNewConstMetric(cfgSuccessTsDesc, prometheus.GaugeValue, unixTime(last))
+	discovered, failures := p.SC.DiscoveryStats()
+	for source, n := range discovered {
+		ch <- prometheus.MustNewConstMetric(discoveredDesc, prometheus.GaugeValue, float64(n), source)
+	}
+	for source, n := range failures {
+		ch <- prometheus.MustNewConstMetric(discoveryErrDesc, prometheus.CounterValue, float64(n), source)
+	}
+	ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, float64(p.Breaker.State()))
+	ch <- prometheus.MustNewConstMetric(breakerRejectDesc, prometheus.CounterValue, float64(p.Breaker.Rejected()))
+	for reason, n := range common.ResolveErrors() {
+		ch <- prometheus.MustNewConstMetric(resolveErrDesc, prometheus.CounterValue, float64(n), reason)
+	}
+	for host, n := range p.Resolver.ResolveChanges() {
+		ch <- prometheus.MustNewConstMetric(resolveChangeDesc, prometheus.CounterValue, float64(n), host)
+	}
+	if p.Results != nil {
+		sent, dropped, failed := p.Results.Stats()
+		ch <- prometheus.MustNewConstMetric(resultsSentDesc, prometheus.CounterValue, float64(sent))
+		ch <- prometheus.MustNewConstMetric(resultsDropDesc, prometheus.CounterValue, float64(dropped))
+		ch <- prometheus.MustNewConstMetric(resultsFailDesc, prometheus.CounterValue, float64(failed))
+	}
+	ch <- prometheus.MustNewConstMetric(sendRateDesc, prometheus.GaugeValue, p.RateLimiter.Rate())
+	ch <- prometheus.MustNewConstMetric(sentDesc, prometheus.CounterValue, float64(p.RateLimiter.Sent()))
+	ch <- prometheus.MustNewConstMetric(loadShedDesc, prometheus.CounterValue, float64(p.LoadShedder.ShedTotal()))
+	ch <- prometheus.MustNewConstMetric(loadSheddingDesc, prometheus.GaugeValue, bool2Float(p.LoadShedder.Shedding()))
+	ch <- prometheus.MustNewConstMetric(scheduleLagDesc, prometheus.GaugeValue, p.LoadShedder.Lag().Seconds())
+	if p.Sem != nil {
+		ch <- prometheus.MustNewConstMetric(poolActiveDesc, prometheus.GaugeValue, float64(p.Sem.Active()), "global")
+		ch <- prometheus.MustNewConstMetric(poolQueuedDesc, prometheus.GaugeValue, float64(p.Sem.Queued()), "global")
+	}
+	for _, name := range p.Pools.Names() {
+		pool := p.Pools.Get(name, "")
+		ch <- prometheus.MustNewConstMetric(poolActiveDesc, prometheus.GaugeValue, float64(pool.Active()), name)
+		ch <- prometheus.MustNewConstMetric(poolQueuedDesc, prometheus.GaugeValue, float64(pool.Queued()), name)
+	}
+	if p.Responder != nil {
+		ch <- prometheus.MustNewConstMetric(owdResponderDesc, prometheus.CounterValue, float64(p.Responder.Requests()))
+	}
+	if self := p.Self.Compute(); self != nil {
+		ch <- prometheus.MustNewConstMetric(selfLatencyDesc, prometheus.GaugeValue, self.Timer.Seconds(), "timer")
+		if self.ICMPValid {
+			ch <- prometheus.MustNewConstMetric(selfLatencyDesc, prometheus.GaugeValue, self.ICMP.Seconds(), "icmp")
+		}
+	}
+
+	// The per-target series carry the target labels, restricted by the labels allowlist of the protocol (their own labels are always kept)
+	allow := map[string][]string{"ICMP": cfg.ICMP.Labels, "ICMP+MTR": cfg.ICMP.Labels, "MTR": cfg.MTR.Labels, "TCP": cfg.TCP.Labels, "UDP": cfg.UDP.Labels, "HTTPGet": cfg.HTTPGet.Labels, "ARP": cfg.ARP.Labels, "OWD": cfg.OWD.Labels, "DNS": cfg.DNS.Labels}
+
+	slo := map[string]bool{}
+	for _, t := range cfg.Targets {
+		if k := t.Name + " " + t.Host + " " + t.Type; t.SloRtt.Duration() > 0 && !slo[k] {
+			slo[k] = true
+			names, l, l2 := filterLabels(allow[t.Type], []string{"name", "target", "type"}, []string{"name", "target", "type"}, []string{t.Name, t.Host, t.Type}, t.Labels.Kv)
+			sloRttDesc = prometheus.NewDesc("network_exporter_target_slo_rtt_seconds", "Latency SLO configured for the target (slo-rtt)", names, l2)
+			ch <- prometheus.MustNewConstMetric(sloRttDesc, prometheus.GaugeValue, t.SloRtt.Duration().Seconds(), l...)
+		}
+	}
+
+	// Configured host per target name (the MTR targets only keep the resolved address)
+	mtrHosts := map[string]string{}
+	icmpSlo := map[string]time.Duration{}
+	for _, t := range cfg.Targets {
+		if t.Type == "MTR" || t.Type == "ICMP+MTR" {
+			mtrHosts[t.Name] = t.Host
+		}
+		if t.Type == "ICMP" || t.Type == "ICMP+MTR" {
+			icmpSlo[t.Name] = t.SloRtt.Duration()
+		}
+	}
+	weights := cfg.PathQualityWeights()
+
+	icmpLabels := p.PING.ExportLabels()
+	probes := []struct {
+		stats  map[string]target.ProbeStats
+		labels map[string]map[string]string
+	}{
+		{p.PING.ExportStats(), icmpLabels},
+		{p.MTR.ExportStats(), p.MTR.ExportLabels()},
+		{p.TCP.ExportStats(), p.TCP.ExportLabels()},
+		{p.UDP.ExportStats(), p.UDP.ExportLabels()},
+		{p.HTTPGet.ExportStats(), p.HTTPGet.ExportLabels()},
+		{p.ARP.ExportStats(), p.ARP.ExportLabels()},
+		{p.OWD.ExportStats(), p.OWD.ExportLabels()},
+		{p.DNS.ExportStats(), p.DNS.ExportLabels()},
+	}
+	for _, probe := range probes {
+		for target, st := range probe.stats {
+			name := strings.SplitN(target, " ", 2)[0]
+			names, l, l2 := filterLabels(allow[st.Type], append([]string{"ip_version"}, exporterLabelNames...), exporterLabelNames, []string{name, st.Host, st.Ip, st.Type}, probe.labels[target])
+			probeWaitDesc = prometheus.NewDesc("network_exporter_probe_wait_seconds", "Time the last probe cycle waited for a free concurrency slot", names, l2)
+			probeSkippedDesc = prometheus.NewDesc("network_exporter_probe_overlap_skipped_total", "Probe cycles skipped because the previous cycle of the target was still running", names, l2)
+			probeShedDesc = prometheus.NewDesc("network_exporter_probe_load_shed_total", "Probe cycles of the target skipped by the load shedding", names, l2)
+			consecFailDesc = prometheus.NewDesc("network_exporter_target_consecutive_failures", "Consecutive failed probe cycles (reset on success)", names, l2)
+			lastProbeDesc = prometheus.NewDesc("network_exporter_target_last_probe_timestamp_seconds", "Timestamp of the start of the last probe cycle (0 if never probed)", names, l2)
+			nextProbeDesc = prometheus.NewDesc("network_exporter_target_next_probe_timestamp_seconds", "Timestamp the next probe cycle is due at (interval with jitter, startup delay)", names, l2)
+			lastSuccessDesc = prometheus.NewDesc("network_exporter_target_last_success_timestamp_seconds", "Timestamp of the last successful probe cycle (0 if never succeeded)", names, l2)
+			resolvedIpDesc = prometheus.NewDesc("network_exporter_target_resolved_ip", "Address the target host currently resolves to", names, l2)
+			familyUnavailDesc = prometheus.NewDesc("network_exporter_target_family_unavailable", "The address family of the target is not usable on this host (never probed)", names, l2)
+			depSkippedDesc = prometheus.NewDesc("network_exporter_target_skipped_dependency", "The last probe cycle was skipped because the depends-on target is down", append(names, "depends_on"), l2)
+			suppressedDesc = prometheus.NewDesc("network_exporter_probe_suppressed", "The last probe cycle was suppressed by a maintenance window of the target (disable_schedule)", names, l2)
+			recheckDesc = prometheus.NewDesc("network_exporter_probe_recheck", "The target is probed at the icmp.recheck-interval after a lossy cycle until the loss recovers (recheck-loss)", names, l2)
+			ch <- prometheus.MustNewConstMetric(probeWaitDesc, prometheus.GaugeValue, st.Wait.Seconds(), l...)
+			ch <- prometheus.MustNewConstMetric(probeSkippedDesc, prometheus.CounterValue, float64(st.Skipped), l...)
+			ch <- prometheus.MustNewConstMetric(probeShedDesc, prometheus.CounterValue, float64(st.Shed), l...)
+			ch <- prometheus.MustNewConstMetric(lastProbeDesc, prometheus.GaugeValue, unixTime(st.LastProbe), l...)
+			// Omitted in one-shot mode (no next cycle)
+			if !st.NextProbe.IsZero() {
+				ch <- prometheus.MustNewConstMetric(nextProbeDesc, prometheus.GaugeValue, unixTime(st.NextProbe), l...)
+			}
+			ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, unixTime(st.LastSuccess), l...)
+			ch <- prometheus.MustNewConstMetric(consecFailDesc, prometheus.GaugeValue, float64(st.ConsecutiveFailures), l...)
+			if st.FamilyUnavailable {
+				ch <- prometheus.MustNewConstMetric(familyUnavailDesc, prometheus.GaugeValue, 1, l...)
+			}
+			if st.DependsOn != "" {
+				ch <- prometheus.MustNewConstMetric(depSkippedDesc, prometheus.GaugeValue, bool2Float(st.DependencySkipped), append(l, st.DependsOn)...)
+			}
+			if st.Scheduled {
+				ch <- prometheus.MustNewConstMetric(suppressedDesc, prometheus.GaugeValue, bool2Float(st.Suppressed), l...)
+			}
+			if st.Adaptive {
+				ch <- prometheus.MustNewConstMetric(recheckDesc, prometheus.GaugeValue, bool2Float(st.Rechecking), l...)
+			}
+
+			// The series only changes when the resolved address does (targets are re-created on change)
+			if st.Ip != "" {
+				host := st.Host
+				if h, found := mtrHosts[name]; found && st.Type == "MTR" {
+					host = h
+				}
+				ch <- prometheus.MustNewConstMetric(resolvedIpDesc, prometheus.GaugeValue, 1, name, host, st.Ip, st.Type)
+			}
+		}
+	}
+
+	// Omitted when the platform can't read the TOS/traffic class of the reply
+	for target, metric := range p.PING.ExportMetrics() {
+		name := strings.SplitN(target, " ", 2)[0]
+		names, l, l2 := filterLabels(allow["ICMP"], append([]string{"target"}, identityLabels...), []string{"name", "target", "target_ip"}, []string{name, metric.DestAddr, metric.DestIp}, icmpLabels[target])
+		replyDSCPDesc = prometheus.NewDesc("network_exporter_icmp_reply_dscp", "DSCP of the last echo reply (targets with dscp, remarking detection)", names, l2)
+		replyTTLDesc = prometheus.NewDesc("network_exporter_icmp_reply_ttl", "TTL/hop limit of the last echo reply (targets with expected-hops)", names, l2)
+		hopDeviationDesc = prometheus.NewDesc("network_exporter_icmp_hop_deviation", "Hops derived from the reply TTL minus the expected-hops (0 when the route length is unchanged)", append(names, "expected_hops"), l2)
+		unexpectedSrcDesc = prometheus.NewDesc("network_exporter_icmp_unexpected_source_total", "Echo replies discarded because they didn't come from the target address", names, l2)
+		lastRttDesc = prometheus.NewDesc("network_exporter_icmp_last_rtt_seconds", "Round Trip Time of the most recent echo of the last cycle (NaN when it was lost)", names, l2)
+		effTimeoutDesc = prometheus.NewDesc("network_exporter_icmp_effective_timeout_seconds", "Echo timeout of the last cycle of the targets with adaptive-timeout", names, l2)
+		clockOffsetDesc = prometheus.NewDesc("network_exporter_icmp_clock_offset_ms", "Clock offset of the target in milliseconds from the ICMP timestamp replies (icmp-mode: timestamp)", names, l2)
+		qualityDesc = prometheus.NewDesc("network_exporter_path_quality_score", "Path quality score (0-100) of the last cycle weighting the loss, the latency vs slo-rtt and the jitter (conf.quality-score)", names, l2)
+
+		if metric.ReplyDSCPValid {
+			ch <- prometheus.MustNewConstMetric(replyDSCPDesc, prometheus.GaugeValue, float64(metric.ReplyDSCP), l...)
+		}
+		if metric.ExpectedHops > 0 {
+			ch <- prometheus.MustNewConstMetric(replyTTLDesc, prometheus.GaugeValue, float64(metric.ReplyTTL), l...)
+			// Omitted when the reply TTL doesn't fit the initial TTL
+			if metric.Hops > 0 {
+				ch <- prometheus.MustNewConstMetric(hopDeviationDesc, prometheus.GaugeValue, float64(metric.Hops-metric.ExpectedHops), append(l, strconv.Itoa(metric.ExpectedHops))...)
+			}
+		}
+		if cfg.Conf.QualityScore && metric.SntSummary > 0 {
+			// The deviation of a single reply is not a jitter
+			jitter := metric.UncorrectedSDTime
+			if len(metric.Rtts) < 2 {
+				jitter = -1
+			}
+			score := common.QualityScore(metric.DropRate, metric.AvgTime, icmpSlo[name], jitter, cfg.Conf.QualityJitterMax.Duration(), weights)
+			ch <- prometheus.MustNewConstMetric(qualityDesc, prometheus.GaugeValue, score, l...)
+		}
+		ch <- prometheus.MustNewConstMetric(unexpectedSrcDesc, prometheus.CounterValue, float64(metric.UnexpectedSources), l...)
+		if metric.SntSummary > 0 {
+			rtt := metric.LastTime.Seconds()
+			if metric.LastLost {
+				rtt = math.NaN()
+			}
+			ch <- prometheus.MustNewConstMetric(lastRttDesc, prometheus.GaugeValue, rtt, l...)
+		}
+		if metric.EffectiveTimeout > 0 {
+			ch <- prometheus.MustNewConstMetric(effTimeoutDesc, prometheus.GaugeValue, metric.EffectiveTimeout.Seconds(), l...)
+		}
+		if metric.ClockOffsetValid {
+			ch <- prometheus.MustNewConstMetric(clockOffsetDesc, prometheus.GaugeValue, metric.ClockOffset.Seconds()*1000, l...)
+		}
+	}
+
+	httpLabels := p.HTTPGet.ExportLabels()
+	for target, metric := range p.HTTPGet.ExportMetrics() {
+		if !metric.Success || metric.TLSVersion == "" {
+			continue
+		}
+		names, l, l2 := filterLabels(allow["HTTPGet"], tlsInfoLabelNames, tlsInfoLabelNames, []string{target, metric.DestAddr, metric.TLSVersion, metric.TLSCipher, metric.TLSServerName}, httpLabels[target])
+		tlsInfoDesc = prometheus.NewDesc("network_exporter_tls_info", "TLS version and cipher negotiated by the last probe", names, l2)
+		ch <- prometheus.MustNewConstMetric(tlsInfoDesc, prometheus.GaugeValue, 1, l...)
+	}
+
+	// The TCP targets with tls: true, told apart by their address and port ("name ip [port]")
+	tcpLabels := p.TCP.ExportLabels()
+	for target, metric := range p.TCP.ExportMetrics() {
+		if metric.TLS == nil || metric.TLS.Version == "" {
+			continue
+		}
+		name := strings.SplitN(target, " ", 2)[0]
+		names, l, l2 := filterLabels(allow["TCP"], tlsInfoTCPLabelNames, tlsInfoTCPLabelNames, []string{name, metric.DestAddr, metric.TLS.Version, metric.TLS.Cipher, metric.TLS.ServerName, metric.DestIp, metric.DestPort}, tcpLabels[target])
+		tlsInfoDesc = prometheus.NewDesc("network_exporter_tls_info", "TLS version and cipher negotiated by the last probe", names, l2)
+		ch <- prometheus.MustNewConstMetric(tlsInfoDesc, prometheus.GaugeValue, 1, l...)
+	}
+}
@@ -0,0 +1,50 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/syepes/network_exporter/monitor"
+)
+
+var graphiteEscaper = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+
+// graphitePathTags tags identifying a target in the metric path (in order), the other tags are dropped
+var graphitePathTags = []string{"name", "target_ip", "port", "ttl"}
+
+// Graphite renders the probe results in Graphite plaintext (same results as the Prometheus collectors)
+type Graphite struct {
+	PING    *monitor.PING
+	MTR     *monitor.MTR
+	TCP     *monitor.TCPPort
+	UDP     *monitor.UDP
+	HTTPGet *monitor.HTTPGet
+	ARP     *monitor.ARP
+	OWD     *monitor.OWD
+	DNS     *monitor.DNS
+}
+
+// Write writes the `path value timestamp` lines (<prefix>.<measurement>.<name>[.<target_ip>][.<port>][.<ttl>].<field>)
+func (p *Graphite) Write(w io.Writer, prefix string, ts time.Time) error {
+	var buf bytes.Buffer
+	for _, pt := range probePoints(p.PING, p.MTR, p.TCP, p.UDP, p.HTTPGet, p.ARP, p.OWD, p.DNS) {
+		path := pt.measurement
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		for _, k := range graphitePathTags {
+			if v := pt.tags[k]; v != "" {
+				path += "." + graphiteEscaper.ReplaceAllString(v, "_")
+			}
+		}
+		for _, f := range pt.fields {
+			fmt.Fprintf(&buf, "%s.%s %s %d\n", path, f.key, strconv.FormatFloat(f.value, 'g', -1, 64), ts.Unix())
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
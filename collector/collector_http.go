@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
 	"github.com/syepes/network_exporter/monitor"
 	"github.com/syepes/network_exporter/pkg/http"
 )
@@ -14,6 +15,7 @@ var (
 	httpTimeDesc    = prometheus.NewDesc("http_get_seconds", "HTTP Get Drill Down time in seconds", append(httpLabelNames, "type"), nil)
 	httpSizeDesc    = prometheus.NewDesc("http_get_content_bytes", "HTTP Get Content Size in bytes", httpLabelNames, nil)
 	httpStatusDesc  = prometheus.NewDesc("http_get_status", "HTTP Get Status", httpLabelNames, nil)
+	httpSuccessDesc = prometheus.NewDesc("http_get_success", "HTTP Get response received with an expected status code", httpLabelNames, nil)
 	httpTargetsDesc = prometheus.NewDesc("http_get_targets", "Number of active targets", nil, nil)
 	httpStateDesc   = prometheus.NewDesc("http_get_up", "Exporter state", nil, nil)
 	httpMutex       = &sync.Mutex{}
@@ -21,6 +23,7 @@ var (
 
 // HTTPGet prom
 type HTTPGet struct {
+	SC      *config.SafeConfig
 	Monitor *monitor.HTTPGet
 	metrics map[string]*http.HTTPReturn
 	labels  map[string]map[string]string
@@ -31,6 +34,7 @@ func (p *HTTPGet) Describe(ch chan<- *prometheus.Desc) {
 	ch <- httpTimeDesc
 	ch <- httpSizeDesc
 	ch <- httpStatusDesc
+	ch <- httpSuccessDesc
 	ch <- httpTargetsDesc
 	ch <- httpStateDesc
 }
@@ -40,13 +44,8 @@ func (p *HTTPGet) Collect(ch chan<- prometheus.Metric) {
 	httpMutex.Lock()
 	defer httpMutex.Unlock()
 
-	if m := p.Monitor.ExportMetrics(); len(m) > 0 {
-		p.metrics = m
-	}
-
-	if l := p.Monitor.ExportLabels(); len(l) > 0 {
-		p.labels = l
-	}
+	p.metrics = p.Monitor.ExportMetrics()
+	p.labels = p.Monitor.ExportLabels()
 
 	if len(p.metrics) > 0 {
 		ch <- prometheus.MustNewConstMetric(httpStateDesc, prometheus.GaugeValue, 1)
@@ -54,22 +53,24 @@ func (p *HTTPGet) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(httpStateDesc, prometheus.GaugeValue, 0)
 	}
 
+	p.SC.RLock()
+	allow := p.SC.Cfg.HTTPGet.Labels
+	p.SC.RUnlock()
+
 	targets := []string{}
 	for target, metric := range p.metrics {
 		targets = append(targets, target)
 		l := strings.SplitN(target, " ", 2)
 		l = append(l, metric.DestAddr)
-		l2 := prometheus.Labels(p.labels[target])
+		names, l, l2 := filterLabels(allow, identityLabels, httpLabelNames, l, p.labels[target])
 
-		httpTimeDesc = prometheus.NewDesc("http_get_seconds", "HTTP Get Drill Down time in seconds", append(httpLabelNames, "type"), l2)
-		httpSizeDesc = prometheus.NewDesc("http_get_content_bytes", "HTTP Get Content Size in bytes", httpLabelNames, l2)
-		httpStatusDesc = prometheus.NewDesc("http_get_status", "HTTP Get Status", httpLabelNames, l2)
+		httpTimeDesc = prometheus.NewDesc("http_get_seconds", "HTTP Get Drill Down time in seconds", append(names, "type"), l2)
+		httpSizeDesc = prometheus.NewDesc("http_get_content_bytes", "HTTP Get Content Size in bytes", names, l2)
+		httpStatusDesc = prometheus.NewDesc("http_get_status", "HTTP Get Status", names, l2)
+		httpSuccessDesc = prometheus.NewDesc("http_get_success", "HTTP Get response received with an expected status code", names, l2)
 
-		if metric.Success {
-			ch <- prometheus.MustNewConstMetric(httpStatusDesc, prometheus.GaugeValue, float64(metric.Status), l...)
-		} else {
-			ch <- prometheus.MustNewConstMetric(httpStatusDesc, prometheus.GaugeValue, 0, l...)
-		}
+		ch <- prometheus.MustNewConstMetric(httpStatusDesc, prometheus.GaugeValue, float64(metric.Status), l...)
+		ch <- prometheus.MustNewConstMetric(httpSuccessDesc, prometheus.GaugeValue, bool2Float(metric.Success), l...)
 
 		ch <- prometheus.MustNewConstMetric(httpSizeDesc, prometheus.GaugeValue, float64(metric.ContentLength), l...)
 		ch <- prometheus.MustNewConstMetric(httpTimeDesc, prometheus.GaugeValue, metric.DNSLookup.Seconds(), append(l, "DNSLookup")...)
@@ -82,6 +83,7 @@ func (p *HTTPGet) Collect(ch chan<- prometheus.Metric) {
 			ch <- prometheus.MustNewConstMetric(httpTimeDesc, prometheus.GaugeValue, float64(metric.TLSLastChainExpiry.Unix()), append(l, "TLSLastChainExpiry")...)
 		}
 		ch <- prometheus.MustNewConstMetric(httpTimeDesc, prometheus.GaugeValue, metric.ServerProcessing.Seconds(), append(l, "ServerProcessing")...)
+		ch <- prometheus.MustNewConstMetric(httpTimeDesc, prometheus.GaugeValue, metric.TTFB.Seconds(), append(l, "TTFB")...)
 		ch <- prometheus.MustNewConstMetric(httpTimeDesc, prometheus.GaugeValue, metric.ContentTransfer.Seconds(), append(l, "ContentTransfer")...)
 		ch <- prometheus.MustNewConstMetric(httpTimeDesc, prometheus.GaugeValue, metric.Total.Seconds(), append(l, "Total")...)
 	}
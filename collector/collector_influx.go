@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syepes/network_exporter/monitor"
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// Influx renders the probe results in InfluxDB line protocol (same results as the Prometheus collectors)
+type Influx struct {
+	PING    *monitor.PING
+	MTR     *monitor.MTR
+	TCP     *monitor.TCPPort
+	UDP     *monitor.UDP
+	HTTPGet *monitor.HTTPGet
+	ARP     *monitor.ARP
+	OWD     *monitor.OWD
+	DNS     *monitor.DNS
+}
+
+// ServeHTTP influx
+func (p *Influx) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	ts := time.Now().UnixNano()
+
+	for _, pt := range probePoints(p.PING, p.MTR, p.TCP, p.UDP, p.HTTPGet, p.ARP, p.OWD, p.DNS) {
+		writeInfluxLine(&buf, pt.measurement, pt.tags, pt.labels, pt.fields, ts)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeInfluxLine writes a single point, the target labels are added as extra tags
+func writeInfluxLine(buf *bytes.Buffer, measurement string, tags map[string]string, extra map[string]string, fields []pointField, ts int64) {
+	all := make(map[string]string, len(tags)+len(extra))
+	for k, v := range extra {
+		all[k] = v
+	}
+	for k, v := range tags {
+		all[k] = v
+	}
+
+	// Tags sorted by key as recommended by InfluxDB
+	keys := make([]string, 0, len(all))
+	for k, v := range all {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	buf.WriteString(influxEscaper.Replace(measurement))
+	for _, k := range keys {
+		fmt.Fprintf(buf, ",%s=%s", influxEscaper.Replace(k), influxEscaper.Replace(all[k]))
+	}
+	for i, f := range fields {
+		if i == 0 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, "%s=%s", influxEscaper.Replace(f.key), strconv.FormatFloat(f.value, 'g', -1, 64))
+	}
+	fmt.Fprintf(buf, " %d\n", ts)
+}
@@ -6,25 +6,33 @@ import (
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
 	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/pkg/common"
 	"github.com/syepes/network_exporter/pkg/mtr"
 )
 
 var (
-	mtrLabelNames  = []string{"name", "target", "ttl", "path"}
+	mtrLabelNames  = []string{"name", "target", "protocol", "ttl", "path"}
 	mtrDesc        = prometheus.NewDesc("mtr_rtt_seconds", "Round Trip Time in seconds", append(mtrLabelNames, "type"), nil)
 	mtrSntDesc     = prometheus.NewDesc("mtr_rtt_snt_count", "Round Trip Send Package Total", append(mtrLabelNames, "type"), nil)
 	mtrSntFailDesc = prometheus.NewDesc("mtr_rtt_snt_fail_count", "Round Trip Send Package Fail Total", append(mtrLabelNames, "type"), nil)
 	mtrSntTimeDesc = prometheus.NewDesc("mtr_rtt_snt_seconds", "Round Trip Send Package Time Total", append(mtrLabelNames, "type"), nil)
-	mtrHopsDesc    = prometheus.NewDesc("mtr_hops", "Number of route hops", []string{"name", "target"}, nil)
+	mtrHopInfoDesc = prometheus.NewDesc("mtr_hop_info", "Reverse DNS name of the hop (mtr.resolve-hops)", append(mtrLabelNames, "hostname"), nil)
+	mtrHopsDesc    = prometheus.NewDesc("mtr_hops", "Number of route hops", []string{"name", "target", "protocol"}, nil)
+	mtrWindowDesc  = prometheus.NewDesc("mtr_window_rtt_seconds", "Round Trip Time in seconds averaged over the sliding window of runs (mtr-window)", append(mtrLabelNames, "type"), nil)
+	mtrWinRunsDesc = prometheus.NewDesc("mtr_window_runs", "Number of runs in the sliding window (restarted when the path changes)", []string{"name", "target", "protocol"}, nil)
 	mtrTargetsDesc = prometheus.NewDesc("mtr_targets", "Number of active targets", nil, nil)
 	mtrStateDesc   = prometheus.NewDesc("mtr_up", "Exporter state", nil, nil)
 	mtrMutex       = &sync.Mutex{}
+	mtrHopGeoNames = []string{"asn", "as_name", "country"}
 )
 
 // MTR prom
 type MTR struct {
+	SC      *config.SafeConfig
 	Monitor *monitor.MTR
+	GeoIP   *common.GeoIP
 	metrics map[string]*mtr.MtrResult
 	labels  map[string]map[string]string
 }
@@ -32,7 +40,10 @@ type MTR struct {
 // Describe prom
 func (p *MTR) Describe(ch chan<- *prometheus.Desc) {
 	ch <- mtrDesc
+	ch <- mtrHopInfoDesc
 	ch <- mtrHopsDesc
+	ch <- mtrWindowDesc
+	ch <- mtrWinRunsDesc
 	ch <- mtrTargetsDesc
 	ch <- mtrStateDesc
 }
@@ -42,13 +53,8 @@ func (p *MTR) Collect(ch chan<- prometheus.Metric) {
 	mtrMutex.Lock()
 	defer mtrMutex.Unlock()
 
-	if m := p.Monitor.ExportMetrics(); len(m) > 0 {
-		p.metrics = m
-	}
-
-	if l := p.Monitor.ExportLabels(); len(l) > 0 {
-		p.labels = l
-	}
+	p.metrics = p.Monitor.ExportMetrics()
+	p.labels = p.Monitor.ExportLabels()
 
 	if len(p.metrics) > 0 {
 		ch <- prometheus.MustNewConstMetric(mtrStateDesc, prometheus.GaugeValue, 1)
@@ -56,19 +62,26 @@ func (p *MTR) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(mtrStateDesc, prometheus.GaugeValue, 0)
 	}
 
+	p.SC.RLock()
+	allow := p.SC.Cfg.MTR.Labels
+	p.SC.RUnlock()
+
 	targets := []string{}
 	for target, metric := range p.metrics {
 		targets = append(targets, target)
-		l := []string{target, metric.DestAddr}
-		l2 := prometheus.Labels(p.labels[target])
+		base, l, l2 := filterLabels(allow, identityLabels, []string{"name", "target", "protocol"}, []string{strings.SplitN(target, " ", 2)[0], metric.DestAddr, metric.Protocol}, p.labels[target])
+		hopL2, geoNames := p.hopGeoLabels(allow, l2)
+		names := append(append(base, "ttl", "path"), geoNames...)
 
-		mtrDesc = prometheus.NewDesc("mtr_rtt_seconds", "Round Trip Time in seconds", append(mtrLabelNames, "type"), l2)
-		mtrHopsDesc = prometheus.NewDesc("mtr_hops", "Number of route hops", []string{"name", "target"}, l2)
+		mtrDesc = prometheus.NewDesc("mtr_rtt_seconds", "Round Trip Time in seconds", append(names, "type"), hopL2)
+		mtrHopsDesc = prometheus.NewDesc("mtr_hops", "Number of route hops", base, l2)
+		mtrHopInfoDesc = prometheus.NewDesc("mtr_hop_info", "Reverse DNS name of the hop (mtr.resolve-hops)", append(names, "hostname"), hopL2)
 
 		ch <- prometheus.MustNewConstMetric(mtrHopsDesc, prometheus.GaugeValue, float64(len(metric.Hops)), l...)
 		for _, hop := range metric.Hops {
 			ll := append(l, strconv.Itoa(hop.TTL))
 			ll = append(ll, hop.AddressTo)
+			ll = append(ll, p.hopGeoValues(geoNames, hop.AddressTo)...)
 			ch <- prometheus.MustNewConstMetric(mtrDesc, prometheus.GaugeValue, hop.LastTime.Seconds(), append(ll, "last")...)
 			ch <- prometheus.MustNewConstMetric(mtrDesc, prometheus.GaugeValue, hop.SumTime.Seconds(), append(ll, "sum")...)
 			ch <- prometheus.MustNewConstMetric(mtrDesc, prometheus.GaugeValue, hop.BestTime.Seconds(), append(ll, "best")...)
@@ -79,15 +92,34 @@ func (p *MTR) Collect(ch chan<- prometheus.Metric) {
 			ch <- prometheus.MustNewConstMetric(mtrDesc, prometheus.GaugeValue, hop.CorrectedSDTime.Seconds(), append(ll, "csd")...)
 			ch <- prometheus.MustNewConstMetric(mtrDesc, prometheus.GaugeValue, hop.RangeTime.Seconds(), append(ll, "range")...)
 			ch <- prometheus.MustNewConstMetric(mtrDesc, prometheus.GaugeValue, float64(hop.Loss), append(ll, "loss")...)
+			if hop.AddressName != "" {
+				ch <- prometheus.MustNewConstMetric(mtrHopInfoDesc, prometheus.GaugeValue, 1, append(ll, hop.AddressName)...)
+			}
+		}
+
+		if metric.WindowRuns > 0 {
+			mtrWindowDesc = prometheus.NewDesc("mtr_window_rtt_seconds", "Round Trip Time in seconds averaged over the sliding window of runs (mtr-window)", append(names, "type"), hopL2)
+			mtrWinRunsDesc = prometheus.NewDesc("mtr_window_runs", "Number of runs in the sliding window (restarted when the path changes)", base, l2)
+			ch <- prometheus.MustNewConstMetric(mtrWinRunsDesc, prometheus.GaugeValue, float64(metric.WindowRuns), l...)
+			for _, hop := range metric.WindowHops {
+				ll := append(l, strconv.Itoa(hop.TTL))
+				ll = append(ll, hop.AddressTo)
+				ll = append(ll, p.hopGeoValues(geoNames, hop.AddressTo)...)
+				ch <- prometheus.MustNewConstMetric(mtrWindowDesc, prometheus.GaugeValue, hop.BestTime.Seconds(), append(ll, "best")...)
+				ch <- prometheus.MustNewConstMetric(mtrWindowDesc, prometheus.GaugeValue, hop.AvgTime.Seconds(), append(ll, "mean")...)
+				ch <- prometheus.MustNewConstMetric(mtrWindowDesc, prometheus.GaugeValue, hop.WorstTime.Seconds(), append(ll, "worst")...)
+				ch <- prometheus.MustNewConstMetric(mtrWindowDesc, prometheus.GaugeValue, hop.Loss, append(ll, "loss")...)
+			}
 		}
 
-		mtrSntDesc = prometheus.NewDesc("mtr_rtt_snt_count", "Round Trip Send Package Total", mtrLabelNames, l2)
-		mtrSntFailDesc = prometheus.NewDesc("mtr_rtt_snt_fail_count", "Round Trip Send Package Fail Total", mtrLabelNames, l2)
-		mtrSntTimeDesc = prometheus.NewDesc("mtr_rtt_snt_seconds", "Round Trip Send Package Time Total", mtrLabelNames, l2)
+		mtrSntDesc = prometheus.NewDesc("mtr_rtt_snt_count", "Round Trip Send Package Total", names, hopL2)
+		mtrSntFailDesc = prometheus.NewDesc("mtr_rtt_snt_fail_count", "Round Trip Send Package Fail Total", names, hopL2)
+		mtrSntTimeDesc = prometheus.NewDesc("mtr_rtt_snt_seconds", "Round Trip Send Package Time Total", names, hopL2)
 
 		for ttl, summary := range metric.HopSummaryMap {
 			ll := append(l, strings.Split(ttl, "_")[0])
 			ll = append(ll, summary.AddressTo)
+			ll = append(ll, p.hopGeoValues(geoNames, summary.AddressTo)...)
 			ch <- prometheus.MustNewConstMetric(mtrSntDesc, prometheus.CounterValue, float64(summary.Snt), ll...)
 			ch <- prometheus.MustNewConstMetric(mtrSntFailDesc, prometheus.CounterValue, float64(summary.SntFail), ll...)
 			ch <- prometheus.MustNewConstMetric(mtrSntTimeDesc, prometheus.CounterValue, summary.SntTime.Seconds(), ll...)
@@ -95,3 +127,47 @@ func (p *MTR) Collect(ch chan<- prometheus.Metric) {
 	}
 	ch <- prometheus.MustNewConstMetric(mtrTargetsDesc, prometheus.GaugeValue, float64(len(targets)))
 }
+
+// hopGeoLabels returns the constant labels of the hop series and the GeoIP label names of the hops kept by the allowlist
+// The asn/as_name/country of the hop series describe the hop, the ones of the target are removed from the constant labels
+func (p *MTR) hopGeoLabels(allow []string, l2 prometheus.Labels) (prometheus.Labels, []string) {
+	if !p.GeoIP.Enabled() {
+		return l2, nil
+	}
+	keep := map[string]bool{}
+	for _, k := range allow {
+		keep[k] = true
+	}
+	names := []string{}
+	for _, k := range mtrHopGeoNames {
+		if allow == nil || keep[k] {
+			names = append(names, k)
+		}
+	}
+	if len(names) == 0 {
+		return l2, nil
+	}
+
+	l := prometheus.Labels{}
+	for k, v := range l2 {
+		l[k] = v
+	}
+	for _, k := range names {
+		delete(l, k)
+	}
+	return l, names
+}
+
+// hopGeoValues returns the values of the hop GeoIP labels (empty for the unknown hops)
+func (p *MTR) hopGeoValues(names []string, ip string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	asn, asName, country := p.GeoIP.Hop(ip)
+	geo := map[string]string{"asn": asn, "as_name": asName, "country": country}
+	values := make([]string, len(names))
+	for i, k := range names {
+		values[i] = geo[k]
+	}
+	return values
+}
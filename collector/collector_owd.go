@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/pkg/owd"
+)
+
+var (
+	owdLabelNames  = []string{"name", "target", "target_ip", "source_ip"}
+	owdStatusDesc  = prometheus.NewDesc("owd_status", "Probe status (reply received from the peer responder)", owdLabelNames, nil)
+	owdForwardDesc = prometheus.NewDesc("owd_forward_seconds", "One-way delay to the peer in seconds", append(owdLabelNames, "type"), nil)
+	owdReverseDesc = prometheus.NewDesc("owd_reverse_seconds", "One-way delay from the peer in seconds", append(owdLabelNames, "type"), nil)
+	owdRttDesc     = prometheus.NewDesc("owd_rtt_seconds", "Round Trip Time in seconds without the peer processing time", owdLabelNames, nil)
+	owdOffsetDesc  = prometheus.NewDesc("owd_clock_offset_seconds", "Estimated clock offset of the peer in seconds", owdLabelNames, nil)
+	owdLossDesc    = prometheus.NewDesc("owd_loss_percent", "Packet loss in percent", owdLabelNames, nil)
+	owdTargetsDesc = prometheus.NewDesc("owd_targets", "Number of active targets", nil, nil)
+	owdStateDesc   = prometheus.NewDesc("owd_up", "Exporter state", nil, nil)
+	owdMutex       = &sync.Mutex{}
+)
+
+// OWD prom
+type OWD struct {
+	SC      *config.SafeConfig
+	Monitor *monitor.OWD
+	metrics map[string]*owd.OwdResult
+	labels  map[string]map[string]string
+}
+
+// Describe prom
+func (p *OWD) Describe(ch chan<- *prometheus.Desc) {
+	ch <- owdStatusDesc
+	ch <- owdForwardDesc
+	ch <- owdReverseDesc
+	ch <- owdRttDesc
+	ch <- owdOffsetDesc
+	ch <- owdLossDesc
+	ch <- owdTargetsDesc
+	ch <- owdStateDesc
+}
+
+// Collect prom
+func (p *OWD) Collect(ch chan<- prometheus.Metric) {
+	owdMutex.Lock()
+	defer owdMutex.Unlock()
+
+	p.metrics = p.Monitor.ExportMetrics()
+	p.labels = p.Monitor.ExportLabels()
+
+	if len(p.metrics) > 0 {
+		ch <- prometheus.MustNewConstMetric(owdStateDesc, prometheus.GaugeValue, 1)
+	} else {
+		ch <- prometheus.MustNewConstMetric(owdStateDesc, prometheus.GaugeValue, 0)
+	}
+
+	p.SC.RLock()
+	allow := p.SC.Cfg.OWD.Labels
+	p.SC.RUnlock()
+
+	targets := []string{}
+	for target, metric := range p.metrics {
+		targets = append(targets, target)
+		names, l, l2 := filterLabels(allow, identityLabels, owdLabelNames, []string{target, metric.DestAddr, metric.DestIp, metric.SrcIp}, p.labels[target])
+
+		owdStatusDesc = prometheus.NewDesc("owd_status", "Probe status (reply received from the peer responder)", names, l2)
+		owdForwardDesc = prometheus.NewDesc("owd_forward_seconds", "One-way delay to the peer in seconds", append(names, "type"), l2)
+		owdReverseDesc = prometheus.NewDesc("owd_reverse_seconds", "One-way delay from the peer in seconds", append(names, "type"), l2)
+		owdRttDesc = prometheus.NewDesc("owd_rtt_seconds", "Round Trip Time in seconds without the peer processing time", names, l2)
+		owdOffsetDesc = prometheus.NewDesc("owd_clock_offset_seconds", "Estimated clock offset of the peer in seconds", names, l2)
+		owdLossDesc = prometheus.NewDesc("owd_loss_percent", "Packet loss in percent", names, l2)
+
+		ch <- prometheus.MustNewConstMetric(owdStatusDesc, prometheus.GaugeValue, bool2Float(metric.Success), l...)
+		ch <- prometheus.MustNewConstMetric(owdLossDesc, prometheus.GaugeValue, metric.DropRate, l...)
+		if !metric.Success {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(owdForwardDesc, prometheus.GaugeValue, metric.ForwardBest.Seconds(), append(l, "best")...)
+		ch <- prometheus.MustNewConstMetric(owdForwardDesc, prometheus.GaugeValue, metric.ForwardAvg.Seconds(), append(l, "mean")...)
+		ch <- prometheus.MustNewConstMetric(owdForwardDesc, prometheus.GaugeValue, metric.ForwardWorst.Seconds(), append(l, "worst")...)
+		ch <- prometheus.MustNewConstMetric(owdReverseDesc, prometheus.GaugeValue, metric.ReverseBest.Seconds(), append(l, "best")...)
+		ch <- prometheus.MustNewConstMetric(owdReverseDesc, prometheus.GaugeValue, metric.ReverseAvg.Seconds(), append(l, "mean")...)
+		ch <- prometheus.MustNewConstMetric(owdReverseDesc, prometheus.GaugeValue, metric.ReverseWorst.Seconds(), append(l, "worst")...)
+		ch <- prometheus.MustNewConstMetric(owdRttDesc, prometheus.GaugeValue, metric.RttAvg.Seconds(), l...)
+		ch <- prometheus.MustNewConstMetric(owdOffsetDesc, prometheus.GaugeValue, metric.ClockOffset.Seconds(), l...)
+	}
+	ch <- prometheus.MustNewConstMetric(owdTargetsDesc, prometheus.GaugeValue, float64(len(targets)))
+}
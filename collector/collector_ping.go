@@ -1,10 +1,13 @@
 package collector
 
 import (
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/syepes/network_exporter/config"
 	"github.com/syepes/network_exporter/monitor"
 	"github.com/syepes/network_exporter/pkg/ping"
 )
@@ -17,6 +20,19 @@ var (
 	icmpSntFailSummaryDesc = prometheus.NewDesc("ping_rtt_snt_fail_count", "Packet sent fail count", icmpLabelNames, nil)
 	icmpSntTimeSummaryDesc = prometheus.NewDesc("ping_rtt_snt_seconds", "Packet sent time total", icmpLabelNames, nil)
 	icmpLossDesc           = prometheus.NewDesc("ping_loss_percent", "Packet loss in percent", icmpLabelNames, nil)
+	icmpSentDesc           = prometheus.NewDesc("ping_packets_sent_total", "Echo requests sent to the target", icmpLabelNames, nil)
+	icmpReceivedDesc       = prometheus.NewDesc("ping_packets_received_total", "Echo replies received from the target", icmpLabelNames, nil)
+	icmpRttHistogramDesc   = prometheus.NewDesc("ping_rtt_histogram_seconds", "Round Trip Time distribution of the echo replies in seconds (icmp.histogram-buckets)", icmpLabelNames, nil)
+	icmpFlowRttDesc        = prometheus.NewDesc("ping_flow_rtt_seconds", "Round Trip Time in seconds per flow", append(icmpLabelNames, "flow", "type"), nil)
+	icmpFlowLossDesc       = prometheus.NewDesc("ping_flow_loss_percent", "Packet loss in percent per flow", append(icmpLabelNames, "flow"), nil)
+	icmpClassRttDesc       = prometheus.NewDesc("ping_dscp_rtt_seconds", "Round Trip Time in seconds per DSCP class", append(icmpLabelNames, "dscp", "type"), nil)
+	icmpClassLossDesc      = prometheus.NewDesc("ping_dscp_loss_percent", "Packet loss in percent per DSCP class", append(icmpLabelNames, "dscp"), nil)
+	icmpClassReplyDesc     = prometheus.NewDesc("ping_dscp_reply_dscp", "DSCP of the last echo reply per DSCP class (remarking detection)", append(icmpLabelNames, "dscp"), nil)
+	icmpJitterDesc         = prometheus.NewDesc("ping_jitter_seconds", "Interarrival jitter of the echo replies in seconds (RFC 3550)", icmpLabelNames, nil)
+	icmpOutOfOrderDesc     = prometheus.NewDesc("ping_out_of_order_packets", "Echo replies received out of order in the last burst (targets with burst)", icmpLabelNames, nil)
+	icmpPathMTUDesc        = prometheus.NewDesc("ping_path_mtu_bytes", "Path MTU in bytes discovered with the DF echoes (targets with pmtu)", icmpLabelNames, nil)
+	icmpWinLossDesc        = prometheus.NewDesc("ping_window_loss_percent", "Packet loss in percent of the echoes sent within the window (icmp.windows)", append(icmpLabelNames, "window"), nil)
+	icmpWinRttDesc         = prometheus.NewDesc("ping_window_rtt_ewma_seconds", "Exponentially weighted moving average of the mean Round Trip Time in seconds with the window as time constant (icmp.windows)", append(icmpLabelNames, "window"), nil)
 	icmpTargetsDesc        = prometheus.NewDesc("ping_targets", "Number of active targets", nil, nil)
 	icmpStateDesc          = prometheus.NewDesc("ping_up", "Exporter state", nil, nil)
 	icmpMutex              = &sync.Mutex{}
@@ -24,6 +40,7 @@ var (
 
 // PING prom
 type PING struct {
+	SC      *config.SafeConfig
 	Monitor *monitor.PING
 	metrics map[string]*ping.PingResult
 	labels  map[string]map[string]string
@@ -34,6 +51,19 @@ func (p *PING) Describe(ch chan<- *prometheus.Desc) {
 	ch <- icmpStatusDesc
 	ch <- icmpRttDesc
 	ch <- icmpLossDesc
+	ch <- icmpSentDesc
+	ch <- icmpReceivedDesc
+	ch <- icmpRttHistogramDesc
+	ch <- icmpFlowRttDesc
+	ch <- icmpFlowLossDesc
+	ch <- icmpClassRttDesc
+	ch <- icmpClassLossDesc
+	ch <- icmpClassReplyDesc
+	ch <- icmpJitterDesc
+	ch <- icmpOutOfOrderDesc
+	ch <- icmpPathMTUDesc
+	ch <- icmpWinLossDesc
+	ch <- icmpWinRttDesc
 	ch <- icmpTargetsDesc
 	ch <- icmpStateDesc
 }
@@ -43,13 +73,8 @@ func (p *PING) Collect(ch chan<- prometheus.Metric) {
 	icmpMutex.Lock()
 	defer icmpMutex.Unlock()
 
-	if m := p.Monitor.ExportMetrics(); len(m) > 0 {
-		p.metrics = m
-	}
-
-	if l := p.Monitor.ExportLabels(); len(l) > 0 {
-		p.labels = l
-	}
+	p.metrics = p.Monitor.ExportMetrics()
+	p.labels = p.Monitor.ExportLabels()
 
 	if len(p.metrics) > 0 {
 		ch <- prometheus.MustNewConstMetric(icmpStateDesc, prometheus.GaugeValue, 1)
@@ -57,20 +82,27 @@ func (p *PING) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(icmpStateDesc, prometheus.GaugeValue, 0)
 	}
 
+	p.SC.RLock()
+	allow := p.SC.Cfg.ICMP.Labels
+	p.SC.RUnlock()
+
 	targets := []string{}
 	for target, metric := range p.metrics {
 		targets = append(targets, target)
 		l := strings.SplitN(strings.SplitN(target, " ", 2)[0], " ", 2) // get name without ip and create slice
 		l = append(l, metric.DestAddr)
 		l = append(l, metric.DestIp)
-		l2 := prometheus.Labels(p.labels[target])
+		names, l, l2 := filterLabels(allow, identityLabels, icmpLabelNames, l, p.labels[target])
 
-		icmpStatusDesc = prometheus.NewDesc("ping_status", "Ping Status", icmpLabelNames, l2)
-		icmpRttDesc = prometheus.NewDesc("ping_rtt_seconds", "Round Trip Time in seconds", append(icmpLabelNames, "type"), l2)
-		icmpSntSummaryDesc = prometheus.NewDesc("ping_rtt_snt_count", "Packet sent count", icmpLabelNames, l2)
-		icmpSntFailSummaryDesc = prometheus.NewDesc("ping_rtt_snt_fail_count", "Packet sent fail count", icmpLabelNames, l2)
-		icmpSntTimeSummaryDesc = prometheus.NewDesc("ping_rtt_snt_seconds", "Packet sent time total", icmpLabelNames, l2)
-		icmpLossDesc = prometheus.NewDesc("ping_loss_percent", "Packet loss in percent", icmpLabelNames, l2)
+		icmpStatusDesc = prometheus.NewDesc("ping_status", "Ping Status", names, l2)
+		icmpRttDesc = prometheus.NewDesc("ping_rtt_seconds", "Round Trip Time in seconds", append(names, "type"), l2)
+		icmpSntSummaryDesc = prometheus.NewDesc("ping_rtt_snt_count", "Packet sent count", names, l2)
+		icmpSntFailSummaryDesc = prometheus.NewDesc("ping_rtt_snt_fail_count", "Packet sent fail count", names, l2)
+		icmpSntTimeSummaryDesc = prometheus.NewDesc("ping_rtt_snt_seconds", "Packet sent time total", names, l2)
+		icmpLossDesc = prometheus.NewDesc("ping_loss_percent", "Packet loss in percent", names, l2)
+		icmpSentDesc = prometheus.NewDesc("ping_packets_sent_total", "Echo requests sent to the target", names, l2)
+		icmpReceivedDesc = prometheus.NewDesc("ping_packets_received_total", "Echo replies received from the target", names, l2)
+		icmpJitterDesc = prometheus.NewDesc("ping_jitter_seconds", "Interarrival jitter of the echo replies in seconds (RFC 3550)", names, l2)
 
 		if metric.Success {
 			ch <- prometheus.MustNewConstMetric(icmpStatusDesc, prometheus.GaugeValue, 1, l...)
@@ -90,6 +122,64 @@ func (p *PING) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(icmpSntFailSummaryDesc, prometheus.GaugeValue, float64(metric.SntFailSummary), l...)
 		ch <- prometheus.MustNewConstMetric(icmpSntTimeSummaryDesc, prometheus.GaugeValue, metric.SntTimeSummary.Seconds(), l...)
 		ch <- prometheus.MustNewConstMetric(icmpLossDesc, prometheus.GaugeValue, metric.DropRate, l...)
+		ch <- prometheus.MustNewConstMetric(icmpSentDesc, prometheus.CounterValue, float64(metric.SntSummary), l...)
+		ch <- prometheus.MustNewConstMetric(icmpReceivedDesc, prometheus.CounterValue, float64(metric.SntSummary-metric.SntFailSummary), l...)
+		ch <- prometheus.MustNewConstMetric(icmpJitterDesc, prometheus.GaugeValue, metric.Jitter.Seconds(), l...)
+		// Echoes sent one at a time can't be reordered
+		if metric.Burst {
+			icmpOutOfOrderDesc = prometheus.NewDesc("ping_out_of_order_packets", "Echo replies received out of order in the last burst (targets with burst)", names, l2)
+			ch <- prometheus.MustNewConstMetric(icmpOutOfOrderDesc, prometheus.GaugeValue, float64(metric.OutOfOrder), l...)
+		}
+		if h := metric.RttHistogram; h != nil {
+			icmpRttHistogramDesc = prometheus.NewDesc("ping_rtt_histogram_seconds", "Round Trip Time distribution of the echo replies in seconds (icmp.histogram-buckets)", names, l2)
+			ch <- prometheus.MustNewConstHistogram(icmpRttHistogramDesc, h.Count, h.Sum, h.Buckets(), l...)
+		}
+		// Omitted while the discovery fails
+		if metric.PathMTU > 0 {
+			icmpPathMTUDesc = prometheus.NewDesc("ping_path_mtu_bytes", "Path MTU in bytes discovered with the DF echoes (targets with pmtu)", names, l2)
+			ch <- prometheus.MustNewConstMetric(icmpPathMTUDesc, prometheus.GaugeValue, float64(metric.PathMTU), l...)
+		}
+
+		if len(metric.Windows) > 0 {
+			icmpWinLossDesc = prometheus.NewDesc("ping_window_loss_percent", "Packet loss in percent of the echoes sent within the window (icmp.windows)", append(names, "window"), l2)
+			icmpWinRttDesc = prometheus.NewDesc("ping_window_rtt_ewma_seconds", "Exponentially weighted moving average of the mean Round Trip Time in seconds with the window as time constant (icmp.windows)", append(names, "window"), l2)
+			for _, w := range metric.Windows {
+				lw := append(append([]string{}, l...), model.Duration(w.Window).String())
+				ch <- prometheus.MustNewConstMetric(icmpWinLossDesc, prometheus.GaugeValue, w.DropRate, lw...)
+				// Unknown until a reply was received
+				if w.RttValid {
+					ch <- prometheus.MustNewConstMetric(icmpWinRttDesc, prometheus.GaugeValue, w.RttEWMA.Seconds(), lw...)
+				}
+			}
+		}
+
+		if len(metric.Flows) > 0 {
+			icmpFlowRttDesc = prometheus.NewDesc("ping_flow_rtt_seconds", "Round Trip Time in seconds per flow", append(names, "flow", "type"), l2)
+			icmpFlowLossDesc = prometheus.NewDesc("ping_flow_loss_percent", "Packet loss in percent per flow", append(names, "flow"), l2)
+			for i, flow := range metric.Flows {
+				lf := append(append([]string{}, l...), strconv.Itoa(i))
+				ch <- prometheus.MustNewConstMetric(icmpFlowRttDesc, prometheus.GaugeValue, flow.BestTime.Seconds(), append(lf, "best")...)
+				ch <- prometheus.MustNewConstMetric(icmpFlowRttDesc, prometheus.GaugeValue, flow.AvgTime.Seconds(), append(lf, "mean")...)
+				ch <- prometheus.MustNewConstMetric(icmpFlowRttDesc, prometheus.GaugeValue, flow.WorstTime.Seconds(), append(lf, "worst")...)
+				ch <- prometheus.MustNewConstMetric(icmpFlowLossDesc, prometheus.GaugeValue, flow.DropRate, lf...)
+			}
+		}
+
+		if len(metric.Classes) > 0 {
+			icmpClassRttDesc = prometheus.NewDesc("ping_dscp_rtt_seconds", "Round Trip Time in seconds per DSCP class", append(names, "dscp", "type"), l2)
+			icmpClassLossDesc = prometheus.NewDesc("ping_dscp_loss_percent", "Packet loss in percent per DSCP class", append(names, "dscp"), l2)
+			icmpClassReplyDesc = prometheus.NewDesc("ping_dscp_reply_dscp", "DSCP of the last echo reply per DSCP class (remarking detection)", append(names, "dscp"), l2)
+			for _, class := range metric.Classes {
+				lc := append(append([]string{}, l...), strconv.Itoa(class.DSCP))
+				ch <- prometheus.MustNewConstMetric(icmpClassRttDesc, prometheus.GaugeValue, class.BestTime.Seconds(), append(lc, "best")...)
+				ch <- prometheus.MustNewConstMetric(icmpClassRttDesc, prometheus.GaugeValue, class.AvgTime.Seconds(), append(lc, "mean")...)
+				ch <- prometheus.MustNewConstMetric(icmpClassRttDesc, prometheus.GaugeValue, class.WorstTime.Seconds(), append(lc, "worst")...)
+				ch <- prometheus.MustNewConstMetric(icmpClassLossDesc, prometheus.GaugeValue, class.DropRate, lc...)
+				if class.ReplyDSCPValid {
+					ch <- prometheus.MustNewConstMetric(icmpClassReplyDesc, prometheus.GaugeValue, float64(class.ReplyDSCP), lc...)
+				}
+			}
+		}
 	}
 	ch <- prometheus.MustNewConstMetric(icmpTargetsDesc, prometheus.GaugeValue, float64(len(targets)))
 }
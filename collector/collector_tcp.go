@@ -5,21 +5,41 @@ import (
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
 	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/pkg/common"
 	"github.com/syepes/network_exporter/pkg/tcp"
 )
 
 var (
-	tcpLabelNames  = []string{"name", "target", "target_ip", "source_ip", "port"}
-	tcpTimeDesc    = prometheus.NewDesc("tcp_connection_seconds", "Connection time in seconds", tcpLabelNames, nil)
-	tcpStatusDesc  = prometheus.NewDesc("tcp_connection_status", "Connection Status", tcpLabelNames, nil)
-	tcpTargetsDesc = prometheus.NewDesc("tcp_targets", "Number of active targets", nil, nil)
-	tcpStateDesc   = prometheus.NewDesc("tcp_up", "Exporter state", nil, nil)
-	tcpMutex       = &sync.Mutex{}
+	tcpLabelNames    = []string{"name", "target", "target_ip", "source_ip", "port"}
+	tcpTimeDesc      = prometheus.NewDesc("tcp_connection_seconds", "Connection time in seconds", tcpLabelNames, nil)
+	tcpStatusDesc    = prometheus.NewDesc("tcp_connection_status", "Connection Status", tcpLabelNames, nil)
+	tcpFOUsedDesc    = prometheus.NewDesc("tcp_fast_open_used", "The data sent in the SYN (TCP Fast Open) was accepted by the peer", tcpLabelNames, nil)
+	tcpFOUnavDesc    = prometheus.NewDesc("tcp_fast_open_unavailable", "TCP Fast Open was requested but is not available on this platform", tcpLabelNames, nil)
+	tcpWarmupDesc    = prometheus.NewDesc("tcp_connection_warmup_failed", "Number of failed warm-up connections in the last cycle", tcpLabelNames, nil)
+	tcpResetDesc     = prometheus.NewDesc("tcp_connection_reset_after_connect", "The connection was accepted then closed/reset by the peer (nothing really listening)", tcpLabelNames, nil)
+	tcpRespDesc      = prometheus.NewDesc("tcp_response_bytes", "Bytes of the response read until the expected pattern matched or the read ended", tcpLabelNames, nil)
+	tcpExpectDesc    = prometheus.NewDesc("tcp_expect_matched", "The response matched the expected pattern", tcpLabelNames, nil)
+	tcpReuseDesc     = prometheus.NewDesc("tcp_connection_reuse", "The target keeps a persistent connection (reuse-connection) instead of connecting every cycle", tcpLabelNames, nil)
+	tcpReusedDesc    = prometheus.NewDesc("tcp_connection_reused", "The last round trip ran on the persistent connection (0 when it had to be re-established)", tcpLabelNames, nil)
+	tcpRoundTripDesc = prometheus.NewDesc("tcp_round_trip_seconds", "Time from the request (tcp-send) to the response in seconds", tcpLabelNames, nil)
+	tcpAttemptsDesc  = prometheus.NewDesc("tcp_connection_attempts_total", "Connects to the target port (warm-up connects excluded)", tcpLabelNames, nil)
+	tcpSuccessesDesc = prometheus.NewDesc("tcp_connection_successes_total", "Successful connects to the target port", tcpLabelNames, nil)
+	tcpHistogramDesc = prometheus.NewDesc("tcp_connection_histogram_seconds", "Connection time distribution of the successful connects in seconds (tcp.histogram-buckets)", tcpLabelNames, nil)
+	tcpProxyDesc     = prometheus.NewDesc("tcp_proxy_connection_seconds", "Connection time to the proxy in seconds (tcp_connection_seconds is end-to-end through the proxy)", tcpLabelNames, nil)
+	tcpTLSTimeDesc   = prometheus.NewDesc("tcp_tls_handshake_seconds", "TLS handshake time in seconds", tcpLabelNames, nil)
+	tcpTLSVerifyDesc = prometheus.NewDesc("tcp_tls_verified", "The certificate chain and name of the peer were verified", tcpLabelNames, nil)
+	tcpTLSAfterDesc  = prometheus.NewDesc("tcp_tls_cert_not_after", "Expiry (NotAfter) of the peer certificate in unix seconds", tcpLabelNames, nil)
+	tcpTLSBeforeDesc = prometheus.NewDesc("tcp_tls_cert_not_before", "Start of validity (NotBefore) of the peer certificate in unix seconds", tcpLabelNames, nil)
+	tcpTargetsDesc   = prometheus.NewDesc("tcp_targets", "Number of active targets", nil, nil)
+	tcpStateDesc     = prometheus.NewDesc("tcp_up", "Exporter state", nil, nil)
+	tcpMutex         = &sync.Mutex{}
 )
 
 // TCP prom
 type TCP struct {
+	SC      *config.SafeConfig
 	Monitor *monitor.TCPPort
 	metrics map[string]*tcp.TCPPortReturn
 	labels  map[string]map[string]string
@@ -29,6 +49,23 @@ type TCP struct {
 func (p *TCP) Describe(ch chan<- *prometheus.Desc) {
 	ch <- tcpTimeDesc
 	ch <- tcpStatusDesc
+	ch <- tcpFOUsedDesc
+	ch <- tcpFOUnavDesc
+	ch <- tcpWarmupDesc
+	ch <- tcpResetDesc
+	ch <- tcpRespDesc
+	ch <- tcpExpectDesc
+	ch <- tcpReuseDesc
+	ch <- tcpReusedDesc
+	ch <- tcpRoundTripDesc
+	ch <- tcpAttemptsDesc
+	ch <- tcpSuccessesDesc
+	ch <- tcpHistogramDesc
+	ch <- tcpProxyDesc
+	ch <- tcpTLSTimeDesc
+	ch <- tcpTLSVerifyDesc
+	ch <- tcpTLSAfterDesc
+	ch <- tcpTLSBeforeDesc
 	ch <- tcpTargetsDesc
 	ch <- tcpStateDesc
 }
@@ -38,13 +75,8 @@ func (p *TCP) Collect(ch chan<- prometheus.Metric) {
 	tcpMutex.Lock()
 	defer tcpMutex.Unlock()
 
-	if m := p.Monitor.ExportMetrics(); len(m) > 0 {
-		p.metrics = m
-	}
-
-	if l := p.Monitor.ExportLabels(); len(l) > 0 {
-		p.labels = l
-	}
+	p.metrics = p.Monitor.ExportMetrics()
+	p.labels = p.Monitor.ExportLabels()
 
 	if len(p.metrics) > 0 {
 		ch <- prometheus.MustNewConstMetric(tcpStateDesc, prometheus.GaugeValue, 1)
@@ -52,18 +84,25 @@ func (p *TCP) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(tcpStateDesc, prometheus.GaugeValue, 0)
 	}
 
+	p.SC.RLock()
+	allow := p.SC.Cfg.TCP.Labels
+	p.SC.RUnlock()
+
 	targets := []string{}
 	for target, metric := range p.metrics {
-		targets = append(targets, target)
+		// The ports of a multi-port target ("name ip port") count as a single target
+		targets = common.AppendIfMissing(targets, strings.Join(strings.SplitN(target, " ", 3)[:2], " "))
 		l := strings.SplitN(strings.SplitN(target, " ", 2)[0], " ", 2) // get name without ip and create slice
 		l = append(l, metric.DestAddr)
 		l = append(l, metric.DestIp)
 		l = append(l, metric.SrcIp)
 		l = append(l, metric.DestPort)
-		l2 := prometheus.Labels(p.labels[target])
+		names, l, l2 := filterLabels(allow, append([]string{"port"}, identityLabels...), tcpLabelNames, l, p.labels[target])
 
-		tcpTimeDesc = prometheus.NewDesc("tcp_connection_seconds", "Connection time in seconds", tcpLabelNames, l2)
-		tcpStatusDesc = prometheus.NewDesc("tcp_connection_status", "Connection Status", tcpLabelNames, l2)
+		tcpTimeDesc = prometheus.NewDesc("tcp_connection_seconds", "Connection time in seconds", names, l2)
+		tcpStatusDesc = prometheus.NewDesc("tcp_connection_status", "Connection Status", names, l2)
+		tcpAttemptsDesc = prometheus.NewDesc("tcp_connection_attempts_total", "Connects to the target port (warm-up connects excluded)", names, l2)
+		tcpSuccessesDesc = prometheus.NewDesc("tcp_connection_successes_total", "Successful connects to the target port", names, l2)
 
 		ch <- prometheus.MustNewConstMetric(tcpTimeDesc, prometheus.GaugeValue, metric.ConTime.Seconds(), l...)
 
@@ -72,6 +111,69 @@ func (p *TCP) Collect(ch chan<- prometheus.Metric) {
 		} else {
 			ch <- prometheus.MustNewConstMetric(tcpStatusDesc, prometheus.GaugeValue, 0, l...)
 		}
+		ch <- prometheus.MustNewConstMetric(tcpAttemptsDesc, prometheus.CounterValue, float64(metric.Attempts), l...)
+		ch <- prometheus.MustNewConstMetric(tcpSuccessesDesc, prometheus.CounterValue, float64(metric.Successes), l...)
+		if h := metric.ConHistogram; h != nil {
+			tcpHistogramDesc = prometheus.NewDesc("tcp_connection_histogram_seconds", "Connection time distribution of the successful connects in seconds (tcp.histogram-buckets)", names, l2)
+			ch <- prometheus.MustNewConstHistogram(tcpHistogramDesc, h.Count, h.Sum, h.Buckets(), l...)
+		}
+
+		if metric.FastOpenRequested {
+			tcpFOUsedDesc = prometheus.NewDesc("tcp_fast_open_used", "The data sent in the SYN (TCP Fast Open) was accepted by the peer", names, l2)
+			tcpFOUnavDesc = prometheus.NewDesc("tcp_fast_open_unavailable", "TCP Fast Open was requested but is not available on this platform", names, l2)
+			// Only known where TFO is available (TCP_INFO), tcp_fast_open_unavailable otherwise
+			if metric.FastOpenAvailable {
+				ch <- prometheus.MustNewConstMetric(tcpFOUsedDesc, prometheus.GaugeValue, bool2Float(metric.FastOpenUsed), l...)
+			}
+			ch <- prometheus.MustNewConstMetric(tcpFOUnavDesc, prometheus.GaugeValue, bool2Float(!metric.FastOpenAvailable), l...)
+		}
+
+		if metric.Proxied {
+			tcpProxyDesc = prometheus.NewDesc("tcp_proxy_connection_seconds", "Connection time to the proxy in seconds (tcp_connection_seconds is end-to-end through the proxy)", names, l2)
+			ch <- prometheus.MustNewConstMetric(tcpProxyDesc, prometheus.GaugeValue, metric.ProxyConTime.Seconds(), l...)
+		}
+
+		if metric.ResetChecked {
+			tcpResetDesc = prometheus.NewDesc("tcp_connection_reset_after_connect", "The connection was accepted then closed/reset by the peer (nothing really listening)", names, l2)
+			ch <- prometheus.MustNewConstMetric(tcpResetDesc, prometheus.GaugeValue, bool2Float(metric.ResetAfterConnect), l...)
+		}
+
+		if metric.ExpectChecked {
+			tcpRespDesc = prometheus.NewDesc("tcp_response_bytes", "Bytes of the response read until the expected pattern matched or the read ended", names, l2)
+			tcpExpectDesc = prometheus.NewDesc("tcp_expect_matched", "The response matched the expected pattern", names, l2)
+			ch <- prometheus.MustNewConstMetric(tcpRespDesc, prometheus.GaugeValue, float64(metric.ResponseBytes), l...)
+			ch <- prometheus.MustNewConstMetric(tcpExpectDesc, prometheus.GaugeValue, bool2Float(metric.ExpectMatched), l...)
+		}
+
+		tcpReuseDesc = prometheus.NewDesc("tcp_connection_reuse", "The target keeps a persistent connection (reuse-connection) instead of connecting every cycle", names, l2)
+		ch <- prometheus.MustNewConstMetric(tcpReuseDesc, prometheus.GaugeValue, bool2Float(metric.Reuse), l...)
+		if metric.Reuse {
+			tcpReusedDesc = prometheus.NewDesc("tcp_connection_reused", "The last round trip ran on the persistent connection (0 when it had to be re-established)", names, l2)
+			ch <- prometheus.MustNewConstMetric(tcpReusedDesc, prometheus.GaugeValue, bool2Float(metric.Reused), l...)
+		}
+		if metric.RoundTripChecked {
+			tcpRoundTripDesc = prometheus.NewDesc("tcp_round_trip_seconds", "Time from the request (tcp-send) to the response in seconds", names, l2)
+			ch <- prometheus.MustNewConstMetric(tcpRoundTripDesc, prometheus.GaugeValue, metric.RoundTrip.Seconds(), l...)
+		}
+
+		if t := metric.TLS; t != nil {
+			tcpTLSTimeDesc = prometheus.NewDesc("tcp_tls_handshake_seconds", "TLS handshake time in seconds", names, l2)
+			tcpTLSVerifyDesc = prometheus.NewDesc("tcp_tls_verified", "The certificate chain and name of the peer were verified", names, l2)
+			ch <- prometheus.MustNewConstMetric(tcpTLSTimeDesc, prometheus.GaugeValue, t.Handshake.Seconds(), l...)
+			ch <- prometheus.MustNewConstMetric(tcpTLSVerifyDesc, prometheus.GaugeValue, bool2Float(t.Verified), l...)
+			// Only completed handshakes have a certificate, their version and cipher are in network_exporter_tls_info
+			if !t.NotAfter.IsZero() {
+				tcpTLSAfterDesc = prometheus.NewDesc("tcp_tls_cert_not_after", "Expiry (NotAfter) of the peer certificate in unix seconds", names, l2)
+				tcpTLSBeforeDesc = prometheus.NewDesc("tcp_tls_cert_not_before", "Start of validity (NotBefore) of the peer certificate in unix seconds", names, l2)
+				ch <- prometheus.MustNewConstMetric(tcpTLSAfterDesc, prometheus.GaugeValue, float64(t.NotAfter.Unix()), l...)
+				ch <- prometheus.MustNewConstMetric(tcpTLSBeforeDesc, prometheus.GaugeValue, float64(t.NotBefore.Unix()), l...)
+			}
+		}
+
+		if metric.Warmup > 0 {
+			tcpWarmupDesc = prometheus.NewDesc("tcp_connection_warmup_failed", "Number of failed warm-up connections in the last cycle", names, l2)
+			ch <- prometheus.MustNewConstMetric(tcpWarmupDesc, prometheus.GaugeValue, float64(metric.WarmupFailed), l...)
+		}
 	}
 	ch <- prometheus.MustNewConstMetric(tcpTargetsDesc, prometheus.GaugeValue, float64(len(targets)))
 }
@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+// tcpListener local listener accepting and closing the connections of the TCP targets
+func tcpListener(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// loadConfig writes the configuration to the file and (re)loads it
+func loadConfig(t *testing.T, sc *config.SafeConfig, file string, cfg string) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.ReloadConfig(log.NewNopLogger(), file); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+}
+
+// waitFor polls the condition until it holds or the deadline passes
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// tcpTargetsConfig TCP section and targets probing the local listener
+func tcpTargetsConfig(addr string, names ...string) string {
+	s := "tcp:\n  interval: 100ms\n  timeout: 1s\ntargets:\n"
+	for _, n := range names {
+		s += fmt.Sprintf("  - name: %s\n    host: %s\n    type: TCP\n", n, addr)
+	}
+	return s
+}
+
+// gatherNames returns the name label of the series of the metric
+func gatherNames(t *testing.T, g prometheus.Gatherer, metric string) map[string]bool {
+	t.Helper()
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	names := map[string]bool{}
+	for _, mf := range mfs {
+		if mf.GetName() != metric {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "name" {
+					names[l.GetValue()] = true
+				}
+			}
+		}
+	}
+	return names
+}
+
+func TestTCPReloadDropsRemovedTargetSeries(t *testing.T) {
+	addr := tcpListener(t)
+	logger := log.NewNopLogger()
+	file := filepath.Join(t.TempDir(), "network_exporter.yml")
+	sc := &config.SafeConfig{Cfg: &config.Config{}}
+	loadConfig(t, sc, file, tcpTargetsConfig(addr, "kept", "removed"))
+
+	resolver := &config.Resolver{Resolver: net.DefaultResolver, Timeout: time.Second}
+	m := monitor.NewTCPPort(logger, sc, resolver, common.NewSemaphore(0), common.NewPools(nil), common.NewLoadShedder(0, 0, 0))
+	defer m.Stop()
+	m.AddTargets()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&TCP{SC: sc, Monitor: m})
+
+	if !waitFor(5*time.Second, func() bool { return len(gatherNames(t, reg, "tcp_connection_status")) == 2 }) {
+		t.Fatalf("targets not probed: %v", gatherNames(t, reg, "tcp_connection_status"))
+	}
+
+	loadConfig(t, sc, file, tcpTargetsConfig(addr, "kept"))
+	resolver.NewPass()
+	m.DelTargets()
+	_ = m.CheckActiveTargets()
+	m.AddTargets()
+
+	names := gatherNames(t, reg, "tcp_connection_status")
+	if names["removed"] {
+		t.Errorf("series of the removed target still exported after the reload: %v", names)
+	}
+	if !names["kept"] {
+		t.Errorf("series of the kept target missing after the reload: %v", names)
+	}
+}
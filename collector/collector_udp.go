@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/pkg/udp"
+)
+
+var (
+	udpLabelNames  = []string{"name", "target", "target_ip", "source_ip", "port"}
+	udpStatusDesc  = prometheus.NewDesc("udp_status", "Check status (response received and matching udp-expect)", udpLabelNames, nil)
+	udpRttDesc     = prometheus.NewDesc("udp_rtt_seconds", "Round Trip Time in seconds (0 without accepted response)", udpLabelNames, nil)
+	udpBytesDesc   = prometheus.NewDesc("udp_response_bytes", "Size of the last response datagram", udpLabelNames, nil)
+	udpFailureDesc = prometheus.NewDesc("udp_failure", "Failure reason of the check (timeout|refused|mismatch|error)", append(udpLabelNames, "reason"), nil)
+	udpTargetsDesc = prometheus.NewDesc("udp_targets", "Number of active targets", nil, nil)
+	udpStateDesc   = prometheus.NewDesc("udp_up", "Exporter state", nil, nil)
+	udpMutex       = &sync.Mutex{}
+)
+
+// UDP prom
+type UDP struct {
+	SC      *config.SafeConfig
+	Monitor *monitor.UDP
+	metrics map[string]*udp.UDPReturn
+	labels  map[string]map[string]string
+}
+
+// Describe prom
+func (p *UDP) Describe(ch chan<- *prometheus.Desc) {
+	ch <- udpStatusDesc
+	ch <- udpRttDesc
+	ch <- udpBytesDesc
+	ch <- udpFailureDesc
+	ch <- udpTargetsDesc
+	ch <- udpStateDesc
+}
+
+// Collect prom
+func (p *UDP) Collect(ch chan<- prometheus.Metric) {
+	udpMutex.Lock()
+	defer udpMutex.Unlock()
+
+	p.metrics = p.Monitor.ExportMetrics()
+	p.labels = p.Monitor.ExportLabels()
+
+	if len(p.metrics) > 0 {
+		ch <- prometheus.MustNewConstMetric(udpStateDesc, prometheus.GaugeValue, 1)
+	} else {
+		ch <- prometheus.MustNewConstMetric(udpStateDesc, prometheus.GaugeValue, 0)
+	}
+
+	p.SC.RLock()
+	allow := p.SC.Cfg.UDP.Labels
+	p.SC.RUnlock()
+
+	targets := []string{}
+	for target, metric := range p.metrics {
+		targets = append(targets, target)
+		l := []string{strings.SplitN(target, " ", 2)[0], metric.DestAddr, metric.DestIp, metric.SrcIp, metric.DestPort}
+		names, l, l2 := filterLabels(allow, append([]string{"port"}, identityLabels...), udpLabelNames, l, p.labels[target])
+
+		udpStatusDesc = prometheus.NewDesc("udp_status", "Check status (response received and matching udp-expect)", names, l2)
+		udpRttDesc = prometheus.NewDesc("udp_rtt_seconds", "Round Trip Time in seconds (0 without accepted response)", names, l2)
+		udpBytesDesc = prometheus.NewDesc("udp_response_bytes", "Size of the last response datagram", names, l2)
+		udpFailureDesc = prometheus.NewDesc("udp_failure", "Failure reason of the check (timeout|refused|mismatch|error)", append(names, "reason"), l2)
+
+		ch <- prometheus.MustNewConstMetric(udpStatusDesc, prometheus.GaugeValue, bool2Float(metric.Success), l...)
+		ch <- prometheus.MustNewConstMetric(udpRttDesc, prometheus.GaugeValue, metric.Rtt.Seconds(), l...)
+		ch <- prometheus.MustNewConstMetric(udpBytesDesc, prometheus.GaugeValue, float64(metric.ResponseBytes), l...)
+		for _, reason := range udp.Reasons {
+			ch <- prometheus.MustNewConstMetric(udpFailureDesc, prometheus.GaugeValue, bool2Float(metric.Reason == reason), append(l, reason)...)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(udpTargetsDesc, prometheus.GaugeValue, float64(len(targets)))
+}
@@ -1,10 +1,18 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"math"
+	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,49 +21,217 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/dns"
+	"github.com/syepes/network_exporter/pkg/http"
+	"github.com/syepes/network_exporter/pkg/mtr"
+	"github.com/syepes/network_exporter/pkg/owd"
+	"github.com/syepes/network_exporter/pkg/ping"
+	"github.com/syepes/network_exporter/pkg/tcp"
+	"github.com/syepes/network_exporter/pkg/udp"
 
 	yaml "gopkg.in/yaml.v3"
 )
 
 // Config represents configuration for the exporter
 
-type Targets []struct {
+type Targets []Target
+
+// Target a probed target of the configuration
+type Target struct {
 	Name     string   `yaml:"name" json:"name"`
 	Host     string   `yaml:"host" json:"host"`
 	Type     string   `yaml:"type" json:"type"`
 	Proxy    string   `yaml:"proxy" json:"proxy"`
 	Probe    []string `yaml:"probe" json:"probe"`
+	Alias    string   `yaml:"alias" json:"alias"`
 	SourceIp string   `yaml:"source_ip" json:"source_ip"`
-	Labels   extraKV  `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Priority int      `yaml:"priority" json:"priority"`
+	FastOpen bool     `yaml:"tcp-fast-open" json:"tcp-fast-open"`
+	Warmup   int      `yaml:"warmup" json:"warmup"`
+
+	InterPacketInterval duration `yaml:"inter-packet-interval" json:"inter-packet-interval"`
+	AdaptiveTimeout     duration `yaml:"adaptive-timeout" json:"adaptive-timeout"`
+	RecheckLoss         float64  `yaml:"recheck-loss" json:"recheck-loss"`
+	ResetWait           duration `yaml:"tcp-reset-wait" json:"tcp-reset-wait"`
+	SourcePort          string   `yaml:"source-port" json:"source-port"`
+	Flows               int      `yaml:"flows" json:"flows"`
+	DSCP                int      `yaml:"dscp" json:"dscp"`
+	DSCPClasses         []int    `yaml:"dscp-classes" json:"dscp-classes"`
+	ExpectedHops        int      `yaml:"expected-hops" json:"expected-hops"`
+	InitialTTL          int      `yaml:"initial-ttl" json:"initial-ttl"`
+	IcmpMode            string   `yaml:"icmp-mode" json:"icmp-mode"`
+	PMTU                bool     `yaml:"pmtu" json:"pmtu"`
+	Burst               bool     `yaml:"burst" json:"burst"`
+	SloRtt              duration `yaml:"slo-rtt" json:"slo-rtt"`
+	DependsOn           string   `yaml:"depends-on" json:"depends-on"`
+	MtrWindow           int      `yaml:"mtr-window" json:"mtr-window"`
+	MtrProtocol         string   `yaml:"mtr-protocol" json:"mtr-protocol"`
+	MtrPort             int      `yaml:"mtr-port" json:"mtr-port"`
+	BindDevice          string   `yaml:"bind-device" json:"bind-device"`
+	FwMark              int      `yaml:"fwmark" json:"fwmark"`
+	SourceInterface     string   `yaml:"source_interface" json:"source_interface"`
+	TCPSend             string   `yaml:"tcp-send" json:"tcp-send"`
+	TCPExpect           string   `yaml:"tcp-expect" json:"tcp-expect"`
+	UDPSend             string   `yaml:"udp-send" json:"udp-send"`
+	UDPSendHex          string   `yaml:"udp-send-hex" json:"udp-send-hex"`
+	UDPExpect           string   `yaml:"udp-expect" json:"udp-expect"`
+	ReuseConnection     bool     `yaml:"reuse-connection" json:"reuse-connection"`
+	TLS                 bool     `yaml:"tls" json:"tls"`
+	TLSServerName       string   `yaml:"tls-server-name" json:"tls-server-name"`
+	TLSSkipVerify       bool     `yaml:"tls-insecure-skip-verify" json:"tls-insecure-skip-verify"`
+	UpPolicy            string   `yaml:"up-policy" json:"up-policy"`
+	Pool                string   `yaml:"pool" json:"pool"`
+	ExpectedStatus      []string `yaml:"expected-status" json:"expected-status"`
+	HTTPMethod          string   `yaml:"http-method" json:"http-method"`
+	DNSType             string   `yaml:"dns-type" json:"dns-type"`
+	DNSServer           string   `yaml:"dns-server" json:"dns-server"`
+	Resolve             string   `yaml:"resolve" json:"resolve"`
+	IPVersion           string   `yaml:"ip-version" json:"ip-version"`
+	Exclude             []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	Labels              extraKV  `yaml:"labels,omitempty" json:"labels,omitempty"`
+
+	// Maintenance windows during which the target is not probed
+	DisableSchedule []common.ScheduleEntry `yaml:"disable_schedule,omitempty" json:"disable_schedule,omitempty"`
+
+	// Overrides of the protocol settings (icmp, mtr, tcp, udp), unset keeps the global ones
+	Interval durationRange `yaml:"interval" json:"interval"`
+	Timeout  duration      `yaml:"timeout" json:"timeout"`
+	Count    int           `yaml:"count" json:"count"`
+	MaxHops  int           `yaml:"max-hops" json:"max-hops"`
+}
+
+type Graphite struct {
+	Address  string   `yaml:"address" json:"address"`
+	Prefix   string   `yaml:"prefix" json:"prefix" default:"network_exporter"`
+	Interval duration `yaml:"interval" json:"interval" default:"60s"`
+}
+
+type ARP struct {
+	Interval durationRange `yaml:"interval" json:"interval" default:"5s"`
+	Timeout  duration      `yaml:"timeout" json:"timeout" default:"1s"`
+	Labels   []string      `yaml:"labels" json:"labels"`
+}
+
+type OWD struct {
+	Interval durationRange `yaml:"interval" json:"interval" default:"15s"`
+	Timeout  duration      `yaml:"timeout" json:"timeout" default:"1s"`
+	Count    int           `yaml:"count" json:"count" default:"10"`
+	Listen   string        `yaml:"listen" json:"listen"`
+	Labels   []string      `yaml:"labels" json:"labels"`
+}
+
+type DNS struct {
+	Interval durationRange `yaml:"interval" json:"interval" default:"15s"`
+	Timeout  duration      `yaml:"timeout" json:"timeout" default:"2s"`
+	Labels   []string      `yaml:"labels" json:"labels"`
+}
+
+type UDP struct {
+	Interval durationRange `yaml:"interval" json:"interval" default:"5s"`
+	Timeout  duration      `yaml:"timeout" json:"timeout" default:"4s"`
+	Labels   []string      `yaml:"labels" json:"labels"`
 }
 
 type HTTPGet struct {
-	Interval duration `yaml:"interval" json:"interval" default:"15s"`
-	Timeout  duration `yaml:"timeout" json:"timeout" default:"14s"`
+	Interval durationRange `yaml:"interval" json:"interval" default:"15s"`
+	Timeout  duration      `yaml:"timeout" json:"timeout" default:"14s"`
+	Labels   []string      `yaml:"labels" json:"labels"`
 }
 
 type TCP struct {
-	Interval duration `yaml:"interval" json:"interval" default:"5s"`
-	Timeout  duration `yaml:"timeout" json:"timeout" default:"4s"`
+	Interval   durationRange `yaml:"interval" json:"interval" default:"5s"`
+	Timeout    duration      `yaml:"timeout" json:"timeout" default:"4s"`
+	SourcePort string        `yaml:"source-port" json:"source-port"`
+	DSCP       int           `yaml:"dscp" json:"dscp"`
+	Labels     []string      `yaml:"labels" json:"labels"`
+
+	HistogramBuckets []float64 `yaml:"histogram-buckets" json:"histogram-buckets"`
 }
 
 type MTR struct {
-	Interval duration `yaml:"interval" json:"interval" default:"5s"`
-	Timeout  duration `yaml:"timeout" json:"timeout" default:"4s"`
-	MaxHops  int      `yaml:"max-hops" json:"max-hops" default:"30"`
-	Count    int      `yaml:"count" json:"count" default:"10"`
+	Interval durationRange `yaml:"interval" json:"interval" default:"5s"`
+	Timeout  duration      `yaml:"timeout" json:"timeout" default:"4s"`
+	MaxHops  int           `yaml:"max-hops" json:"max-hops" default:"30"`
+	Count    int           `yaml:"count" json:"count" default:"10"`
+	Protocol string        `yaml:"protocol" json:"protocol"`
+	Port     int           `yaml:"port" json:"port"`
+	DSCP     int           `yaml:"dscp" json:"dscp"`
+	Labels   []string      `yaml:"labels" json:"labels"`
+
+	ResolveHops    bool     `yaml:"resolve-hops" json:"resolve-hops"`
+	ResolveTimeout duration `yaml:"resolve-timeout" json:"resolve-timeout" default:"500ms"`
 }
 
 type ICMP struct {
-	Interval duration `yaml:"interval" json:"interval" default:"5s"`
-	Timeout  duration `yaml:"timeout" json:"timeout" default:"4s"`
-	Count    int      `yaml:"count" json:"count" default:"10"`
+	Interval            durationRange `yaml:"interval" json:"interval" default:"5s"`
+	Timeout             duration      `yaml:"timeout" json:"timeout" default:"4s"`
+	Count               int           `yaml:"count" json:"count" default:"10"`
+	InterPacketInterval duration      `yaml:"inter-packet-interval" json:"inter-packet-interval" default:"0s"`
+	RandomPayload       bool          `yaml:"random-payload" json:"random-payload"`
+	DSCP                int           `yaml:"dscp" json:"dscp"`
+	Labels              []string      `yaml:"labels" json:"labels"`
+
+	HistogramBuckets []float64  `yaml:"histogram-buckets" json:"histogram-buckets"`
+	Windows          []duration `yaml:"windows" json:"windows"`
+
+	PMTUMax     int      `yaml:"pmtu-max" json:"pmtu-max" default:"1500"`
+	PMTUTimeout duration `yaml:"pmtu-timeout" json:"pmtu-timeout" default:"1s"`
+
+	RecheckLoss     float64  `yaml:"recheck-loss" json:"recheck-loss" default:"0"`
+	RecheckInterval duration `yaml:"recheck-interval" json:"recheck-interval" default:"1s"`
+	RecheckCount    int      `yaml:"recheck-count" json:"recheck-count" default:"20"`
+	RecheckRecover  int      `yaml:"recheck-recover" json:"recheck-recover" default:"3"`
+}
+
+// WindowDurations returns the aggregation windows of the ICMP targets
+func (c ICMP) WindowDurations() []time.Duration {
+	windows := make([]time.Duration, len(c.Windows))
+	for i, w := range c.Windows {
+		windows[i] = w.Duration()
+	}
+	return windows
 }
 
 type Conf struct {
 	Refresh           duration `yaml:"refresh" json:"refresh" default:"0s"`
+	ResolveRefresh    duration `yaml:"resolve-refresh" json:"resolve-refresh" default:"0s"`
 	Nameserver        string   `yaml:"nameserver" json:"nameserver"`
 	NameserverTimeout duration `yaml:"nameserver_timeout" json:"nameserver_timeout" default:"250ms"`
+	NameserverNoHosts bool     `yaml:"nameserver_no_hosts" json:"nameserver_no_hosts"`
+	TimeoutJitter     duration `yaml:"timeout-jitter" json:"timeout-jitter" default:"0s"`
+	HostsFile         string   `yaml:"hosts_file" json:"hosts_file"`
+	GeoIPAsnDB        string   `yaml:"geoip_asn_db" json:"geoip_asn_db"`
+	GeoIPCountryDB    string   `yaml:"geoip_country_db" json:"geoip_country_db"`
+	MaxConcurrency    int      `yaml:"max-concurrency" json:"max-concurrency" default:"0"`
+	MaxPps            int      `yaml:"max-pps" json:"max-pps" default:"0"`
+	StartSpread       bool     `yaml:"start-spread" json:"start-spread"`
+	MaxTargets        int      `yaml:"max-targets" json:"max-targets" default:"0"`
+	MaxTargetsMode    string   `yaml:"max-targets-mode" json:"max-targets-mode" default:"warn"`
+
+	ResolverBreakerThreshold int      `yaml:"resolver-breaker-threshold" json:"resolver-breaker-threshold" default:"0"`
+	ResolverBreakerWindow    duration `yaml:"resolver-breaker-window" json:"resolver-breaker-window" default:"30s"`
+	ResolverBreakerCooldown  duration `yaml:"resolver-breaker-cooldown" json:"resolver-breaker-cooldown" default:"30s"`
+
+	LoadShedLag      duration `yaml:"load-shed-lag" json:"load-shed-lag" default:"0s"`
+	LoadShedFraction float64  `yaml:"load-shed-fraction" json:"load-shed-fraction" default:"0.5"`
+	LoadShedPriority int      `yaml:"load-shed-priority" json:"load-shed-priority" default:"0"`
+
+	ConcurrencyPools map[string]int `yaml:"concurrency-pools" json:"concurrency-pools"`
+
+	QualityScore     bool               `yaml:"quality-score" json:"quality-score"`
+	QualityWeights   map[string]float64 `yaml:"quality-weights" json:"quality-weights"`
+	QualityJitterMax duration           `yaml:"quality-jitter-max" json:"quality-jitter-max" default:"50ms"`
+
+	SkipUnavailableFamilies bool   `yaml:"skip-unavailable-families" json:"skip-unavailable-families"`
+	IPVersion               string `yaml:"ip-version" json:"ip-version"`
+
+	Privileged *bool `yaml:"privileged" json:"privileged"`
+
+	SourceIp        string `yaml:"source_ip" json:"source_ip"`
+	SourceInterface string `yaml:"source_interface" json:"source_interface"`
+
+	NamePattern     string `yaml:"name-pattern" json:"name-pattern"`
+	NamePatternMode string `yaml:"name-pattern-mode" json:"name-pattern-mode" default:"fail"`
 }
 
 type Config struct {
@@ -63,12 +239,28 @@ type Config struct {
 	ICMP    `yaml:"icmp" json:"icmp"`
 	MTR     `yaml:"mtr" json:"mtr"`
 	TCP     `yaml:"tcp" json:"tcp"`
+	UDP     `yaml:"udp" json:"udp"`
 	HTTPGet `yaml:"http_get" json:"http_get"`
+	ARP     `yaml:"arp" json:"arp"`
+	OWD     `yaml:"owd" json:"owd"`
+	DNS     `yaml:"dns" json:"dns"`
 	Targets `yaml:"targets" json:"targets"`
+
+	Graphite   `yaml:"graphite" json:"graphite"`
+	Consul     `yaml:"consul" json:"consul"`
+	Kubernetes `yaml:"kubernetes" json:"kubernetes"`
+
+	MetricRelabel []RelabelConfig `yaml:"metric_relabel" json:"metric_relabel"`
 }
 
 type duration time.Duration
 
+// durationRange a duration or a range of durations (e.g. 9s-11s) from which each cycle picks a random interval
+type durationRange struct {
+	min time.Duration
+	max time.Duration
+}
+
 type extraKV struct {
 	Kv map[string]string `yaml:"kv,omitempty" json:"kv,omitempty"`
 }
@@ -80,51 +272,419 @@ func (b *extraKV) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // SafeConfig Safe configuration reload
 type Resolver struct {
-	Resolver *net.Resolver
-	Timeout  time.Duration
+	Resolver  *net.Resolver
+	HostsFile string
+	Breaker   *common.Breaker
+	GeoIP     *common.GeoIP
+	Timeout   time.Duration
+
+	mtx     sync.Mutex
+	pass    map[string]*passLookup
+	last    map[string][]string
+	changes map[string]uint64
+}
+
+// passLookup Resolution of a host shared by the monitors during a refresh pass
+type passLookup struct {
+	done  chan struct{}
+	addrs []string
+	err   error
+}
+
+// NewPass starts a refresh pass, the hosts are resolved again on their next lookup
+func (r *Resolver) NewPass() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.pass = nil
+}
+
+// PassAddrs resolves the host once per refresh pass, the ICMP and MTR sub-probes of an ICMP+MTR target get the same addresses
+// Concurrent lookups of the same host wait for the first one, failed lookups are not kept
+// The transient failures (timeout, circuit open) return the last resolved addresses so the running targets aren't removed
+func (r *Resolver) PassAddrs(host string, mode string) ([]string, error) {
+	key := mode + " " + host
+	r.mtx.Lock()
+	if r.pass == nil {
+		r.pass = map[string]*passLookup{}
+	}
+	l, found := r.pass[key]
+	if !found {
+		l = &passLookup{done: make(chan struct{})}
+		r.pass[key] = l
+	}
+	r.mtx.Unlock()
+
+	if found {
+		<-l.done
+		return l.addrs, l.err
+	}
+
+	l.addrs, l.err = common.DestAddrsMode(context.Background(), host, mode, r.Resolver, r.HostsFile, r.Breaker, r.Timeout)
+	r.mtx.Lock()
+	if l.err != nil {
+		if r.pass[key] == l {
+			delete(r.pass, key)
+		}
+		// A transient failure keeps the last resolved addresses, the host still exists
+		var rerr *common.ResolveError
+		if last, found := r.last[key]; found && errors.As(l.err, &rerr) && rerr.Reason != common.ResolveErrNotFound && rerr.Reason != common.ResolveErrNoAddress {
+			l.addrs, l.err = last, nil
+		}
+	} else {
+		if r.last == nil {
+			r.last, r.changes = map[string][]string{}, map[string]uint64{}
+		}
+		if last, found := r.last[key]; found && !sameAddrs(last, l.addrs) {
+			r.changes[host]++
+		}
+		r.last[key] = l.addrs
+	}
+	r.mtx.Unlock()
+	close(l.done)
+	return l.addrs, l.err
 }
 
+// ResolveChanges returns the number of times the resolved addresses of each host changed
+func (r *Resolver) ResolveChanges() map[string]uint64 {
+	if r == nil {
+		return nil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	out := make(map[string]uint64, len(r.changes))
+	for h, n := range r.changes {
+		out[h] = n
+	}
+	return out
+}
+
+// sameAddrs reports if both lists hold the same addresses (in any order)
+func sameAddrs(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, v := range a {
+		seen[v]++
+	}
+	for _, v := range b {
+		if seen[v] == 0 {
+			return false
+		}
+		seen[v]--
+	}
+	return true
+}
+
+// Overrides Command-line values taking precedence over the configuration file (zero values are ignored)
+type Overrides struct {
+	ICMPInterval    time.Duration
+	ICMPTimeout     time.Duration
+	ICMPCount       int
+	MTRInterval     time.Duration
+	MTRTimeout      time.Duration
+	MTRMaxHops      int
+	MTRCount        int
+	TCPInterval     time.Duration
+	TCPTimeout      time.Duration
+	HTTPGetInterval time.Duration
+	HTTPGetTimeout  time.Duration
+}
+
+// reservedLabels Label names of the series that the target labels can't shadow
+var reservedLabels = map[string]bool{
+	"name": true, "target": true, "target_ip": true, "source_ip": true, "type": true, "port": true, "protocol": true,
+	"ttl": true, "path": true, "hostname": true, "device": true, "server": true, "record_type": true, "version": true,
+	"cipher": true, "server_name": true, "reason": true, "flow": true, "dscp": true, "expected_hops": true,
+	"depends_on": true, "ip_version": true, "le": true, "quantile": true, "window": true,
+}
+
+// reservedNames Target names shadowed by the sub-paths of /metrics (the /metrics/<name> of a target)
+var reservedNames = map[string]bool{"influx": true}
+
 // SafeConfig Safe configuration reload
 type SafeConfig struct {
-	Cfg *Config
+	Cfg            *Config
+	Overrides      *Overrides
+	ReadRetries    int
+	ReadRetryDelay time.Duration
 	sync.RWMutex
+
+	// Last reload duration and the cumulative targets added/removed by the reloads
+	reloadDuration time.Duration
+	targetsAdded   uint64
+	targetsRemoved uint64
+	loaded         bool
+
+	// Outcome of the last reload (file or env) and the time of the last successful one
+	reloadSuccess bool
+	reloadTime    time.Time
+
+	// Last applied configuration and the targets of the discovery sources merged into it
+	raw             *yaml.Node
+	discovered      map[string]Targets
+	discoveryErrors map[string]uint64
+}
+
+// readConfigFile reads the configuration file retrying (with a jittered backoff) on I/O errors
+// A missing file is not retried, the parsing errors are left to the caller
+func (sc *SafeConfig) readConfigFile(logger log.Logger, confFile string) ([]byte, error) {
+	delay := sc.ReadRetryDelay
+	for attempt := 0; ; attempt++ {
+		b, err := os.ReadFile(confFile)
+		if err == nil || errors.Is(err, fs.ErrNotExist) || attempt >= sc.ReadRetries {
+			return b, err
+		}
+
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		level.Warn(logger).Log("type", "Config", "func", "readConfigFile", "msg", fmt.Sprintf("Reading config file failed, retrying in %s (%d/%d)", wait, attempt+1, sc.ReadRetries), "err", err)
+		time.Sleep(wait)
+		delay *= 2
+	}
 }
 
 // ReloadConfig Safe configuration reload
 func (sc *SafeConfig) ReloadConfig(logger log.Logger, confFile string) (err error) {
+	defer func() { sc.setReloadStatus(err) }()
+	start := time.Now()
+	b, err := sc.readConfigFile(logger, confFile)
+	if err != nil {
+		return fmt.Errorf("reading config file: %s", err)
+	}
+	root, err := loadDocument(confFile, filepath.Dir(confFile), b)
+	if err != nil {
+		return err
+	}
+	return sc.applyConfig(logger, start, root)
+}
+
+// ReloadConfigEnv is ReloadConfig reading the configuration (JSON or YAML) from an environment variable
+// A list is taken as the targets, the rest of the configuration keeps its defaults
+func (sc *SafeConfig) ReloadConfigEnv(logger log.Logger, envVar string) (err error) {
+	defer func() { sc.setReloadStatus(err) }()
+	start := time.Now()
+	v, found := os.LookupEnv(envVar)
+	if !found {
+		return fmt.Errorf("reading config env: %s is not set", envVar)
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(v), &node); err != nil {
+		return fmt.Errorf("parsing config env: %s", err)
+	}
+	b := []byte(v)
+	if len(node.Content) == 1 && node.Content[0].Kind == yaml.SequenceNode {
+		if b, err = yaml.Marshal(map[string]*yaml.Node{"targets": node.Content[0]}); err != nil {
+			return fmt.Errorf("parsing config env: %s", err)
+		}
+	}
+	// The includes of the env configuration are relative to the working directory
+	root, err := loadDocument(envVar, ".", b)
+	if err != nil {
+		return err
+	}
+	return sc.applyConfig(logger, start, root)
+}
+
+// applyConfig parses, validates and swaps the configuration
+func (sc *SafeConfig) applyConfig(logger log.Logger, start time.Time, root *yaml.Node) error {
+	c, err := sc.parseConfig(logger, root)
+	if err != nil {
+		return err
+	}
+
+	sc.Lock()
+	// The initial load is not counted as added targets
+	if sc.loaded {
+		added, removed := diffTargets(sc.Cfg.Targets, c.Targets)
+		sc.targetsAdded += uint64(added)
+		sc.targetsRemoved += uint64(removed)
+	}
+	sc.Cfg = c
+	sc.raw = root
+	sc.loaded = true
+	sc.reloadDuration = time.Since(start)
+	sc.Unlock()
+
+	return nil
+}
+
+// ReloadStats returns the duration of the last reload and the cumulative targets added/removed by the reloads
+func (sc *SafeConfig) ReloadStats() (duration time.Duration, added uint64, removed uint64) {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.reloadDuration, sc.targetsAdded, sc.targetsRemoved
+}
+
+// setReloadStatus records the outcome of a reload
+func (sc *SafeConfig) setReloadStatus(err error) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.reloadSuccess = err == nil
+	if err == nil {
+		sc.reloadTime = time.Now()
+	}
+}
+
+// ReloadStatus returns if the last reload succeeded and the time of the last successful one
+func (sc *SafeConfig) ReloadStatus() (success bool, last time.Time) {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.reloadSuccess, sc.reloadTime
+}
+
+// SetDiscovered replaces the targets of a discovery source and applies them with the last configuration
+// On error the previous targets of the source are kept
+func (sc *SafeConfig) SetDiscovered(logger log.Logger, source string, targets Targets) error {
+	start := time.Now()
+	sc.Lock()
+	if !sc.loaded {
+		sc.Unlock()
+		return fmt.Errorf("no configuration loaded")
+	}
+	if sc.discovered == nil {
+		sc.discovered = map[string]Targets{}
+	}
+	prev, found := sc.discovered[source]
+	sc.discovered[source] = targets
+	root := sc.raw
+	sc.Unlock()
+
+	if err := sc.applyConfig(logger, start, root); err != nil {
+		sc.Lock()
+		if found {
+			sc.discovered[source] = prev
+		} else {
+			delete(sc.discovered, source)
+		}
+		sc.Unlock()
+		return err
+	}
+	return nil
+}
+
+// DiscoveryFailed accounts a failed refresh of a discovery source
+func (sc *SafeConfig) DiscoveryFailed(source string) {
+	sc.Lock()
+	defer sc.Unlock()
+	if sc.discoveryErrors == nil {
+		sc.discoveryErrors = map[string]uint64{}
+	}
+	sc.discoveryErrors[source]++
+}
+
+// DiscoveryStats returns the number of targets and the failed refreshes of the discovery sources
+func (sc *SafeConfig) DiscoveryStats() (targets map[string]int, failures map[string]uint64) {
+	sc.RLock()
+	defer sc.RUnlock()
+	targets = make(map[string]int, len(sc.discovered))
+	for s, t := range sc.discovered {
+		targets[s] = len(t)
+	}
+	failures = make(map[string]uint64, len(sc.discoveryErrors))
+	for s, n := range sc.discoveryErrors {
+		failures[s] = n
+	}
+	return targets, failures
+}
+
+// discoveredTargets returns the targets of all the discovery sources
+func (sc *SafeConfig) discoveredTargets() Targets {
+	sc.RLock()
+	defer sc.RUnlock()
+	sources := make([]string, 0, len(sc.discovered))
+	for s := range sc.discovered {
+		sources = append(sources, s)
+	}
+	sort.Strings(sources)
+	targets := Targets{}
+	for _, s := range sources {
+		targets = append(targets, sc.discovered[s]...)
+	}
+	return targets
+}
+
+// diffTargets counts the targets (by type, name and host) only present in the new or the old configuration
+func diffTargets(prev Targets, next Targets) (added int, removed int) {
+	seen := make(map[string]int, len(prev))
+	for _, t := range prev {
+		seen[t.Type+" "+t.Name+" "+t.Host]++
+	}
+	for _, t := range next {
+		if k := t.Type + " " + t.Name + " " + t.Host; seen[k] > 0 {
+			seen[k]--
+		} else {
+			added++
+		}
+	}
+	for _, n := range seen {
+		removed += n
+	}
+	return added, removed
+}
+
+// ValidateConfig runs the ReloadConfig parsing and validation on a proposed configuration without applying it
+// The includes are relative to the directory of confFile
+func (sc *SafeConfig) ValidateConfig(logger log.Logger, confFile string, b []byte) error {
+	root, err := loadDocument(confFile, filepath.Dir(confFile), b)
+	if err != nil {
+		return err
+	}
+	_, err = sc.parseConfig(logger, root)
+	return err
+}
+
+// parseConfig decodes, sets the defaults and validates a configuration
+func (sc *SafeConfig) parseConfig(logger log.Logger, root *yaml.Node) (*Config, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		panic(err)
 	}
 
+	// The merged document keeps the lines of the files, an empty document has no root
 	var c = &Config{}
-	f, err := os.Open(confFile)
-	if err != nil {
-		return fmt.Errorf("reading config file: %s", err)
+	if root != nil {
+		if err = root.Decode(c); err != nil {
+			return nil, fmt.Errorf("parsing config file: %s", err)
+		}
 	}
-	defer f.Close()
 
-	decoder := yaml.NewDecoder(f)
-	if err = decoder.Decode(c); err != nil {
-		return fmt.Errorf("parsing config file: %s", err)
+	if err := defaults.Set(c); err != nil {
+		return nil, fmt.Errorf("setting defaults: %s", err)
 	}
 
-	if err := defaults.Set(c); err != nil {
-		return fmt.Errorf("setting defaults: %s", err)
+	if sc.Overrides != nil {
+		for _, o := range sc.Overrides.Apply(c) {
+			level.Info(logger).Log("type", "Config", "func", "ReloadConfig", "msg", fmt.Sprintf("Flag takes precedence over the config file: %s", o))
+		}
+	}
+
+	// The discovered targets are filtered and validated as the static ones
+	c.Targets = append(c.Targets, sc.discoveredTargets()...)
+
+	// The CIDR and range hosts are expanded first, the expanded targets are validated and filtered as the others
+	if c.Targets, err = expandTargets(c.Targets); err != nil {
+		return nil, fmt.Errorf("parsing config file: %s", err)
+	}
+
+	// The dependencies are checked on all the targets (they may be probed by another instance)
+	if err = HasDependencyCycle(c.Targets); err != nil {
+		return nil, fmt.Errorf("parsing config file: %s", err)
 	}
 
 	// Validate and Filter config
 	targets := Targets{}
-	re := regexp.MustCompile("^ICMP|MTR|ICMP+MTR|TCP|HTTPGet$")
+	re := regexp.MustCompile("^ICMP|MTR|ICMP+MTR|TCP|UDP|HTTPGet|ARP|OWD|DNS$")
 	for _, t := range c.Targets {
-		if common.SrvRecordCheck(t.Host) {
+		// The DNS targets query the SRV records themselves
+		if t.Type != "DNS" && common.SrvRecordCheck(t.Host) {
 			found := re.MatchString(t.Type)
 			if !found {
-				level.Error(logger).Log("type", "Config", "func", "ReloadConfig", "msg", fmt.Sprintf("Target '%s' has unknown check type '%s' must be one of (ICMP|MTR|ICMP+MTR|TCP|HTTPGet)", t.Name, t.Type))
+				level.Error(logger).Log("type", "Config", "func", "ReloadConfig", "msg", fmt.Sprintf("Target '%s' has unknown check type '%s' must be one of (ICMP|MTR|ICMP+MTR|TCP|UDP|HTTPGet|ARP|OWD|DNS)", t.Name, t.Type))
 				continue
 			}
-			// Check that SRV record's type is TCP, if config's type is TCP
-			if t.Type == "TCP" {
+			// Check that SRV record's type is TCP/UDP, if config's type is TCP/UDP
+			if t.Type == "TCP" || t.Type == "UDP" {
 				if !strings.EqualFold(t.Type, strings.Split(t.Host, ".")[1][1:]) {
 					level.Error(logger).Log("type", "Config", "func", "ReloadConfig", "msg", fmt.Sprintf("Target %s type '%s' doesn't match SRV record proto '%s'", t.Name, t.Type, strings.Split(t.Host, ".")[1][1:]))
 					continue
@@ -157,7 +717,7 @@ func (sc *SafeConfig) ReloadConfig(logger log.Logger, confFile string) (err erro
 		} else {
 			found := re.MatchString(t.Type)
 			if !found {
-				level.Error(logger).Log("type", "Config", "func", "ReloadConfig", "msg", "Target '%s' has unknown check type '%s' must be one of (ICMP|MTR|ICMP+MTR|TCP|HTTPGet)", t.Name, t.Type)
+				level.Error(logger).Log("type", "Config", "func", "ReloadConfig", "msg", "Target '%s' has unknown check type '%s' must be one of (ICMP|MTR|ICMP+MTR|TCP|UDP|HTTPGet|ARP|OWD|DNS)", t.Name, t.Type)
 				continue
 			}
 
@@ -179,25 +739,600 @@ func (sc *SafeConfig) ReloadConfig(logger log.Logger, confFile string) (err erro
 	c.Targets = targets
 
 	if _, err = HasDuplicateTargets(c.Targets); err != nil {
-		return fmt.Errorf("parsing config file: %s", err)
+		return nil, fmt.Errorf("parsing config file: %s", err)
+	}
+
+	// The target labels are attached to all the series of the target, an invalid or duplicated label name would fail the scrapes
+	labelRe := regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+	for _, t := range c.Targets {
+		if reservedNames[t.Name] {
+			return nil, fmt.Errorf("target name '%s' is reserved (/metrics/%s)", t.Name, t.Name)
+		}
+		for k := range t.Labels.Kv {
+			if !labelRe.MatchString(k) || strings.HasPrefix(k, "__") {
+				return nil, fmt.Errorf("target %s label '%s' must match %s and not start with __", t.Name, k, labelRe)
+			}
+			if reservedLabels[k] {
+				return nil, fmt.Errorf("target %s label '%s' is reserved by the series", t.Name, k)
+			}
+		}
+	}
+
+	// The label allowlists can only keep the labels of the series, the target labels and the generated ones (alias, GeoIP, discovery)
+	known := map[string]bool{
+		"alias": true, "asn": true, "as_name": true, "country": true, "consul_service": true, "consul_node": true,
+		"kubernetes_node": true, "kubernetes_namespace": true, "kubernetes_pod": true,
+	}
+	for k := range reservedLabels {
+		known[k] = true
+	}
+	for _, t := range c.Targets {
+		for k := range t.Labels.Kv {
+			known[k] = true
+		}
+	}
+	for _, s := range c.Consul.Services {
+		for k := range s.Labels.Kv {
+			known[k] = true
+		}
+	}
+	for _, r := range c.Kubernetes.Roles {
+		for k := range r.Labels.Kv {
+			known[k] = true
+		}
+	}
+	for _, a := range []struct {
+		section string
+		labels  []string
+	}{{"icmp", c.ICMP.Labels}, {"mtr", c.MTR.Labels}, {"tcp", c.TCP.Labels}, {"udp", c.UDP.Labels}, {"http_get", c.HTTPGet.Labels}, {"dns", c.DNS.Labels}, {"arp", c.ARP.Labels}, {"owd", c.OWD.Labels}} {
+		for _, k := range a.labels {
+			if !known[k] {
+				return nil, fmt.Errorf("%s.labels '%s' is not a label of the series or of the targets", a.section, k)
+			}
+		}
+	}
+
+	// The alias is attached to the target series as an extra label
+	for i := range c.Targets {
+		t := &c.Targets[i]
+		if t.Alias == "" {
+			continue
+		}
+		if !labelRe.MatchString(t.Alias) {
+			return nil, fmt.Errorf("target %s alias '%s' must match %s", t.Name, t.Alias, labelRe)
+		}
+		if _, found := t.Labels.Kv["alias"]; found {
+			return nil, fmt.Errorf("target %s has both an alias and an alias label", t.Name)
+		}
+		kv := map[string]string{"alias": t.Alias}
+		for k, v := range t.Labels.Kv {
+			kv[k] = v
+		}
+		t.Labels.Kv = kv
+	}
+
+	// The address families probed (ip-version of the target, otherwise the global one) select the records used to resolve the host
+	if !validIPVersion(c.Conf.IPVersion) {
+		return nil, fmt.Errorf("conf.ip-version must be one of (4|6|both)")
+	}
+	if c.Conf.SourceIp != "" && net.ParseIP(c.Conf.SourceIp) == nil {
+		return nil, fmt.Errorf("conf.source_ip %s is not a valid ip", c.Conf.SourceIp)
+	}
+	if c.Conf.SourceInterface != "" && runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("conf.source_interface is only supported on linux")
+	}
+	for i := range c.Targets {
+		t := &c.Targets[i]
+		if !validIPVersion(t.IPVersion) {
+			return nil, fmt.Errorf("target %s ip-version must be one of (4|6|both)", t.Name)
+		}
+		probed := t.Type == "ICMP" || t.Type == "MTR" || t.Type == "ICMP+MTR" || t.Type == "TCP" || t.Type == "UDP"
+		if t.IPVersion != "" && !probed {
+			return nil, fmt.Errorf("target %s ip-version is only supported by the ICMP, MTR, TCP and UDP targets", t.Name)
+		}
+		if t.IPVersion != "" && t.Resolve != "" {
+			return nil, fmt.Errorf("target %s has both resolve and ip-version", t.Name)
+		}
+		if t.Resolve != "" || !probed {
+			continue
+		}
+		if t.IPVersion != "" {
+			t.Resolve = IPVersionResolve(t.IPVersion)
+		} else {
+			t.Resolve = IPVersionResolve(c.Conf.IPVersion)
+		}
 	}
 
 	// Config precheck
-	if c.ICMP.Interval <= 0 || c.MTR.Interval <= 0 || c.TCP.Interval <= 0 || c.HTTPGet.Interval <= 0 {
-		return fmt.Errorf("intervals (icmp,mtr,tcp,http_get) must be >0")
+	if c.ICMP.Interval.Duration() <= 0 || c.MTR.Interval.Duration() <= 0 || c.TCP.Interval.Duration() <= 0 || c.UDP.Interval.Duration() <= 0 || c.HTTPGet.Interval.Duration() <= 0 || c.ARP.Interval.Duration() <= 0 || c.OWD.Interval.Duration() <= 0 || c.DNS.Interval.Duration() <= 0 {
+		return nil, fmt.Errorf("intervals (icmp,mtr,tcp,udp,http_get,arp,owd,dns) must be >0")
 	}
 	if c.MTR.MaxHops < 0 || c.MTR.MaxHops > 65500 {
-		return fmt.Errorf("mtr.max-hops must be between 0 and 65500")
+		return nil, fmt.Errorf("mtr.max-hops must be between 0 and 65500")
 	}
 	if c.MTR.Count < 0 || c.MTR.Count > 65500 {
-		return fmt.Errorf("mtr.count must be between 0 and 65500")
+		return nil, fmt.Errorf("mtr.count must be between 0 and 65500")
+	}
+	if !mtr.ValidProtocol(c.MTR.Protocol) || c.MTR.Port < 0 || c.MTR.Port > 65535 {
+		return nil, fmt.Errorf("mtr.protocol must be one of (icmp|tcp|udp) and mtr.port between 0 and 65535")
+	}
+	if c.MTR.Protocol != "" && c.MTR.Protocol != mtr.ProtocolICMP && runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("mtr.protocol %s is only supported on linux", c.MTR.Protocol)
+	}
+	if c.ICMP.DSCP < 0 || c.ICMP.DSCP > 63 || c.MTR.DSCP < 0 || c.MTR.DSCP > 63 || c.TCP.DSCP < 0 || c.TCP.DSCP > 63 {
+		return nil, fmt.Errorf("icmp.dscp, mtr.dscp and tcp.dscp must be between 0 and 63")
+	}
+	if c.TCP.DSCP != 0 && runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("tcp.dscp is only supported on linux")
+	}
+	if c.ICMP.InterPacketInterval < 0 {
+		return nil, fmt.Errorf("icmp.inter-packet-interval must be >=0")
+	}
+	if err := common.ValidateBuckets(c.ICMP.HistogramBuckets); err != nil {
+		return nil, fmt.Errorf("icmp.histogram-buckets: %w", err)
+	}
+	if c.ICMP.PMTUMax < 1280 || c.ICMP.PMTUMax > 65535 {
+		return nil, fmt.Errorf("icmp.pmtu-max must be between 1280 and 65535")
+	}
+	if c.ICMP.PMTUTimeout <= 0 {
+		return nil, fmt.Errorf("icmp.pmtu-timeout must be >0")
+	}
+	if err := common.ValidateWindows(c.ICMP.WindowDurations()); err != nil {
+		return nil, fmt.Errorf("icmp.windows: %w", err)
+	}
+	if c.ICMP.RecheckLoss < 0 || c.ICMP.RecheckLoss > 1 {
+		return nil, fmt.Errorf("icmp.recheck-loss must be between 0 and 1")
+	}
+	if c.ICMP.RecheckInterval <= 0 {
+		return nil, fmt.Errorf("icmp.recheck-interval must be >0")
+	}
+	if c.ICMP.RecheckCount < 1 || c.ICMP.RecheckCount > 65500 {
+		return nil, fmt.Errorf("icmp.recheck-count must be between 1 and 65500")
+	}
+	if c.ICMP.RecheckRecover < 1 {
+		return nil, fmt.Errorf("icmp.recheck-recover must be >=1")
+	}
+	if err := common.ValidateBuckets(c.TCP.HistogramBuckets); err != nil {
+		return nil, fmt.Errorf("tcp.histogram-buckets: %w", err)
+	}
+	if c.OWD.Count < 1 || c.OWD.Count > 1000 {
+		return nil, fmt.Errorf("owd.count must be between 1 and 1000")
+	}
+	if time.Duration(c.OWD.Count)*(c.OWD.Timeout.Duration()+owd.PacketSpacing) > c.OWD.Interval.Duration() {
+		return nil, fmt.Errorf("owd.count %d * (owd.timeout %s + %s) exceeds owd.interval %s", c.OWD.Count, c.OWD.Timeout.Duration(), owd.PacketSpacing, c.OWD.Interval.Duration())
+	}
+	if c.OWD.Listen != "" {
+		if _, err := net.ResolveUDPAddr("udp", c.OWD.Listen); err != nil {
+			return nil, fmt.Errorf("owd.listen: %w", err)
+		}
+	}
+	for _, t := range c.Targets {
+		if _, err := common.NewSchedule(t.DisableSchedule); err != nil {
+			return nil, fmt.Errorf("target %s disable_schedule: %s", t.Name, err)
+		}
+		if t.Interval.Duration() < 0 || t.Timeout < 0 {
+			return nil, fmt.Errorf("target %s interval and timeout must be >=0", t.Name)
+		}
+		if (t.Interval.Duration() > 0 || t.Timeout > 0) && t.Type != "ICMP" && t.Type != "MTR" && t.Type != "ICMP+MTR" && t.Type != "TCP" && t.Type != "UDP" && t.Type != "HTTPGet" {
+			return nil, fmt.Errorf("target %s interval and timeout are only supported by the ICMP, MTR, TCP, UDP and HTTPGet targets", t.Name)
+		}
+		if t.Timeout > 0 && c.Conf.TimeoutJitter > t.Timeout/2 {
+			return nil, fmt.Errorf("target %s timeout must be at least twice conf.timeout-jitter", t.Name)
+		}
+		if t.Count < 0 || t.Count > 65500 {
+			return nil, fmt.Errorf("target %s count must be between 0 and 65500", t.Name)
+		}
+		if t.Count > 0 && t.Type != "ICMP" && t.Type != "MTR" && t.Type != "ICMP+MTR" {
+			return nil, fmt.Errorf("target %s count is only supported by the ICMP and MTR targets", t.Name)
+		}
+		if t.MaxHops < 0 || t.MaxHops > 65500 {
+			return nil, fmt.Errorf("target %s max-hops must be between 0 and 65500", t.Name)
+		}
+		if t.MaxHops > 0 && t.Type != "MTR" && t.Type != "ICMP+MTR" {
+			return nil, fmt.Errorf("target %s max-hops is only supported by the MTR targets", t.Name)
+		}
+		if t.DSCP < 0 || t.DSCP > 63 {
+			return nil, fmt.Errorf("target %s dscp must be between 0 and 63", t.Name)
+		}
+		if t.DSCP != 0 && t.Type != "ICMP" && t.Type != "MTR" && t.Type != "ICMP+MTR" && t.Type != "TCP" {
+			return nil, fmt.Errorf("target %s dscp is only supported by the ICMP, MTR and TCP targets", t.Name)
+		}
+		if t.DSCP != 0 && t.Type == "TCP" && runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("target %s dscp of the TCP targets is only supported on linux", t.Name)
+		}
+	}
+	for _, t := range c.Targets {
+		if t.Type != "ICMP" && t.Type != "ICMP+MTR" {
+			continue
+		}
+		count, timeout := c.ICMP.Count, c.ICMP.Timeout
+		if t.Count > 0 {
+			count = t.Count
+		}
+		if t.Timeout > 0 {
+			timeout = t.Timeout
+		}
+		ipi := c.ICMP.InterPacketInterval
+		if t.InterPacketInterval != 0 {
+			ipi = t.InterPacketInterval
+		}
+		if ipi < 0 {
+			return nil, fmt.Errorf("target %s inter-packet-interval must be >=0", t.Name)
+		}
+		if len(t.DSCPClasses) > 0 {
+			if len(t.DSCPClasses) > ping.MaxDSCPClasses {
+				return nil, fmt.Errorf("target %s dscp-classes supports at most %d classes", t.Name, ping.MaxDSCPClasses)
+			}
+			if t.DSCP != 0 || t.Flows > 1 {
+				return nil, fmt.Errorf("target %s dscp-classes can't be combined with dscp or flows", t.Name)
+			}
+			if count*len(t.DSCPClasses) > ping.MaxClassPackets {
+				return nil, fmt.Errorf("target %s dscp-classes %d * count %d exceeds %d echoes per cycle", t.Name, len(t.DSCPClasses), count, ping.MaxClassPackets)
+			}
+			seen := map[int]bool{}
+			for _, d := range t.DSCPClasses {
+				if d < 0 || d > 63 {
+					return nil, fmt.Errorf("target %s dscp-classes must be between 0 and 63", t.Name)
+				}
+				if seen[d] {
+					return nil, fmt.Errorf("target %s dscp-classes has the duplicated class %d", t.Name, d)
+				}
+				seen[d] = true
+			}
+		}
+		if t.AdaptiveTimeout != 0 && t.AdaptiveTimeout < timeout {
+			return nil, fmt.Errorf("target %s adaptive-timeout must be >= its timeout", t.Name)
+		}
+		if t.RecheckLoss < 0 || t.RecheckLoss > 1 {
+			return nil, fmt.Errorf("target %s recheck-loss must be between 0 and 1", t.Name)
+		}
+		if t.RecheckLoss > 0 || c.ICMP.RecheckLoss > 0 {
+			interval := c.ICMP.Interval
+			if t.Interval.Duration() > 0 {
+				interval = t.Interval
+			}
+			if c.ICMP.RecheckInterval.Duration() >= interval.Duration() {
+				return nil, fmt.Errorf("target %s icmp.recheck-interval %s must be below its interval %s", t.Name, c.ICMP.RecheckInterval.Duration(), interval.Duration())
+			}
+			if len(t.DSCPClasses) > 0 && c.ICMP.RecheckCount*len(t.DSCPClasses) > ping.MaxClassPackets {
+				return nil, fmt.Errorf("target %s dscp-classes %d * icmp.recheck-count %d exceeds %d echoes per cycle", t.Name, len(t.DSCPClasses), c.ICMP.RecheckCount, ping.MaxClassPackets)
+			}
+		}
+		if t.ExpectedHops < 0 || t.ExpectedHops > 255 {
+			return nil, fmt.Errorf("target %s expected-hops must be between 0 and 255", t.Name)
+		}
+		if t.InitialTTL != 0 {
+			if t.ExpectedHops == 0 {
+				return nil, fmt.Errorf("target %s initial-ttl requires expected-hops", t.Name)
+			}
+			if t.InitialTTL < 1 || t.InitialTTL > 255 {
+				return nil, fmt.Errorf("target %s initial-ttl must be between 1 and 255", t.Name)
+			}
+			if t.ExpectedHops > t.InitialTTL {
+				return nil, fmt.Errorf("target %s expected-hops %d exceeds initial-ttl %d", t.Name, t.ExpectedHops, t.InitialTTL)
+			}
+		}
+		if t.IcmpMode != "" && t.IcmpMode != "echo" && t.IcmpMode != "timestamp" {
+			return nil, fmt.Errorf("target %s icmp-mode must be echo or timestamp", t.Name)
+		}
+		if t.Flows < 0 || t.Flows > ping.MaxFlows {
+			return nil, fmt.Errorf("target %s flows must be between 0 and %d", t.Name, ping.MaxFlows)
+		}
+		if ipi > 0 && ipi.Duration()*time.Duration(count) > timeout.Duration() {
+			return nil, fmt.Errorf("target %s inter-packet-interval %s * count %d exceeds timeout %s", t.Name, ipi.Duration(), count, timeout.Duration())
+		}
+	}
+	httpMethodRe := regexp.MustCompile("^(GET|HEAD)$")
+	for _, t := range c.Targets {
+		if t.SloRtt.Duration() < 0 {
+			return nil, fmt.Errorf("target %s slo-rtt must be >=0", t.Name)
+		}
+		if !mtr.ValidProtocol(t.MtrProtocol) || t.MtrPort < 0 || t.MtrPort > 65535 {
+			return nil, fmt.Errorf("target %s mtr-protocol must be one of (icmp|tcp|udp) and mtr-port between 0 and 65535", t.Name)
+		}
+		if (t.MtrProtocol != "" || t.MtrPort > 0) && t.Type != "MTR" && t.Type != "ICMP+MTR" {
+			return nil, fmt.Errorf("target %s mtr-protocol and mtr-port are only supported by the MTR targets", t.Name)
+		}
+		if protocol := t.MtrProtocol; t.MtrPort > 0 && (protocol == mtr.ProtocolICMP || (protocol == "" && (c.MTR.Protocol == "" || c.MTR.Protocol == mtr.ProtocolICMP))) {
+			return nil, fmt.Errorf("target %s mtr-port requires the tcp or udp mtr-protocol (or mtr.protocol)", t.Name)
+		}
+		if t.MtrProtocol != "" && t.MtrProtocol != mtr.ProtocolICMP && runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("target %s mtr-protocol %s is only supported on linux", t.Name, t.MtrProtocol)
+		}
+		if t.MtrWindow < 0 || t.MtrWindow > mtr.MaxWindow {
+			return nil, fmt.Errorf("target %s mtr-window must be between 0 and %d", t.Name, mtr.MaxWindow)
+		}
+		if t.PMTU && t.Type != "ICMP" && t.Type != "ICMP+MTR" {
+			return nil, fmt.Errorf("target %s pmtu is only supported by the ICMP targets", t.Name)
+		}
+		if t.Burst && t.Type != "ICMP" && t.Type != "ICMP+MTR" {
+			return nil, fmt.Errorf("target %s burst is only supported by the ICMP targets", t.Name)
+		}
+		if t.PMTU && runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("target %s pmtu is only supported on linux", t.Name)
+		}
+		if t.Type == "ARP" && runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("target %s type ARP is only supported on linux", t.Name)
+		}
+		if t.Type == "ARP" && t.BindDevice == "" {
+			return nil, fmt.Errorf("target %s type ARP requires a bind-device", t.Name)
+		}
+		if t.FwMark != 0 {
+			if t.FwMark < 0 || int64(t.FwMark) > math.MaxUint32 {
+				return nil, fmt.Errorf("target %s fwmark must be between 0 and %#x", t.Name, uint32(math.MaxUint32))
+			}
+			if t.Type != "ICMP" && t.Type != "MTR" && t.Type != "ICMP+MTR" && t.Type != "TCP" {
+				return nil, fmt.Errorf("target %s fwmark is only supported by the ICMP, MTR and TCP targets", t.Name)
+			}
+			if runtime.GOOS != "linux" {
+				return nil, fmt.Errorf("target %s fwmark is only supported on linux", t.Name)
+			}
+		}
+		if t.SourceIp != "" && net.ParseIP(t.SourceIp) == nil {
+			return nil, fmt.Errorf("target %s source_ip %s is not a valid ip", t.Name, t.SourceIp)
+		}
+		if t.SourceInterface != "" {
+			if t.Type != "ICMP" && t.Type != "MTR" && t.Type != "ICMP+MTR" && t.Type != "TCP" {
+				return nil, fmt.Errorf("target %s source_interface is only supported by the ICMP, MTR and TCP targets", t.Name)
+			}
+			if runtime.GOOS != "linux" {
+				return nil, fmt.Errorf("target %s source_interface is only supported on linux", t.Name)
+			}
+		}
+		if !common.ValidResolveMode(t.Resolve) {
+			return nil, fmt.Errorf("target %s resolve must be one of (ip|ipv4|ipv6|cname)", t.Name)
+		}
+		if t.Type == "OWD" {
+			if _, port, err := net.SplitHostPort(t.Host); err != nil || port == "" {
+				return nil, fmt.Errorf("target %s type OWD requires a host:port (address of the peer responder)", t.Name)
+			}
+		}
+		if t.Type == "UDP" {
+			if _, port, err := net.SplitHostPort(t.Host); err != nil || port == "" {
+				return nil, fmt.Errorf("target %s type UDP requires a host:port", t.Name)
+			}
+		}
+		if (t.DNSType != "" || t.DNSServer != "") && t.Type != "DNS" {
+			return nil, fmt.Errorf("target %s dns-type and dns-server are only supported by the DNS targets", t.Name)
+		}
+		if !dns.ValidType(t.DNSType) {
+			return nil, fmt.Errorf("target %s dns-type must be one of (A|AAAA|CNAME|SRV)", t.Name)
+		}
+		if t.HTTPMethod != "" && (t.Type != "HTTPGet" || !httpMethodRe.MatchString(t.HTTPMethod)) {
+			return nil, fmt.Errorf("target %s http-method is only supported by the HTTPGet targets and must be one of (GET|HEAD)", t.Name)
+		}
+		if _, err := http.ParseStatusSpec(t.ExpectedStatus); err != nil {
+			return nil, fmt.Errorf("target %s expected-status: %w", t.Name, err)
+		}
+		if _, err := tcp.ParseSourcePorts(t.SourcePort); err != nil {
+			return nil, fmt.Errorf("target %s source-port: %w", t.Name, err)
+		}
+		if conn := strings.Split(t.Host, ":"); t.Type == "TCP" && len(conn) == 2 {
+			if _, err := tcp.ParsePorts(conn[1]); err != nil {
+				return nil, fmt.Errorf("target %s ports: %w", t.Name, err)
+			}
+		}
+		if _, found := c.Conf.ConcurrencyPools[t.Pool]; t.Pool != "" && !found {
+			return nil, fmt.Errorf("target %s pool %s is not defined in conf.concurrency-pools", t.Name, t.Pool)
+		}
+		if _, err := ping.ParseUpPolicy(t.UpPolicy); err != nil {
+			return nil, fmt.Errorf("target %s up-policy: %w", t.Name, err)
+		}
+		if t.UpPolicy != "" && t.Type != "ICMP" && t.Type != "ICMP+MTR" {
+			return nil, fmt.Errorf("target %s up-policy is only supported by the ICMP targets", t.Name)
+		}
+		if (t.TCPSend != "" || t.TCPExpect != "") && t.Type != "TCP" {
+			return nil, fmt.Errorf("target %s tcp-send and tcp-expect are only supported by the TCP targets", t.Name)
+		}
+		if _, err := regexp.Compile(t.TCPExpect); err != nil {
+			return nil, fmt.Errorf("target %s tcp-expect: %w", t.Name, err)
+		}
+		if t.Warmup < 0 || t.Warmup > tcp.MaxWarmup {
+			return nil, fmt.Errorf("target %s warmup must be between 0 and %d", t.Name, tcp.MaxWarmup)
+		}
+		if t.TLS && t.Type != "TCP" {
+			return nil, fmt.Errorf("target %s tls is only supported by the TCP targets", t.Name)
+		}
+		if (t.TLSServerName != "" || t.TLSSkipVerify) && !t.TLS {
+			return nil, fmt.Errorf("target %s tls-server-name and tls-insecure-skip-verify require tls", t.Name)
+		}
+		if (t.UDPSend != "" || t.UDPSendHex != "" || t.UDPExpect != "") && t.Type != "UDP" {
+			return nil, fmt.Errorf("target %s udp-send, udp-send-hex and udp-expect are only supported by the UDP targets", t.Name)
+		}
+		if t.UDPSend != "" && t.UDPSendHex != "" {
+			return nil, fmt.Errorf("target %s has both udp-send and udp-send-hex", t.Name)
+		}
+		if _, err := udp.Payload(t.UDPSend, t.UDPSendHex); err != nil {
+			return nil, fmt.Errorf("target %s udp-send-hex: %w", t.Name, err)
+		}
+		if _, err := regexp.Compile(t.UDPExpect); err != nil {
+			return nil, fmt.Errorf("target %s udp-expect: %w", t.Name, err)
+		}
+		if t.ResetWait.Duration() < 0 {
+			return nil, fmt.Errorf("target %s tcp-reset-wait must be >=0", t.Name)
+		}
+		if t.ReuseConnection && (t.Type != "TCP" || t.TCPSend == "" || t.ResetWait.Duration() > 0) {
+			return nil, fmt.Errorf("target %s reuse-connection is only supported by the TCP targets, requires tcp-send (the round trip request) and excludes tcp-reset-wait", t.Name)
+		}
+	}
+	if _, err := tcp.ParseSourcePorts(c.TCP.SourcePort); err != nil {
+		return nil, fmt.Errorf("tcp.source-port: %w", err)
+	}
+	if c.Conf.MaxConcurrency < 0 {
+		return nil, fmt.Errorf("conf.max-concurrency must be >=0")
+	}
+	for _, timeout := range []duration{c.ICMP.Timeout, c.MTR.Timeout, c.TCP.Timeout, c.UDP.Timeout, c.HTTPGet.Timeout, c.ARP.Timeout, c.OWD.Timeout, c.DNS.Timeout} {
+		if c.Conf.TimeoutJitter < 0 || c.Conf.TimeoutJitter > timeout/2 {
+			return nil, fmt.Errorf("conf.timeout-jitter must be between 0 and half of the timeouts (icmp,mtr,tcp,udp,http_get,arp,owd,dns)")
+		}
+	}
+	if c.Conf.MaxPps < 0 {
+		return nil, fmt.Errorf("conf.max-pps must be >=0")
+	}
+	if c.Conf.LoadShedLag.Duration() < 0 {
+		return nil, fmt.Errorf("conf.load-shed-lag must be >=0")
+	}
+	if c.Conf.LoadShedFraction < 0 || c.Conf.LoadShedFraction > 1 {
+		return nil, fmt.Errorf("conf.load-shed-fraction must be between 0 and 1")
+	}
+	if c.MTR.ResolveHops && c.MTR.ResolveTimeout.Duration() <= 0 {
+		return nil, fmt.Errorf("mtr.resolve-timeout must be >0")
+	}
+	if c.Conf.ResolverBreakerThreshold < 0 {
+		return nil, fmt.Errorf("conf.resolver-breaker-threshold must be >=0")
+	}
+	for k, w := range c.Conf.QualityWeights {
+		if k != "loss" && k != "latency" && k != "jitter" {
+			return nil, fmt.Errorf("conf.quality-weights %s must be one of (loss|latency|jitter)", k)
+		}
+		if w < 0 {
+			return nil, fmt.Errorf("conf.quality-weights %s must be >=0", k)
+		}
+	}
+	if c.Conf.QualityJitterMax.Duration() <= 0 {
+		return nil, fmt.Errorf("conf.quality-jitter-max must be >0")
+	}
+	for name, size := range c.Conf.ConcurrencyPools {
+		if size <= 0 || name == "global" {
+			return nil, fmt.Errorf("conf.concurrency-pools %s must be >0 (global is reserved)", name)
+		}
+	}
+	if c.Conf.MaxTargets < 0 {
+		return nil, fmt.Errorf("conf.max-targets must be >=0")
+	}
+	if c.Graphite.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Graphite.Address); err != nil {
+			return nil, fmt.Errorf("graphite.address must be host:port: %s", err)
+		}
+		if c.Graphite.Interval <= 0 {
+			return nil, fmt.Errorf("graphite.interval must be >0")
+		}
+	}
+	if err := c.Consul.validate(); err != nil {
+		return nil, err
+	}
+	if err := c.Kubernetes.validate(); err != nil {
+		return nil, err
+	}
+	for i := range c.MetricRelabel {
+		if err := c.MetricRelabel[i].compile(); err != nil {
+			return nil, fmt.Errorf("metric_relabel[%d]: %s", i, err)
+		}
+	}
+	if c.Conf.MaxTargetsMode != "warn" && c.Conf.MaxTargetsMode != "fail" {
+		return nil, fmt.Errorf("conf.max-targets-mode must be one of (warn|fail)")
+	}
+	if c.Conf.NamePatternMode != "warn" && c.Conf.NamePatternMode != "fail" {
+		return nil, fmt.Errorf("conf.name-pattern-mode must be one of (warn|fail)")
+	}
+	if c.Conf.NamePattern != "" {
+		re, err := regexp.Compile("^(?:" + c.Conf.NamePattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("conf.name-pattern: %w", err)
+		}
+		invalid := []string{}
+		for _, t := range c.Targets {
+			if !re.MatchString(t.Name) {
+				invalid = common.AppendIfMissing(invalid, t.Name)
+			}
+		}
+		if len(invalid) > 0 {
+			if c.Conf.NamePatternMode == "fail" {
+				return nil, fmt.Errorf("target names %s don't match conf.name-pattern %s", strings.Join(invalid, ", "), c.Conf.NamePattern)
+			}
+			level.Warn(logger).Log("type", "Config", "func", "ReloadConfig", "msg", fmt.Sprintf("Target names %s don't match conf.name-pattern %s", strings.Join(invalid, ", "), c.Conf.NamePattern))
+		}
+	}
+	if c.MaxTargetsExceeded() {
+		if c.Conf.MaxTargetsMode == "fail" {
+			return nil, fmt.Errorf("number of targets %d exceeds conf.max-targets %d", len(c.Targets), c.Conf.MaxTargets)
+		}
+		level.Warn(logger).Log("type", "Config", "func", "ReloadConfig", "msg", fmt.Sprintf("!!! Number of targets %d exceeds conf.max-targets %d, this probe instance is oversubscribed !!!", len(c.Targets), c.Conf.MaxTargets))
 	}
 
-	sc.Lock()
-	sc.Cfg = c
-	sc.Unlock()
+	return c, nil
+}
 
-	return nil
+// PathQualityWeights returns the weights of the path quality score (conf.quality-weights), the unset weights are 0
+// Without conf.quality-weights the loss counts 50%, the latency 30% and the jitter 20%
+func (c *Config) PathQualityWeights() common.QualityWeights {
+	if c.Conf.QualityWeights == nil {
+		return common.QualityWeights{Loss: 0.5, Latency: 0.3, Jitter: 0.2}
+	}
+	return common.QualityWeights{Loss: c.Conf.QualityWeights["loss"], Latency: c.Conf.QualityWeights["latency"], Jitter: c.Conf.QualityWeights["jitter"]}
+}
+
+// Schedules returns the maintenance windows of the targets by type and name (TYPE/name), the ICMP+MTR targets have both
+func (c *Config) Schedules() map[string]*common.Schedule {
+	schedules := map[string]*common.Schedule{}
+	for _, t := range c.Targets {
+		s, err := common.NewSchedule(t.DisableSchedule)
+		if err != nil || s == nil {
+			continue
+		}
+		types := []string{t.Type}
+		if t.Type == "ICMP+MTR" {
+			types = []string{"ICMP", "MTR"}
+		}
+		for _, typ := range types {
+			schedules[typ+"/"+t.Name] = s
+		}
+	}
+	return schedules
+}
+
+// MaxTargetsExceeded the number of targets is above the conf.max-targets soft limit
+func (c *Config) MaxTargetsExceeded() bool {
+	return c.Conf.MaxTargets > 0 && len(c.Targets) > c.Conf.MaxTargets
+}
+
+// Apply sets the overridden values on the config and returns the list of the applied overrides
+func (o *Overrides) Apply(c *Config) []string {
+	applied := []string{}
+	setDuration := func(name string, dst durationSetter, v time.Duration) {
+		if v > 0 {
+			applied = append(applied, fmt.Sprintf("%s=%s (config: %s)", name, v, dst.Duration()))
+			dst.Set(v)
+		}
+	}
+	setInt := func(name string, dst *int, v int) {
+		if v > 0 {
+			applied = append(applied, fmt.Sprintf("%s=%d (config: %d)", name, v, *dst))
+			*dst = v
+		}
+	}
+
+	setDuration("icmp.interval", &c.ICMP.Interval, o.ICMPInterval)
+	setDuration("icmp.timeout", &c.ICMP.Timeout, o.ICMPTimeout)
+	setInt("icmp.count", &c.ICMP.Count, o.ICMPCount)
+	setDuration("mtr.interval", &c.MTR.Interval, o.MTRInterval)
+	setDuration("mtr.timeout", &c.MTR.Timeout, o.MTRTimeout)
+	setInt("mtr.max-hops", &c.MTR.MaxHops, o.MTRMaxHops)
+	setInt("mtr.count", &c.MTR.Count, o.MTRCount)
+	setDuration("tcp.interval", &c.TCP.Interval, o.TCPInterval)
+	setDuration("tcp.timeout", &c.TCP.Timeout, o.TCPTimeout)
+	setDuration("http_get.interval", &c.HTTPGet.Interval, o.HTTPGetInterval)
+	setDuration("http_get.timeout", &c.HTTPGet.Timeout, o.HTTPGetTimeout)
+	return applied
+}
+
+// Timing returns the command-line interval, timeout, count and max-hops of a protocol (zero when not overridden)
+// These are already set as the protocol settings, the monitors also apply them over the per target values
+func (o *Overrides) Timing(protocol string) (interval time.Duration, timeout time.Duration, count int, maxHops int) {
+	if o == nil {
+		return 0, 0, 0, 0
+	}
+	switch protocol {
+	case "ICMP":
+		return o.ICMPInterval, o.ICMPTimeout, o.ICMPCount, 0
+	case "MTR":
+		return o.MTRInterval, o.MTRTimeout, o.MTRCount, o.MTRMaxHops
+	case "TCP":
+		return o.TCPInterval, o.TCPTimeout, 0, 0
+	case "HTTPGet":
+		return o.HTTPGetInterval, o.HTTPGetTimeout, 0, 0
+	}
+	return 0, 0, 0, 0
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler interface.
@@ -224,13 +1359,103 @@ func (d *duration) Set(dur time.Duration) {
 	*d = duration(dur)
 }
 
+// durationSetter the durations that can be overridden
+type durationSetter interface {
+	Duration() time.Duration
+	Set(dur time.Duration)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler interface.
+func (d *durationRange) UnmarshalYAML(unmashal func(interface{}) error) error {
+	var s string
+	if err := unmashal(&s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// UnmarshalText parses a duration or a min-max range of durations (also used for the defaults)
+func (d *durationRange) UnmarshalText(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	dmin, err := time.ParseDuration(strings.TrimSpace(lo))
+	if err != nil {
+		return err
+	}
+	dmax, err := time.ParseDuration(strings.TrimSpace(hi))
+	if err != nil {
+		return err
+	}
+	if dmax < dmin {
+		return fmt.Errorf("invalid duration range %q, the maximum is lower than the minimum", s)
+	}
+	d.min = dmin
+	d.max = dmax
+	return nil
+}
+
+// MarshalText renders the duration or the range
+func (d durationRange) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// String duration or min-max range
+func (d durationRange) String() string {
+	if d.max > d.min {
+		return d.min.String() + "-" + d.max.String()
+	}
+	return d.min.String()
+}
+
+// Duration is a convenience getter (lower bound of the range).
+func (d durationRange) Duration() time.Duration {
+	return d.min
+}
+
+// Max upper bound of the range (the duration itself without range)
+func (d durationRange) Max() time.Duration {
+	if d.max < d.min {
+		return d.min
+	}
+	return d.max
+}
+
+// Set updates the underlying duration (drops the range).
+func (d *durationRange) Set(dur time.Duration) {
+	d.min = dur
+	d.max = dur
+}
+
+// validIPVersion the ip-version is empty or one of (4|6|both)
+func validIPVersion(v string) bool {
+	return v == "" || v == "4" || v == "6" || v == "both"
+}
+
+// IPVersionResolve returns the resolve mode of the ip-version, both (and empty) resolves the A and AAAA records
+func IPVersionResolve(v string) string {
+	switch v {
+	case "4":
+		return common.ResolveIPv4
+	case "6":
+		return common.ResolveIPv6
+	}
+	return ""
+}
+
 // HasDuplicateTargets Find duplicates with same type
 func HasDuplicateTargets(m Targets) (bool, error) {
 	tmp := map[string]map[string]bool{
 		"TCP":     map[string]bool{},
+		"UDP":     map[string]bool{},
 		"ICMP":    map[string]bool{},
 		"MTR":     map[string]bool{},
 		"HTTPGet": map[string]bool{},
+		"ARP":     map[string]bool{},
+		"OWD":     map[string]bool{},
+		"DNS":     map[string]bool{},
 	}
 
 	for _, t := range m {
@@ -252,3 +1477,47 @@ func HasDuplicateTargets(m Targets) (bool, error) {
 	}
 	return false, nil
 }
+
+// HasDependencyCycle checks that the depends-on targets exist and don't depend (indirectly) on themselves
+func HasDependencyCycle(m Targets) error {
+	deps := map[string][]string{}
+	for _, t := range m {
+		deps[t.Name] = deps[t.Name]
+	}
+	for _, t := range m {
+		if t.DependsOn == "" {
+			continue
+		}
+		if _, found := deps[t.DependsOn]; !found {
+			return fmt.Errorf("target %s depends-on unknown target: %s", t.Name, t.DependsOn)
+		}
+		deps[t.Name] = append(deps[t.Name], t.DependsOn)
+	}
+
+	// 1: visiting, 2: done
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("found dependency cycle: %s", strings.Join(path, " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, d := range deps[name] {
+			if err := visit(d, path); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for _, t := range m {
+		if err := visit(t.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+// writeHosts rewrites the hosts file with a new modification time so the next lookup reads it again
+func writeHosts(t *testing.T, path string, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolverPassAddrsSameAddressWithinPass(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	now := time.Now()
+	writeHosts(t, hostsFile, "192.0.2.10 target.example\n", now.Add(-time.Hour))
+	r := &Resolver{Resolver: net.DefaultResolver, HostsFile: hostsFile, Timeout: time.Second}
+
+	// ICMP sub-probe of an ICMP+MTR target
+	r.NewPass()
+	icmpAddrs, err := r.PassAddrs("target.example", common.ResolveIP)
+	if err != nil {
+		t.Fatalf("icmp lookup: %v", err)
+	}
+
+	// The record changes before the MTR sub-probe resolves the same host in the same pass
+	writeHosts(t, hostsFile, "192.0.2.20 target.example\n", now)
+	var wg sync.WaitGroup
+	mtrAddrs := make([][]string, 4)
+	for i := range mtrAddrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mtrAddrs[i], _ = r.PassAddrs("target.example", common.ResolveIP)
+		}(i)
+	}
+	wg.Wait()
+	for _, addrs := range mtrAddrs {
+		if !sameAddrs(icmpAddrs, addrs) {
+			t.Fatalf("sub-probes of the same pass resolved %v and %v", icmpAddrs, addrs)
+		}
+	}
+	if len(icmpAddrs) != 1 || icmpAddrs[0] != "192.0.2.10" {
+		t.Fatalf("first lookup = %v, want [192.0.2.10]", icmpAddrs)
+	}
+
+	// The next pass resolves the host again
+	r.NewPass()
+	addrs, err := r.PassAddrs("target.example", common.ResolveIP)
+	if err != nil {
+		t.Fatalf("next pass lookup: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.0.2.20" {
+		t.Fatalf("next pass = %v, want [192.0.2.20]", addrs)
+	}
+	if n := r.ResolveChanges()["target.example"]; n != 1 {
+		t.Errorf("resolve changes = %d, want 1", n)
+	}
+}
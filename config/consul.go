@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Consul service discovery of the targets, an empty address disables it
+type Consul struct {
+	Address    string          `yaml:"address" json:"address"`
+	Token      string          `yaml:"token" json:"token"`
+	Datacenter string          `yaml:"datacenter" json:"datacenter"`
+	Refresh    duration        `yaml:"refresh" json:"refresh" default:"30s"`
+	Timeout    duration        `yaml:"timeout" json:"timeout" default:"5s"`
+	StaleTTL   duration        `yaml:"stale-target-ttl" json:"stale-target-ttl"`
+	Services   []ConsulService `yaml:"services" json:"services"`
+}
+
+// ConsulService Consul service whose instances are probed, the instances must have all the tags
+type ConsulService struct {
+	Service          string   `yaml:"service" json:"service"`
+	Tags             []string `yaml:"tags" json:"tags"`
+	Type             string   `yaml:"type" json:"type" default:"ICMP"`
+	IncludeUnhealthy bool     `yaml:"include-unhealthy" json:"include-unhealthy"`
+	Probe            []string `yaml:"probe" json:"probe"`
+	Labels           extraKV  `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+// consulEntry instance of the Consul /v1/health/service endpoint
+type consulEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string   `json:"ID"`
+		Service string   `json:"Service"`
+		Tags    []string `json:"Tags"`
+		Address string   `json:"Address"`
+		Port    int      `json:"Port"`
+	} `json:"Service"`
+}
+
+// validate checks the consul section
+func (c *Consul) validate() error {
+	if c.Address == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("consul.address must be an http(s) URL")
+	}
+	if c.Refresh <= 0 || c.Timeout <= 0 {
+		return fmt.Errorf("consul.refresh and consul.timeout must be >0")
+	}
+	if c.StaleTTL < 0 || (c.StaleTTL > 0 && c.StaleTTL < c.Refresh) {
+		return fmt.Errorf("consul.stale-target-ttl must be 0 (disabled) or >= consul.refresh")
+	}
+	for i, s := range c.Services {
+		if s.Service == "" {
+			return fmt.Errorf("consul.services[%d] service is required", i)
+		}
+		if s.Type != "ICMP" && s.Type != "MTR" && s.Type != "ICMP+MTR" && s.Type != "TCP" {
+			return fmt.Errorf("consul.services[%d] type must be one of (ICMP|MTR|ICMP+MTR|TCP)", i)
+		}
+	}
+	return nil
+}
+
+// ConsulTargets returns the targets of the instances of the consul services, named <service id>-<node>
+// The TCP targets probe the service port, the service address falls back to the node address
+func ConsulTargets(c Consul) (Targets, error) {
+	client := &http.Client{Timeout: c.Timeout.Duration()}
+	targets := Targets{}
+	for _, s := range c.Services {
+		entries, err := c.instances(client, s)
+		if err != nil {
+			return nil, fmt.Errorf("consul service %s: %w", s.Service, err)
+		}
+
+		for _, e := range entries {
+			if !hasTags(e.Service.Tags, s.Tags) {
+				continue
+			}
+			host := e.Service.Address
+			if host == "" {
+				host = e.Node.Address
+			}
+			if s.Type == "TCP" {
+				host = net.JoinHostPort(host, strconv.Itoa(e.Service.Port))
+			}
+
+			kv := map[string]string{"consul_service": e.Service.Service, "consul_node": e.Node.Node}
+			for k, v := range s.Labels.Kv {
+				kv[k] = v
+			}
+			targets = append(targets, Targets{{
+				Name:   e.Service.ID + "-" + e.Node.Node,
+				Host:   host,
+				Type:   s.Type,
+				Probe:  s.Probe,
+				Labels: extraKV{Kv: kv},
+			}}...)
+		}
+	}
+
+	sort.SliceStable(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets, nil
+}
+
+// instances reads the instances of a service (only the passing ones unless include-unhealthy)
+func (c *Consul) instances(client *http.Client, s ConsulService) ([]consulEntry, error) {
+	q := url.Values{}
+	if !s.IncludeUnhealthy {
+		q.Set("passing", "true")
+	}
+	if c.Datacenter != "" {
+		q.Set("dc", c.Datacenter)
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.Address, "/")+"/v1/health/service/"+url.PathEscape(s.Service)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+
+	entries := []consulEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return entries, nil
+}
+
+// hasTags the instance has all the required tags
+func hasTags(tags []string, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, t := range tags {
+			if t == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
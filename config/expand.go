@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxExpandedHosts upper bound of the hosts of an expanded target (a /20), a mistyped prefix would flood the probes
+const maxExpandedHosts = 4096
+
+// rangeRe [01-20] and [1,3,5-7] numeric ranges of a host name, the width of a zero padded start is kept
+var rangeRe = regexp.MustCompile(`\[(\d+(?:-\d+)?(?:,\d+(?:-\d+)?)*)\]`)
+
+// expandTargets replaces the targets with a CIDR host (10.0.0.0/28, [2001:db8::/126]:80) or a host name range (web[01-20].example.com)
+// by a target per host named <name>-<ip> or <name>-<range values>, the hosts matching the exclude list are skipped
+func expandTargets(m Targets) (Targets, error) {
+	targets := make(Targets, 0, len(m))
+	for _, t := range m {
+		expanded, suffixes, err := expandHost(t.Type, t.Host)
+		if err != nil {
+			return nil, fmt.Errorf("target %s host %s: %s", t.Name, t.Host, err)
+		}
+		if expanded == nil {
+			if len(t.Exclude) > 0 {
+				return nil, fmt.Errorf("target %s exclude requires a CIDR or a range host", t.Name)
+			}
+			targets = append(targets, t)
+			continue
+		}
+
+		exclude, err := parseExclude(t.Exclude)
+		if err != nil {
+			return nil, fmt.Errorf("target %s exclude: %s", t.Name, err)
+		}
+		for i, h := range expanded {
+			if exclude(h) {
+				continue
+			}
+			sub := t
+			sub.Name = t.Name + "-" + suffixes[i]
+			sub.Host = h
+			sub.Exclude = nil
+			targets = append(targets, sub)
+		}
+	}
+	return targets, nil
+}
+
+// expandHost returns the hosts and the name suffixes of a CIDR or range host (nil for a single host)
+func expandHost(typ string, host string) ([]string, []string, error) {
+	if rangeRe.MatchString(host) {
+		return expandRange(host)
+	}
+
+	// The host part of host:port, the HTTPGet URLs and the DNS queries have no CIDR form
+	if typ == "HTTPGet" || typ == "DNS" || !strings.Contains(host, "/") {
+		return nil, nil, nil
+	}
+	prefix, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		prefix, port = h, p
+	}
+	pfx, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pfx != pfx.Masked() {
+		return nil, nil, fmt.Errorf("%s is not the network address of the prefix (%s)", pfx.Addr(), pfx.Masked())
+	}
+	bits := pfx.Addr().BitLen() - pfx.Bits()
+	if bits > 12 {
+		return nil, nil, fmt.Errorf("expands to more than %d hosts", maxExpandedHosts)
+	}
+
+	first, last := pfx.Addr(), lastAddr(pfx)
+	// The network and broadcast addresses of the IPv4 subnets aren't hosts
+	if pfx.Addr().Is4() && bits > 1 {
+		first, last = first.Next(), last.Prev()
+	}
+	hosts, suffixes := []string{}, []string{}
+	for a := first; a.IsValid() && a.Compare(last) <= 0; a = a.Next() {
+		h := a.String()
+		if port != "" {
+			h = net.JoinHostPort(h, port)
+		}
+		hosts = append(hosts, h)
+		suffixes = append(suffixes, a.String())
+	}
+	return hosts, suffixes, nil
+}
+
+// lastAddr returns the last address of the prefix
+func lastAddr(pfx netip.Prefix) netip.Addr {
+	b := pfx.Addr().AsSlice()
+	for i := pfx.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// expandRange returns the cartesian product of the ranges of the host name, the suffix joins the values with -
+func expandRange(host string) ([]string, []string, error) {
+	hosts, suffixes := []string{""}, []string{""}
+	rest := host
+	for {
+		loc := rangeRe.FindStringSubmatchIndex(rest)
+		if loc == nil {
+			break
+		}
+		values, err := rangeValues(rest[loc[2]:loc[3]])
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(hosts)*len(values) > maxExpandedHosts {
+			return nil, nil, fmt.Errorf("expands to more than %d hosts", maxExpandedHosts)
+		}
+
+		nextHosts, nextSuffixes := make([]string, 0, len(hosts)*len(values)), make([]string, 0, len(hosts)*len(values))
+		for i := range hosts {
+			for _, v := range values {
+				nextHosts = append(nextHosts, hosts[i]+rest[:loc[0]]+v)
+				s := v
+				if suffixes[i] != "" {
+					s = suffixes[i] + "-" + v
+				}
+				nextSuffixes = append(nextSuffixes, s)
+			}
+		}
+		hosts, suffixes = nextHosts, nextSuffixes
+		rest = rest[loc[1]:]
+	}
+	for i := range hosts {
+		hosts[i] += rest
+	}
+	return hosts, suffixes, nil
+}
+
+// rangeValues returns the values of a range list (1,3,5-7), a start with leading zeros pads the values to its width
+func rangeValues(list string) ([]string, error) {
+	values := []string{}
+	for _, r := range strings.Split(list, ",") {
+		start, end, found := strings.Cut(r, "-")
+		if !found {
+			end = start
+		}
+		lo, err := strconv.Atoi(start)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := strconv.Atoi(end)
+		if err != nil {
+			return nil, err
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("range [%s] start is above its end", r)
+		}
+		if hi-lo >= maxExpandedHosts {
+			return nil, fmt.Errorf("expands to more than %d hosts", maxExpandedHosts)
+		}
+		width := 0
+		if len(start) > 1 && start[0] == '0' {
+			width = len(start)
+		}
+		for v := lo; v <= hi; v++ {
+			values = append(values, fmt.Sprintf("%0*d", width, v))
+		}
+	}
+	return values, nil
+}
+
+// parseExclude returns the matcher of the excluded hosts (addresses, CIDRs or host names, the port and the URL parts are ignored)
+func parseExclude(exclude []string) (func(host string) bool, error) {
+	prefixes, names := []netip.Prefix{}, map[string]bool{}
+	for _, e := range exclude {
+		if strings.Contains(e, "/") {
+			pfx, err := netip.ParsePrefix(e)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, pfx.Masked())
+			continue
+		}
+		if a, err := netip.ParseAddr(e); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+			continue
+		}
+		names[strings.ToLower(e)] = true
+	}
+
+	return func(host string) bool {
+		if u, err := url.Parse(host); err == nil && u.Scheme != "" && u.Host != "" {
+			host = u.Hostname()
+		} else if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if a, err := netip.ParseAddr(host); err == nil {
+			for _, p := range prefixes {
+				if p.Contains(a) {
+					return true
+				}
+			}
+			return false
+		}
+		return names[strings.ToLower(host)]
+	}, nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// expandGroups removes the target_groups of the document and appends their members to its targets
+// The settings of a group (any target field but name and members) are inherited by its members, the member fields take precedence
+// and the labels are merged. A member is a host or a target mapping, it's named <group>-<host> unless it has a name
+func expandGroups(root *yaml.Node) error {
+	var groups *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "target_groups" {
+			groups = root.Content[i+1]
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+			break
+		}
+	}
+	if groups == nil || groups.Tag == "!!null" {
+		return nil
+	}
+	if groups.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: target_groups must be a list", groups.Line)
+	}
+
+	members := []*yaml.Node{}
+	for _, g := range groups.Content {
+		if g.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: target group must be a mapping", g.Line)
+		}
+		name := mappingValue(g, "name")
+		if name == nil || name.Value == "" {
+			return fmt.Errorf("line %d: target group without name", g.Line)
+		}
+		list := mappingValue(g, "members")
+		if list == nil || list.Kind != yaml.SequenceNode || len(list.Content) == 0 {
+			return fmt.Errorf("line %d: target group %s has no members", g.Line, name.Value)
+		}
+
+		for _, m := range list.Content {
+			t, err := groupMember(g, name.Value, m)
+			if err != nil {
+				return err
+			}
+			members = append(members, t)
+		}
+	}
+
+	targets := mappingValue(root, "targets")
+	switch {
+	case targets == nil:
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "targets"}, &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: members})
+	case targets.Kind == yaml.ScalarNode && targets.Tag == "!!null":
+		*targets = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: targets.Line, Column: targets.Column, Content: members}
+	case targets.Kind == yaml.SequenceNode:
+		targets.Content = append(targets.Content, members...)
+	default:
+		return fmt.Errorf("line %d: targets must be a list", targets.Line)
+	}
+	return nil
+}
+
+// groupMember returns the target mapping of a member with the settings of its group
+func groupMember(group *yaml.Node, name string, m *yaml.Node) (*yaml.Node, error) {
+	member := m
+	if m.Kind == yaml.ScalarNode {
+		member = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: m.Line, Column: m.Column, Content: []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: "host"}, m}}
+	}
+	if member.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: target group %s member must be a host or a mapping", m.Line, name)
+	}
+	host := mappingValue(member, "host")
+	if host == nil || host.Value == "" {
+		return nil, fmt.Errorf("line %d: target group %s member without host", m.Line, name)
+	}
+
+	t := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: member.Line, Column: member.Column}
+	if mappingValue(member, "name") == nil {
+		t.Content = append(t.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "name"}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name + "-" + host.Value, Line: host.Line, Column: host.Column})
+	}
+	for i := 0; i+1 < len(group.Content); i += 2 {
+		k, v := group.Content[i], group.Content[i+1]
+		if k.Value == "name" || k.Value == "members" {
+			continue
+		}
+		mv := mappingValue(member, k.Value)
+		if mv == nil {
+			t.Content = append(t.Content, k, v)
+			continue
+		}
+		// The labels of the member are added to the ones of the group
+		if k.Value == "labels" && v.Kind == yaml.MappingNode && mv.Kind == yaml.MappingNode {
+			labels := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: mv.Line, Column: mv.Column}
+			for j := 0; j+1 < len(v.Content); j += 2 {
+				if mappingValue(mv, v.Content[j].Value) == nil {
+					labels.Content = append(labels.Content, v.Content[j], v.Content[j+1])
+				}
+			}
+			labels.Content = append(labels.Content, mv.Content...)
+			t.Content = append(t.Content, k, labels)
+		}
+	}
+	for i := 0; i+1 < len(member.Content); i += 2 {
+		k := member.Content[i]
+		if k.Value == "labels" && mappingValue(t, "labels") != nil {
+			continue
+		}
+		t.Content = append(t.Content, k, member.Content[i+1])
+	}
+	return t, nil
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// envRe ${VAR} and ${VAR:-default} references, $${VAR} is kept as the literal ${VAR}
+var envRe = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// loadDocument expands the environment variables of the configuration and merges the files of its include directive (relative to dir)
+// The members of the target_groups of each file are added to its targets, the sequences (targets, metric_relabel) of the included files are appended, the keys of their sections can't already be defined
+// The merged document is decoded as is, its nodes keep the lines of their files (nil for an empty document)
+func loadDocument(name string, dir string, b []byte) (*yaml.Node, error) {
+	root, err := parseDocument(b)
+	if err != nil || root == nil {
+		return nil, err
+	}
+
+	patterns, err := includePatterns(root)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file: %s", err)
+	}
+	if err := expandGroups(root); err != nil {
+		return nil, fmt.Errorf("parsing config file: %s", err)
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %s", p, err)
+		}
+		// An empty config directory is not an error, a missing file is
+		if len(matches) == 0 && !strings.ContainsAny(p, "*?[") {
+			return nil, fmt.Errorf("include %s: no such file", p)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+
+	owners := map[string]string{}
+	if err := targetOwners(owners, root, name); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		fb, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %s", f, err)
+		}
+		inc, err := parseDocument(fb)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %s", f, strings.TrimPrefix(err.Error(), "parsing config file: "))
+		}
+		if inc == nil {
+			continue
+		}
+		if mappingValue(inc, "include") != nil {
+			return nil, fmt.Errorf("include %s: include is only supported in the main config file", f)
+		}
+		if err := expandGroups(inc); err != nil {
+			return nil, fmt.Errorf("include %s: %s", f, err)
+		}
+		if err := targetOwners(owners, inc, f); err != nil {
+			return nil, err
+		}
+		if err := mergeDocument(root, inc, f); err != nil {
+			return nil, err
+		}
+	}
+
+	return root, nil
+}
+
+// parseDocument returns the top level mapping of the document with its environment variables expanded (nil for an empty document)
+func parseDocument(b []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("parsing config file: %s", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("parsing config file: line %d: the configuration must be a mapping", root.Line)
+	}
+	if err := expandEnv(root); err != nil {
+		return nil, fmt.Errorf("parsing config file: %s", err)
+	}
+	return root, nil
+}
+
+// expandEnv expands the environment variable references of the scalar values, the keys and the structure are not affected
+// A reference to an unset variable without default is an error
+func expandEnv(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var err error
+		v := envRe.ReplaceAllStringFunc(n.Value, func(m string) string {
+			sub := envRe.FindStringSubmatch(m)
+			if sub[1] != "" {
+				return m[1:]
+			}
+			if v, found := os.LookupEnv(sub[2]); found && (v != "" || sub[3] == "") {
+				return v
+			}
+			if sub[3] != "" {
+				return sub[4]
+			}
+			if err == nil {
+				err = fmt.Errorf("line %d: environment variable %s is not set", n.Line, sub[2])
+			}
+			return m
+		})
+		if err != nil {
+			return err
+		}
+		if v != n.Value {
+			n.Value = v
+			// The plain scalars are resolved again with their expanded value (${PORT} was a string)
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+		return nil
+	}
+	for i, c := range n.Content {
+		if n.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := expandEnv(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includePatterns removes the include directive (a path or a list of paths and glob patterns) from the mapping
+func includePatterns(root *yaml.Node) ([]string, error) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "include" {
+			continue
+		}
+		v := root.Content[i+1]
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+
+		var patterns []string
+		if v.Kind == yaml.ScalarNode {
+			if v.Value == "" || v.Tag == "!!null" {
+				return nil, nil
+			}
+			return []string{v.Value}, nil
+		}
+		if err := v.Decode(&patterns); err != nil {
+			return nil, fmt.Errorf("include: %s", err)
+		}
+		return patterns, nil
+	}
+	return nil, nil
+}
+
+// mappingValue returns the value of the key of the mapping (nil if absent)
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mergeDocument merges the top level keys of an included file into the configuration
+func mergeDocument(dst *yaml.Node, src *yaml.Node, file string) error {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		k, v := src.Content[i], src.Content[i+1]
+		dv := mappingValue(dst, k.Value)
+		switch {
+		case dv == nil:
+			dst.Content = append(dst.Content, k, v)
+		case dv.Kind == yaml.ScalarNode && dv.Tag == "!!null":
+			*dv = *v
+		case dv.Kind == yaml.SequenceNode && v.Kind == yaml.SequenceNode:
+			dv.Content = append(dv.Content, v.Content...)
+		case dv.Kind == yaml.MappingNode && v.Kind == yaml.MappingNode:
+			for j := 0; j+1 < len(v.Content); j += 2 {
+				if mappingValue(dv, v.Content[j].Value) != nil {
+					return fmt.Errorf("include %s: %s.%s is already defined", file, k.Value, v.Content[j].Value)
+				}
+				dv.Content = append(dv.Content, v.Content[j], v.Content[j+1])
+			}
+		default:
+			return fmt.Errorf("include %s: %s is already defined", file, k.Value)
+		}
+	}
+	return nil
+}
+
+// targetOwners records the file defining each target (by type and name), a target defined in two files is an error
+// The duplicates within a file are left to HasDuplicateTargets
+func targetOwners(owners map[string]string, root *yaml.Node, file string) error {
+	v := mappingValue(root, "targets")
+	if v == nil || v.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, n := range v.Content {
+		var t struct {
+			Name string `yaml:"name"`
+			Type string `yaml:"type"`
+		}
+		if err := n.Decode(&t); err != nil {
+			return fmt.Errorf("parsing %s: line %d: %s", file, n.Line, err)
+		}
+		types := []string{t.Type}
+		if t.Type == "ICMP+MTR" {
+			types = []string{"ICMP", "MTR"}
+		}
+		for _, typ := range types {
+			key := typ + "/" + t.Name
+			if owner, found := owners[key]; found && owner != file {
+				return fmt.Errorf("found duplicated record: %s (%s) is defined in %s and %s", t.Name, t.Type, owner, file)
+			}
+			owners[key] = file
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Kubernetes service discovery of the nodes and pods, disabled without roles
+type Kubernetes struct {
+	APIServer string           `yaml:"api-server" json:"api-server"`
+	TokenFile string           `yaml:"token-file" json:"token-file" default:"/var/run/secrets/kubernetes.io/serviceaccount/token"`
+	CAFile    string           `yaml:"ca-file" json:"ca-file" default:"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"`
+	Refresh   duration         `yaml:"refresh" json:"refresh" default:"30s"`
+	Timeout   duration         `yaml:"timeout" json:"timeout" default:"5s"`
+	StaleTTL  duration         `yaml:"stale-target-ttl" json:"stale-target-ttl"`
+	Roles     []KubernetesRole `yaml:"roles" json:"roles"`
+}
+
+// KubernetesRole nodes or pods (of a namespace, default all) matching the label selector
+type KubernetesRole struct {
+	Role      string   `yaml:"role" json:"role" default:"node"`
+	Namespace string   `yaml:"namespace" json:"namespace"`
+	Selector  string   `yaml:"selector" json:"selector"`
+	Type      string   `yaml:"type" json:"type" default:"ICMP"`
+	Probe     []string `yaml:"probe" json:"probe"`
+	Labels    extraKV  `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+// kubernetesList items of the node and pod lists of the API
+type kubernetesList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+		Status struct {
+			Phase     string              `json:"phase"`
+			PodIP     string              `json:"podIP"`
+			Addresses []kubernetesAddress `json:"addresses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// kubernetesAddress address of a node
+type kubernetesAddress struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// Enabled the kubernetes discovery has roles
+func (k *Kubernetes) Enabled() bool {
+	return len(k.Roles) > 0
+}
+
+// validate checks the kubernetes section
+func (k *Kubernetes) validate() error {
+	if !k.Enabled() {
+		return nil
+	}
+	if k.APIServer != "" {
+		if u, err := url.Parse(k.APIServer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("kubernetes.api-server must be an http(s) URL")
+		}
+	}
+	if k.Refresh <= 0 || k.Timeout <= 0 {
+		return fmt.Errorf("kubernetes.refresh and kubernetes.timeout must be >0")
+	}
+	if k.StaleTTL < 0 || (k.StaleTTL > 0 && k.StaleTTL < k.Refresh) {
+		return fmt.Errorf("kubernetes.stale-target-ttl must be 0 (disabled) or >= kubernetes.refresh")
+	}
+	for i, r := range k.Roles {
+		if r.Role != "node" && r.Role != "pod" {
+			return fmt.Errorf("kubernetes.roles[%d] role must be one of (node|pod)", i)
+		}
+		if r.Namespace != "" && r.Role != "pod" {
+			return fmt.Errorf("kubernetes.roles[%d] namespace is only supported by the pod role", i)
+		}
+		if r.Type != "ICMP" && r.Type != "MTR" && r.Type != "ICMP+MTR" {
+			return fmt.Errorf("kubernetes.roles[%d] type must be one of (ICMP|MTR|ICMP+MTR)", i)
+		}
+	}
+	return nil
+}
+
+// KubernetesTargets returns the targets of the nodes (named <node>, InternalIP then ExternalIP) and of the running pods (named <namespace>/<pod>)
+// Without api-server the in-cluster API and service account are used, the token is read on every refresh (rotated tokens)
+func KubernetesTargets(k Kubernetes) (Targets, error) {
+	server := k.APIServer
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("kubernetes.api-server is not set and not running in a cluster")
+		}
+		server = "https://" + net.JoinHostPort(host, port)
+	}
+	client, token, err := k.client()
+	if err != nil {
+		return nil, err
+	}
+
+	targets := Targets{}
+	for _, r := range k.Roles {
+		path := "/api/v1/nodes"
+		if r.Role == "pod" {
+			path = "/api/v1/pods"
+			if r.Namespace != "" {
+				path = "/api/v1/namespaces/" + url.PathEscape(r.Namespace) + "/pods"
+			}
+		}
+		list, err := kubernetesGet(client, token, strings.TrimSuffix(server, "/")+path, r.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes %s list: %w", r.Role, err)
+		}
+
+		for _, o := range list.Items {
+			kv := map[string]string{}
+			name, host := o.Metadata.Name, ""
+			if r.Role == "node" {
+				host = nodeAddress(o.Status.Addresses)
+				kv["kubernetes_node"] = o.Metadata.Name
+			} else {
+				if o.Status.Phase != "Running" {
+					continue
+				}
+				name = o.Metadata.Namespace + "/" + o.Metadata.Name
+				host = o.Status.PodIP
+				kv["kubernetes_namespace"] = o.Metadata.Namespace
+				kv["kubernetes_pod"] = o.Metadata.Name
+				kv["kubernetes_node"] = o.Spec.NodeName
+			}
+			if host == "" {
+				continue
+			}
+			for lk, lv := range r.Labels.Kv {
+				kv[lk] = lv
+			}
+			targets = append(targets, Targets{{
+				Name:   name,
+				Host:   host,
+				Type:   r.Type,
+				Probe:  r.Probe,
+				Labels: extraKV{Kv: kv},
+			}}...)
+		}
+	}
+
+	sort.SliceStable(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets, nil
+}
+
+// client returns the API client (trusting the ca-file when present) and the service account token (empty without token-file)
+func (k *Kubernetes) client() (*http.Client, string, error) {
+	token, err := os.ReadFile(k.TokenFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, "", fmt.Errorf("kubernetes.token-file: %w", err)
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	ca, err := os.ReadFile(k.CAFile)
+	if err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, "", fmt.Errorf("kubernetes.ca-file has no valid certificate")
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, "", fmt.Errorf("kubernetes.ca-file: %w", err)
+	}
+	return &http.Client{Transport: tr, Timeout: k.Timeout.Duration()}, strings.TrimSpace(string(token)), nil
+}
+
+// kubernetesGet lists the objects of the API path matching the label selector
+func kubernetesGet(client *http.Client, token string, u string, selector string) (*kubernetesList, error) {
+	if selector != "" {
+		u += "?" + url.Values{"labelSelector": []string{selector}}.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+
+	list := &kubernetesList{}
+	if err := json.NewDecoder(resp.Body).Decode(list); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return list, nil
+}
+
+// nodeAddress returns the InternalIP of the node, otherwise its ExternalIP
+func nodeAddress(addrs []kubernetesAddress) string {
+	for _, t := range []string{"InternalIP", "ExternalIP"} {
+		for _, a := range addrs {
+			if a.Type == t {
+				return a.Address
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var labelNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// RelabelConfig rule rewriting the labels of the exported metrics (same semantic as the Prometheus metric_relabel_configs)
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels" json:"source_labels"`
+	Separator    string   `yaml:"separator" json:"separator" default:";"`
+	Regex        string   `yaml:"regex" json:"regex" default:"(.*)"`
+	TargetLabel  string   `yaml:"target_label" json:"target_label"`
+	Replacement  string   `yaml:"replacement" json:"replacement" default:"$1"`
+	Action       string   `yaml:"action" json:"action" default:"replace"`
+
+	re *regexp.Regexp
+}
+
+// compile validates the rule and compiles its anchored regex
+func (r *RelabelConfig) compile() (err error) {
+	if r.re, err = regexp.Compile("^(?:" + r.Regex + ")$"); err != nil {
+		return fmt.Errorf("regex: %w", err)
+	}
+	for _, l := range r.SourceLabels {
+		if !labelNameRe.MatchString(l) {
+			return fmt.Errorf("invalid source label %q", l)
+		}
+	}
+
+	switch r.Action {
+	case "replace":
+		if !labelNameRe.MatchString(r.TargetLabel) || r.TargetLabel == "__name__" {
+			return fmt.Errorf("action replace requires a valid target_label (the metric name can't be changed)")
+		}
+	case "keep", "drop":
+		if len(r.SourceLabels) == 0 {
+			return fmt.Errorf("action %s requires source_labels", r.Action)
+		}
+	case "labelmap", "labeldrop", "labelkeep":
+	default:
+		return fmt.Errorf("action must be one of (replace|keep|drop|labelmap|labeldrop|labelkeep)")
+	}
+	return nil
+}
+
+// Relabel applies the rules to the labels of a metric (its name is available as __name__)
+// Returns false when the metric must be dropped, the labels starting with __ are removed once all the rules are applied
+func Relabel(rules []RelabelConfig, labels map[string]string) bool {
+	for i := range rules {
+		r := &rules[i]
+		switch r.Action {
+		case "replace":
+			val := r.sourceValue(labels)
+			m := r.re.FindStringSubmatchIndex(val)
+			if m == nil {
+				continue
+			}
+			res := string(r.re.ExpandString(nil, r.Replacement, val, m))
+			if res == "" {
+				delete(labels, r.TargetLabel)
+			} else {
+				labels[r.TargetLabel] = res
+			}
+		case "keep":
+			if !r.re.MatchString(r.sourceValue(labels)) {
+				return false
+			}
+		case "drop":
+			if r.re.MatchString(r.sourceValue(labels)) {
+				return false
+			}
+		case "labelmap":
+			for k, v := range labels {
+				if k != "__name__" && r.re.MatchString(k) {
+					labels[r.re.ReplaceAllString(k, r.Replacement)] = v
+				}
+			}
+		case "labeldrop":
+			for k := range labels {
+				if k != "__name__" && r.re.MatchString(k) {
+					delete(labels, k)
+				}
+			}
+		case "labelkeep":
+			for k := range labels {
+				if k != "__name__" && !r.re.MatchString(k) {
+					delete(labels, k)
+				}
+			}
+		}
+	}
+
+	for k := range labels {
+		if strings.HasPrefix(k, "__") {
+			delete(labels, k)
+		}
+	}
+	return true
+}
+
+func (r *RelabelConfig) sourceValue(labels map[string]string) string {
+	vals := make([]string, len(r.SourceLabels))
+	for i, l := range r.SourceLabels {
+		vals[i] = labels[l]
+	}
+	return strings.Join(vals, r.Separator)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/config"
+)
+
+// discoverySource service discovery of targets, merged with the static targets of the configuration
+type discoverySource struct {
+	name    string
+	enabled func(c *config.Config) bool
+	refresh func(c *config.Config) time.Duration
+	targets func(c *config.Config) (config.Targets, error)
+	ttl     func(c *config.Config) time.Duration
+	last    []byte               // last applied targets (JSON)
+	applied config.Targets       // last applied targets
+	seen    map[string]time.Time // last refresh returning the target (type, name and host)
+}
+
+var discoverySources = []*discoverySource{
+	{
+		name:    "consul",
+		enabled: func(c *config.Config) bool { return c.Consul.Address != "" },
+		refresh: func(c *config.Config) time.Duration { return c.Consul.Refresh.Duration() },
+		targets: func(c *config.Config) (config.Targets, error) { return config.ConsulTargets(c.Consul) },
+		ttl:     func(c *config.Config) time.Duration { return c.Consul.StaleTTL.Duration() },
+	},
+	{
+		name:    "kubernetes",
+		enabled: func(c *config.Config) bool { return c.Kubernetes.Enabled() },
+		refresh: func(c *config.Config) time.Duration { return c.Kubernetes.Refresh.Duration() },
+		targets: func(c *config.Config) (config.Targets, error) { return config.KubernetesTargets(c.Kubernetes) },
+		ttl:     func(c *config.Config) time.Duration { return c.Kubernetes.StaleTTL.Duration() },
+	},
+}
+
+// startDiscovery periodically refreshes the targets of a discovery source enabled at startup
+func startDiscovery(d *discoverySource) {
+	sc.RLock()
+	enabled := d.enabled(sc.Cfg)
+	sc.RUnlock()
+	if !enabled {
+		return
+	}
+
+	for {
+		sc.RLock()
+		refresh := d.refresh(sc.Cfg)
+		sc.RUnlock()
+		time.Sleep(refresh)
+		refreshDiscovery(d, true)
+	}
+}
+
+// refreshDiscovery reads the targets of the source and applies them when they changed since the last refresh
+// A failed refresh keeps the previously discovered targets (until their stale-target-ttl), a source disabled by a reload drops its targets
+// The monitors are only updated once they are started
+func refreshDiscovery(d *discoverySource, monitors bool) {
+	sc.RLock()
+	cfg := sc.Cfg
+	sc.RUnlock()
+
+	now := time.Now()
+	targets := config.Targets{}
+	if d.enabled(cfg) {
+		t, err := d.targets(cfg)
+		if err != nil {
+			sc.DiscoveryFailed(d.name)
+			level.Error(logger).Log("msg", "Discovery failed, keeping the previous targets", "source", d.name, "err", err)
+			expireDiscovery(d, d.ttl(cfg), now, monitors)
+			return
+		}
+		targets = t
+	}
+	seen := make(map[string]time.Time, len(targets))
+	for _, t := range targets {
+		seen[discoveryKey(t.Type, t.Name, t.Host)] = now
+	}
+	d.seen = seen
+
+	fp, _ := json.Marshal(targets)
+	if bytes.Equal(fp, d.last) {
+		return
+	}
+	if applyDiscovery(d, targets, fp, monitors) {
+		level.Info(logger).Log("msg", fmt.Sprintf("Discovery found %d targets", len(targets)), "source", d.name)
+	}
+}
+
+// expireDiscovery drops the previously discovered targets not returned by a refresh of the source within the ttl (0 keeps them)
+func expireDiscovery(d *discoverySource, ttl time.Duration, now time.Time, monitors bool) {
+	if ttl <= 0 {
+		return
+	}
+	targets := config.Targets{}
+	for _, t := range d.applied {
+		if now.Sub(d.seen[discoveryKey(t.Type, t.Name, t.Host)]) < ttl {
+			targets = append(targets, t)
+		}
+	}
+	expired := len(d.applied) - len(targets)
+	if expired == 0 {
+		return
+	}
+
+	fp, _ := json.Marshal(targets)
+	if applyDiscovery(d, targets, fp, monitors) {
+		level.Warn(logger).Log("msg", fmt.Sprintf("Discovery expired %d stale targets, not refreshed within %s", expired, ttl), "source", d.name)
+	}
+}
+
+// applyDiscovery merges the targets of the source into the configuration and updates the monitors
+func applyDiscovery(d *discoverySource, targets config.Targets, fp []byte, monitors bool) bool {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+	if err := sc.SetDiscovered(logger, d.name, targets); err != nil {
+		sc.DiscoveryFailed(d.name)
+		level.Error(logger).Log("msg", "Discovered targets skipped", "source", d.name, "err", err)
+		return false
+	}
+	d.last, d.applied = fp, targets
+	if monitors {
+		applyTargets()
+	}
+	return true
+}
+
+func discoveryKey(typ string, name string, host string) string {
+	return typ + " " + name + " " + host
+}
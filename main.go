@@ -8,6 +8,10 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -23,61 +27,268 @@ import (
 	"github.com/syepes/network_exporter/config"
 	"github.com/syepes/network_exporter/monitor"
 	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/owd"
+	"github.com/syepes/network_exporter/target"
 )
 
 const version string = "1.7.4"
 
 var (
 	listenAddress    = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests").Default(":9427").String()
+	routePrefix      = kingpin.Flag("web.route-prefix", "Prefix of all the HTTP endpoints (e.g. /network-exporter when served behind a path-prefixed reverse proxy)").Default("").String()
 	configFile       = kingpin.Flag("config.file", "Exporter configuration file").Default("/app/cfg/network_exporter.yml").String()
+	configEnv        = kingpin.Flag("config.env", "Read the configuration (JSON or YAML, a list is taken as the targets) from this environment variable instead of config.file").Default("").String()
+	cfgReadRetries   = kingpin.Flag("config.read-retries", "Number of retries when reading the configuration file fails (I/O errors only)").Default("3").Int()
+	cfgReadDelay     = kingpin.Flag("config.read-retry-delay", "Initial delay between the configuration file read retries (doubled on each retry, jittered)").Default("500ms").Duration()
+	enableInflux     = kingpin.Flag("web.enable-influx", "Serve the probe results in InfluxDB line protocol on /metrics/influx").Default("false").Bool()
+	webConfigFile    = kingpin.Flag("web.config.file", "Web configuration file (Prometheus web-config format) with the basic_auth_users required on all the endpoints").Default("").String()
+	probeTokenFile   = kingpin.Flag("web.probe-token-file", "File with the bearer token required on the on-demand probe endpoints (/probe), /metrics is not affected").Default("").String()
+	enableLifecycle  = kingpin.Flag("web.enable-lifecycle", "Allow reloading the configuration with POST /-/reload (requires --web.reload-token-file or the basic_auth_users of --web.config.file)").Default("false").Bool()
+	reloadTokenFile  = kingpin.Flag("web.reload-token-file", "File with the bearer token required on POST /-/reload").Default("").String()
+	enableCfgWrite   = kingpin.Flag("web.enable-config-write", "Allow replacing the configuration file with PUT /config (validated before writing, previous file kept as .bak), requires the basic_auth_users of --web.config.file").Default("false").Bool()
+	stateFile        = kingpin.Flag("state.file", "File persisting the per target state (consecutive failures, last success, up/down) across the restarts").Default("").String()
+	stateFlush       = kingpin.Flag("state.flush-interval", "Interval between the writes of the state file").Default("30s").Duration()
+	shutdownTimeout  = kingpin.Flag("shutdown.timeout", "Maximum time waiting for the running probes to finish on SIGTERM/SIGINT, the probes still running are then cancelled").Default("10s").Duration()
+	eventsFile       = kingpin.Flag("events.file", "Append the up/down transitions of the targets as JSON lines to this file (- for stdout)").Default("").String()
+	resultsFile      = kingpin.Flag("results.file", "Append the result of every probe cycle as JSON lines to this file (- for stdout)").Default("").String()
+	resultsURL       = kingpin.Flag("results.http-url", "Post the result of every probe cycle as JSON lines (application/x-ndjson) to this HTTP endpoint").Default("").String()
+	resultsBatch     = kingpin.Flag("results.http-batch", "Maximum number of results per post to --results.http-url").Default("500").Int()
+	resultsInterval  = kingpin.Flag("results.http-interval", "Interval between the posts of the pending results to --results.http-url").Default("1s").Duration()
+	resultsTimeout   = kingpin.Flag("results.http-timeout", "Timeout of the posts to --results.http-url").Default("10s").Duration()
+	oneshot          = kingpin.Flag("oneshot", "Probe every target once, print the results and exit (non-zero exit code when a target failed)").Default("false").Bool()
+	oneshotOutput    = kingpin.Flag("oneshot.output", "Output format of the one-shot results (prometheus, json)").Default("prometheus").Enum("prometheus", "json")
+	configCheck      = kingpin.Flag("config.check", "Validate the configuration and resolve the target hosts, print the errors and exit (non-zero exit code when invalid) without probing").Default("false").Bool()
+	configCheckDNS   = kingpin.Flag("config.check.resolve", "Resolve the target hosts with --config.check (--no-config.check.resolve for the offline checks)").Default("true").Bool()
+	collectorGo      = kingpin.Flag("collector.go", "Export the Go runtime metrics (go_*)").Default("true").Bool()
+	collectorProcess = kingpin.Flag("collector.process", "Export the process metrics (process_*, Linux and Windows only)").Default("true").Bool()
+	collectorPrefix  = kingpin.Flag("collector.runtime-prefix", "Prefix of the Go runtime and process metric names (e.g. network_exporter_)").Default("").String()
 	enableProfileing = kingpin.Flag("profiling", "Enable Profiling (pprof + fgprof)").Default("false").Bool()
+	readTimeout      = kingpin.Flag("web.read-timeout", "Maximum duration for reading the entire HTTP request").Default("30s").Duration()
+	writeTimeout     = kingpin.Flag("web.write-timeout", "Maximum duration before timing out writes of the HTTP response (must cover the slowest /metrics scrape)").Default("2m").Duration()
+	idleTimeout      = kingpin.Flag("web.idle-timeout", "Maximum amount of time to wait for the next request when keep-alives are enabled").Default("2m").Duration()
+	icmpInterval     = kingpin.Flag("icmp.interval", "Override the configured icmp.interval").Duration()
+	icmpTimeout      = kingpin.Flag("icmp.timeout", "Override the configured icmp.timeout").Duration()
+	icmpCount        = kingpin.Flag("icmp.count", "Override the configured icmp.count").Int()
+	mtrInterval      = kingpin.Flag("mtr.interval", "Override the configured mtr.interval").Duration()
+	mtrTimeout       = kingpin.Flag("mtr.timeout", "Override the configured mtr.timeout").Duration()
+	mtrMaxHops       = kingpin.Flag("mtr.max-hops", "Override the configured mtr.max-hops").Int()
+	mtrCount         = kingpin.Flag("mtr.count", "Override the configured mtr.count").Int()
+	tcpInterval      = kingpin.Flag("tcp.interval", "Override the configured tcp.interval").Duration()
+	tcpTimeout       = kingpin.Flag("tcp.timeout", "Override the configured tcp.timeout").Duration()
+	httpGetInterval  = kingpin.Flag("http_get.interval", "Override the configured http_get.interval").Duration()
+	httpGetTimeout   = kingpin.Flag("http_get.timeout", "Override the configured http_get.timeout").Duration()
 	sc               = &config.SafeConfig{Cfg: &config.Config{}}
 	logger           log.Logger
-	icmpID           *common.IcmpID // goroutine shared counter
+	icmpID           *common.IcmpID      // goroutine shared counter
+	probeSem         *common.Semaphore   // goroutine shared probe limiter
+	probePools       *common.Pools       // goroutine shared probe limiter partitions
+	rateLimiter      *common.RateLimiter // goroutine shared packet pacing
+	loadShedder      *common.LoadShedder // goroutine shared load shedding
+	resultsSink      *common.HTTPSink    // probe results posted to --results.http-url
+	resultsLog       *common.EventLog    // probe results appended to --results.file
+	eventLog         *common.EventLog    // transitions appended to --events.file
+	resolver         *config.Resolver    // goroutine shared name resolution
+	reloadMtx        sync.Mutex          // serializes the config reloads
 	monitorPING      *monitor.PING
 	monitorMTR       *monitor.MTR
 	monitorTCP       *monitor.TCPPort
+	monitorUDP       *monitor.UDP
 	monitorHTTPGet   *monitor.HTTPGet
+	monitorARP       *monitor.ARP
+	monitorOWD       *monitor.OWD
+	monitorDNS       *monitor.DNS
+	owdResponder     *owd.Responder
+	selfProbe        *target.Self
+	webCfg           *webConfig
 
-	indexHTML = `<!doctype html><html><head> <meta charset="UTF-8"><title>Network Exporter (Version ` + version + `)</title></head><body><h1>Network Exporter</h1><p><a href="%s">Metrics</a></p></body></html>`
+	indexHTML = `<!doctype html><html><head> <meta charset="UTF-8"><title>Network Exporter (Version ` + version + `)</title></head><body><h1>Network Exporter</h1><p><a href="%s">Metrics</a></p><p><a href="%s">Targets</a></p></body></html>`
 )
 
-func init() {
+// parseFlags parses the command line and sets up the logger (in main, the tests of the package don't get the flags)
+func parseFlags() {
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.Version(version)
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
 	logger = promlog.New(promlogConfig)
-	icmpID = &common.IcmpID{}
+	icmpID = common.NewIcmpID()
 }
 
 func main() {
+	parseFlags()
 	level.Info(logger).Log("msg", "Starting network_exporter", "version", version)
 
+	if *cfgReadRetries < 0 || *cfgReadDelay < 0 {
+		level.Error(logger).Log("msg", "Loading config", "err", "--config.read-retries and --config.read-retry-delay must be >=0")
+		os.Exit(1)
+	}
+	sc.ReadRetries = *cfgReadRetries
+	sc.ReadRetryDelay = *cfgReadDelay
+	sc.Overrides = &config.Overrides{
+		ICMPInterval:    *icmpInterval,
+		ICMPTimeout:     *icmpTimeout,
+		ICMPCount:       *icmpCount,
+		MTRInterval:     *mtrInterval,
+		MTRTimeout:      *mtrTimeout,
+		MTRMaxHops:      *mtrMaxHops,
+		MTRCount:        *mtrCount,
+		TCPInterval:     *tcpInterval,
+		TCPTimeout:      *tcpTimeout,
+		HTTPGetInterval: *httpGetInterval,
+		HTTPGetTimeout:  *httpGetTimeout,
+	}
+
+	if *configCheck {
+		os.Exit(runConfigCheck(*configCheckDNS))
+	}
+
 	level.Info(logger).Log("msg", "Loading config")
-	if err := sc.ReloadConfig(logger, *configFile); err != nil {
+	if *configEnv != "" && *enableCfgWrite {
+		level.Error(logger).Log("msg", "Loading config", "err", "--web.enable-config-write requires a config file (--config.env is set)")
+		os.Exit(1)
+	}
+	if err := loadConfig(); err != nil {
 		level.Error(logger).Log("msg", "Loading config", "err", err)
 		os.Exit(1)
 	}
+	if *webConfigFile != "" {
+		c, err := loadWebConfig(*webConfigFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Loading web config", "err", err)
+			os.Exit(1)
+		}
+		webCfg = c
+	}
+	if *probeTokenFile != "" {
+		if _, err := readToken(*probeTokenFile); err != nil {
+			level.Error(logger).Log("msg", "Reading probe token", "err", err)
+			os.Exit(1)
+		}
+	}
+	if *enableCfgWrite && (webCfg == nil || len(webCfg.BasicAuthUsers) == 0) {
+		level.Error(logger).Log("msg", "Enabling config write", "err", "--web.enable-config-write requires the basic_auth_users of --web.config.file")
+		os.Exit(1)
+	}
+	if *enableLifecycle {
+		if *reloadTokenFile == "" && (webCfg == nil || len(webCfg.BasicAuthUsers) == 0) {
+			level.Error(logger).Log("msg", "Enabling lifecycle", "err", "--web.enable-lifecycle requires --web.reload-token-file or the basic_auth_users of --web.config.file")
+			os.Exit(1)
+		}
+		if *reloadTokenFile != "" {
+			if _, err := readToken(*reloadTokenFile); err != nil {
+				level.Error(logger).Log("msg", "Reading reload token", "err", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Unusable address families (IPv6 administratively disabled), their targets are marked once instead of failing every cycle
+	if v4, v6 := common.DetectFamilies(); !v4 || !v6 {
+		action := "marked family_unavailable"
+		if sc.Cfg.Conf.SkipUnavailableFamilies {
+			action = "skipped"
+		}
+		level.Warn(logger).Log("msg", fmt.Sprintf("Address family not usable on this host, its targets are %s", action), "ipv4", v4, "ipv6", v6)
+	}
+
+	// Raw ICMP sockets (CAP_NET_RAW) or the unprivileged ICMP datagram sockets, detected without conf.privileged (not reloaded)
+	setPrivileges(sc.Cfg.Conf.Privileged)
+
+	resolver = getResolver()
+	probeSem = common.NewSemaphore(sc.Cfg.Conf.MaxConcurrency)
+	probePools = common.NewPools(sc.Cfg.Conf.ConcurrencyPools)
+	rateLimiter = common.NewRateLimiter(sc.Cfg.Conf.MaxPps)
+	loadShedder = common.NewLoadShedder(sc.Cfg.Conf.LoadShedLag.Duration(), sc.Cfg.Conf.LoadShedFraction, sc.Cfg.Conf.LoadShedPriority)
+
+	// The first discovery is done before the monitors start, they get the discovered targets with the static ones
+	for _, d := range discoverySources {
+		if d.enabled(sc.Cfg) {
+			refreshDiscovery(d, false)
+		}
+	}
+
+	if *oneshot {
+		os.Exit(runOneshot(*oneshotOutput))
+	}
 
 	reloadSignal()
 
-	resolver := getResolver()
+	if *stateFile != "" {
+		loadState(*stateFile)
+	}
+	if *eventsFile != "" {
+		l, err := common.NewEventLog(logger, *eventsFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Opening event log", "err", err)
+			os.Exit(1)
+		}
+		eventLog = l
+		target.SetEventLog(l)
+	}
+	sinks := []target.ResultSink{}
+	if *resultsFile != "" {
+		l, err := common.NewEventLog(logger, *resultsFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Opening results file", "err", err)
+			os.Exit(1)
+		}
+		resultsLog = l
+		sinks = append(sinks, l)
+	}
+	if *resultsURL != "" {
+		resultsSink = common.NewHTTPSink(logger, *resultsURL, *resultsBatch, *resultsInterval, *resultsTimeout)
+		sinks = append(sinks, resultsSink)
+	}
+	target.SetResultSinks(sinks...)
 
-	monitorPING = monitor.NewPing(logger, sc, resolver, icmpID)
+	// Answers the one-way delay probes of the peers, the listen address is not reloaded
+	if sc.Cfg.OWD.Listen != "" {
+		r, err := owd.NewResponder(logger, sc.Cfg.OWD.Listen)
+		if err != nil {
+			level.Error(logger).Log("msg", "Starting one-way delay responder", "err", err)
+			os.Exit(1)
+		}
+		owdResponder = r
+		level.Info(logger).Log("msg", "Listening for one-way delay probes on "+sc.Cfg.OWD.Listen)
+	}
+
+	// Always-on probe of the box itself, interprets the target latencies under CPU/scheduling pressure
+	selfProbe = target.NewSelf(logger, icmpID, 5*time.Second)
+
+	target.SetSchedules(sc.Cfg.Schedules())
+	monitorPING = monitor.NewPing(logger, sc, resolver, icmpID, rateLimiter, probeSem, probePools, loadShedder)
 	go monitorPING.AddTargets()
 
-	monitorMTR = monitor.NewMTR(logger, sc, resolver, icmpID)
+	monitorMTR = monitor.NewMTR(logger, sc, resolver, icmpID, rateLimiter, probeSem, probePools, loadShedder)
 	go monitorMTR.AddTargets()
 
-	monitorTCP = monitor.NewTCPPort(logger, sc, resolver)
+	monitorTCP = monitor.NewTCPPort(logger, sc, resolver, probeSem, probePools, loadShedder)
 	go monitorTCP.AddTargets()
 
-	monitorHTTPGet = monitor.NewHTTPGet(logger, sc, resolver)
+	monitorUDP = monitor.NewUDP(logger, sc, resolver, probeSem, probePools, loadShedder)
+	go monitorUDP.AddTargets()
+
+	monitorHTTPGet = monitor.NewHTTPGet(logger, sc, resolver, probeSem, probePools, loadShedder)
 	go monitorHTTPGet.AddTargets()
 
+	monitorARP = monitor.NewARP(logger, sc, resolver, probeSem, probePools, loadShedder)
+	go monitorARP.AddTargets()
+
+	monitorOWD = monitor.NewOWD(logger, sc, resolver, probeSem, probePools, loadShedder)
+	go monitorOWD.AddTargets()
+
+	monitorDNS = monitor.NewDNS(logger, sc, resolver, probeSem, probePools, loadShedder)
+	go monitorDNS.AddTargets()
+
 	go startConfigRefresh()
+	go startResolveRefresh()
+	for _, d := range discoverySources {
+		go startDiscovery(d)
+	}
+	go startGraphitePush()
+	go startStateFlush(*stateFile, *stateFlush)
+	shutdownSignal()
 
 	startServer()
 }
@@ -90,23 +301,117 @@ func startConfigRefresh() {
 
 	for range time.NewTicker(interval).C {
 		level.Info(logger).Log("msg", "ReLoading config")
-		if err := sc.ReloadConfig(logger, *configFile); err != nil {
+		if err := reloadConfig(); err != nil {
 			level.Error(logger).Log("msg", "Reloading config skipped", "err", err)
+		}
+	}
+}
+
+// startResolveRefresh periodically resolves the target hosts again without reloading the configuration
+// The targets whose addresses changed are re-created on the new ones, the unchanged targets keep probing
+func startResolveRefresh() {
+	interval := sc.Cfg.Conf.ResolveRefresh.Duration()
+	if interval <= 0 {
+		return
+	}
+
+	for range time.NewTicker(interval).C {
+		level.Debug(logger).Log("msg", "Resolving the targets")
+		reloadMtx.Lock()
+		applyTargets()
+		reloadMtx.Unlock()
+	}
+}
+
+// startGraphitePush periodically sends the probe results to the graphite.address (Carbon plaintext over TCP)
+// The address and prefix are taken from the current configuration on each push
+func startGraphitePush() {
+	sc.RLock()
+	interval := sc.Cfg.Graphite.Interval.Duration()
+	enabled := sc.Cfg.Graphite.Address != ""
+	sc.RUnlock()
+	if !enabled {
+		return
+	}
+
+	g := &collector.Graphite{PING: monitorPING, MTR: monitorMTR, TCP: monitorTCP, UDP: monitorUDP, HTTPGet: monitorHTTPGet, ARP: monitorARP, OWD: monitorOWD, DNS: monitorDNS}
+	for range time.NewTicker(interval).C {
+		sc.RLock()
+		address := sc.Cfg.Graphite.Address
+		prefix := sc.Cfg.Graphite.Prefix
+		sc.RUnlock()
+		if address == "" {
 			continue
-		} else {
-			monitorPING.DelTargets()
-			_ = monitorPING.CheckActiveTargets()
-			monitorPING.AddTargets()
-			monitorMTR.DelTargets()
-			_ = monitorMTR.CheckActiveTargets()
-			monitorMTR.AddTargets()
-			monitorTCP.DelTargets()
-			_ = monitorTCP.CheckActiveTargets()
-			monitorTCP.AddTargets()
-			monitorHTTPGet.DelTargets()
-			monitorHTTPGet.AddTargets()
 		}
+
+		if err := pushGraphite(g, address, prefix, interval); err != nil {
+			level.Error(logger).Log("msg", fmt.Sprintf("Pushing metrics to graphite %s", address), "err", err)
+		}
+	}
+}
+
+// pushGraphite sends a single batch, the push must complete within the interval
+func pushGraphite(g *collector.Graphite, address string, prefix string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
 	}
+	return g.Write(conn, prefix, time.Now())
+}
+
+// loadConfig reloads the configuration from the file or the environment variable
+func loadConfig() error {
+	if *configEnv != "" {
+		return sc.ReloadConfigEnv(logger, *configEnv)
+	}
+	return sc.ReloadConfig(logger, *configFile)
+}
+
+// reloadConfig reloads the configuration file and applies the target changes to the monitors
+func reloadConfig() error {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	applyTargets()
+	return nil
+}
+
+// applyTargets applies the configuration changes to the monitors, the callers hold reloadMtx
+func applyTargets() {
+	rateLimiter.SetRate(sc.Cfg.Conf.MaxPps)
+	loadShedder.Set(sc.Cfg.Conf.LoadShedLag.Duration(), sc.Cfg.Conf.LoadShedFraction, sc.Cfg.Conf.LoadShedPriority)
+	// The hosts are resolved once per reload, shared by the ICMP and MTR monitors
+	resolver.NewPass()
+	loadGeoIP(resolver.GeoIP)
+	target.SetSchedules(sc.Cfg.Schedules())
+	monitorPING.DelTargets()
+	_ = monitorPING.CheckActiveTargets()
+	monitorPING.AddTargets()
+	monitorMTR.DelTargets()
+	_ = monitorMTR.CheckActiveTargets()
+	monitorMTR.AddTargets()
+	monitorTCP.DelTargets()
+	_ = monitorTCP.CheckActiveTargets()
+	monitorTCP.AddTargets()
+	monitorUDP.DelTargets()
+	_ = monitorUDP.CheckActiveTargets()
+	monitorUDP.AddTargets()
+	monitorHTTPGet.DelTargets()
+	monitorHTTPGet.AddTargets()
+	monitorARP.DelTargets()
+	monitorARP.AddTargets()
+	monitorOWD.DelTargets()
+	monitorOWD.AddTargets()
+	monitorDNS.DelTargets()
+	monitorDNS.AddTargets()
 }
 
 func startServer() {
@@ -114,16 +419,43 @@ func startServer() {
 	metricsPath := "/metrics"
 
 	reg := prometheus.NewRegistry()
-	reg.MustRegister(collectors.NewGoCollector())
-	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
-	reg.MustRegister(&collector.MTR{Monitor: monitorMTR})
-	reg.MustRegister(&collector.PING{Monitor: monitorPING})
-	reg.MustRegister(&collector.TCP{Monitor: monitorTCP})
-	reg.MustRegister(&collector.HTTPGet{Monitor: monitorHTTPGet})
-	h := promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
-	mux.Handle(metricsPath, h)
+	runtimeReg := prometheus.WrapRegistererWithPrefix(*collectorPrefix, reg)
+	if *collectorGo {
+		runtimeReg.MustRegister(collectors.NewGoCollector())
+	}
+	if *collectorProcess {
+		runtimeReg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	reg.MustRegister(&collector.MTR{SC: sc, Monitor: monitorMTR, GeoIP: resolver.GeoIP})
+	reg.MustRegister(&collector.PING{SC: sc, Monitor: monitorPING})
+	reg.MustRegister(&collector.TCP{SC: sc, Monitor: monitorTCP})
+	reg.MustRegister(&collector.HTTPGet{SC: sc, Monitor: monitorHTTPGet})
+	reg.MustRegister(&collector.ARP{SC: sc, Monitor: monitorARP})
+	reg.MustRegister(&collector.OWD{SC: sc, Monitor: monitorOWD})
+	reg.MustRegister(&collector.DNS{SC: sc, Monitor: monitorDNS})
+	reg.MustRegister(&collector.UDP{SC: sc, Monitor: monitorUDP})
+	reg.MustRegister(&collector.Exporter{SC: sc, PING: monitorPING, MTR: monitorMTR, TCP: monitorTCP, UDP: monitorUDP, HTTPGet: monitorHTTPGet, ARP: monitorARP, OWD: monitorOWD, DNS: monitorDNS, Breaker: resolver.Breaker, Resolver: resolver, Results: resultsSink, RateLimiter: rateLimiter, LoadShedder: loadShedder, Self: selfProbe, Sem: probeSem, Pools: probePools, Responder: owdResponder})
+	g := relabelGatherer(reg)
+	h := promhttp.HandlerFor(g, promhttp.HandlerOpts{DisableCompression: false})
+	mux.Handle(metricsPath, compressHandler(h))
+	mux.Handle(metricsPath+"/", targetHandler(metricsPath+"/", g))
+	mux.Handle("/targets", targetsHandler())
+	mux.Handle("/probe", tokenAuthHandler(*probeTokenFile, http.HandlerFunc(probeHandler)))
+	if *enableLifecycle {
+		mux.Handle("/-/reload", tokenAuthHandler(*reloadTokenFile, reloadHandler()))
+	}
+	if *enableCfgWrite {
+		mux.Handle("/config", configHandler(*configFile))
+	}
+	if *enableInflux {
+		mux.Handle(metricsPath+"/influx", compressHandler(&collector.Influx{PING: monitorPING, MTR: monitorMTR, TCP: monitorTCP, UDP: monitorUDP, HTTPGet: monitorHTTPGet, ARP: monitorARP, OWD: monitorOWD, DNS: monitorDNS}))
+	}
+	prefix := "/" + strings.Trim(*routePrefix, "/")
+	if prefix == "/" {
+		prefix = ""
+	}
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, indexHTML, metricsPath)
+		fmt.Fprintf(w, indexHTML, prefix+metricsPath, prefix+"/targets")
 	})
 
 	if *enableProfileing {
@@ -137,23 +469,112 @@ func startServer() {
 		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 	}
 
+	// The routes are registered without the prefix, it's stripped before reaching them
+	var handler http.Handler = mux
+	if prefix != "" {
+		root := http.NewServeMux()
+		root.Handle(prefix+"/", http.StripPrefix(prefix, mux))
+		root.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			http.Redirect(w, r, prefix+"/", http.StatusFound)
+		})
+		handler = root
+	}
+	handler = basicAuthHandler(webCfg, handler)
+
 	level.Info(logger).Log("msg", "Starting ping exporter", "version", version)
-	level.Info(logger).Log("msg", fmt.Sprintf("Listening for %s on %s", metricsPath, *listenAddress))
-	level.Error(logger).Log("msg", "Could not start http", "err", http.ListenAndServe(*listenAddress, mux))
+	// Validated with the web config at startup
+	tlsCfg, _ := webCfg.tlsConfig()
+	level.Info(logger).Log("msg", fmt.Sprintf("Listening for %s on %s", prefix+metricsPath, *listenAddress), "tls", tlsCfg != nil)
+	srv := &http.Server{
+		Addr:         *listenAddress,
+		Handler:      handler,
+		TLSConfig:    tlsCfg,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+	if tlsCfg != nil {
+		level.Error(logger).Log("msg", "Could not start https", "err", srv.ListenAndServeTLS("", ""))
+		return
+	}
+	level.Error(logger).Log("msg", "Could not start http", "err", srv.ListenAndServe())
 }
 
 func getResolver() *config.Resolver {
+	// Shared by all the lookups, protects the DNS infrastructure during incidents
+	breaker := common.NewBreaker(sc.Cfg.Conf.ResolverBreakerThreshold, sc.Cfg.Conf.ResolverBreakerWindow.Duration(), sc.Cfg.Conf.ResolverBreakerCooldown.Duration())
+	geoIP := common.NewGeoIP()
+	loadGeoIP(geoIP)
+
 	if sc.Cfg.Conf.Nameserver == "" {
 		level.Info(logger).Log("msg", "Configured default DNS resolver")
-		return &config.Resolver{Resolver: net.DefaultResolver, Timeout: sc.Cfg.Conf.NameserverTimeout.Duration()}
+		return &config.Resolver{Resolver: net.DefaultResolver, Breaker: breaker, GeoIP: geoIP, Timeout: sc.Cfg.Conf.NameserverTimeout.Duration()}
+	}
+
+	// The hosts file is consulted first like the system resolver does
+	hostsFile := ""
+	if !sc.Cfg.Conf.NameserverNoHosts {
+		hostsFile = sc.Cfg.Conf.HostsFile
+		if hostsFile == "" {
+			hostsFile = defaultHostsFile()
+		}
 	}
 
-	level.Info(logger).Log("msg", "Configured custom DNS resolver")
+	level.Info(logger).Log("msg", fmt.Sprintf("Configured custom DNS resolver (hosts file: %q)", hostsFile))
 	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
 		d := net.Dialer{Timeout: sc.Cfg.Conf.NameserverTimeout.Duration()}
 		return d.DialContext(ctx, "udp", sc.Cfg.Conf.Nameserver)
 	}
-	return &config.Resolver{Resolver: &net.Resolver{PreferGo: true, Dial: dialer}, Timeout: sc.Cfg.Conf.NameserverTimeout.Duration()}
+	return &config.Resolver{Resolver: &net.Resolver{PreferGo: true, Dial: dialer}, HostsFile: hostsFile, Breaker: breaker, GeoIP: geoIP, Timeout: sc.Cfg.Conf.NameserverTimeout.Duration()}
+}
+
+// loadGeoIP (re)opens the optional ASN/Country databases when their paths or files changed, the current ones are kept when they can't be opened
+func loadGeoIP(geoIP *common.GeoIP) {
+	loaded, err := geoIP.Load(sc.Cfg.Conf.GeoIPAsnDB, sc.Cfg.Conf.GeoIPCountryDB)
+	if err != nil {
+		level.Error(logger).Log("msg", "Could not load the GeoIP databases", "enabled", geoIP.Enabled(), "err", err)
+		return
+	}
+	if !loaded {
+		return
+	}
+	if !geoIP.Enabled() {
+		level.Info(logger).Log("msg", "Disabled GeoIP enrichment")
+		return
+	}
+	level.Info(logger).Log("msg", fmt.Sprintf("Configured GeoIP enrichment (asn db: %q, country db: %q)", sc.Cfg.Conf.GeoIPAsnDB, sc.Cfg.Conf.GeoIPCountryDB))
+}
+
+// setPrivileges selects the ICMP socket mode, the unprivileged mode is used when the raw sockets can't be opened (auto-detection)
+func setPrivileges(privileged *bool) {
+	raw, datagram := common.DetectPrivileges()
+	unprivileged := "the MTR hops are probed with UDP (ICMP errors of the socket error queue, Linux only), no ICMP timestamp, path MTU, reply DSCP nor ARP/NDP"
+	switch {
+	case privileged == nil && !raw && datagram:
+		common.SetPrivileged(false)
+		level.Warn(logger).Log("msg", "Raw ICMP sockets not permitted (CAP_NET_RAW), using the unprivileged ICMP datagram sockets: "+unprivileged)
+	case privileged == nil && !raw:
+		level.Warn(logger).Log("msg", "Neither the raw ICMP sockets (CAP_NET_RAW) nor the unprivileged ICMP datagram sockets (net.ipv4.ping_group_range) are permitted, the ICMP probes will fail")
+	case privileged != nil && !*privileged:
+		common.SetPrivileged(false)
+		level.Warn(logger).Log("msg", "Using the unprivileged ICMP datagram sockets (conf.privileged: false): "+unprivileged)
+		if !datagram {
+			level.Warn(logger).Log("msg", "The unprivileged ICMP datagram sockets are not permitted (net.ipv4.ping_group_range), the ICMP probes will fail")
+		}
+	case !raw:
+		level.Warn(logger).Log("msg", "Raw ICMP sockets not permitted (CAP_NET_RAW), the ICMP probes will fail (conf.privileged: true)")
+	}
+}
+
+func defaultHostsFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
 }
 
 func expVars(w http.ResponseWriter, r *http.Request) {
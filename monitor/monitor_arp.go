@@ -0,0 +1,278 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/pkg/arp"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/target"
+)
+
+// ARP manages the goroutines responsible for collecting ARP data
+type ARP struct {
+	logger   log.Logger
+	sc       *config.SafeConfig
+	resolver *config.Resolver
+	sem      *common.Semaphore
+	pools    *common.Pools
+	shedder  *common.LoadShedder
+	interval time.Duration
+	jitter   time.Duration
+	timeout  time.Duration
+	tJitter  time.Duration
+	targets  map[string]*target.ARP
+	applied  appliedConfigs
+	slots    []string
+	mtx      sync.RWMutex
+}
+
+// NewARP creates and configures a new Monitoring ARP instance
+func NewARP(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, sem *common.Semaphore, pools *common.Pools, shedder *common.LoadShedder) *ARP {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &ARP{
+		logger:   logger,
+		sc:       sc,
+		resolver: resolver,
+		sem:      sem,
+		pools:    pools,
+		shedder:  shedder,
+		interval: sc.Cfg.ARP.Interval.Duration(),
+		jitter:   sc.Cfg.ARP.Interval.Max() - sc.Cfg.ARP.Interval.Duration(),
+		timeout:  sc.Cfg.ARP.Timeout.Duration(),
+		tJitter:  sc.Cfg.Conf.TimeoutJitter.Duration(),
+		targets:  make(map[string]*target.ARP),
+	}
+}
+
+// Stop brings the monitoring gracefully to a halt
+func (p *ARP) Stop() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for id := range p.targets {
+		p.removeTarget(id)
+	}
+}
+
+// Wait blocks until the goroutines of all the targets return (one-shot mode)
+func (p *ARP) Wait() {
+	p.mtx.RLock()
+	targets := make([]*target.ARP, 0, len(p.targets))
+	for _, t := range p.targets {
+		targets = append(targets, t)
+	}
+	p.mtx.RUnlock()
+
+	for _, t := range targets {
+		t.Wait()
+	}
+}
+
+// AddTargets adds newly added targets from the configuration
+func (p *ARP) AddTargets() {
+	level.Debug(p.logger).Log("type", "ARP", "func", "AddTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "ARP")))
+
+	targetActiveTmp := []string{}
+	for _, v := range p.targets {
+		targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
+	}
+
+	targetConfigTmp := []string{}
+	for _, v := range p.sc.Cfg.Targets {
+		if v.Type == "ARP" {
+			targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name)
+		}
+	}
+
+	// The keys of the pass set the start phases (conf.start-spread)
+	p.mtx.Lock()
+	p.slots = targetConfigTmp
+	p.mtx.Unlock()
+
+	targetAdd := common.CompareList(targetActiveTmp, targetConfigTmp)
+	level.Debug(p.logger).Log("type", "ARP", "func", "AddTargets", "msg", fmt.Sprintf("targetName: %v", targetAdd))
+
+	for _, targetName := range targetAdd {
+		for _, target := range p.sc.Cfg.Targets {
+			if target.Name != targetName {
+				continue
+			}
+			if target.Type == "ARP" {
+				p.applied.set(target.Name, target)
+				err := p.AddTarget(target)
+				if err != nil {
+					level.Warn(p.logger).Log("type", "ARP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
+				}
+			}
+		}
+	}
+}
+
+// AddTarget adds a target to the monitored list
+func (p *ARP) AddTarget(t config.Target) (err error) {
+	return p.AddTargetDelayed(t, 0)
+}
+
+// AddTargetDelayed is AddTarget with a startup delay
+func (p *ARP) AddTargetDelayed(t config.Target, startupDelay time.Duration) (err error) {
+	level.Info(p.logger).Log("type", "ARP", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s on %s) in %s", t.Name, t.Host, t.BindDevice, startupDelay))
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// The first IPv4 address is probed with ARP, without IPv4 (resolve: ipv6) the first IPv6 with NDP
+	ipAddrs, err := common.DestAddrsMode(context.Background(), t.Host, t.Resolve, p.resolver.Resolver, p.resolver.HostsFile, p.resolver.Breaker, p.resolver.Timeout)
+	if err != nil {
+		return err
+	}
+	ip := ""
+	for _, ipAddr := range ipAddrs {
+		parsed := net.ParseIP(ipAddr)
+		if parsed == nil {
+			continue
+		}
+		if parsed.To4() != nil {
+			ip = ipAddr
+			break
+		}
+		if ip == "" {
+			ip = ipAddr
+		}
+	}
+	if ip == "" {
+		return fmt.Errorf("no IP address for %s", t.Host)
+	}
+
+	startupDelay = startDelay(p.sc, p.slots, t.Name, p.interval, startupDelay)
+	target, err := target.NewARP(p.logger, p.sem, p.pools.Get(t.Pool, "ARP"), p.shedder, startupDelay, target.ARPOptions{
+		Options: target.Options{Name: t.Name, Host: t.Host, IP: ip, SrcAddr: t.SourceIp, Interval: p.interval, Jitter: p.jitter, Timeout: jitteredTimeout(p.timeout, p.tJitter), Priority: t.Priority, DependsOn: t.DependsOn, Labels: t.Labels.Kv},
+		Device:  t.BindDevice,
+	})
+	if err != nil {
+		return err
+	}
+	p.removeTarget(t.Name)
+	p.targets[t.Name] = target
+	return nil
+}
+
+// DelTargets deletes/stops the removed targets from the configuration
+func (p *ARP) DelTargets() {
+	level.Debug(p.logger).Log("type", "ARP", "func", "DelTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "ARP")))
+
+	// The targets whose configuration changed are re-created by AddTargets, the unchanged ones keep running
+	for _, name := range p.applied.changed(p.sc, "ARP") {
+		p.mtx.Lock()
+		for key := range p.targets {
+			if strings.SplitN(key, " ", 2)[0] == name {
+				level.Info(p.logger).Log("type", "ARP", "func", "DelTargets", "msg", fmt.Sprintf("Re-creating changed Target: %s", key))
+				p.removeTarget(key)
+			}
+		}
+		p.mtx.Unlock()
+	}
+
+	targetActiveTmp := []string{}
+	for _, v := range p.targets {
+		if v != nil {
+			targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
+		}
+	}
+
+	targetConfigTmp := []string{}
+	for _, v := range p.sc.Cfg.Targets {
+		if v.Type == "ARP" {
+			targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name)
+		}
+	}
+
+	targetDelete := common.CompareList(targetConfigTmp, targetActiveTmp)
+	for _, targetName := range targetDelete {
+		for _, t := range p.targets {
+			if t == nil {
+				continue
+			}
+			if t.Name() == targetName {
+				p.RemoveTarget(targetName)
+			}
+		}
+	}
+}
+
+// RemoveTarget removes a target from the monitoring list
+func (p *ARP) RemoveTarget(key string) {
+	level.Info(p.logger).Log("type", "ARP", "func", "RemoveTarget", "msg", fmt.Sprintf("Removing Target: %s", key))
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.removeTarget(key)
+}
+
+// Stops monitoring a target and removes it from the list (if the list includes the target)
+func (p *ARP) removeTarget(key string) {
+	target, found := p.targets[key]
+	if !found {
+		return
+	}
+	target.Stop()
+	delete(p.targets, key)
+}
+
+// ExportMetrics collects the metrics for each monitored target and returns it as a simple map
+func (p *ARP) ExportMetrics() map[string]*arp.ARPReturn {
+	m := make(map[string]*arp.ARPReturn)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		name := target.Name()
+		metrics := target.Compute()
+
+		if metrics != nil {
+			// level.Debug(p.logger).Log("type", "ARP", "func", "ExportMetrics", "msg", fmt.Sprintf("Name: %s, Metrics: %+v, Labels: %+v", name, metrics, target.Labels()))
+			m[name] = metrics
+		}
+	}
+	return m
+}
+
+// ExportLabels target labels
+func (p *ARP) ExportLabels() map[string]map[string]string {
+	l := make(map[string]map[string]string)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		name := target.Name()
+		labels := target.Labels()
+
+		if labels != nil {
+			l[name] = labels
+		}
+	}
+	return l
+}
+
+// ExportStats target scheduling details
+func (p *ARP) ExportStats() map[string]target.ProbeStats {
+	st := make(map[string]target.ProbeStats)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		st[target.Name()] = target.Stats()
+	}
+	return st
+}
@@ -1,9 +1,16 @@
 package monitor
 
 import (
+	"encoding/json"
+	"math/rand"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/pkg/common"
 )
 
 // countTargets Count the number of target by type
@@ -16,3 +23,175 @@ func countTargets(sc *config.SafeConfig, target string) (count int) {
 	}
 	return count
 }
+
+// jitteredTimeout shortens the timeout of a new target by a random part of conf.timeout-jitter
+// The targets failing together (common upstream outage) then time out at different moments
+func jitteredTimeout(timeout time.Duration, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return timeout
+	}
+	return timeout - time.Duration(rand.Int63n(int64(jitter)+1))
+}
+
+// targetTiming returns the interval, jitter and timeout of a target, its overrides (>0) take precedence over the protocol settings
+// The command-line overrides of the protocol (already set as its settings) take precedence over the target ones
+func targetTiming(sc *config.SafeConfig, protocol string, interval time.Duration, jitter time.Duration, timeout time.Duration, defInterval time.Duration, defJitter time.Duration, defTimeout time.Duration) (time.Duration, time.Duration, time.Duration) {
+	flagInterval, flagTimeout, _, _ := sc.Overrides.Timing(protocol)
+	if interval <= 0 || flagInterval > 0 {
+		interval, jitter = defInterval, defJitter
+	}
+	if timeout <= 0 || flagTimeout > 0 {
+		timeout = defTimeout
+	}
+	return interval, jitter, timeout
+}
+
+// targetCount returns the count and max-hops of a target, as targetTiming the command-line overrides take precedence over the target ones
+func targetCount(sc *config.SafeConfig, protocol string, count int, maxHops int, defCount int, defMaxHops int) (int, int) {
+	_, _, flagCount, flagMaxHops := sc.Overrides.Timing(protocol)
+	if count <= 0 || flagCount > 0 {
+		count = defCount
+	}
+	if maxHops <= 0 || flagMaxHops > 0 {
+		maxHops = defMaxHops
+	}
+	return count, maxHops
+}
+
+// targetSource returns the source ip and interface of a target, its overrides take precedence over the conf ones
+// The conf source_ip only applies to the targets of its address family
+func targetSource(srcAddr string, device string, ip string, defSrcAddr string, defDevice string) (string, string) {
+	if srcAddr == "" && common.SameFamily(ip, defSrcAddr) {
+		srcAddr = defSrcAddr
+	}
+	if device == "" {
+		device = defDevice
+	}
+	return srcAddr, device
+}
+
+// dscpLabels returns the labels of a target with the dscp of its probes (a copy, unchanged for unmarked probes)
+func dscpLabels(labels map[string]string, dscp int) map[string]string {
+	if dscp == 0 {
+		return labels
+	}
+	l := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		l[k] = v
+	}
+	l["dscp"] = strconv.Itoa(dscp)
+	return l
+}
+
+// skipFamily returns the family_unavailable error when the address family of the ip is not usable and conf.skip-unavailable-families is set
+// Otherwise the target is added and marked unavailable (never probed)
+func skipFamily(sc *config.SafeConfig, ip string) error {
+	if sc.Cfg.Conf.SkipUnavailableFamilies && !common.FamilyUsable(ip) {
+		return common.FamilyError(ip)
+	}
+	return nil
+}
+
+// startDelay returns the startup delay of a target, with conf.start-spread its cycles are shifted to the phase of its slot in the interval
+// The slots are the target keys of the pass in the configuration order, each of the n targets starts interval/n after the previous one
+// The phases are aligned on the clock so they are kept across the restarts, the targets missing from the slots start after their delay
+func startDelay(sc *config.SafeConfig, slots []string, name string, interval time.Duration, delay time.Duration) time.Duration {
+	if !sc.Cfg.Conf.StartSpread || interval <= 0 {
+		return delay
+	}
+	slot := -1
+	for i, s := range slots {
+		if s == name {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		return delay
+	}
+	phase := time.Duration(int64(interval) * int64(slot) / int64(len(slots)))
+	start := time.Duration(time.Now().Add(delay).UnixNano()) % interval
+	return delay + (phase-start+interval)%interval
+}
+
+// addrLabels returns the labels of a target address: the ip_version (4 or 6) and the GeoIP ones added to the configured labels (these take precedence)
+func addrLabels(geoIP *common.GeoIP, ip string, labels map[string]string) map[string]string {
+	l := map[string]string{}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		l["ip_version"] = "6"
+		if parsed.To4() != nil {
+			l["ip_version"] = "4"
+		}
+	}
+	for k, v := range geoIP.Labels(ip, labels) {
+		l[k] = v
+	}
+	return l
+}
+
+// familyAddrs returns the first address of each family (in the resolver order), the MTR traces a single path per family
+func familyAddrs(addrs []string) []string {
+	out := []string{}
+	var v4, v6 bool
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil || (ip.To4() != nil && v4) || (ip.To4() == nil && v6) {
+			continue
+		}
+		if ip.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// appliedConfigs Configuration of the targets when they were started (by target name)
+// The reloads only re-create the targets whose configuration changed, the unchanged ones keep running
+type appliedConfigs struct {
+	mtx sync.Mutex
+	m   map[string]string
+}
+
+func fingerprint(target interface{}) string {
+	b, _ := json.Marshal(target)
+	return string(b)
+}
+
+// set records the configuration a target is started with
+func (a *appliedConfigs) set(name string, target interface{}) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.m == nil {
+		a.m = map[string]string{}
+	}
+	a.m[name] = fingerprint(target)
+}
+
+// changed returns the names of the targets (of the types) whose configuration differs from the applied one
+// The changed and removed targets are forgotten until they are started again
+func (a *appliedConfigs) changed(sc *config.SafeConfig, types ...string) (names []string) {
+	current := map[string]string{}
+	for _, v := range sc.Cfg.Targets {
+		for _, t := range types {
+			if v.Type == t {
+				current[v.Name] = fingerprint(v)
+			}
+		}
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for name, fp := range a.m {
+		cur, found := current[name]
+		if found && cur != fp {
+			names = append(names, name)
+		}
+		if !found || cur != fp {
+			delete(a.m, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syepes/network_exporter/config"
+)
+
+func TestTargetTimingFlagOverrides(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "network_exporter.yml")
+	cfg := "icmp:\n  interval: 5s\n  timeout: 4s\n  count: 10\nmtr:\n  interval: 30s\n  timeout: 20s\n  count: 10\n  max-hops: 30\ntargets:\n" +
+		"  - name: target\n    host: 192.0.2.10\n    type: ICMP+MTR\n    interval: 60s\n    timeout: 30s\n    count: 20\n    max-hops: 15\n"
+	sc := &config.SafeConfig{Cfg: &config.Config{}, Overrides: &config.Overrides{ICMPTimeout: time.Second, ICMPCount: 3, MTRMaxHops: 8}}
+	loadConfig(t, sc, file, cfg)
+	tg := sc.Cfg.Targets[0]
+	icmp, mtr := sc.Cfg.ICMP, sc.Cfg.MTR
+
+	// The ICMP flags win over the target overrides, the target interval is kept as no flag sets it
+	interval, _, timeout := targetTiming(sc, "ICMP", tg.Interval.Duration(), 0, tg.Timeout.Duration(), icmp.Interval.Duration(), 0, icmp.Timeout.Duration())
+	if interval != 60*time.Second || timeout != time.Second {
+		t.Errorf("ICMP interval/timeout = %s/%s, want 1m0s/1s (--icmp.timeout)", interval, timeout)
+	}
+	if count, _ := targetCount(sc, "ICMP", tg.Count, 0, icmp.Count, 0); count != 3 {
+		t.Errorf("ICMP count = %d, want 3 (--icmp.count)", count)
+	}
+
+	// The ICMP flags don't apply to the MTR part of the target
+	_, _, timeout = targetTiming(sc, "MTR", tg.Interval.Duration(), 0, tg.Timeout.Duration(), mtr.Interval.Duration(), 0, mtr.Timeout.Duration())
+	if timeout != 30*time.Second {
+		t.Errorf("MTR timeout = %s, want the target 30s", timeout)
+	}
+	if count, maxHops := targetCount(sc, "MTR", tg.Count, tg.MaxHops, mtr.Count, mtr.MaxHops); count != 20 || maxHops != 8 {
+		t.Errorf("MTR count/max-hops = %d/%d, want 20/8 (--mtr.max-hops)", count, maxHops)
+	}
+
+	// Without flags the target overrides take precedence
+	sc.Overrides = nil
+	if _, _, timeout := targetTiming(sc, "ICMP", tg.Interval.Duration(), 0, tg.Timeout.Duration(), icmp.Interval.Duration(), 0, icmp.Timeout.Duration()); timeout != 30*time.Second {
+		t.Errorf("ICMP timeout without flags = %s, want the target 30s", timeout)
+	}
+}
+
+func TestStartDelaySpread(t *testing.T) {
+	sc := &config.SafeConfig{Cfg: &config.Config{}}
+	sc.Cfg.Conf.StartSpread = true
+	interval := time.Second
+	slots := []string{"a 192.0.2.1", "a 192.0.2.2", "b 192.0.2.3", "c 192.0.2.4"}
+
+	for _, tc := range []struct {
+		name  string
+		delay time.Duration
+		phase time.Duration
+	}{
+		{"a 192.0.2.1", 0, 0},
+		{"a 192.0.2.2", 0, 250 * time.Millisecond},
+		{"b 192.0.2.3", 0, 500 * time.Millisecond},
+		{"c 192.0.2.4", 0, 750 * time.Millisecond},
+		// An explicit delay is extended up to the phase
+		{"b 192.0.2.3", 1500 * time.Millisecond, 500 * time.Millisecond},
+	} {
+		d := startDelay(sc, slots, tc.name, interval, tc.delay)
+		if d < tc.delay || d >= tc.delay+interval {
+			t.Errorf("%s: delay %s, want between %s and %s", tc.name, d, tc.delay, tc.delay+interval)
+		}
+		// The first cycle starts at the phase of the slot in the interval
+		phase := time.Duration(time.Now().Add(d).UnixNano()) % interval
+		if diff := (phase - tc.phase + interval) % interval; diff > 20*time.Millisecond && diff < interval-20*time.Millisecond {
+			t.Errorf("%s: starts at the phase %s of the interval, want %s", tc.name, phase, tc.phase)
+		}
+	}
+
+	if d := startDelay(sc, slots, "missing 192.0.2.9", interval, 0); d != 0 {
+		t.Errorf("target missing from the slots delayed by %s, want 0", d)
+	}
+	sc.Cfg.Conf.StartSpread = false
+	if d := startDelay(sc, slots, "c 192.0.2.4", interval, 0); d != 0 {
+		t.Errorf("delay without start-spread = %s, want 0", d)
+	}
+}
@@ -0,0 +1,263 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/dns"
+	"github.com/syepes/network_exporter/target"
+)
+
+// DNS manages the goroutines responsible for collecting DNS data
+type DNS struct {
+	logger   log.Logger
+	sc       *config.SafeConfig
+	resolver *config.Resolver
+	sem      *common.Semaphore
+	pools    *common.Pools
+	shedder  *common.LoadShedder
+	interval time.Duration
+	jitter   time.Duration
+	timeout  time.Duration
+	tJitter  time.Duration
+	server   string
+	targets  map[string]*target.DNS
+	applied  appliedConfigs
+	slots    []string
+	mtx      sync.RWMutex
+}
+
+// NewDNS creates and configures a new Monitoring DNS instance
+func NewDNS(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, sem *common.Semaphore, pools *common.Pools, shedder *common.LoadShedder) *DNS {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &DNS{
+		logger:   logger,
+		sc:       sc,
+		resolver: resolver,
+		sem:      sem,
+		pools:    pools,
+		shedder:  shedder,
+		interval: sc.Cfg.DNS.Interval.Duration(),
+		jitter:   sc.Cfg.DNS.Interval.Max() - sc.Cfg.DNS.Interval.Duration(),
+		timeout:  sc.Cfg.DNS.Timeout.Duration(),
+		tJitter:  sc.Cfg.Conf.TimeoutJitter.Duration(),
+		server:   sc.Cfg.Conf.Nameserver,
+		targets:  make(map[string]*target.DNS),
+	}
+}
+
+// Stop brings the monitoring gracefully to a halt
+func (p *DNS) Stop() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for id := range p.targets {
+		p.removeTarget(id)
+	}
+}
+
+// Wait blocks until the goroutines of all the targets return (one-shot mode)
+func (p *DNS) Wait() {
+	p.mtx.RLock()
+	targets := make([]*target.DNS, 0, len(p.targets))
+	for _, t := range p.targets {
+		targets = append(targets, t)
+	}
+	p.mtx.RUnlock()
+
+	for _, t := range targets {
+		t.Wait()
+	}
+}
+
+// AddTargets adds newly added targets from the configuration
+func (p *DNS) AddTargets() {
+	level.Debug(p.logger).Log("type", "DNS", "func", "AddTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "DNS")))
+
+	targetActiveTmp := []string{}
+	for _, v := range p.targets {
+		targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
+	}
+
+	targetConfigTmp := []string{}
+	for _, v := range p.sc.Cfg.Targets {
+		if v.Type == "DNS" {
+			targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name)
+		}
+	}
+
+	// The keys of the pass set the start phases (conf.start-spread)
+	p.mtx.Lock()
+	p.slots = targetConfigTmp
+	p.mtx.Unlock()
+
+	targetAdd := common.CompareList(targetActiveTmp, targetConfigTmp)
+	level.Debug(p.logger).Log("type", "DNS", "func", "AddTargets", "msg", fmt.Sprintf("targetName: %v", targetAdd))
+
+	for _, targetName := range targetAdd {
+		for _, target := range p.sc.Cfg.Targets {
+			if target.Name != targetName {
+				continue
+			}
+			if target.Type == "DNS" {
+				p.applied.set(target.Name, target)
+				err := p.AddTarget(target)
+				if err != nil {
+					level.Warn(p.logger).Log("type", "DNS", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
+				}
+			}
+		}
+	}
+}
+
+// AddTarget adds a target to the monitored list
+func (p *DNS) AddTarget(t config.Target) (err error) {
+	return p.AddTargetDelayed(t, 0)
+}
+
+// AddTargetDelayed is AddTarget with a startup delay
+func (p *DNS) AddTargetDelayed(t config.Target, startupDelay time.Duration) (err error) {
+	level.Info(p.logger).Log("type", "DNS", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s) in %s", t.Name, t.Host, startupDelay))
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// The host is the queried name, the target nameserver overrides the conf.nameserver one (default: system nameserver)
+	server := t.DNSServer
+	if server == "" {
+		server = p.server
+	}
+	server = dns.ServerAddr(server)
+
+	startupDelay = startDelay(p.sc, p.slots, t.Name, p.interval, startupDelay)
+	target, err := target.NewDNS(p.logger, p.sem, p.pools.Get(t.Pool, "DNS"), p.shedder, startupDelay, target.DNSOptions{
+		Options: target.Options{Name: t.Name, Host: t.Host, SrcAddr: t.SourceIp, Interval: p.interval, Jitter: p.jitter, Timeout: jitteredTimeout(p.timeout, p.tJitter), Priority: t.Priority, DependsOn: t.DependsOn, Labels: t.Labels.Kv},
+		Server:  server,
+		QType:   t.DNSType,
+	})
+	if err != nil {
+		return err
+	}
+	p.removeTarget(t.Name)
+	p.targets[t.Name] = target
+	return nil
+}
+
+// DelTargets deletes/stops the removed targets from the configuration
+func (p *DNS) DelTargets() {
+	level.Debug(p.logger).Log("type", "DNS", "func", "DelTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "DNS")))
+
+	// The targets whose configuration changed are re-created by AddTargets, the unchanged ones keep running
+	for _, name := range p.applied.changed(p.sc, "DNS") {
+		p.mtx.Lock()
+		for key := range p.targets {
+			if strings.SplitN(key, " ", 2)[0] == name {
+				level.Info(p.logger).Log("type", "DNS", "func", "DelTargets", "msg", fmt.Sprintf("Re-creating changed Target: %s", key))
+				p.removeTarget(key)
+			}
+		}
+		p.mtx.Unlock()
+	}
+
+	targetActiveTmp := []string{}
+	for _, v := range p.targets {
+		if v != nil {
+			targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
+		}
+	}
+
+	targetConfigTmp := []string{}
+	for _, v := range p.sc.Cfg.Targets {
+		if v.Type == "DNS" {
+			targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name)
+		}
+	}
+
+	targetDelete := common.CompareList(targetConfigTmp, targetActiveTmp)
+	for _, targetName := range targetDelete {
+		for _, t := range p.targets {
+			if t == nil {
+				continue
+			}
+			if t.Name() == targetName {
+				p.RemoveTarget(targetName)
+			}
+		}
+	}
+}
+
+// RemoveTarget removes a target from the monitoring list
+func (p *DNS) RemoveTarget(key string) {
+	level.Info(p.logger).Log("type", "DNS", "func", "RemoveTarget", "msg", fmt.Sprintf("Removing Target: %s", key))
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.removeTarget(key)
+}
+
+// Stops monitoring a target and removes it from the list (if the list includes the target)
+func (p *DNS) removeTarget(key string) {
+	target, found := p.targets[key]
+	if !found {
+		return
+	}
+	target.Stop()
+	delete(p.targets, key)
+}
+
+// ExportMetrics collects the metrics for each monitored target and returns it as a simple map
+func (p *DNS) ExportMetrics() map[string]*dns.DNSReturn {
+	m := make(map[string]*dns.DNSReturn)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		name := target.Name()
+		metrics := target.Compute()
+
+		if metrics != nil {
+			// level.Debug(p.logger).Log("type", "DNS", "func", "ExportMetrics", "msg", fmt.Sprintf("Name: %s, Metrics: %+v, Labels: %+v", name, metrics, target.Labels()))
+			m[name] = metrics
+		}
+	}
+	return m
+}
+
+// ExportLabels target labels
+func (p *DNS) ExportLabels() map[string]map[string]string {
+	l := make(map[string]map[string]string)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		name := target.Name()
+		labels := target.Labels()
+
+		if labels != nil {
+			l[name] = labels
+		}
+	}
+	return l
+}
+
+// ExportStats target scheduling details
+func (p *DNS) ExportStats() map[string]target.ProbeStats {
+	st := make(map[string]target.ProbeStats)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		st[target.Name()] = target.Stats()
+	}
+	return st
+}
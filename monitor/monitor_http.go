@@ -3,6 +3,7 @@ package monitor
 import (
 	"fmt"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,14 +20,21 @@ type HTTPGet struct {
 	logger   log.Logger
 	sc       *config.SafeConfig
 	resolver *config.Resolver
+	sem      *common.Semaphore
+	pools    *common.Pools
+	shedder  *common.LoadShedder
 	interval time.Duration
+	jitter   time.Duration
 	timeout  time.Duration
+	tJitter  time.Duration
 	targets  map[string]*target.HTTPGet
+	applied  appliedConfigs
+	slots    []string
 	mtx      sync.RWMutex
 }
 
 // NewHTTPGet creates and configures a new Monitoring HTTPGet instance
-func NewHTTPGet(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver) *HTTPGet {
+func NewHTTPGet(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, sem *common.Semaphore, pools *common.Pools, shedder *common.LoadShedder) *HTTPGet {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -34,8 +42,13 @@ func NewHTTPGet(logger log.Logger, sc *config.SafeConfig, resolver *config.Resol
 		logger:   logger,
 		sc:       sc,
 		resolver: resolver,
+		sem:      sem,
+		pools:    pools,
+		shedder:  shedder,
 		interval: sc.Cfg.HTTPGet.Interval.Duration(),
+		jitter:   sc.Cfg.HTTPGet.Interval.Max() - sc.Cfg.HTTPGet.Interval.Duration(),
 		timeout:  sc.Cfg.HTTPGet.Timeout.Duration(),
+		tJitter:  sc.Cfg.Conf.TimeoutJitter.Duration(),
 		targets:  make(map[string]*target.HTTPGet),
 	}
 }
@@ -50,6 +63,20 @@ func (p *HTTPGet) Stop() {
 	}
 }
 
+// Wait blocks until the goroutines of all the targets return (one-shot mode)
+func (p *HTTPGet) Wait() {
+	p.mtx.RLock()
+	targets := make([]*target.HTTPGet, 0, len(p.targets))
+	for _, t := range p.targets {
+		targets = append(targets, t)
+	}
+	p.mtx.RUnlock()
+
+	for _, t := range targets {
+		t.Wait()
+	}
+}
+
 // AddTargets adds newly added targets from the configuration
 func (p *HTTPGet) AddTargets() {
 	level.Debug(p.logger).Log("type", "HTTPGet", "func", "AddTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "HTTPGet")))
@@ -66,6 +93,11 @@ func (p *HTTPGet) AddTargets() {
 		}
 	}
 
+	// The keys of the pass set the start phases (conf.start-spread)
+	p.mtx.Lock()
+	p.slots = targetConfigTmp
+	p.mtx.Unlock()
+
 	targetAdd := common.CompareList(targetActiveTmp, targetConfigTmp)
 	level.Debug(p.logger).Log("type", "HTTPGet", "func", "AddTargets", "msg", fmt.Sprintf("targetName: %v", targetAdd))
 
@@ -75,16 +107,10 @@ func (p *HTTPGet) AddTargets() {
 				continue
 			}
 			if target.Type == "HTTPGet" {
-				if target.Proxy != "" {
-					err := p.AddTarget(target.Name, target.Host, target.SourceIp, target.Proxy, target.Labels.Kv)
-					if err != nil {
-						level.Warn(p.logger).Log("type", "HTTPGet", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
-					}
-				} else {
-					err := p.AddTarget(target.Name, target.Host, target.SourceIp, "", target.Labels.Kv)
-					if err != nil {
-						level.Warn(p.logger).Log("type", "HTTPGet", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
-					}
+				p.applied.set(target.Name, target)
+				err := p.AddTarget(target)
+				if err != nil {
+					level.Warn(p.logger).Log("type", "HTTPGet", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
 				}
 			}
 		}
@@ -92,41 +118,53 @@ func (p *HTTPGet) AddTargets() {
 }
 
 // AddTarget adds a target to the monitored list
-func (p *HTTPGet) AddTarget(name string, url string, srcAddr string, proxy string, labels map[string]string) (err error) {
-	return p.AddTargetDelayed(name, url, srcAddr, proxy, labels, 0)
+func (p *HTTPGet) AddTarget(t config.Target) (err error) {
+	return p.AddTargetDelayed(t, 0)
 }
 
 // AddTargetDelayed is AddTarget with a startup delay
-func (p *HTTPGet) AddTargetDelayed(name string, urlStr string, srcAddr string, proxy string, labels map[string]string, startupDelay time.Duration) (err error) {
-	if proxy != "" {
-		level.Info(p.logger).Log("type", "HTTPGet", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s) with proxy (%s) in %s", name, urlStr, proxy, startupDelay))
+func (p *HTTPGet) AddTargetDelayed(t config.Target, startupDelay time.Duration) (err error) {
+	if t.Proxy != "" {
+		level.Info(p.logger).Log("type", "HTTPGet", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s) with proxy (%s) in %s", t.Name, t.Host, t.Proxy, startupDelay))
 	} else {
-		level.Info(p.logger).Log("type", "HTTPGet", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s) in %s", name, urlStr, startupDelay))
+		level.Info(p.logger).Log("type", "HTTPGet", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s) in %s", t.Name, t.Host, startupDelay))
 	}
 
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 
 	// Check URL
-	dURL, err := url.ParseRequestURI(urlStr)
+	dURL, err := url.ParseRequestURI(t.Host)
 	if err != nil {
 		return err
 	}
 
 	// Check Proxy URL
-	if proxy != "" {
-		_, err := url.ParseRequestURI(proxy)
+	if t.Proxy != "" {
+		_, err := url.ParseRequestURI(t.Proxy)
 		if err != nil {
 			return err
 		}
 	}
 
-	target, err := target.NewHTTPGet(p.logger, startupDelay, name, dURL.String(), srcAddr, proxy, p.interval, p.timeout, labels)
+	expected, err := http.ParseStatusSpec(t.ExpectedStatus)
+	if err != nil {
+		return err
+	}
+
+	interval, jitter, timeout := targetTiming(p.sc, "HTTPGet", t.Interval.Duration(), t.Interval.Max()-t.Interval.Duration(), t.Timeout.Duration(), p.interval, p.jitter, p.timeout)
+	startupDelay = startDelay(p.sc, p.slots, t.Name, interval, startupDelay)
+	target, err := target.NewHTTPGet(p.logger, p.sem, p.pools.Get(t.Pool, "HTTPGet"), p.shedder, startupDelay, target.HTTPGetOptions{
+		Options:  target.Options{Name: t.Name, Host: dURL.String(), SrcAddr: t.SourceIp, Interval: interval, Jitter: jitter, Timeout: jitteredTimeout(timeout, p.tJitter), Priority: t.Priority, DependsOn: t.DependsOn, Labels: t.Labels.Kv},
+		Proxy:    t.Proxy,
+		Method:   t.HTTPMethod,
+		Expected: expected,
+	})
 	if err != nil {
 		return err
 	}
-	p.removeTarget(name)
-	p.targets[name] = target
+	p.removeTarget(t.Name)
+	p.targets[t.Name] = target
 	return nil
 }
 
@@ -134,6 +172,18 @@ func (p *HTTPGet) AddTargetDelayed(name string, urlStr string, srcAddr string, p
 func (p *HTTPGet) DelTargets() {
 	level.Debug(p.logger).Log("type", "HTTPGet", "func", "DelTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "HTTPGet")))
 
+	// The targets whose configuration changed are re-created by AddTargets, the unchanged ones keep running
+	for _, name := range p.applied.changed(p.sc, "HTTPGet") {
+		p.mtx.Lock()
+		for key := range p.targets {
+			if strings.SplitN(key, " ", 2)[0] == name {
+				level.Info(p.logger).Log("type", "HTTPGet", "func", "DelTargets", "msg", fmt.Sprintf("Re-creating changed Target: %s", key))
+				p.removeTarget(key)
+			}
+		}
+		p.mtx.Unlock()
+	}
+
 	targetActiveTmp := []string{}
 	for _, v := range p.targets {
 		if v != nil {
@@ -215,3 +265,16 @@ func (p *HTTPGet) ExportLabels() map[string]map[string]string {
 	}
 	return l
 }
+
+// ExportStats target scheduling details
+func (p *HTTPGet) ExportStats() map[string]target.ProbeStats {
+	st := make(map[string]target.ProbeStats)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		st[target.Name()] = target.Stats()
+	}
+	return st
+}
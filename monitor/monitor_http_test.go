@@ -0,0 +1,44 @@
+package monitor
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+func TestHTTPGetTargetTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	logger := log.NewNopLogger()
+	file := filepath.Join(t.TempDir(), "network_exporter.yml")
+	cfg := "http_get:\n  interval: 10s\n  timeout: 10s\ntargets:\n" +
+		"  - name: target\n    host: " + srv.URL + "\n    type: HTTPGet\n    interval: 100ms\n    timeout: 200ms\n"
+	sc := &config.SafeConfig{Cfg: &config.Config{}}
+	loadConfig(t, sc, file, cfg)
+
+	resolver := &config.Resolver{Resolver: net.DefaultResolver, Timeout: time.Second}
+	p := NewHTTPGet(logger, sc, resolver, common.NewSemaphore(0), common.NewPools(nil), common.NewLoadShedder(0, 0, 0))
+	defer p.Stop()
+	p.AddTargets()
+
+	// The request times out after the target timeout, not the http_get one
+	if !waitFor(5*time.Second, func() bool { return p.ExportMetrics()["target"] != nil }) {
+		t.Fatalf("no result within the target timeout")
+	}
+	if p.ExportMetrics()["target"].Success {
+		t.Errorf("request of the slow server succeeded, want a timeout")
+	}
+}
@@ -1,8 +1,9 @@
 package monitor
 
 import (
-	"context"
 	"fmt"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,17 +20,32 @@ type MTR struct {
 	logger   log.Logger
 	sc       *config.SafeConfig
 	resolver *config.Resolver
+	sem      *common.Semaphore
+	pools    *common.Pools
+	shedder  *common.LoadShedder
+	limiter  *common.RateLimiter
 	icmpID   *common.IcmpID
 	interval time.Duration
+	jitter   time.Duration
 	timeout  time.Duration
+	tJitter  time.Duration
 	maxHops  int
 	count    int
+	protocol string
+	port     int
+	dscp     int
+	resolve  bool
+	rTimeout time.Duration
+	srcAddr  string
+	device   string
 	targets  map[string]*target.MTR
+	applied  appliedConfigs
+	slots    []string
 	mtx      sync.RWMutex
 }
 
 // NewMTR creates and configures a new Monitoring MTR instance
-func NewMTR(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, icmpID *common.IcmpID) *MTR {
+func NewMTR(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, icmpID *common.IcmpID, limiter *common.RateLimiter, sem *common.Semaphore, pools *common.Pools, shedder *common.LoadShedder) *MTR {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -37,11 +53,24 @@ func NewMTR(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver,
 		logger:   logger,
 		sc:       sc,
 		resolver: resolver,
+		sem:      sem,
+		pools:    pools,
+		shedder:  shedder,
+		limiter:  limiter,
 		icmpID:   icmpID,
 		interval: sc.Cfg.MTR.Interval.Duration(),
+		jitter:   sc.Cfg.MTR.Interval.Max() - sc.Cfg.MTR.Interval.Duration(),
 		timeout:  sc.Cfg.MTR.Timeout.Duration(),
+		tJitter:  sc.Cfg.Conf.TimeoutJitter.Duration(),
 		maxHops:  sc.Cfg.MTR.MaxHops,
+		protocol: sc.Cfg.MTR.Protocol,
+		port:     sc.Cfg.MTR.Port,
+		dscp:     sc.Cfg.MTR.DSCP,
 		count:    sc.Cfg.MTR.Count,
+		resolve:  sc.Cfg.MTR.ResolveHops,
+		rTimeout: sc.Cfg.MTR.ResolveTimeout.Duration(),
+		srcAddr:  sc.Cfg.Conf.SourceIp,
+		device:   sc.Cfg.Conf.SourceInterface,
 		targets:  make(map[string]*target.MTR),
 	}
 }
@@ -56,6 +85,20 @@ func (p *MTR) Stop() {
 	}
 }
 
+// Wait blocks until the goroutines of all the targets return (one-shot mode)
+func (p *MTR) Wait() {
+	p.mtx.RLock()
+	targets := make([]*target.MTR, 0, len(p.targets))
+	for _, t := range p.targets {
+		targets = append(targets, t)
+	}
+	p.mtx.RUnlock()
+
+	for _, t := range targets {
+		t.Wait()
+	}
+}
+
 // AddTargets adds newly added targets from the configuration
 func (p *MTR) AddTargets() {
 	level.Debug(p.logger).Log("type", "MTR", "func", "AddTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "MTR")))
@@ -68,48 +111,107 @@ func (p *MTR) AddTargets() {
 	targetConfigTmp := []string{}
 	for _, v := range p.sc.Cfg.Targets {
 		if v.Type == "MTR" || v.Type == "ICMP+MTR" {
-			targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name)
+			ipAddrs, err := p.resolver.PassAddrs(v.Host, v.Resolve)
+			if err != nil || len(ipAddrs) == 0 {
+				level.Warn(p.logger).Log("type", "MTR", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", v.Host), "err", err)
+			}
+			for _, ipAddr := range familyAddrs(ipAddrs) {
+				targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name+" "+ipAddr)
+			}
 		}
 	}
 
+	// The keys of the pass set the start phases (conf.start-spread)
+	p.mtx.Lock()
+	p.slots = targetConfigTmp
+	p.mtx.Unlock()
+
 	targetAdd := common.CompareList(targetActiveTmp, targetConfigTmp)
 	level.Debug(p.logger).Log("type", "MTR", "func", "AddTargets", "msg", fmt.Sprintf("targetName: %v", targetAdd))
 
 	for _, targetName := range targetAdd {
 		for _, target := range p.sc.Cfg.Targets {
-			if target.Name != targetName {
-				continue
-			}
-
 			if target.Type == "MTR" || target.Type == "ICMP+MTR" {
-				err := p.AddTarget(target.Name, target.Host, target.SourceIp, target.Labels.Kv)
-				if err != nil {
-					level.Warn(p.logger).Log("type", "MTR", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
+				ipAddrs, err := p.resolver.PassAddrs(target.Host, target.Resolve)
+				if err != nil || len(ipAddrs) == 0 {
+					level.Warn(p.logger).Log("type", "MTR", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", target.Host), "err", err)
+				}
+
+				for _, ipAddr := range familyAddrs(ipAddrs) {
+					if target.Name+" "+ipAddr != targetName {
+						continue
+					}
+					p.applied.set(target.Name, target)
+					err := p.AddTarget(target.Name+" "+ipAddr, ipAddr, target)
+					if err != nil {
+						level.Warn(p.logger).Log("type", "MTR", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target. Host: %s IP: %s", target.Host, ipAddr), "err", err)
+					}
 				}
 			}
 		}
 	}
 }
 
-// AddTarget adds a target to the monitored list
-func (p *MTR) AddTarget(name string, host string, srcAddr string, labels map[string]string) (err error) {
-	return p.AddTargetDelayed(name, host, srcAddr, labels, 0)
+// AddTarget adds an address of a target to the monitored list
+func (p *MTR) AddTarget(name string, ip string, t config.Target) (err error) {
+	return p.AddTargetDelayed(name, ip, t, 0)
 }
 
 // AddTargetDelayed is AddTarget with a startup delay
-func (p *MTR) AddTargetDelayed(name string, host string, srcAddr string, labels map[string]string, startupDelay time.Duration) (err error) {
-	level.Info(p.logger).Log("type", "MTR", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s) in %s", name, host, startupDelay))
+func (p *MTR) AddTargetDelayed(name string, ip string, t config.Target, startupDelay time.Duration) (err error) {
+	level.Info(p.logger).Log("type", "MTR", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s/%s) in %s", name, t.Host, ip, startupDelay))
+
+	if err := skipFamily(p.sc, ip); err != nil {
+		return err
+	}
+	if err := common.CheckMark(t.FwMark); err != nil {
+		return err
+	}
+	srcAddr, device := targetSource(t.SourceIp, t.SourceInterface, ip, p.srcAddr, p.device)
+	if err := common.CheckDevice(device); err != nil {
+		return err
+	}
+
+	// The target dscp overrides mtr.dscp
+	dscp := t.DSCP
+	if dscp == 0 {
+		dscp = p.dscp
+	}
+	labels := dscpLabels(addrLabels(p.resolver.GeoIP, ip, t.Labels.Kv), dscp)
+	interval, jitter, timeout := targetTiming(p.sc, "MTR", t.Interval.Duration(), t.Interval.Max()-t.Interval.Duration(), t.Timeout.Duration(), p.interval, p.jitter, p.timeout)
+	count, maxHops := targetCount(p.sc, "MTR", t.Count, t.MaxHops, p.count, p.maxHops)
+	// The target protocol overrides mtr.protocol, mtr.port only applies to mtr.protocol
+	protocol, port := t.MtrProtocol, t.MtrPort
+	if protocol == "" {
+		protocol = p.protocol
+	}
+	if port <= 0 && protocol == p.protocol {
+		port = p.port
+	}
 
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 
-	// Resolve hostnames
-	ipAddrs, err := common.DestAddrs(context.Background(), host, p.resolver.Resolver, p.resolver.Timeout)
-	if err != nil || len(ipAddrs) == 0 {
-		return err
+	// Reverse lookups of the hops (disabled by default)
+	var resolver *net.Resolver
+	if p.resolve {
+		resolver = p.resolver.Resolver
 	}
 
-	target, err := target.NewMTR(p.logger, p.icmpID, startupDelay, name, ipAddrs[0], srcAddr, p.interval, p.timeout, p.maxHops, p.count, labels)
+	startupDelay = startDelay(p.sc, p.slots, name, interval, startupDelay)
+	target, err := target.NewMTR(p.logger, p.icmpID, p.limiter, p.sem, p.pools.Get(t.Pool, "MTR"), p.shedder, startupDelay, target.MTROptions{
+		Options:        target.Options{Name: name, Host: t.Host, IP: ip, SrcAddr: srcAddr, Interval: interval, Jitter: jitter, Timeout: jitteredTimeout(timeout, p.tJitter), Priority: t.Priority, DependsOn: t.DependsOn, Labels: labels},
+		DSCP:           dscp,
+		Mark:           t.FwMark,
+		Device:         device,
+		MaxHops:        maxHops,
+		Count:          count,
+		Protocol:       protocol,
+		Port:           port,
+		Resolver:       resolver,
+		ResolveTimeout: p.rTimeout,
+		Window:         t.MtrWindow,
+	})
 	if err != nil {
 		return err
 	}
@@ -122,6 +224,18 @@ func (p *MTR) AddTargetDelayed(name string, host string, srcAddr string, labels
 func (p *MTR) DelTargets() {
 	level.Debug(p.logger).Log("type", "MTR", "func", "DelTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "MTR")))
 
+	// The targets whose configuration changed are re-created by AddTargets, the unchanged ones keep running
+	for _, name := range p.applied.changed(p.sc, "MTR", "ICMP+MTR") {
+		p.mtx.Lock()
+		for key := range p.targets {
+			if strings.SplitN(key, " ", 2)[0] == name {
+				level.Info(p.logger).Log("type", "MTR", "func", "DelTargets", "msg", fmt.Sprintf("Re-creating changed Target: %s", key))
+				p.removeTarget(key)
+			}
+		}
+		p.mtx.Unlock()
+	}
+
 	targetActiveTmp := []string{}
 	for _, v := range p.targets {
 		if v != nil {
@@ -132,7 +246,13 @@ func (p *MTR) DelTargets() {
 	targetConfigTmp := []string{}
 	for _, v := range p.sc.Cfg.Targets {
 		if v.Type == "MTR" || v.Type == "ICMP+MTR" {
-			targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name)
+			ipAddrs, err := p.resolver.PassAddrs(v.Host, v.Resolve)
+			if err != nil || len(ipAddrs) == 0 {
+				level.Warn(p.logger).Log("type", "MTR", "func", "DelTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", v.Host), "err", err)
+			}
+			for _, ipAddr := range familyAddrs(ipAddrs) {
+				targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name+" "+ipAddr)
+			}
 		}
 	}
 
@@ -176,12 +296,12 @@ func (p *MTR) CheckActiveTargets() (err error) {
 		targetActiveTmp[v.Name()] = v.Host()
 	}
 
-	for targetName, targetIp := range targetActiveTmp {
+	for targetKey, targetIp := range targetActiveTmp {
 		for _, target := range p.sc.Cfg.Targets {
-			if target.Name != targetName {
+			if target.Name != strings.SplitN(targetKey, " ", 2)[0] {
 				continue
 			}
-			ipAddrs, err := common.DestAddrs(context.Background(), target.Host, p.resolver.Resolver, p.resolver.Timeout)
+			ipAddrs, err := p.resolver.PassAddrs(target.Host, target.Resolve)
 			if err != nil || len(ipAddrs) == 0 {
 				return err
 			}
@@ -193,12 +313,20 @@ func (p *MTR) CheckActiveTargets() (err error) {
 					}
 				}
 				return false
-			}(ipAddrs, targetIp) {
+			}(familyAddrs(ipAddrs), targetIp) {
 
-				p.RemoveTarget(targetName)
-				err := p.AddTarget(target.Name, target.Host, target.SourceIp, target.Labels.Kv)
-				if err != nil {
-					level.Warn(p.logger).Log("type", "MTR", "func", "CheckActiveTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
+				p.RemoveTarget(targetKey)
+
+				// The traced address of the family changed, the other families keep running
+				for _, ipAddr := range familyAddrs(ipAddrs) {
+					if _, found := targetActiveTmp[target.Name+" "+ipAddr]; found {
+						continue
+					}
+					targetActiveTmp[target.Name+" "+ipAddr] = ipAddr
+					err := p.AddTarget(target.Name+" "+ipAddr, ipAddr, target)
+					if err != nil {
+						level.Warn(p.logger).Log("type", "MTR", "func", "CheckActiveTargets", "msg", fmt.Sprintf("Skipping target. Host: %s IP: %s", target.Host, ipAddr), "err", err)
+					}
 				}
 			}
 		}
@@ -242,3 +370,16 @@ func (p *MTR) ExportLabels() map[string]map[string]string {
 	}
 	return l
 }
+
+// ExportStats target scheduling details
+func (p *MTR) ExportStats() map[string]target.ProbeStats {
+	st := make(map[string]target.ProbeStats)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		st[target.Name()] = target.Stats()
+	}
+	return st
+}
@@ -0,0 +1,271 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/owd"
+	"github.com/syepes/network_exporter/target"
+)
+
+// OWD manages the goroutines responsible for collecting OWD data
+type OWD struct {
+	logger   log.Logger
+	sc       *config.SafeConfig
+	resolver *config.Resolver
+	sem      *common.Semaphore
+	pools    *common.Pools
+	shedder  *common.LoadShedder
+	interval time.Duration
+	jitter   time.Duration
+	timeout  time.Duration
+	tJitter  time.Duration
+	count    int
+	targets  map[string]*target.OWD
+	applied  appliedConfigs
+	slots    []string
+	mtx      sync.RWMutex
+}
+
+// NewOWD creates and configures a new Monitoring OWD instance
+func NewOWD(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, sem *common.Semaphore, pools *common.Pools, shedder *common.LoadShedder) *OWD {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &OWD{
+		logger:   logger,
+		sc:       sc,
+		resolver: resolver,
+		sem:      sem,
+		pools:    pools,
+		shedder:  shedder,
+		interval: sc.Cfg.OWD.Interval.Duration(),
+		jitter:   sc.Cfg.OWD.Interval.Max() - sc.Cfg.OWD.Interval.Duration(),
+		timeout:  sc.Cfg.OWD.Timeout.Duration(),
+		tJitter:  sc.Cfg.Conf.TimeoutJitter.Duration(),
+		count:    sc.Cfg.OWD.Count,
+		targets:  make(map[string]*target.OWD),
+	}
+}
+
+// Stop brings the monitoring gracefully to a halt
+func (p *OWD) Stop() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for id := range p.targets {
+		p.removeTarget(id)
+	}
+}
+
+// Wait blocks until the goroutines of all the targets return (one-shot mode)
+func (p *OWD) Wait() {
+	p.mtx.RLock()
+	targets := make([]*target.OWD, 0, len(p.targets))
+	for _, t := range p.targets {
+		targets = append(targets, t)
+	}
+	p.mtx.RUnlock()
+
+	for _, t := range targets {
+		t.Wait()
+	}
+}
+
+// AddTargets adds newly added targets from the configuration
+func (p *OWD) AddTargets() {
+	level.Debug(p.logger).Log("type", "OWD", "func", "AddTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "OWD")))
+
+	targetActiveTmp := []string{}
+	for _, v := range p.targets {
+		targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
+	}
+
+	targetConfigTmp := []string{}
+	for _, v := range p.sc.Cfg.Targets {
+		if v.Type == "OWD" {
+			targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name)
+		}
+	}
+
+	// The keys of the pass set the start phases (conf.start-spread)
+	p.mtx.Lock()
+	p.slots = targetConfigTmp
+	p.mtx.Unlock()
+
+	targetAdd := common.CompareList(targetActiveTmp, targetConfigTmp)
+	level.Debug(p.logger).Log("type", "OWD", "func", "AddTargets", "msg", fmt.Sprintf("targetName: %v", targetAdd))
+
+	for _, targetName := range targetAdd {
+		for _, target := range p.sc.Cfg.Targets {
+			if target.Name != targetName {
+				continue
+			}
+			if target.Type == "OWD" {
+				p.applied.set(target.Name, target)
+				err := p.AddTarget(target)
+				if err != nil {
+					level.Warn(p.logger).Log("type", "OWD", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
+				}
+			}
+		}
+	}
+}
+
+// AddTarget adds a target to the monitored list
+func (p *OWD) AddTarget(t config.Target) (err error) {
+	return p.AddTargetDelayed(t, 0)
+}
+
+// AddTargetDelayed is AddTarget with a startup delay
+func (p *OWD) AddTargetDelayed(t config.Target, startupDelay time.Duration) (err error) {
+	level.Info(p.logger).Log("type", "OWD", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s) in %s", t.Name, t.Host, startupDelay))
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// The host is the address of the peer responder (host:port), the first resolved address is probed
+	addr, port, err := net.SplitHostPort(t.Host)
+	if err != nil {
+		return err
+	}
+	ipAddrs, err := common.DestAddrsMode(context.Background(), addr, t.Resolve, p.resolver.Resolver, p.resolver.HostsFile, p.resolver.Breaker, p.resolver.Timeout)
+	if err != nil || len(ipAddrs) == 0 {
+		return err
+	}
+	if err := skipFamily(p.sc, ipAddrs[0]); err != nil {
+		return err
+	}
+
+	startupDelay = startDelay(p.sc, p.slots, t.Name, p.interval, startupDelay)
+	target, err := target.NewOWD(p.logger, p.sem, p.pools.Get(t.Pool, "OWD"), p.shedder, startupDelay, target.OWDOptions{
+		Options: target.Options{Name: t.Name, Host: t.Host, IP: ipAddrs[0], SrcAddr: t.SourceIp, Interval: p.interval, Jitter: p.jitter, Timeout: jitteredTimeout(p.timeout, p.tJitter), Priority: t.Priority, DependsOn: t.DependsOn, Labels: t.Labels.Kv},
+		Port:    port,
+		Count:   p.count,
+	})
+	if err != nil {
+		return err
+	}
+	p.removeTarget(t.Name)
+	p.targets[t.Name] = target
+	return nil
+}
+
+// DelTargets deletes/stops the removed targets from the configuration
+func (p *OWD) DelTargets() {
+	level.Debug(p.logger).Log("type", "OWD", "func", "DelTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "OWD")))
+
+	// The targets whose configuration changed are re-created by AddTargets, the unchanged ones keep running
+	for _, name := range p.applied.changed(p.sc, "OWD") {
+		p.mtx.Lock()
+		for key := range p.targets {
+			if strings.SplitN(key, " ", 2)[0] == name {
+				level.Info(p.logger).Log("type", "OWD", "func", "DelTargets", "msg", fmt.Sprintf("Re-creating changed Target: %s", key))
+				p.removeTarget(key)
+			}
+		}
+		p.mtx.Unlock()
+	}
+
+	targetActiveTmp := []string{}
+	for _, v := range p.targets {
+		if v != nil {
+			targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
+		}
+	}
+
+	targetConfigTmp := []string{}
+	for _, v := range p.sc.Cfg.Targets {
+		if v.Type == "OWD" {
+			targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name)
+		}
+	}
+
+	targetDelete := common.CompareList(targetConfigTmp, targetActiveTmp)
+	for _, targetName := range targetDelete {
+		for _, t := range p.targets {
+			if t == nil {
+				continue
+			}
+			if t.Name() == targetName {
+				p.RemoveTarget(targetName)
+			}
+		}
+	}
+}
+
+// RemoveTarget removes a target from the monitoring list
+func (p *OWD) RemoveTarget(key string) {
+	level.Info(p.logger).Log("type", "OWD", "func", "RemoveTarget", "msg", fmt.Sprintf("Removing Target: %s", key))
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.removeTarget(key)
+}
+
+// Stops monitoring a target and removes it from the list (if the list includes the target)
+func (p *OWD) removeTarget(key string) {
+	target, found := p.targets[key]
+	if !found {
+		return
+	}
+	target.Stop()
+	delete(p.targets, key)
+}
+
+// ExportMetrics collects the metrics for each monitored target and returns it as a simple map
+func (p *OWD) ExportMetrics() map[string]*owd.OwdResult {
+	m := make(map[string]*owd.OwdResult)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		name := target.Name()
+		metrics := target.Compute()
+
+		if metrics != nil {
+			// level.Debug(p.logger).Log("type", "OWD", "func", "ExportMetrics", "msg", fmt.Sprintf("Name: %s, Metrics: %+v, Labels: %+v", name, metrics, target.Labels()))
+			m[name] = metrics
+		}
+	}
+	return m
+}
+
+// ExportLabels target labels
+func (p *OWD) ExportLabels() map[string]map[string]string {
+	l := make(map[string]map[string]string)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		name := target.Name()
+		labels := target.Labels()
+
+		if labels != nil {
+			l[name] = labels
+		}
+	}
+	return l
+}
+
+// ExportStats target scheduling details
+func (p *OWD) ExportStats() map[string]target.ProbeStats {
+	st := make(map[string]target.ProbeStats)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		st[target.Name()] = target.Stats()
+	}
+	return st
+}
@@ -1,8 +1,8 @@
 package monitor
 
 import (
-	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,16 +19,34 @@ type PING struct {
 	logger   log.Logger
 	sc       *config.SafeConfig
 	resolver *config.Resolver
+	sem      *common.Semaphore
+	pools    *common.Pools
+	shedder  *common.LoadShedder
+	limiter  *common.RateLimiter
 	icmpID   *common.IcmpID
 	interval time.Duration
+	jitter   time.Duration
 	timeout  time.Duration
+	tJitter  time.Duration
 	count    int
+	ipi      time.Duration
+	random   bool
+	dscp     int
+	buckets  []float64
+	windows  []time.Duration
+	pmtuMax  int
+	pmtuTout time.Duration
+	recheck  target.Recheck
+	srcAddr  string
+	device   string
 	targets  map[string]*target.PING
+	applied  appliedConfigs
+	slots    []string
 	mtx      sync.RWMutex
 }
 
 // NewPing creates and configures a new Monitoring ICMP instance
-func NewPing(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, icmpID *common.IcmpID) *PING {
+func NewPing(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, icmpID *common.IcmpID, limiter *common.RateLimiter, sem *common.Semaphore, pools *common.Pools, shedder *common.LoadShedder) *PING {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -36,10 +54,26 @@ func NewPing(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver
 		logger:   logger,
 		sc:       sc,
 		resolver: resolver,
+		sem:      sem,
+		pools:    pools,
+		shedder:  shedder,
+		limiter:  limiter,
 		icmpID:   icmpID,
 		interval: sc.Cfg.ICMP.Interval.Duration(),
+		jitter:   sc.Cfg.ICMP.Interval.Max() - sc.Cfg.ICMP.Interval.Duration(),
 		timeout:  sc.Cfg.ICMP.Timeout.Duration(),
+		tJitter:  sc.Cfg.Conf.TimeoutJitter.Duration(),
 		count:    sc.Cfg.ICMP.Count,
+		ipi:      sc.Cfg.ICMP.InterPacketInterval.Duration(),
+		random:   sc.Cfg.ICMP.RandomPayload,
+		dscp:     sc.Cfg.ICMP.DSCP,
+		buckets:  sc.Cfg.ICMP.HistogramBuckets,
+		windows:  sc.Cfg.ICMP.WindowDurations(),
+		pmtuMax:  sc.Cfg.ICMP.PMTUMax,
+		pmtuTout: sc.Cfg.ICMP.PMTUTimeout.Duration(),
+		recheck:  target.Recheck{Loss: sc.Cfg.ICMP.RecheckLoss, Interval: sc.Cfg.ICMP.RecheckInterval.Duration(), Count: sc.Cfg.ICMP.RecheckCount, Recover: sc.Cfg.ICMP.RecheckRecover},
+		srcAddr:  sc.Cfg.Conf.SourceIp,
+		device:   sc.Cfg.Conf.SourceInterface,
 		targets:  make(map[string]*target.PING),
 	}
 }
@@ -54,10 +88,32 @@ func (p *PING) Stop() {
 	}
 }
 
+// Wait blocks until the goroutines of all the targets return (one-shot mode)
+func (p *PING) Wait() {
+	p.mtx.RLock()
+	targets := make([]*target.PING, 0, len(p.targets))
+	for _, t := range p.targets {
+		targets = append(targets, t)
+	}
+	p.mtx.RUnlock()
+
+	for _, t := range targets {
+		t.Wait()
+	}
+}
+
 // AddTargets adds newly added targets from the configuration
 func (p *PING) AddTargets() {
 	level.Debug(p.logger).Log("type", "ICMP", "func", "AddTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "ICMP")))
 
+	// The reloaded histogram buckets also apply to the running targets
+	p.mtx.Lock()
+	p.buckets = p.sc.Cfg.ICMP.HistogramBuckets
+	for _, t := range p.targets {
+		t.SetBuckets(p.buckets)
+	}
+	p.mtx.Unlock()
+
 	targetActiveTmp := []string{}
 	for _, v := range p.targets {
 		targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
@@ -66,7 +122,7 @@ func (p *PING) AddTargets() {
 	targetConfigTmp := []string{}
 	for _, v := range p.sc.Cfg.Targets {
 		if v.Type == "ICMP" || v.Type == "ICMP+MTR" {
-			ipAddrs, err := common.DestAddrs(context.Background(), v.Host, p.resolver.Resolver, p.resolver.Timeout)
+			ipAddrs, err := p.resolver.PassAddrs(v.Host, v.Resolve)
 			if err != nil || len(ipAddrs) == 0 {
 				level.Warn(p.logger).Log("type", "ICMP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", v.Host), "err", err)
 			}
@@ -76,13 +132,18 @@ func (p *PING) AddTargets() {
 		}
 	}
 
+	// The keys of the pass set the start phases (conf.start-spread)
+	p.mtx.Lock()
+	p.slots = targetConfigTmp
+	p.mtx.Unlock()
+
 	targetAdd := common.CompareList(targetActiveTmp, targetConfigTmp)
 	level.Debug(p.logger).Log("type", "ICMP", "func", "AddTargets", "msg", fmt.Sprintf("targetName: %v", targetAdd))
 
 	for _, targetName := range targetAdd {
 		for _, target := range p.sc.Cfg.Targets {
 			if target.Type == "ICMP" || target.Type == "ICMP+MTR" {
-				ipAddrs, err := common.DestAddrs(context.Background(), target.Host, p.resolver.Resolver, p.resolver.Timeout)
+				ipAddrs, err := p.resolver.PassAddrs(target.Host, target.Resolve)
 				if err != nil || len(ipAddrs) == 0 {
 					level.Warn(p.logger).Log("type", "ICMP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", target.Host), "err", err)
 				}
@@ -91,7 +152,8 @@ func (p *PING) AddTargets() {
 					if target.Name+" "+ipAddr != targetName {
 						continue
 					}
-					err := p.AddTarget(target.Name+" "+ipAddr, target.Host, ipAddr, target.SourceIp, target.Labels.Kv)
+					p.applied.set(target.Name, target)
+					err := p.AddTarget(target.Name+" "+ipAddr, ipAddr, target)
 					if err != nil {
 						level.Warn(p.logger).Log("type", "ICMP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target. Host: %s IP: %s", target.Host, ipAddr), "err", err)
 					}
@@ -101,19 +163,87 @@ func (p *PING) AddTargets() {
 	}
 }
 
-// AddTarget adds a target to the monitored list
-func (p *PING) AddTarget(name string, host string, ip string, srcAddr string, labels map[string]string) (err error) {
-	return p.AddTargetDelayed(name, host, ip, srcAddr, labels, 0)
+// AddTarget adds an address of a target to the monitored list
+func (p *PING) AddTarget(name string, ip string, t config.Target) (err error) {
+	return p.AddTargetDelayed(name, ip, t, 0)
 }
 
 // AddTargetDelayed is AddTarget with a startup delay
-func (p *PING) AddTargetDelayed(name string, host string, ip string, srcAddr string, labels map[string]string, startupDelay time.Duration) (err error) {
-	level.Info(p.logger).Log("type", "ICMP", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s/%s) in %s", name, host, ip, startupDelay))
+func (p *PING) AddTargetDelayed(name string, ip string, t config.Target, startupDelay time.Duration) (err error) {
+	level.Info(p.logger).Log("type", "ICMP", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s/%s) in %s", name, t.Host, ip, startupDelay))
+
+	if err := skipFamily(p.sc, ip); err != nil {
+		return err
+	}
 
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 
-	target, err := target.NewPing(p.logger, p.icmpID, startupDelay, name, host, ip, srcAddr, p.interval, p.timeout, p.count, labels)
+	interval, jitter, timeout := targetTiming(p.sc, "ICMP", t.Interval.Duration(), t.Interval.Max()-t.Interval.Duration(), t.Timeout.Duration(), p.interval, p.jitter, p.timeout)
+	count, _ := targetCount(p.sc, "ICMP", t.Count, 0, p.count, 0)
+
+	// Inter-packet spacing (target, global), defaults to the cycle interval
+	ipi := t.InterPacketInterval.Duration()
+	if ipi <= 0 {
+		ipi = p.ipi
+	}
+	if ipi <= 0 {
+		ipi = interval
+	}
+	if err := common.CheckMark(t.FwMark); err != nil {
+		return err
+	}
+	srcAddr, device := targetSource(t.SourceIp, t.SourceInterface, ip, p.srcAddr, p.device)
+	if err := common.CheckDevice(device); err != nil {
+		return err
+	}
+
+	// The target dscp overrides icmp.dscp, the dscp-classes targets mark each batch with its class
+	dscp := t.DSCP
+	if dscp == 0 && len(t.DSCPClasses) == 0 {
+		dscp = p.dscp
+	}
+	labels := dscpLabels(addrLabels(p.resolver.GeoIP, ip, t.Labels.Kv), dscp)
+	policy, err := ping.ParseUpPolicy(t.UpPolicy)
+	if err != nil {
+		return err
+	}
+
+	// The path MTU is searched up to icmp.pmtu-max
+	pmtuMax := 0
+	if t.PMTU {
+		pmtuMax = p.pmtuMax
+	}
+
+	// The target recheck-loss overrides icmp.recheck-loss
+	recheck := p.recheck
+	if t.RecheckLoss > 0 {
+		recheck.Loss = t.RecheckLoss
+	}
+
+	startupDelay = startDelay(p.sc, p.slots, name, interval, startupDelay)
+	target, err := target.NewPing(p.logger, p.icmpID, p.limiter, p.sem, p.pools.Get(t.Pool, "ICMP"), p.shedder, startupDelay, target.PingOptions{
+		Options:         target.Options{Name: name, Host: t.Host, IP: ip, SrcAddr: srcAddr, Interval: interval, Jitter: jitter, Timeout: jitteredTimeout(timeout, p.tJitter), Priority: t.Priority, DependsOn: t.DependsOn, Labels: labels},
+		IPI:             ipi,
+		AdaptiveTimeout: t.AdaptiveTimeout.Duration(),
+		Count:           count,
+		Flows:           t.Flows,
+		RandomPayload:   p.random,
+		DSCP:            dscp,
+		DSCPClasses:     t.DSCPClasses,
+		Mark:            t.FwMark,
+		Device:          device,
+		Timestamp:       t.IcmpMode == "timestamp",
+		PMTU:            pmtuMax,
+		PMTUTimeout:     p.pmtuTout,
+		Burst:           t.Burst,
+		ExpectedHops:    t.ExpectedHops,
+		InitialTTL:      t.InitialTTL,
+		UpPolicy:        policy,
+		Buckets:         p.buckets,
+		Windows:         p.windows,
+		Recheck:         recheck,
+	})
 	if err != nil {
 		return err
 	}
@@ -126,6 +256,18 @@ func (p *PING) AddTargetDelayed(name string, host string, ip string, srcAddr str
 func (p *PING) DelTargets() {
 	level.Debug(p.logger).Log("type", "ICMP", "func", "DelTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "ICMP")))
 
+	// The targets whose configuration changed are re-created by AddTargets, the unchanged ones keep running
+	for _, name := range p.applied.changed(p.sc, "ICMP", "ICMP+MTR") {
+		p.mtx.Lock()
+		for key := range p.targets {
+			if strings.SplitN(key, " ", 2)[0] == name {
+				level.Info(p.logger).Log("type", "ICMP", "func", "DelTargets", "msg", fmt.Sprintf("Re-creating changed Target: %s", key))
+				p.removeTarget(key)
+			}
+		}
+		p.mtx.Unlock()
+	}
+
 	targetActiveTmp := []string{}
 	for _, v := range p.targets {
 		if v != nil {
@@ -136,7 +278,7 @@ func (p *PING) DelTargets() {
 	targetConfigTmp := []string{}
 	for _, v := range p.sc.Cfg.Targets {
 		if v.Type == "ICMP" || v.Type == "ICMP+MTR" {
-			ipAddrs, err := common.DestAddrs(context.Background(), v.Host, p.resolver.Resolver, p.resolver.Timeout)
+			ipAddrs, err := p.resolver.PassAddrs(v.Host, v.Resolve)
 			if err != nil || len(ipAddrs) == 0 {
 				level.Warn(p.logger).Log("type", "ICMP", "func", "DelTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", v.Host), "err", err)
 			}
@@ -191,7 +333,7 @@ func (p *PING) CheckActiveTargets() (err error) {
 			if target.Name != targetName {
 				continue
 			}
-			ipAddrs, err := common.DestAddrs(context.Background(), target.Host, p.resolver.Resolver, p.resolver.Timeout)
+			ipAddrs, err := p.resolver.PassAddrs(target.Host, target.Resolve)
 			if err != nil || len(ipAddrs) == 0 {
 				return err
 			}
@@ -207,13 +349,13 @@ func (p *PING) CheckActiveTargets() (err error) {
 
 				p.RemoveTarget(targetName + " " + targetIp)
 
-				ipAddrs, err := common.DestAddrs(context.Background(), target.Host, p.resolver.Resolver, p.resolver.Timeout)
+				ipAddrs, err := p.resolver.PassAddrs(target.Host, target.Resolve)
 				if err != nil || len(ipAddrs) == 0 {
 					level.Warn(p.logger).Log("type", "ICMP", "func", "CheckActiveTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", target.Host), "err", err)
 				}
 
 				for _, ipAddr := range ipAddrs {
-					err := p.AddTarget(target.Name+" "+ipAddr, target.Host, ipAddr, target.SourceIp, target.Labels.Kv)
+					err := p.AddTarget(target.Name+" "+ipAddr, ipAddr, target)
 					if err != nil {
 						level.Warn(p.logger).Log("type", "ICMP", "func", "CheckActiveTargets", "msg", fmt.Sprintf("Skipping target. Host: %s IP: %s", target.Host, ipAddr), "err", err)
 					}
@@ -260,3 +402,16 @@ func (p *PING) ExportLabels() map[string]map[string]string {
 	}
 	return l
 }
+
+// ExportStats target scheduling details
+func (p *PING) ExportStats() map[string]target.ProbeStats {
+	st := make(map[string]target.ProbeStats)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		st[target.Name()] = target.Stats()
+	}
+	return st
+}
@@ -1,8 +1,9 @@
 package monitor
 
 import (
-	"context"
 	"fmt"
+	"net/url"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -20,14 +21,26 @@ type TCPPort struct {
 	logger   log.Logger
 	sc       *config.SafeConfig
 	resolver *config.Resolver
+	sem      *common.Semaphore
+	pools    *common.Pools
+	shedder  *common.LoadShedder
 	interval time.Duration
+	jitter   time.Duration
 	timeout  time.Duration
+	tJitter  time.Duration
+	srcPort  string
+	dscp     int
+	buckets  []float64
+	srcAddr  string
+	device   string
 	targets  map[string]*target.TCPPort
+	applied  appliedConfigs
+	slots    []string
 	mtx      sync.RWMutex
 }
 
 // NewTCPPort creates and configures a new Monitoring TCP instance
-func NewTCPPort(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver) *TCPPort {
+func NewTCPPort(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, sem *common.Semaphore, pools *common.Pools, shedder *common.LoadShedder) *TCPPort {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -35,8 +48,18 @@ func NewTCPPort(logger log.Logger, sc *config.SafeConfig, resolver *config.Resol
 		logger:   logger,
 		sc:       sc,
 		resolver: resolver,
+		sem:      sem,
+		pools:    pools,
+		shedder:  shedder,
 		interval: sc.Cfg.TCP.Interval.Duration(),
+		jitter:   sc.Cfg.TCP.Interval.Max() - sc.Cfg.TCP.Interval.Duration(),
 		timeout:  sc.Cfg.TCP.Timeout.Duration(),
+		tJitter:  sc.Cfg.Conf.TimeoutJitter.Duration(),
+		srcPort:  sc.Cfg.TCP.SourcePort,
+		dscp:     sc.Cfg.TCP.DSCP,
+		buckets:  sc.Cfg.TCP.HistogramBuckets,
+		srcAddr:  sc.Cfg.Conf.SourceIp,
+		device:   sc.Cfg.Conf.SourceInterface,
 		targets:  make(map[string]*target.TCPPort),
 	}
 }
@@ -51,10 +74,32 @@ func (p *TCPPort) Stop() {
 	}
 }
 
+// Wait blocks until the goroutines of all the targets return (one-shot mode)
+func (p *TCPPort) Wait() {
+	p.mtx.RLock()
+	targets := make([]*target.TCPPort, 0, len(p.targets))
+	for _, t := range p.targets {
+		targets = append(targets, t)
+	}
+	p.mtx.RUnlock()
+
+	for _, t := range targets {
+		t.Wait()
+	}
+}
+
 // AddTargets adds newly added targets from the configuration
 func (p *TCPPort) AddTargets() {
 	level.Debug(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "TCP")))
 
+	// The reloaded histogram buckets also apply to the running targets
+	p.mtx.Lock()
+	p.buckets = p.sc.Cfg.TCP.HistogramBuckets
+	for _, t := range p.targets {
+		t.SetBuckets(p.buckets)
+	}
+	p.mtx.Unlock()
+
 	targetActiveTmp := []string{}
 	for _, v := range p.targets {
 		targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
@@ -68,7 +113,7 @@ func (p *TCPPort) AddTargets() {
 				level.Warn(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target, could not identify host: %v (%v)", v.Host, v.Name))
 				continue
 			}
-			ipAddrs, err := common.DestAddrs(context.Background(), conn[0], p.resolver.Resolver, p.resolver.Timeout)
+			ipAddrs, err := p.targetAddrs(conn[0], v.Proxy, v.Resolve)
 			if err != nil || len(ipAddrs) == 0 {
 				level.Warn(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", v.Host), "err", err)
 			}
@@ -78,6 +123,11 @@ func (p *TCPPort) AddTargets() {
 		}
 	}
 
+	// The keys of the pass set the start phases (conf.start-spread)
+	p.mtx.Lock()
+	p.slots = targetConfigTmp
+	p.mtx.Unlock()
+
 	targetAdd := common.CompareList(targetActiveTmp, targetConfigTmp)
 	level.Debug(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("targetName: %v", targetAdd))
 
@@ -89,7 +139,7 @@ func (p *TCPPort) AddTargets() {
 					level.Warn(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target, could not identify host: %v (%v)", target.Host, target.Name))
 					continue
 				}
-				ipAddrs, err := common.DestAddrs(context.Background(), conn[0], p.resolver.Resolver, p.resolver.Timeout)
+				ipAddrs, err := p.targetAddrs(conn[0], target.Proxy, target.Resolve)
 				if err != nil || len(ipAddrs) == 0 {
 					level.Warn(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", target.Name), "err", err)
 				}
@@ -102,12 +152,13 @@ func (p *TCPPort) AddTargets() {
 						level.Warn(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target, could not identify host: %v (%v)", target.Host, target.Name))
 						continue
 					}
-					ipAddrs, err := common.DestAddrs(context.Background(), conn[0], p.resolver.Resolver, p.resolver.Timeout)
+					ipAddrs, err := p.targetAddrs(conn[0], target.Proxy, target.Resolve)
 					if err != nil || len(ipAddrs) == 0 {
 						level.Warn(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", target.Host), "err", err)
 					}
 					for _, ipAddr := range ipAddrs {
-						err := p.AddTarget(target.Name+" "+ipAddr, conn[0], ipAddr, target.SourceIp, conn[1], target.Labels.Kv)
+						p.applied.set(target.Name, target)
+						err := p.AddTarget(target.Name+" "+ipAddr, conn[0], ipAddr, conn[1], target)
 						if err != nil {
 							level.Warn(p.logger).Log("type", "TCP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target. Host: %s IP: %s", target.Host, ipAddr), "err", err)
 						}
@@ -118,19 +169,96 @@ func (p *TCPPort) AddTargets() {
 	}
 }
 
-// AddTarget adds a target to the monitored list
-func (p *TCPPort) AddTarget(name string, host string, ip string, srcAddr string, port string, labels map[string]string) (err error) {
-	return p.AddTargetDelayed(name, host, ip, srcAddr, port, labels, 0)
+// AddTarget adds an address of a target to the monitored list, host and port are the parts of the target host
+func (p *TCPPort) AddTarget(name string, host string, ip string, port string, t config.Target) (err error) {
+	return p.AddTargetDelayed(name, host, ip, port, t, 0)
 }
 
 // AddTargetDelayed is AddTarget with a startup delay
-func (p *TCPPort) AddTargetDelayed(name string, host string, ip string, srcAddr string, port string, labels map[string]string, startupDelay time.Duration) (err error) {
-	level.Info(p.logger).Log("type", "TCP", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s/%s:%s) in %s", name, host, ip, port, startupDelay))
+func (p *TCPPort) AddTargetDelayed(name string, host string, ip string, port string, t config.Target, startupDelay time.Duration) (err error) {
+	if t.Proxy != "" {
+		level.Info(p.logger).Log("type", "TCP", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s:%s) with proxy (%s) in %s", name, host, port, t.Proxy, startupDelay))
+	} else {
+		level.Info(p.logger).Log("type", "TCP", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s/%s:%s) in %s", name, host, ip, port, startupDelay))
+	}
+
+	if err := skipFamily(p.sc, ip); err != nil {
+		return err
+	}
+
+	interval, jitter, timeout := targetTiming(p.sc, "TCP", t.Interval.Duration(), t.Interval.Max()-t.Interval.Duration(), t.Timeout.Duration(), p.interval, p.jitter, p.timeout)
+
+	// The target source port overrides the tcp.source-port one
+	srcPort := t.SourcePort
+	if srcPort == "" {
+		srcPort = p.srcPort
+	}
+	srcPorts, err := tcp.ParseSourcePorts(srcPort)
+	if err != nil {
+		return err
+	}
+	if err := common.CheckMark(t.FwMark); err != nil {
+		return err
+	}
+	srcAddr, device := targetSource(t.SourceIp, t.SourceInterface, ip, p.srcAddr, p.device)
+	if err := common.CheckDevice(device); err != nil {
+		return err
+	}
+
+	// The target dscp overrides tcp.dscp
+	dscp := t.DSCP
+	if dscp == 0 {
+		dscp = p.dscp
+	}
+	labels := dscpLabels(addrLabels(p.resolver.GeoIP, ip, t.Labels.Kv), dscp)
+	ports, err := tcp.ParsePorts(port)
+	if err != nil {
+		return err
+	}
+	var expectRe *regexp.Regexp
+	if t.TCPExpect != "" {
+		if expectRe, err = regexp.Compile(t.TCPExpect); err != nil {
+			return err
+		}
+	}
+
+	// The certificate is verified against the target host unless tls-server-name is set
+	var tlsOpts *tcp.TLSOptions
+	if t.TLS {
+		tlsOpts = &tcp.TLSOptions{ServerName: host, InsecureSkipVerify: t.TLSSkipVerify}
+		if t.TLSServerName != "" {
+			tlsOpts.ServerName = t.TLSServerName
+		}
+	}
+
+	var proxyURL *url.URL
+	if t.Proxy != "" {
+		if proxyURL, err = tcp.ParseProxy(t.Proxy); err != nil {
+			return err
+		}
+	}
 
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 
-	target, err := target.NewTCPPort(p.logger, startupDelay, name, host, ip, srcAddr, port, p.interval, p.timeout, labels)
+	startupDelay = startDelay(p.sc, p.slots, name, interval, startupDelay)
+	target, err := target.NewTCPPort(p.logger, p.sem, p.pools.Get(t.Pool, "TCP"), p.shedder, startupDelay, target.TCPOptions{
+		Options:   target.Options{Name: name, Host: host, IP: ip, SrcAddr: srcAddr, Interval: interval, Jitter: jitter, Timeout: jitteredTimeout(timeout, p.tJitter), Priority: t.Priority, DependsOn: t.DependsOn, Labels: labels},
+		SrcPorts:  srcPorts,
+		DSCP:      dscp,
+		Mark:      t.FwMark,
+		Device:    device,
+		Ports:     ports,
+		FastOpen:  t.FastOpen,
+		Warmup:    t.Warmup,
+		ResetWait: t.ResetWait.Duration(),
+		Send:      t.TCPSend,
+		Expect:    expectRe,
+		TLS:       tlsOpts,
+		Proxy:     proxyURL,
+		Reuse:     t.ReuseConnection,
+		Buckets:   p.buckets,
+	})
 	if err != nil {
 		return err
 	}
@@ -139,10 +267,30 @@ func (p *TCPPort) AddTargetDelayed(name string, host string, ip string, srcAddr
 	return nil
 }
 
+// targetAddrs returns the addresses of a target host, the host of a target with a proxy is resolved by the proxy
+func (p *TCPPort) targetAddrs(host string, proxy string, mode string) ([]string, error) {
+	if proxy != "" {
+		return []string{host}, nil
+	}
+	return p.resolver.PassAddrs(host, mode)
+}
+
 // DelTargets deletes/stops the removed targets from the configuration
 func (p *TCPPort) DelTargets() {
 	level.Debug(p.logger).Log("type", "TCP", "func", "DelTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "TCP")))
 
+	// The targets whose configuration changed are re-created by AddTargets, the unchanged ones keep running
+	for _, name := range p.applied.changed(p.sc, "TCP") {
+		p.mtx.Lock()
+		for key := range p.targets {
+			if strings.SplitN(key, " ", 2)[0] == name {
+				level.Info(p.logger).Log("type", "TCP", "func", "DelTargets", "msg", fmt.Sprintf("Re-creating changed Target: %s", key))
+				p.removeTarget(key)
+			}
+		}
+		p.mtx.Unlock()
+	}
+
 	targetActiveTmp := []string{}
 	for _, v := range p.targets {
 		if v != nil {
@@ -158,7 +306,7 @@ func (p *TCPPort) DelTargets() {
 				level.Warn(p.logger).Log("type", "TCP", "func", "DelTargets", "msg", fmt.Sprintf("Skipping target, could not identify host: %v (%v)", v.Host, v.Name))
 				continue
 			}
-			ipAddrs, err := common.DestAddrs(context.Background(), conn[0], p.resolver.Resolver, p.resolver.Timeout)
+			ipAddrs, err := p.targetAddrs(conn[0], v.Proxy, v.Resolve)
 			if err != nil || len(ipAddrs) == 0 {
 				level.Warn(p.logger).Log("type", "TCP", "func", "DelTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", v.Host), "err", err)
 			}
@@ -213,7 +361,7 @@ func (p *TCPPort) CheckActiveTargets() (err error) {
 			if target.Name != targetName {
 				continue
 			}
-			ipAddrs, err := common.DestAddrs(context.Background(), strings.Split(target.Host, ":")[0], p.resolver.Resolver, p.resolver.Timeout)
+			ipAddrs, err := p.targetAddrs(strings.Split(target.Host, ":")[0], target.Proxy, target.Resolve)
 			if err != nil || len(ipAddrs) == 0 {
 				return err
 			}
@@ -235,7 +383,7 @@ func (p *TCPPort) CheckActiveTargets() (err error) {
 					continue
 				}
 				for _, ipAddr := range ipAddrs {
-					err := p.AddTarget(target.Name+" "+ipAddr, conn[0], ipAddr, target.SourceIp, conn[1], target.Labels.Kv)
+					err := p.AddTarget(target.Name+" "+ipAddr, conn[0], ipAddr, conn[1], target)
 					if err != nil {
 						level.Warn(p.logger).Log("type", "TCP", "func", "CheckActiveTargets", "msg", fmt.Sprintf("Skipping target: %s", target.Host), "err", err)
 					}
@@ -257,9 +405,14 @@ func (p *TCPPort) ExportMetrics() map[string]*tcp.TCPPortReturn {
 		name := target.Name()
 		metrics := target.Compute()
 
-		if metrics != nil {
+		// The multi-port targets are exported per port ("name ip port")
+		if len(metrics) == 1 {
 			// level.Debug(p.logger).Log("type", "TCP", "func", "ExportMetrics", "msg", fmt.Sprintf("Name: %s, Metrics: %+v, Labels: %+v", name, metrics, target.Labels()))
-			m[name] = metrics
+			m[name] = metrics[0]
+		} else {
+			for _, metric := range metrics {
+				m[name+" "+metric.DestPort] = metric
+			}
 		}
 	}
 	return m
@@ -277,8 +430,27 @@ func (p *TCPPort) ExportLabels() map[string]map[string]string {
 		labels := target.Labels()
 
 		if labels != nil {
-			l[name] = labels
+			if ports := target.Ports(); len(ports) == 1 {
+				l[name] = labels
+			} else {
+				for _, port := range ports {
+					l[name+" "+port] = labels
+				}
+			}
 		}
 	}
 	return l
 }
+
+// ExportStats target scheduling details
+func (p *TCPPort) ExportStats() map[string]target.ProbeStats {
+	st := make(map[string]target.ProbeStats)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		st[target.Name()] = target.Stats()
+	}
+	return st
+}
@@ -0,0 +1,163 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+// tcpListener local listener accepting and closing the connections of the TCP targets
+func tcpListener(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// loadConfig writes the configuration to the file and (re)loads it
+func loadConfig(t *testing.T, sc *config.SafeConfig, file string, cfg string) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.ReloadConfig(log.NewNopLogger(), file); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+}
+
+// waitFor polls the condition until it holds or the deadline passes
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return true
+}
+
+func TestTCPReloadKeepsUnchangedTarget(t *testing.T) {
+	addr := tcpListener(t)
+	logger := log.NewNopLogger()
+	file := filepath.Join(t.TempDir(), "network_exporter.yml")
+	cfg := "tcp:\n  interval: 1s\n  timeout: 1s\ntargets:\n" +
+		"  - name: unchanged\n    host: " + addr + "\n    type: TCP\n" +
+		"  - name: changed\n    host: " + addr + "\n    type: TCP\n"
+
+	sc := &config.SafeConfig{Cfg: &config.Config{}}
+	loadConfig(t, sc, file, cfg)
+
+	resolver := &config.Resolver{Resolver: net.DefaultResolver, Timeout: time.Second}
+	p := NewTCPPort(logger, sc, resolver, common.NewSemaphore(0), common.NewPools(nil), common.NewLoadShedder(0, 0, 0))
+	defer p.Stop()
+	p.AddTargets()
+
+	unchanged, changed := "unchanged 127.0.0.1", "changed 127.0.0.1"
+	p.mtx.RLock()
+	before := p.targets[unchanged]
+	beforeChanged := p.targets[changed]
+	p.mtx.RUnlock()
+	if before == nil || beforeChanged == nil {
+		t.Fatalf("targets not started: %v", p.targets)
+	}
+
+	// Same configuration: no target is re-created
+	loadConfig(t, sc, file, cfg)
+	p.DelTargets()
+	_ = p.CheckActiveTargets()
+	p.AddTargets()
+	p.mtx.RLock()
+	after, afterChanged := p.targets[unchanged], p.targets[changed]
+	p.mtx.RUnlock()
+	if after != before || afterChanged != beforeChanged {
+		t.Fatalf("targets re-created by a reload of the same configuration")
+	}
+
+	// Only the target whose configuration changed is re-created
+	loadConfig(t, sc, file, cfg+"    timeout: 500ms\n")
+	p.DelTargets()
+	_ = p.CheckActiveTargets()
+	p.AddTargets()
+	p.mtx.RLock()
+	after, afterChanged = p.targets[unchanged], p.targets[changed]
+	p.mtx.RUnlock()
+	if after != before {
+		t.Errorf("unchanged target re-created by the reload")
+	}
+	if afterChanged == nil || afterChanged == beforeChanged {
+		t.Errorf("changed target not re-created by the reload")
+	}
+}
+
+func TestTCPReloadAppliesHistogramBuckets(t *testing.T) {
+	addr := tcpListener(t)
+	logger := log.NewNopLogger()
+	file := filepath.Join(t.TempDir(), "network_exporter.yml")
+	sc := &config.SafeConfig{Cfg: &config.Config{}}
+	reload := func(buckets string) {
+		t.Helper()
+		loadConfig(t, sc, file, "tcp:\n  interval: 100ms\n  timeout: 1s\n  histogram-buckets: "+buckets+"\ntargets:\n"+
+			"  - name: target\n    host: "+addr+"\n    type: TCP\n")
+	}
+	reload("[0.01, 0.1]")
+
+	resolver := &config.Resolver{Resolver: net.DefaultResolver, Timeout: time.Second}
+	p := NewTCPPort(logger, sc, resolver, common.NewSemaphore(0), common.NewPools(nil), common.NewLoadShedder(0, 0, 0))
+	defer p.Stop()
+	p.AddTargets()
+
+	// waitBounds waits for a cycle exporting a histogram with the buckets
+	waitBounds := func(want []float64) {
+		t.Helper()
+		var got []float64
+		if !waitFor(5*time.Second, func() bool {
+			for _, r := range p.ExportMetrics() {
+				if r.ConHistogram != nil {
+					got = r.ConHistogram.Bounds
+				}
+			}
+			return fmt.Sprint(got) == fmt.Sprint(want)
+		}) {
+			t.Fatalf("histogram buckets = %v, want %v", got, want)
+		}
+	}
+	waitBounds([]float64{0.01, 0.1})
+
+	p.mtx.RLock()
+	before := p.targets["target 127.0.0.1"]
+	p.mtx.RUnlock()
+
+	reload("[0.005, 0.02, 0.2]")
+	resolver.NewPass()
+	p.DelTargets()
+	_ = p.CheckActiveTargets()
+	p.AddTargets()
+
+	p.mtx.RLock()
+	after := p.targets["target 127.0.0.1"]
+	p.mtx.RUnlock()
+	if after != before {
+		t.Fatalf("target re-created, the buckets must apply to the running target")
+	}
+	waitBounds([]float64{0.005, 0.02, 0.2})
+}
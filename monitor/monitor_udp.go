@@ -0,0 +1,351 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/udp"
+	"github.com/syepes/network_exporter/target"
+)
+
+// UDP manages the goroutines responsible for collecting UDP data
+type UDP struct {
+	logger   log.Logger
+	sc       *config.SafeConfig
+	resolver *config.Resolver
+	sem      *common.Semaphore
+	pools    *common.Pools
+	shedder  *common.LoadShedder
+	interval time.Duration
+	jitter   time.Duration
+	timeout  time.Duration
+	tJitter  time.Duration
+	targets  map[string]*target.UDP
+	applied  appliedConfigs
+	slots    []string
+	mtx      sync.RWMutex
+}
+
+// NewUDP creates and configures a new Monitoring UDP instance
+func NewUDP(logger log.Logger, sc *config.SafeConfig, resolver *config.Resolver, sem *common.Semaphore, pools *common.Pools, shedder *common.LoadShedder) *UDP {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &UDP{
+		logger:   logger,
+		sc:       sc,
+		resolver: resolver,
+		sem:      sem,
+		pools:    pools,
+		shedder:  shedder,
+		interval: sc.Cfg.UDP.Interval.Duration(),
+		jitter:   sc.Cfg.UDP.Interval.Max() - sc.Cfg.UDP.Interval.Duration(),
+		timeout:  sc.Cfg.UDP.Timeout.Duration(),
+		tJitter:  sc.Cfg.Conf.TimeoutJitter.Duration(),
+		targets:  make(map[string]*target.UDP),
+	}
+}
+
+// Stop brings the monitoring gracefully to a halt
+func (p *UDP) Stop() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for id := range p.targets {
+		p.removeTarget(id)
+	}
+}
+
+// Wait blocks until the goroutines of all the targets return (one-shot mode)
+func (p *UDP) Wait() {
+	p.mtx.RLock()
+	targets := make([]*target.UDP, 0, len(p.targets))
+	for _, t := range p.targets {
+		targets = append(targets, t)
+	}
+	p.mtx.RUnlock()
+
+	for _, t := range targets {
+		t.Wait()
+	}
+}
+
+// addrs returns the host, port and resolved addresses of a target
+func (p *UDP) addrs(hostPort string, resolve string) (string, string, []string, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", "", nil, err
+	}
+	ipAddrs, err := p.resolver.PassAddrs(host, resolve)
+	return host, port, ipAddrs, err
+}
+
+// AddTargets adds newly added targets from the configuration
+func (p *UDP) AddTargets() {
+	level.Debug(p.logger).Log("type", "UDP", "func", "AddTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "UDP")))
+
+	targetActiveTmp := []string{}
+	for _, v := range p.targets {
+		targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
+	}
+
+	targetConfigTmp := []string{}
+	for _, v := range p.sc.Cfg.Targets {
+		if v.Type == "UDP" {
+			_, _, ipAddrs, err := p.addrs(v.Host, v.Resolve)
+			if err != nil || len(ipAddrs) == 0 {
+				level.Warn(p.logger).Log("type", "UDP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", v.Host), "err", err)
+			}
+			for _, ipAddr := range ipAddrs {
+				targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name+" "+ipAddr)
+			}
+		}
+	}
+
+	// The keys of the pass set the start phases (conf.start-spread)
+	p.mtx.Lock()
+	p.slots = targetConfigTmp
+	p.mtx.Unlock()
+
+	targetAdd := common.CompareList(targetActiveTmp, targetConfigTmp)
+	level.Debug(p.logger).Log("type", "UDP", "func", "AddTargets", "msg", fmt.Sprintf("targetName: %v", targetAdd))
+
+	for _, targetName := range targetAdd {
+		for _, target := range p.sc.Cfg.Targets {
+			if target.Type == "UDP" {
+				host, port, ipAddrs, err := p.addrs(target.Host, target.Resolve)
+				if err != nil || len(ipAddrs) == 0 {
+					level.Warn(p.logger).Log("type", "UDP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", target.Host), "err", err)
+				}
+
+				for _, ipAddr := range ipAddrs {
+					if target.Name+" "+ipAddr != targetName {
+						continue
+					}
+					p.applied.set(target.Name, target)
+					err := p.AddTarget(target.Name+" "+ipAddr, host, ipAddr, port, target)
+					if err != nil {
+						level.Warn(p.logger).Log("type", "UDP", "func", "AddTargets", "msg", fmt.Sprintf("Skipping target. Host: %s IP: %s", target.Host, ipAddr), "err", err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// AddTarget adds an address of a target to the monitored list
+func (p *UDP) AddTarget(name string, host string, ip string, port string, t config.Target) (err error) {
+	return p.AddTargetDelayed(name, host, ip, port, t, 0)
+}
+
+// AddTargetDelayed is AddTarget with a startup delay
+func (p *UDP) AddTargetDelayed(name string, host string, ip string, port string, t config.Target, startupDelay time.Duration) (err error) {
+	level.Info(p.logger).Log("type", "UDP", "func", "AddTargetDelayed", "msg", fmt.Sprintf("Adding Target: %s (%s/%s:%s) in %s", name, host, ip, port, startupDelay))
+
+	if err := skipFamily(p.sc, ip); err != nil {
+		return err
+	}
+
+	interval, jitter, timeout := targetTiming(p.sc, "UDP", t.Interval.Duration(), t.Interval.Max()-t.Interval.Duration(), t.Timeout.Duration(), p.interval, p.jitter, p.timeout)
+
+	payload, err := udp.Payload(t.UDPSend, t.UDPSendHex)
+	if err != nil {
+		return err
+	}
+	var expectRe *regexp.Regexp
+	if t.UDPExpect != "" {
+		if expectRe, err = regexp.Compile(t.UDPExpect); err != nil {
+			return err
+		}
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	startupDelay = startDelay(p.sc, p.slots, name, interval, startupDelay)
+	target, err := target.NewUDP(p.logger, p.sem, p.pools.Get(t.Pool, "UDP"), p.shedder, startupDelay, target.UDPOptions{
+		Options: target.Options{Name: name, Host: host, IP: ip, SrcAddr: t.SourceIp, Interval: interval, Jitter: jitter, Timeout: jitteredTimeout(timeout, p.tJitter), Priority: t.Priority, DependsOn: t.DependsOn, Labels: addrLabels(p.resolver.GeoIP, ip, t.Labels.Kv)},
+		Port:    port,
+		Payload: payload,
+		Expect:  expectRe,
+	})
+	if err != nil {
+		return err
+	}
+	p.removeTarget(name)
+	p.targets[name] = target
+	return nil
+}
+
+// DelTargets deletes/stops the removed targets from the configuration
+func (p *UDP) DelTargets() {
+	level.Debug(p.logger).Log("type", "UDP", "func", "DelTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "UDP")))
+
+	// The targets whose configuration changed are re-created by AddTargets, the unchanged ones keep running
+	for _, name := range p.applied.changed(p.sc, "UDP") {
+		p.mtx.Lock()
+		for key := range p.targets {
+			if strings.SplitN(key, " ", 2)[0] == name {
+				level.Info(p.logger).Log("type", "UDP", "func", "DelTargets", "msg", fmt.Sprintf("Re-creating changed Target: %s", key))
+				p.removeTarget(key)
+			}
+		}
+		p.mtx.Unlock()
+	}
+
+	targetActiveTmp := []string{}
+	for _, v := range p.targets {
+		if v != nil {
+			targetActiveTmp = common.AppendIfMissing(targetActiveTmp, v.Name())
+		}
+	}
+
+	targetConfigTmp := []string{}
+	for _, v := range p.sc.Cfg.Targets {
+		if v.Type == "UDP" {
+			_, _, ipAddrs, err := p.addrs(v.Host, v.Resolve)
+			if err != nil || len(ipAddrs) == 0 {
+				level.Warn(p.logger).Log("type", "UDP", "func", "DelTargets", "msg", fmt.Sprintf("Skipping resolve target: %s", v.Host), "err", err)
+			}
+			for _, ipAddr := range ipAddrs {
+				targetConfigTmp = common.AppendIfMissing(targetConfigTmp, v.Name+" "+ipAddr)
+			}
+		}
+	}
+
+	targetDelete := common.CompareList(targetConfigTmp, targetActiveTmp)
+	for _, targetName := range targetDelete {
+		for _, t := range p.targets {
+			if t == nil {
+				continue
+			}
+			if t.Name() == targetName {
+				p.RemoveTarget(targetName)
+			}
+		}
+	}
+}
+
+// RemoveTarget removes a target from the monitoring list
+func (p *UDP) RemoveTarget(key string) {
+	level.Info(p.logger).Log("type", "UDP", "func", "RemoveTarget", "msg", fmt.Sprintf("Removing Target: %s", key))
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.removeTarget(key)
+}
+
+// Stops monitoring a target and removes it from the list (if the list includes the target)
+func (p *UDP) removeTarget(key string) {
+	target, found := p.targets[key]
+	if !found {
+		return
+	}
+	target.Stop()
+	delete(p.targets, key)
+}
+
+// Read target if IP was changed (DNS record)
+func (p *UDP) CheckActiveTargets() (err error) {
+	level.Debug(p.logger).Log("type", "UDP", "func", "CheckActiveTargets", "msg", fmt.Sprintf("Current Targets: %d, cfg: %d", len(p.targets), countTargets(p.sc, "UDP")))
+
+	p.mtx.RLock()
+	targetActiveTmp := make(map[string]string)
+	for _, v := range p.targets {
+		targetActiveTmp[v.Name()] = v.Ip()
+	}
+	p.mtx.RUnlock()
+
+	for targetKey, targetIp := range targetActiveTmp {
+		for _, target := range p.sc.Cfg.Targets {
+			if target.Type != "UDP" || target.Name != strings.SplitN(targetKey, " ", 2)[0] {
+				continue
+			}
+			host, port, ipAddrs, err := p.addrs(target.Host, target.Resolve)
+			if err != nil || len(ipAddrs) == 0 {
+				return err
+			}
+
+			if !func(ips []string, target string) bool {
+				for _, ip := range ips {
+					if ip == target {
+						return true
+					}
+				}
+				return false
+			}(ipAddrs, targetIp) {
+
+				p.RemoveTarget(targetKey)
+
+				// The addresses still resolved keep running
+				for _, ipAddr := range ipAddrs {
+					if _, found := targetActiveTmp[target.Name+" "+ipAddr]; found {
+						continue
+					}
+					targetActiveTmp[target.Name+" "+ipAddr] = ipAddr
+					err := p.AddTarget(target.Name+" "+ipAddr, host, ipAddr, port, target)
+					if err != nil {
+						level.Warn(p.logger).Log("type", "UDP", "func", "CheckActiveTargets", "msg", fmt.Sprintf("Skipping target. Host: %s IP: %s", target.Host, ipAddr), "err", err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ExportMetrics collects the metrics for each monitored target and returns it as a simple map
+func (p *UDP) ExportMetrics() map[string]*udp.UDPReturn {
+	m := make(map[string]*udp.UDPReturn)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		name := target.Name()
+		metrics := target.Compute()
+
+		if metrics != nil {
+			m[name] = metrics
+		}
+	}
+	return m
+}
+
+// ExportLabels target labels
+func (p *UDP) ExportLabels() map[string]map[string]string {
+	l := make(map[string]map[string]string)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		name := target.Name()
+		labels := target.Labels()
+
+		if labels != nil {
+			l[name] = labels
+		}
+	}
+	return l
+}
+
+// ExportStats target scheduling details
+func (p *UDP) ExportStats() map[string]target.ProbeStats {
+	st := make(map[string]target.ProbeStats)
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	for _, target := range p.targets {
+		st[target.Name()] = target.Stats()
+	}
+	return st
+}
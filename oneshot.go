@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/syepes/network_exporter/collector"
+	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/target"
+)
+
+// runOneshot probes every target a single time, prints the results and returns the exit code (1 when a target failed)
+func runOneshot(output string) int {
+	// Without interval the targets run a single cycle right away, the echoes keep their configured spacing
+	for i := range sc.Cfg.Targets {
+		t := &sc.Cfg.Targets[i]
+		if t.Interval.Duration() > 0 && t.InterPacketInterval.Duration() <= 0 && sc.Cfg.ICMP.InterPacketInterval.Duration() <= 0 {
+			t.InterPacketInterval.Set(t.Interval.Duration())
+		}
+		t.Interval.Set(0)
+	}
+	if sc.Cfg.ICMP.InterPacketInterval.Duration() <= 0 {
+		sc.Cfg.ICMP.InterPacketInterval.Set(sc.Cfg.ICMP.Interval.Duration())
+	}
+	sc.Cfg.ICMP.Interval.Set(0)
+	sc.Cfg.MTR.Interval.Set(0)
+	sc.Cfg.TCP.Interval.Set(0)
+	sc.Cfg.UDP.Interval.Set(0)
+	sc.Cfg.HTTPGet.Interval.Set(0)
+	sc.Cfg.ARP.Interval.Set(0)
+	sc.Cfg.OWD.Interval.Set(0)
+	sc.Cfg.DNS.Interval.Set(0)
+
+	monitorPING = monitor.NewPing(logger, sc, resolver, icmpID, rateLimiter, probeSem, probePools, loadShedder)
+	monitorMTR = monitor.NewMTR(logger, sc, resolver, icmpID, rateLimiter, probeSem, probePools, loadShedder)
+	monitorTCP = monitor.NewTCPPort(logger, sc, resolver, probeSem, probePools, loadShedder)
+	monitorUDP = monitor.NewUDP(logger, sc, resolver, probeSem, probePools, loadShedder)
+	monitorHTTPGet = monitor.NewHTTPGet(logger, sc, resolver, probeSem, probePools, loadShedder)
+	monitorARP = monitor.NewARP(logger, sc, resolver, probeSem, probePools, loadShedder)
+	monitorOWD = monitor.NewOWD(logger, sc, resolver, probeSem, probePools, loadShedder)
+	monitorDNS = monitor.NewDNS(logger, sc, resolver, probeSem, probePools, loadShedder)
+	target.SetSchedules(sc.Cfg.Schedules())
+	monitorPING.AddTargets()
+	monitorMTR.AddTargets()
+	monitorTCP.AddTargets()
+	monitorUDP.AddTargets()
+	monitorHTTPGet.AddTargets()
+	monitorARP.AddTargets()
+	monitorOWD.AddTargets()
+	monitorDNS.AddTargets()
+
+	monitorPING.Wait()
+	monitorMTR.Wait()
+	monitorTCP.Wait()
+	monitorUDP.Wait()
+	monitorHTTPGet.Wait()
+	monitorARP.Wait()
+	monitorOWD.Wait()
+	monitorDNS.Wait()
+
+	failed := oneshotFailed()
+	var err error
+	switch output {
+	case "json":
+		err = oneshotJSON(failed)
+	default:
+		err = oneshotPrometheus()
+	}
+	if err != nil {
+		level.Error(logger).Log("msg", "Writing the one-shot results", "err", err)
+		return 1
+	}
+
+	if len(failed) > 0 {
+		level.Error(logger).Log("msg", fmt.Sprintf("One-shot run failed targets: %s", strings.Join(failed, ", ")))
+		return 1
+	}
+	return 0
+}
+
+// oneshotFailed returns the targets without a successful cycle, configured targets that could not be started (e.g. unresolvable) included
+func oneshotFailed() []string {
+	failed := []string{}
+	started := map[string]bool{}
+	for _, stats := range []map[string]target.ProbeStats{monitorPING.ExportStats(), monitorMTR.ExportStats(), monitorTCP.ExportStats(), monitorUDP.ExportStats(), monitorHTTPGet.ExportStats(), monitorARP.ExportStats(), monitorOWD.ExportStats(), monitorDNS.ExportStats()} {
+		for key, st := range stats {
+			name := strings.SplitN(key, " ", 2)[0]
+			started[st.Type+" "+name] = true
+			// A target within a maintenance window is not a failure
+			if st.LastSuccess.IsZero() && !st.Suppressed {
+				failed = append(failed, fmt.Sprintf("%s (%s %s)", key, st.Type, st.Host))
+			}
+		}
+	}
+
+	for _, t := range sc.Cfg.Targets {
+		types := []string{t.Type}
+		if t.Type == "ICMP+MTR" {
+			types = []string{"ICMP", "MTR"}
+		}
+		for _, typ := range types {
+			if !started[typ+" "+t.Name] {
+				failed = append(failed, fmt.Sprintf("%s (%s %s not started)", t.Name, typ, t.Host))
+			}
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// oneshotPrometheus writes the results in the Prometheus text format (same metrics as /metrics)
+func oneshotPrometheus() error {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&collector.MTR{SC: sc, Monitor: monitorMTR, GeoIP: resolver.GeoIP})
+	reg.MustRegister(&collector.PING{SC: sc, Monitor: monitorPING})
+	reg.MustRegister(&collector.TCP{SC: sc, Monitor: monitorTCP})
+	reg.MustRegister(&collector.HTTPGet{SC: sc, Monitor: monitorHTTPGet})
+	reg.MustRegister(&collector.ARP{SC: sc, Monitor: monitorARP})
+	reg.MustRegister(&collector.OWD{SC: sc, Monitor: monitorOWD})
+	reg.MustRegister(&collector.DNS{SC: sc, Monitor: monitorDNS})
+	reg.MustRegister(&collector.UDP{SC: sc, Monitor: monitorUDP})
+	reg.MustRegister(&collector.Exporter{SC: sc, PING: monitorPING, MTR: monitorMTR, TCP: monitorTCP, UDP: monitorUDP, HTTPGet: monitorHTTPGet, ARP: monitorARP, OWD: monitorOWD, DNS: monitorDNS, Breaker: resolver.Breaker, Resolver: resolver, RateLimiter: rateLimiter, LoadShedder: loadShedder})
+
+	mfs, err := relabelGatherer(reg).Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(os.Stdout, mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oneshotJSON writes the raw probe results
+func oneshotJSON(failed []string) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"icmp":     monitorPING.ExportMetrics(),
+		"mtr":      monitorMTR.ExportMetrics(),
+		"tcp":      monitorTCP.ExportMetrics(),
+		"udp":      monitorUDP.ExportMetrics(),
+		"http_get": monitorHTTPGet.ExportMetrics(),
+		"arp":      monitorARP.ExportMetrics(),
+		"owd":      monitorOWD.ExportMetrics(),
+		"dns":      monitorDNS.ExportMetrics(),
+		"failed":   failed,
+	})
+}
@@ -0,0 +1,131 @@
+//go:build linux
+// +build linux
+
+package arp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Arp sends an ARP request (IPv4) or an NDP neighbor solicitation (IPv6) for ip on the device and waits for the reply of the owner of the address
+// The source address is the first IPv4 (the link-local IPv6) of the device when srcAddr is not set
+func Arp(destAddr string, ip string, device string, srcAddr string, timeout time.Duration) (*ARPReturn, error) {
+	out := &ARPReturn{DestAddr: destAddr, DestIp: ip, Device: device, Protocol: ProtocolARP}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return out, fmt.Errorf("destination ip: %v is not a valid IP", ip)
+	}
+	ifi, err := net.InterfaceByName(device)
+	if err != nil {
+		return out, err
+	}
+	dst := parsed.To4()
+	if dst == nil {
+		return ndp(out, ifi, parsed.To16(), srcAddr, timeout)
+	}
+	if len(ifi.HardwareAddr) != 6 {
+		return out, fmt.Errorf("device %s has no ethernet address", device)
+	}
+	src, err := sourceIp(ifi, srcAddr)
+	if err != nil {
+		return out, err
+	}
+	out.SrcIp = src.String()
+
+	proto := htons(unix.ETH_P_ARP)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return out, err
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index}); err != nil {
+		return out, err
+	}
+
+	// The kernel builds the ethernet header (SOCK_DGRAM), only the ARP payload is written
+	req := make([]byte, 28)
+	binary.BigEndian.PutUint16(req[0:], 1)      // Ethernet
+	binary.BigEndian.PutUint16(req[2:], 0x0800) // IPv4
+	req[4], req[5] = 6, 4
+	binary.BigEndian.PutUint16(req[6:], 1) // Request
+	copy(req[8:], ifi.HardwareAddr)
+	copy(req[14:], src)
+	copy(req[24:], dst)
+
+	broadcast := &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index, Halen: 6}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	if err := unix.Sendto(fd, req, 0, broadcast); err != nil {
+		return out, err
+	}
+
+	b := make([]byte, 128)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return out, fmt.Errorf("arp timeout")
+		}
+		tv := unix.NsecToTimeval(remaining.Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return out, err
+		}
+
+		n, _, err := unix.Recvfrom(fd, b, 0)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			}
+			return out, err
+		}
+
+		// Reply (oper 2) sent by the owner of the address
+		if n < 28 || binary.BigEndian.Uint16(b[6:]) != 2 || !bytes.Equal(b[14:18], dst) {
+			continue
+		}
+		out.Rtt = time.Since(start)
+		out.HwAddr = net.HardwareAddr(b[8:14]).String()
+		out.Success = true
+		return out, nil
+	}
+}
+
+// sourceIp returns srcAddr or the first IPv4 of the device
+func sourceIp(ifi *net.Interface, srcAddr string) (net.IP, error) {
+	if srcAddr != "" {
+		src := net.ParseIP(srcAddr).To4()
+		if src == nil {
+			return nil, fmt.Errorf("source ip: %v is not a valid IPv4", srcAddr)
+		}
+		return src, nil
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("device %s has no IPv4 address", ifi.Name)
+}
+
+// htons host to network byte order (the link-layer protocol field)
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return binary.NativeEndian.Uint16(b)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package arp
+
+import (
+	"fmt"
+	"time"
+)
+
+// Arp ARP/NDP probes need a link-layer (raw ICMPv6) socket, only implemented on Linux
+func Arp(destAddr string, ip string, device string, srcAddr string, timeout time.Duration) (*ARPReturn, error) {
+	out := &ARPReturn{DestAddr: destAddr, DestIp: ip, Device: device}
+	return out, fmt.Errorf("arp/ndp probes are not supported on this platform")
+}
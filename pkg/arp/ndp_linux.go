@@ -0,0 +1,138 @@
+//go:build linux
+// +build linux
+
+package arp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// ndp sends an NDP neighbor solicitation (RFC 4861) for ip on the device to its solicited-node multicast address
+// and waits for the neighbor advertisement of the owner of the address
+// The source address is the link-local address of the device when srcAddr is not set
+func ndp(out *ARPReturn, ifi *net.Interface, dst net.IP, srcAddr string, timeout time.Duration) (*ARPReturn, error) {
+	out.Protocol = ProtocolNDP
+	src, err := sourceIp6(ifi, srcAddr)
+	if err != nil {
+		return out, err
+	}
+	out.SrcIp = src.String()
+
+	c, err := icmp.ListenPacket("ip6:ipv6-icmp", src.String()+"%"+ifi.Name)
+	if err != nil {
+		return out, err
+	}
+	defer c.Close()
+
+	// The neighbor discovery messages are only valid with a hop limit of 255 (never forwarded)
+	pc := c.IPv6PacketConn()
+	if err := pc.SetMulticastInterface(ifi); err != nil {
+		return out, err
+	}
+	if err := pc.SetMulticastHopLimit(255); err != nil {
+		return out, err
+	}
+	if err := pc.SetControlMessage(ipv6.FlagHopLimit, true); err != nil {
+		return out, err
+	}
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	if err := pc.SetICMPFilter(&filter); err != nil {
+		return out, err
+	}
+
+	// Reserved, target address and source link-layer address option (the checksum is set by the kernel)
+	body := make([]byte, 4, 4+net.IPv6len+8)
+	body = append(body, dst...)
+	if len(ifi.HardwareAddr) == 6 {
+		body = append(append(body, 1, 1), ifi.HardwareAddr...)
+	}
+	req, err := (&icmp.Message{Type: ipv6.ICMPTypeNeighborSolicitation, Body: &icmp.RawBody{Data: body}}).Marshal(nil)
+	if err != nil {
+		return out, err
+	}
+	group := net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, dst[13], dst[14], dst[15]}
+
+	start := time.Now()
+	if err := pc.SetReadDeadline(start.Add(timeout)); err != nil {
+		return out, err
+	}
+	if _, err := pc.WriteTo(req, nil, &net.IPAddr{IP: group, Zone: ifi.Name}); err != nil {
+		return out, err
+	}
+
+	b := make([]byte, 1500)
+	for {
+		n, cm, _, err := pc.ReadFrom(b)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return out, fmt.Errorf("ndp timeout")
+			}
+			return out, err
+		}
+
+		// Advertisement (type 136) of the target address: type, code, checksum, flags, target address, options
+		if n < 8+net.IPv6len || b[0] != byte(ipv6.ICMPTypeNeighborAdvertisement) || b[1] != 0 || (cm != nil && cm.HopLimit != 255) || !bytes.Equal(b[8:8+net.IPv6len], dst) {
+			continue
+		}
+		out.Rtt = time.Since(start)
+		out.HwAddr = targetLinkLayer(b[8+net.IPv6len : n])
+		out.Success = true
+		return out, nil
+	}
+}
+
+// targetLinkLayer returns the target link-layer address option (type 2) of the advertisement options
+func targetLinkLayer(opts []byte) string {
+	for len(opts) >= 8 {
+		size := int(opts[1]) * 8
+		if size == 0 || size > len(opts) {
+			break
+		}
+		if opts[0] == 2 && size >= 8 {
+			return net.HardwareAddr(opts[2:8]).String()
+		}
+		opts = opts[size:]
+	}
+	return ""
+}
+
+// sourceIp6 returns srcAddr or the link-local IPv6 of the device (the first IPv6 without link-local)
+func sourceIp6(ifi *net.Interface, srcAddr string) (net.IP, error) {
+	if srcAddr != "" {
+		src := net.ParseIP(srcAddr)
+		if src == nil || src.To4() != nil {
+			return nil, fmt.Errorf("source ip: %v is not a valid IPv6", srcAddr)
+		}
+		return src, nil
+	}
+
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var first net.IP
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.To4() != nil {
+			continue
+		}
+		if n.IP.IsLinkLocalUnicast() {
+			return n.IP, nil
+		}
+		if first == nil {
+			first = n.IP
+		}
+	}
+	if first == nil {
+		return nil, fmt.Errorf("device %s has no IPv6 address", ifi.Name)
+	}
+	return first, nil
+}
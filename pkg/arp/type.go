@@ -0,0 +1,23 @@
+package arp
+
+import "time"
+
+const defaultTimeout = 1 * time.Second
+
+// Neighbor discovery protocols
+const (
+	ProtocolARP = "arp" // IPv4
+	ProtocolNDP = "ndp" // IPv6
+)
+
+// ARPReturn Calculated results
+type ARPReturn struct {
+	Success  bool          `json:"success"`
+	DestAddr string        `json:"dest_address"`
+	DestIp   string        `json:"dest_ip"`
+	Device   string        `json:"device"`
+	SrcIp    string        `json:"src_ip"`
+	HwAddr   string        `json:"hw_address"`
+	Protocol string        `json:"protocol"`
+	Rtt      time.Duration `json:"rtt"`
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen returned instead of resolving while the resolver circuit breaker is open
+var ErrCircuitOpen = errors.New("resolve_circuit_open")
+
+// Breaker states
+const (
+	BreakerClosed   = 0
+	BreakerOpen     = 1
+	BreakerHalfOpen = 2
+)
+
+// Breaker Circuit breaker shared by all the name resolutions
+// After threshold consecutive failures within window the lookups are short-circuited for cooldown,
+// then a single lookup at a time is let through until one succeeds
+type Breaker struct {
+	mtx       sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	state     int
+	failures  int
+	first     time.Time
+	openedAt  time.Time
+	probing   bool
+	rejected  uint64
+}
+
+// NewBreaker creates a circuit breaker, a threshold <= 0 disables it
+func NewBreaker(threshold int, window time.Duration, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, window: window, cooldown: cooldown}
+}
+
+// Allow reports if a lookup can be sent to the resolver and if it is the half-open probe
+func (b *Breaker) Allow() (ok bool, probe bool) {
+	if b == nil || b.threshold <= 0 {
+		return true, false
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.rejected++
+			return false, false
+		}
+		b.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		// Probe the resolver sparingly, one lookup at a time
+		if b.probing {
+			b.rejected++
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	}
+	return true, false
+}
+
+// Done records the outcome of an allowed lookup
+func (b *Breaker) Done(probe bool, failed bool) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	now := time.Now()
+	if probe {
+		b.state = BreakerOpen
+		b.openedAt = now
+		return
+	}
+	if b.state != BreakerClosed {
+		return
+	}
+
+	if b.failures == 0 || now.Sub(b.first) > b.window {
+		b.failures = 0
+		b.first = now
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = now
+		b.failures = 0
+	}
+}
+
+// State returns the current state (BreakerClosed, BreakerOpen or BreakerHalfOpen)
+func (b *Breaker) State() int {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Rejected returns the number of short-circuited lookups
+func (b *Breaker) Rejected() uint64 {
+	if b == nil {
+		return 0
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.rejected
+}
@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetDevice binds the socket to the network interface (SO_BINDTODEVICE), the probes leave through it whatever the routing says (requires CAP_NET_RAW)
+func SetDevice(c syscall.RawConn, device string) error {
+	if device == "" {
+		return nil
+	}
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = unix.BindToDevice(int(fd), device)
+	}); err != nil {
+		return err
+	}
+	if serr != nil {
+		return fmt.Errorf("binding to the interface %s (requires CAP_NET_RAW): %w", device, serr)
+	}
+	return nil
+}
+
+// CheckDevice reports if the network interface exists (clear error before probing)
+func CheckDevice(device string) error {
+	if device == "" {
+		return nil
+	}
+	if _, err := net.InterfaceByName(device); err != nil {
+		return fmt.Errorf("source interface %s: %w", device, err)
+	}
+	return nil
+}
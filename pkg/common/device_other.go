@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package common
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// SetDevice the interface binding (SO_BINDTODEVICE) is only implemented on Linux
+func SetDevice(c syscall.RawConn, device string) error {
+	if device == "" {
+		return nil
+	}
+	return fmt.Errorf("source interface binding is not supported on this platform")
+}
+
+// CheckDevice the interface binding (SO_BINDTODEVICE) is only implemented on Linux
+func CheckDevice(device string) error {
+	return SetDevice(nil, device)
+}
@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package common
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetDSCP sets the DSCP (upper 6 bits of the IPv4 ToS / IPv6 traffic class) of the socket, a zero dscp leaves it unmarked
+func SetDSCP(c syscall.RawConn, v4 bool, dscp int) error {
+	if dscp == 0 {
+		return nil
+	}
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		if v4 {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, dscp<<2)
+		} else {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscp<<2)
+		}
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package common
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// SetDSCP the DSCP of the TCP/UDP sockets is only implemented on Linux
+func SetDSCP(c syscall.RawConn, v4 bool, dscp int) error {
+	if dscp == 0 {
+		return nil
+	}
+	return fmt.Errorf("dscp is not supported on this platform")
+}
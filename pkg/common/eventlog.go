@@ -0,0 +1,63 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// EventLog Append-only log of events written as JSON lines (file or stdout)
+type EventLog struct {
+	logger log.Logger
+	mtx    sync.Mutex
+	w      io.Writer
+	f      *os.File
+}
+
+// NewEventLog opens the event log, "-" writes to stdout and a file is appended to (created if missing)
+func NewEventLog(logger log.Logger, path string) (*EventLog, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if path == "-" {
+		return &EventLog{logger: logger, w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{logger: logger, w: f, f: f}, nil
+}
+
+// Write appends the event, the write errors are logged (the probes are never blocked by the sink)
+func (l *EventLog) Write(event interface{}) {
+	if l == nil {
+		return
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		level.Error(l.logger).Log("type", "EventLog", "func", "Write", "msg", fmt.Sprintf("%s", err))
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		level.Error(l.logger).Log("type", "EventLog", "func", "Write", "msg", fmt.Sprintf("%s", err))
+	}
+}
+
+// Close closes the event log file
+func (l *EventLog) Close() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// families Address families usable on the host (detected at startup, both assumed usable before)
+var families = struct {
+	sync.RWMutex
+	v4 bool
+	v6 bool
+}{v4: true, v6: true}
+
+// DetectFamilies checks if the IPv4 and IPv6 address families are usable (a socket can be bound to the loopback address)
+// An administratively disabled IPv6 (disable_ipv6, ipv6.disable=1) has no ::1
+func DetectFamilies() (v4 bool, v6 bool) {
+	v4 = familyUsable("udp4", "127.0.0.1:0")
+	v6 = familyUsable("udp6", "[::1]:0")
+
+	families.Lock()
+	defer families.Unlock()
+	families.v4 = v4
+	families.v6 = v6
+	return v4, v6
+}
+
+func familyUsable(network string, address string) bool {
+	c, err := net.ListenPacket(network, address)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// FamilyUsable reports if the address family of the ip is usable (true for the hostnames and empty addresses)
+func FamilyUsable(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return true
+	}
+	families.RLock()
+	defer families.RUnlock()
+	if addr.To4() != nil {
+		return families.v4
+	}
+	return families.v6
+}
+
+// FamilyError error of the targets whose address family is not usable
+func FamilyError(ip string) error {
+	family := "IPv6"
+	if addr := net.ParseIP(ip); addr != nil && addr.To4() != nil {
+		family = "IPv4"
+	}
+	return fmt.Errorf("family_unavailable: %s is not usable on this host (%s)", family, ip)
+}
+
+// SameFamily reports if both ips are of the same address family
+func SameFamily(a string, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipB != nil && (ipA.To4() != nil) == (ipB.To4() != nil)
+}
@@ -2,10 +2,12 @@ package common
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -41,30 +43,212 @@ func SrvRecordHosts(record string) ([]string, error) {
 	return hosts, nil
 }
 
+// Resolution modes of a target host
+const (
+	ResolveIP    = "ip"    // A and AAAA records
+	ResolveIPv4  = "ipv4"  // A records only
+	ResolveIPv6  = "ipv6"  // AAAA records only
+	ResolveCNAME = "cname" // Follow the CNAME chain then the A and AAAA records of the canonical name
+)
+
+// Classified resolution failures
+const (
+	ResolveErrNotFound    = "not_found"
+	ResolveErrNoAddress   = "no_address"
+	ResolveErrCNAMELoop   = "cname_loop"
+	ResolveErrTimeout     = "timeout"
+	ResolveErrCircuitOpen = "circuit_open"
+	ResolveErrOther       = "error"
+)
+
+// maxCNAMEChain Upper bound of the CNAMEs followed in cname mode
+const maxCNAMEChain = 16
+
+// ResolveError Resolution failure with its classification
+type ResolveError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("resolving target (%s): %v", e.Reason, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	resolveErrMtx sync.Mutex
+	resolveErrs   = map[string]uint64{}
+)
+
+// ResolveErrors returns the number of failed resolutions per reason
+func ResolveErrors() map[string]uint64 {
+	resolveErrMtx.Lock()
+	defer resolveErrMtx.Unlock()
+	out := make(map[string]uint64, len(resolveErrs))
+	for k, v := range resolveErrs {
+		out[k] = v
+	}
+	return out
+}
+
+func resolveFailed(reason string, err error) error {
+	resolveErrMtx.Lock()
+	resolveErrs[reason]++
+	resolveErrMtx.Unlock()
+	return &ResolveError{Reason: reason, Err: err}
+}
+
+// ValidResolveMode checks the resolution mode of a target (empty is ip)
+func ValidResolveMode(mode string) bool {
+	switch mode {
+	case "", ResolveIP, ResolveIPv4, ResolveIPv6, ResolveCNAME:
+		return true
+	}
+	return false
+}
+
 // DestAddrs resolve the hostname to all it'ss IP's
-func DestAddrs(ctx context.Context, host string, resolver *net.Resolver, timeout time.Duration) ([]string, error) {
+// When hostsFile is set its entries take precedence over the resolver
+// The resolver lookups go through the breaker (nil disables it)
+func DestAddrs(ctx context.Context, host string, resolver *net.Resolver, hostsFile string, breaker *Breaker, timeout time.Duration) ([]string, error) {
+	return DestAddrsMode(ctx, host, ResolveIP, resolver, hostsFile, breaker, timeout)
+}
+
+// DestAddrsMode is DestAddrs with the resolution mode of the target
+func DestAddrsMode(ctx context.Context, host string, mode string, resolver *net.Resolver, hostsFile string, breaker *Breaker, timeout time.Duration) ([]string, error) {
 	ipAddrs := make([]string, 0)
 
+	if addrs := filterFamily(HostsLookup(hostsFile, host), mode); len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	// Literal addresses don't need the resolver
+	if ip := net.ParseIP(host); ip != nil {
+		if addrs := filterFamily([]string{ip.String()}, mode); len(addrs) > 0 {
+			return addrs, nil
+		}
+		return nil, resolveFailed(ResolveErrNoAddress, fmt.Errorf("%s is not a %s address", host, mode))
+	}
+
+	ok, probe := breaker.Allow()
+	if !ok {
+		return nil, resolveFailed(ResolveErrCircuitOpen, ErrCircuitOpen)
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	addrs, err := resolver.LookupIPAddr(ctx, host)
+	name := host
+	var err error
+	if mode == ResolveCNAME {
+		name, err = followCNAME(ctx, resolver, host)
+	}
+
+	var addrs []net.IP
+	if err == nil {
+		addrs, err = resolver.LookupIP(ctx, lookupNetwork(mode), name)
+	}
+	breaker.Done(probe, err != nil && !isNotFound(err) && !isCNAMELoop(err))
 	if err != nil {
-		return nil, fmt.Errorf("resolving target: %v", err)
+		return nil, resolveFailed(classifyResolveError(err), err)
 	}
 
 	// Validate IPs
 	for _, addr := range addrs {
-		ipAddr, err := net.ResolveIPAddr("ip", addr.IP.String())
+		ipAddr, err := net.ResolveIPAddr("ip", addr.String())
 		if err != nil {
 			continue
 		}
 		ipAddrs = append(ipAddrs, ipAddr.IP.String())
 	}
+	if len(ipAddrs) == 0 {
+		return nil, resolveFailed(ResolveErrNoAddress, fmt.Errorf("no %s address for %s", lookupNetwork(mode), host))
+	}
 
 	return ipAddrs, nil
 }
 
+// errCNAMELoop the CNAME chain loops or is too long
+var errCNAMELoop = errors.New("cname loop")
+
+// followCNAME follows the CNAME chain of the host up to its canonical name
+func followCNAME(ctx context.Context, resolver *net.Resolver, host string) (string, error) {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	seen := map[string]bool{}
+	for i := 0; i < maxCNAMEChain; i++ {
+		seen[name] = true
+		cname, err := resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+		if cname == "" || cname == name {
+			return name, nil
+		}
+		if seen[cname] {
+			return "", fmt.Errorf("%w: %s -> %s", errCNAMELoop, name, cname)
+		}
+		name = cname
+	}
+	return "", fmt.Errorf("%w: more than %d CNAMEs from %s", errCNAMELoop, maxCNAMEChain, host)
+}
+
+// lookupNetwork network of the address lookup for the resolution mode
+func lookupNetwork(mode string) string {
+	switch mode {
+	case ResolveIPv4:
+		return "ip4"
+	case ResolveIPv6:
+		return "ip6"
+	}
+	return "ip"
+}
+
+// filterFamily keeps the addresses of the family of the resolution mode
+func filterFamily(addrs []string, mode string) []string {
+	if mode != ResolveIPv4 && mode != ResolveIPv6 {
+		return addrs
+	}
+	out := []string{}
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		if (ip.To4() != nil) == (mode == ResolveIPv4) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// classifyResolveError reason of a failed lookup
+func classifyResolveError(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case isCNAMELoop(err):
+		return ResolveErrCNAMELoop
+	case isNotFound(err):
+		return ResolveErrNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &dnsErr) && dnsErr.IsTimeout:
+		return ResolveErrTimeout
+	}
+	return ResolveErrOther
+}
+
+func isCNAMELoop(err error) bool {
+	return errors.Is(err, errCNAMELoop)
+}
+
+// isNotFound the name doesn't exist, the resolver itself did answer
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
 // IsEqualIP IP Comparison
 func IsEqualIP(ips1, ips2 string) bool {
 	ip1 := net.ParseIP(ips1)
@@ -145,6 +329,18 @@ func TimeCorrectedDeviation(values []time.Duration) float64 {
 	return math.Sqrt(sd / (float64(len(values)) - 1))
 }
 
+// TimeJitter Updates the interarrival jitter estimate with the consecutive values (RFC 3550 6.4.1: J += (|D| - J) / 16)
+func TimeJitter(jitter time.Duration, values []time.Duration) time.Duration {
+	for i := 1; i < len(values); i++ {
+		d := values[i] - values[i-1]
+		if d < 0 {
+			d = -d
+		}
+		jitter += (d - jitter) / 16
+	}
+	return jitter
+}
+
 // CompareList Compare two lists and return a list with the difference
 func CompareList(a, b []string) []string {
 	var tmpList []string
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package common
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetMark sets the firewall mark (SO_MARK) of the socket, steers the probes with the policy routing (requires CAP_NET_ADMIN)
+func SetMark(c syscall.RawConn, mark int) error {
+	if mark == 0 {
+		return nil
+	}
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, mark)
+	}); err != nil {
+		return err
+	}
+	if serr != nil {
+		return fmt.Errorf("setting fwmark %#x (requires CAP_NET_ADMIN): %w", mark, serr)
+	}
+	return nil
+}
+
+// CheckMark reports if the firewall mark can be set on the probe sockets (clear error before probing)
+func CheckMark(mark int) error {
+	if mark == 0 {
+		return nil
+	}
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, mark); err != nil {
+		return fmt.Errorf("setting fwmark %#x (requires CAP_NET_ADMIN): %w", mark, err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package common
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// SetMark the firewall mark (SO_MARK) is only implemented on Linux
+func SetMark(c syscall.RawConn, mark int) error {
+	if mark == 0 {
+		return nil
+	}
+	return fmt.Errorf("fwmark is not supported on this platform")
+}
+
+// CheckMark the firewall mark (SO_MARK) is only implemented on Linux
+func CheckMark(mark int) error {
+	return SetMark(nil, mark)
+}
@@ -0,0 +1,206 @@
+package common
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIP enriches the resolved addresses with their ASN and country from MaxMind-style (mmdb) databases
+// The databases can be replaced at runtime (Load), the lookups switch to the new ones atomically
+type GeoIP struct {
+	asn         *maxminddb.Reader
+	country     *maxminddb.Reader
+	asnPath     string
+	countryPath string
+	asnMod      time.Time
+	countryMod  time.Time
+	gen         uint64
+	cache       map[string]geoRecord
+	mtx         sync.RWMutex
+}
+
+type geoRecord struct {
+	asn     string
+	asName  string
+	country string
+}
+
+// Fields shared by the GeoLite2/GeoIP2 ASN and Country databases (combined databases are supported too)
+type mmdbRecord struct {
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+	Country                      struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// NewGeoIP returns the enrichment without databases (disabled until Load)
+func NewGeoIP() *GeoIP {
+	return &GeoIP{cache: make(map[string]geoRecord)}
+}
+
+// Load opens the ASN and/or Country databases (an empty path skips the database) when their paths or modification times changed
+// The current databases are kept when the new ones can't be opened, it returns whether the databases were replaced
+func (g *GeoIP) Load(asnPath string, countryPath string) (bool, error) {
+	asnMod, err := modTime(asnPath)
+	if err != nil {
+		return false, err
+	}
+	countryMod, err := modTime(countryPath)
+	if err != nil {
+		return false, err
+	}
+	g.mtx.RLock()
+	unchanged := asnPath == g.asnPath && countryPath == g.countryPath && asnMod.Equal(g.asnMod) && countryMod.Equal(g.countryMod)
+	g.mtx.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	var asn, country *maxminddb.Reader
+	if asnPath != "" {
+		if asn, err = maxminddb.Open(asnPath); err != nil {
+			return false, err
+		}
+	}
+	if countryPath != "" {
+		if countryPath == asnPath {
+			country = asn
+		} else if country, err = maxminddb.Open(countryPath); err != nil {
+			closeReaders(asn, nil)
+			return false, err
+		}
+	}
+
+	// The lookups hold the read lock, the old databases are no longer used once the write lock is acquired
+	g.mtx.Lock()
+	oldASN, oldCountry := g.asn, g.country
+	g.asn, g.country = asn, country
+	g.asnPath, g.countryPath = asnPath, countryPath
+	g.asnMod, g.countryMod = asnMod, countryMod
+	g.cache = make(map[string]geoRecord)
+	g.gen++
+	closeReaders(oldASN, oldCountry)
+	g.mtx.Unlock()
+	return true, nil
+}
+
+// Enabled returns whether a database is loaded
+func (g *GeoIP) Enabled() bool {
+	if g == nil {
+		return false
+	}
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	return g.asn != nil || g.country != nil
+}
+
+// Close releases the databases
+func (g *GeoIP) Close() {
+	if g == nil {
+		return
+	}
+	g.mtx.Lock()
+	closeReaders(g.asn, g.country)
+	g.asn, g.country = nil, nil
+	g.asnPath, g.countryPath = "", ""
+	g.mtx.Unlock()
+}
+
+// Labels returns a copy of labels with the asn/country of ip added (the configured labels take precedence)
+func (g *GeoIP) Labels(ip string, labels map[string]string) map[string]string {
+	if !g.Enabled() {
+		return labels
+	}
+	rec := g.lookup(ip)
+	if rec.asn == "" && rec.country == "" {
+		return labels
+	}
+
+	l := make(map[string]string, len(labels)+2)
+	if rec.asn != "" {
+		l["asn"] = rec.asn
+	}
+	if rec.country != "" {
+		l["country"] = rec.country
+	}
+	for k, v := range labels {
+		l[k] = v
+	}
+	return l
+}
+
+// Hop returns the asn, AS organization name and country of ip (empty when unknown or not an address)
+func (g *GeoIP) Hop(ip string) (asn string, asName string, country string) {
+	if !g.Enabled() {
+		return "", "", ""
+	}
+	rec := g.lookup(ip)
+	return rec.asn, rec.asName, rec.country
+}
+
+// lookup queries the databases once per address, the results (even empty) are cached
+func (g *GeoIP) lookup(ip string) geoRecord {
+	g.mtx.RLock()
+	rec, ok := g.cache[ip]
+	if ok {
+		g.mtx.RUnlock()
+		return rec
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		g.mtx.RUnlock()
+		return rec
+	}
+
+	// Not found addresses leave the record empty
+	var r mmdbRecord
+	if g.asn != nil {
+		_ = g.asn.Lookup(addr, &r)
+	}
+	if g.country != nil && g.country != g.asn {
+		_ = g.country.Lookup(addr, &r)
+	}
+	gen := g.gen
+	g.mtx.RUnlock()
+	if r.AutonomousSystemNumber > 0 {
+		rec.asn = strconv.FormatUint(uint64(r.AutonomousSystemNumber), 10)
+	}
+	rec.asName = r.AutonomousSystemOrganization
+	rec.country = r.Country.IsoCode
+
+	// A record of replaced databases isn't cached
+	g.mtx.Lock()
+	if gen == g.gen {
+		g.cache[ip] = rec
+	}
+	g.mtx.Unlock()
+	return rec
+}
+
+// modTime returns the modification time of a database (zero for an empty path)
+func modTime(path string) (time.Time, error) {
+	if path == "" {
+		return time.Time{}, nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func closeReaders(asn *maxminddb.Reader, country *maxminddb.Reader) {
+	if asn != nil {
+		asn.Close()
+	}
+	if country != nil && country != asn {
+		country.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxHistogramBuckets Upper bound of the buckets of the RTT histograms
+const MaxHistogramBuckets = 32
+
+// Histogram Cumulative distribution of the RTTs of a target (since the target was started)
+// A cycle observes into a copy so the results already exported are never modified
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Count  uint64    `json:"count"`
+	Sum    float64   `json:"sum"`
+}
+
+// ValidateBuckets checks the upper bounds (seconds) of the buckets, they must be >0 and increasing
+func ValidateBuckets(bounds []float64) error {
+	if len(bounds) > MaxHistogramBuckets {
+		return fmt.Errorf("at most %d buckets are supported", MaxHistogramBuckets)
+	}
+	for i, b := range bounds {
+		if b <= 0 {
+			return fmt.Errorf("bucket %v must be >0", b)
+		}
+		if i > 0 && b <= bounds[i-1] {
+			return fmt.Errorf("buckets must be increasing (%v after %v)", b, bounds[i-1])
+		}
+	}
+	return nil
+}
+
+// Next returns the histogram of the next cycle, a copy of h (a new one without previous cycle or when the bounds changed), nil without buckets
+func (h *Histogram) Next(bounds []float64) *Histogram {
+	if len(bounds) == 0 {
+		return nil
+	}
+	if h == nil || !sameBounds(h.Bounds, bounds) {
+		return &Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds))}
+	}
+	n := *h
+	n.Counts = append([]uint64(nil), h.Counts...)
+	return &n
+}
+
+// sameBounds the counts of a histogram can only be carried on with the same buckets
+func sameBounds(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Observe accounts a RTT
+func (h *Histogram) Observe(d time.Duration) {
+	if h == nil {
+		return
+	}
+	v := d.Seconds()
+	for i, b := range h.Bounds {
+		if v <= b {
+			h.Counts[i]++
+			break
+		}
+	}
+	h.Count++
+	h.Sum += v
+}
+
+// Buckets returns the cumulative counts per upper bound (the +Inf bucket is the Count)
+func (h *Histogram) Buckets() map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(h.Bounds))
+	var c uint64
+	for i, b := range h.Bounds {
+		c += h.Counts[i]
+		buckets[b] = c
+	}
+	return buckets
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateBuckets(t *testing.T) {
+	tooMany := make([]float64, MaxHistogramBuckets+1)
+	for i := range tooMany {
+		tooMany[i] = float64(i + 1)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		bounds []float64
+		ok     bool
+	}{
+		{"none", nil, true},
+		{"increasing", []float64{0.005, 0.01, 0.05, 0.1}, true},
+		{"single", []float64{1}, true},
+		{"zero", []float64{0, 0.1}, false},
+		{"negative", []float64{-0.1, 0.1}, false},
+		{"decreasing", []float64{0.1, 0.05}, false},
+		{"duplicated", []float64{0.1, 0.1}, false},
+		{"too many", tooMany, false},
+		{"max", tooMany[:MaxHistogramBuckets], true},
+	} {
+		err := ValidateBuckets(tc.bounds)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: ValidateBuckets(%v) = %v, want ok %t", tc.name, tc.bounds, err, tc.ok)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	var h *Histogram
+	h = h.Next([]float64{0.01, 0.05, 0.1})
+	for _, d := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 30 * time.Millisecond, 200 * time.Millisecond} {
+		h.Observe(d)
+	}
+
+	want := map[float64]uint64{0.01: 2, 0.05: 3, 0.1: 3}
+	got := h.Buckets()
+	if len(got) != len(want) {
+		t.Fatalf("Buckets() = %v, want %v", got, want)
+	}
+	for b, c := range want {
+		if got[b] != c {
+			t.Errorf("Buckets()[%v] = %d, want %d (cumulative)", b, got[b], c)
+		}
+	}
+	if h.Count != 4 {
+		t.Errorf("Count = %d, want 4 (the +Inf bucket)", h.Count)
+	}
+	if sum := 0.245; h.Sum < sum-1e-9 || h.Sum > sum+1e-9 {
+		t.Errorf("Sum = %v, want %v", h.Sum, sum)
+	}
+}
+
+func TestHistogramNext(t *testing.T) {
+	bounds := []float64{0.01, 0.1}
+	var h *Histogram
+	if h.Next(nil) != nil {
+		t.Fatalf("Next without buckets must be nil")
+	}
+	h = h.Next(bounds)
+	h.Observe(5 * time.Millisecond)
+
+	// Same buckets: the counts carry on, the previous cycle is unchanged
+	n := h.Next([]float64{0.01, 0.1})
+	n.Observe(50 * time.Millisecond)
+	if n.Count != 2 || n.Buckets()[0.1] != 2 {
+		t.Errorf("next cycle = %+v, want the counts carried on", n)
+	}
+	if h.Count != 1 || h.Counts[1] != 0 {
+		t.Errorf("previous cycle modified by the next one: %+v", h)
+	}
+
+	// Changed buckets (reload): a fresh histogram with the new bounds
+	r := n.Next([]float64{0.005, 0.02, 0.2})
+	if r.Count != 0 || r.Sum != 0 {
+		t.Errorf("histogram with new buckets = %+v, want a fresh one", r)
+	}
+	if got := r.Buckets(); len(got) != 3 || got[0.2] != 0 {
+		t.Errorf("Buckets() with new buckets = %v, want the 3 new bounds", got)
+	}
+	if _, found := r.Buckets()[0.1]; found {
+		t.Errorf("old bound kept after the buckets changed: %v", r.Buckets())
+	}
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostsCache Parsed hosts file, reloaded when the file modification time changes
+type hostsCache struct {
+	mtx     sync.Mutex
+	path    string
+	modTime time.Time
+	entries map[string][]string
+}
+
+var hosts hostsCache
+
+// HostsLookup returns the addresses of host listed in the hosts file at path (nil when not found)
+func HostsLookup(path string, host string) []string {
+	if path == "" || host == "" {
+		return nil
+	}
+
+	hosts.mtx.Lock()
+	defer hosts.mtx.Unlock()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if hosts.entries == nil || hosts.path != path || !fi.ModTime().Equal(hosts.modTime) {
+		entries, err := parseHosts(path)
+		if err != nil {
+			return nil
+		}
+		hosts.path = path
+		hosts.modTime = fi.ModTime()
+		hosts.entries = entries
+	}
+
+	return hosts.entries[strings.ToLower(strings.TrimSuffix(host, "."))]
+}
+
+// parseHosts reads a hosts(5) formatted file
+func parseHosts(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// Drop the IPv6 zone, not usable by the probes
+		addr := fields[0]
+		if i := strings.IndexByte(addr, '%'); i >= 0 {
+			addr = addr[:i]
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			entries[name] = AppendIfMissing(entries[name], ip.String())
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDestAddrsHostsFileWithCustomNameserver(t *testing.T) {
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	content := "# static entries\n192.0.2.10 backend.example backend\n2001:db8::10 backend.example\n"
+	if err := os.WriteFile(hostsFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Custom nameserver that is never reachable: the lookups only succeed from the hosts file
+	var dials int32
+	resolver := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		d := net.Dialer{Timeout: 100 * time.Millisecond}
+		return d.DialContext(ctx, "udp", "127.0.0.1:1")
+	}}
+
+	for _, tc := range []struct {
+		host string
+		mode string
+		want []string
+	}{
+		{"backend.example", ResolveIP, []string{"192.0.2.10", "2001:db8::10"}},
+		{"BACKEND.example.", ResolveIP, []string{"192.0.2.10", "2001:db8::10"}},
+		{"backend", ResolveIP, []string{"192.0.2.10"}},
+		{"backend.example", ResolveIPv4, []string{"192.0.2.10"}},
+		{"backend.example", ResolveIPv6, []string{"2001:db8::10"}},
+	} {
+		addrs, err := DestAddrsMode(context.Background(), tc.host, tc.mode, resolver, hostsFile, nil, time.Second)
+		if err != nil {
+			t.Errorf("%s (%s): %v", tc.host, tc.mode, err)
+			continue
+		}
+		if len(addrs) != len(tc.want) {
+			t.Errorf("%s (%s) = %v, want %v", tc.host, tc.mode, addrs, tc.want)
+			continue
+		}
+		for i := range addrs {
+			if addrs[i] != tc.want[i] {
+				t.Errorf("%s (%s) = %v, want %v", tc.host, tc.mode, addrs, tc.want)
+				break
+			}
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 0 {
+		t.Errorf("the nameserver was queried %d times for hosts file entries", n)
+	}
+
+	// The other names still go to the (unreachable) nameserver
+	if addrs, err := DestAddrsMode(context.Background(), "missing.example", ResolveIP, resolver, hostsFile, nil, time.Second); err == nil {
+		t.Errorf("missing.example resolved to %v through an unreachable nameserver", addrs)
+	}
+	if atomic.LoadInt32(&dials) == 0 {
+		t.Errorf("the nameserver was not queried for a name missing from the hosts file")
+	}
+}
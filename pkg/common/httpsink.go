@@ -0,0 +1,138 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// httpSinkQueue Events buffered while a batch is being posted, the newer events are dropped when it's full
+const httpSinkQueue = 10000
+
+// HTTPSink Events posted as JSON lines (application/x-ndjson) to an HTTP endpoint in batches
+// The batches are sent when they reach their size or every interval, a failed post drops its batch (not retried)
+type HTTPSink struct {
+	logger   log.Logger
+	url      string
+	batch    int
+	interval time.Duration
+	client   *http.Client
+	queue    chan []byte
+	done     chan struct{}
+	once     sync.Once
+
+	sent    uint64
+	dropped uint64
+	failed  uint64
+}
+
+// NewHTTPSink starts the sink posting to url, each post must complete within timeout
+func NewHTTPSink(logger log.Logger, url string, batch int, interval time.Duration, timeout time.Duration) *HTTPSink {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if batch <= 0 {
+		batch = 1
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	s := &HTTPSink{logger: logger, url: url, batch: batch, interval: interval, client: &http.Client{Timeout: timeout}, queue: make(chan []byte, httpSinkQueue), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+// Write queues the event, the probes are never blocked by the sink
+func (s *HTTPSink) Write(event interface{}) {
+	if s == nil {
+		return
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		level.Error(s.logger).Log("type", "HTTPSink", "func", "Write", "msg", fmt.Sprintf("%s", err))
+		return
+	}
+	select {
+	case s.queue <- b:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Close posts the queued events and stops the sink
+func (s *HTTPSink) Close() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.queue)
+		<-s.done
+	})
+}
+
+// Stats returns the number of events posted, dropped (queue full or failed post) and the failed posts
+func (s *HTTPSink) Stats() (sent uint64, dropped uint64, failed uint64) {
+	if s == nil {
+		return 0, 0, 0
+	}
+	return atomic.LoadUint64(&s.sent), atomic.LoadUint64(&s.dropped), atomic.LoadUint64(&s.failed)
+}
+
+func (s *HTTPSink) run() {
+	defer close(s.done)
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+
+	var buf bytes.Buffer
+	n := 0
+	flush := func() {
+		if n == 0 {
+			return
+		}
+		if err := s.post(buf.Bytes()); err != nil {
+			level.Error(s.logger).Log("type", "HTTPSink", "func", "post", "msg", fmt.Sprintf("Posting %d events to %s", n, s.url), "err", err)
+			atomic.AddUint64(&s.failed, 1)
+			atomic.AddUint64(&s.dropped, uint64(n))
+		} else {
+			atomic.AddUint64(&s.sent, uint64(n))
+		}
+		buf.Reset()
+		n = 0
+	}
+
+	for {
+		select {
+		case b, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+			if n++; n >= s.batch {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+func (s *HTTPSink) post(body []byte) error {
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// lagSmoothing Weight of the last observation in the averaged schedule lag
+const lagSmoothing = 0.2
+
+// LoadShedder skips a fraction of the low priority probe cycles while the schedule lag exceeds the threshold, shared by all the probes
+// The lag is how late a cycle starts (timer lateness and concurrency slot wait), shedding stops once it falls below half of the threshold
+type LoadShedder struct {
+	mtx       sync.Mutex
+	threshold time.Duration
+	fraction  float64
+	priority  int
+	lag       float64
+	shedding  bool
+	shed      uint64
+}
+
+// NewLoadShedder creates a load shedder, a threshold <= 0 disables it (the lag is still averaged)
+// While shedding the cycles of the targets with a priority <= priority are skipped with the given probability
+func NewLoadShedder(threshold time.Duration, fraction float64, priority int) *LoadShedder {
+	return &LoadShedder{threshold: threshold, fraction: fraction, priority: priority}
+}
+
+// Set changes the shedding settings
+func (l *LoadShedder) Set(threshold time.Duration, fraction float64, priority int) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.threshold = threshold
+	l.fraction = fraction
+	l.priority = priority
+	if threshold <= 0 {
+		l.shedding = false
+	}
+}
+
+// Observe accounts the lag of a started probe cycle
+func (l *LoadShedder) Observe(lag time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.lag = lagSmoothing*float64(lag) + (1-lagSmoothing)*l.lag
+	if l.threshold <= 0 {
+		return
+	}
+	if !l.shedding && l.lag > float64(l.threshold) {
+		l.shedding = true
+	} else if l.shedding && l.lag < float64(l.threshold)/2 {
+		l.shedding = false
+	}
+}
+
+// Shed reports if the cycle of a target with the given priority must be skipped
+func (l *LoadShedder) Shed(priority int) bool {
+	if l == nil {
+		return false
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if !l.shedding || priority > l.priority || rand.Float64() >= l.fraction {
+		return false
+	}
+	l.shed++
+	return true
+}
+
+// Shedding reports if the cycles are currently shed
+func (l *LoadShedder) Shedding() bool {
+	if l == nil {
+		return false
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.shedding
+}
+
+// Lag returns the averaged schedule lag
+func (l *LoadShedder) Lag() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return time.Duration(l.lag)
+}
+
+// ShedTotal returns the number of skipped cycles
+func (l *LoadShedder) ShedTotal() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.shed
+}
@@ -0,0 +1,41 @@
+package common
+
+import "sort"
+
+// Pools Named probe concurrency pools partitioning the probe slots (on top of the global limit)
+type Pools struct {
+	pools map[string]*Semaphore
+}
+
+// NewPools creates the pools with their number of slots
+func NewPools(sizes map[string]int) *Pools {
+	p := &Pools{pools: map[string]*Semaphore{}}
+	for name, size := range sizes {
+		p.pools[name] = NewSemaphore(size)
+	}
+	return p
+}
+
+// Get returns the pool of a target, by default the pool named after the probe type (nil without such pool)
+func (p *Pools) Get(name string, probeType string) *Semaphore {
+	if p == nil {
+		return nil
+	}
+	if name == "" {
+		name = probeType
+	}
+	return p.pools[name]
+}
+
+// Names returns the sorted pool names
+func (p *Pools) Names() []string {
+	if p == nil {
+		return nil
+	}
+	names := make([]string, 0, len(p.pools))
+	for name := range p.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"sync"
+
+	"golang.org/x/net/icmp"
+)
+
+// privileges ICMP socket mode (set at startup), raw sockets (CAP_NET_RAW) or the unprivileged ICMP datagram sockets
+var privileges = struct {
+	sync.RWMutex
+	raw bool
+}{raw: true}
+
+// DetectPrivileges checks if the raw ICMP sockets and the unprivileged ICMP datagram sockets (net.ipv4.ping_group_range on Linux) can be opened
+func DetectPrivileges() (raw bool, datagram bool) {
+	raw = icmpUsable("ip4:icmp", "0.0.0.0") || icmpUsable("ip6:ipv6-icmp", "::")
+	datagram = icmpUsable("udp4", "0.0.0.0") || icmpUsable("udp6", "::")
+	return raw, datagram
+}
+
+func icmpUsable(network string, address string) bool {
+	c, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// SetPrivileged selects the raw ICMP sockets (true) or the unprivileged ICMP datagram sockets
+func SetPrivileged(raw bool) {
+	privileges.Lock()
+	defer privileges.Unlock()
+	privileges.raw = raw
+}
+
+// Privileged reports if the ICMP probes use raw sockets
+func Privileged() bool {
+	privileges.RLock()
+	defer privileges.RUnlock()
+	return privileges.raw
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// QualityWeights Weights of the loss, latency and jitter components of the path quality score
+type QualityWeights struct {
+	Loss    float64
+	Latency float64
+	Jitter  float64
+}
+
+// QualityScore 0-100 score of a path, the weighted average of the components (each 0-1):
+// loss 1-loss, latency 1 up to the slo decreasing linearly to 0 at twice the slo (skipped without slo), jitter 1-jitter/jitterMax (0 above)
+// A negative jitter (unknown, less than 2 replies) skips the jitter component, a path without any reply scores 0
+func QualityScore(loss float64, rtt time.Duration, slo time.Duration, jitter time.Duration, jitterMax time.Duration, w QualityWeights) float64 {
+	if loss >= 1 {
+		return 0
+	}
+
+	sum := w.Loss * (1 - loss)
+	total := w.Loss
+	if slo > 0 {
+		sum += w.Latency * math.Max(0, math.Min(1, 2-float64(rtt)/float64(slo)))
+		total += w.Latency
+	}
+	if jitterMax > 0 && jitter >= 0 {
+		sum += w.Jitter * math.Max(0, 1-float64(jitter)/float64(jitterMax))
+		total += w.Jitter
+	}
+	if total <= 0 {
+		return 0
+	}
+	return 100 * sum / total
+}
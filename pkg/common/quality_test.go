@@ -0,0 +1,42 @@
+package common
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestQualityScore(t *testing.T) {
+	w := QualityWeights{Loss: 0.5, Latency: 0.3, Jitter: 0.2}
+	ms := time.Millisecond
+
+	for _, tc := range []struct {
+		name      string
+		loss      float64
+		rtt       time.Duration
+		slo       time.Duration
+		jitter    time.Duration
+		jitterMax time.Duration
+		w         QualityWeights
+		want      float64
+	}{
+		{"zero loss within slo and no jitter", 0, 10 * ms, 20 * ms, 0, 50 * ms, w, 100},
+		{"zero loss without slo", 0, 10 * ms, 0, 0, 50 * ms, w, 100},
+		{"half loss", 0.5, 10 * ms, 20 * ms, 0, 50 * ms, w, 75},
+		{"full loss", 1, 0, 20 * ms, 0, 50 * ms, w, 0},
+		{"full loss without reply data", 1, 0, 0, -1, 50 * ms, w, 0},
+		{"rtt at the slo", 0, 20 * ms, 20 * ms, 0, 50 * ms, w, 100},
+		{"rtt 1.5x above the slo", 0, 30 * ms, 20 * ms, 0, 50 * ms, w, 85},
+		{"rtt twice the slo and above", 0, 60 * ms, 20 * ms, 0, 50 * ms, w, 70},
+		{"jitter at half the max", 0, 10 * ms, 20 * ms, 25 * ms, 50 * ms, w, 90},
+		{"jitter above the max", 0, 10 * ms, 20 * ms, 80 * ms, 50 * ms, w, 80},
+		{"missing jitter is skipped", 0, 30 * ms, 20 * ms, -1, 50 * ms, w, 100 * (0.5 + 0.3*0.5) / 0.8},
+		{"missing jitter and slo", 0.2, 10 * ms, 0, -1, 50 * ms, w, 80},
+		{"no weights", 0, 10 * ms, 20 * ms, 0, 50 * ms, QualityWeights{}, 0},
+	} {
+		got := QualityScore(tc.loss, tc.rtt, tc.slo, tc.jitter, tc.jitterMax, tc.w)
+		if math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: QualityScore = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
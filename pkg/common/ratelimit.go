@@ -0,0 +1,94 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// rateWindow Seconds averaged by the reported send rate
+const rateWindow = 10
+
+// RateLimiter Token bucket (of a single token) pacing the outgoing probe packets, shared by all the ICMP/MTR probes
+// The packets are spaced evenly instead of bursting at the interval boundaries
+type RateLimiter struct {
+	mtx     sync.Mutex
+	pps     int
+	next    time.Time
+	sent    uint64
+	seconds [rateWindow]int64
+	counts  [rateWindow]uint64
+}
+
+// NewRateLimiter creates a limiter of pps packets per second, a pps <= 0 disables the limit (the packets are still counted)
+func NewRateLimiter(pps int) *RateLimiter {
+	return &RateLimiter{pps: pps}
+}
+
+// SetRate changes the packets per second limit
+func (r *RateLimiter) SetRate(pps int) {
+	if r == nil {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.pps = pps
+}
+
+// Wait blocks until the next packet can be sent
+func (r *RateLimiter) Wait() {
+	if r == nil {
+		return
+	}
+
+	r.mtx.Lock()
+	if r.pps > 0 {
+		now := time.Now()
+		if r.next.Before(now) {
+			r.next = now
+		}
+		wait := r.next.Sub(now)
+		r.next = r.next.Add(time.Second / time.Duration(r.pps))
+		r.mtx.Unlock()
+
+		time.Sleep(wait)
+		r.mtx.Lock()
+	}
+	defer r.mtx.Unlock()
+
+	sec := time.Now().Unix()
+	i := sec % rateWindow
+	if r.seconds[i] != sec {
+		r.seconds[i] = sec
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+	r.sent++
+}
+
+// Rate returns the average packets per second sent over the last complete seconds
+func (r *RateLimiter) Rate() float64 {
+	if r == nil {
+		return 0
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := time.Now().Unix()
+	var sum uint64
+	for i := range r.seconds {
+		if r.seconds[i] < now && r.seconds[i] >= now-rateWindow {
+			sum += r.counts[i]
+		}
+	}
+	return float64(sum) / rateWindow
+}
+
+// Sent returns the number of packets sent
+func (r *RateLimiter) Sent() uint64 {
+	if r == nil {
+		return 0
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.sent
+}
@@ -0,0 +1,292 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxScheduleDuration Upper bound of a cron window
+const MaxScheduleDuration = 7 * 24 * time.Hour
+
+// weekdays Names of the days of the week (cron and weekday windows)
+var weekdays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// months Names of the months (cron)
+var months = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+
+// ScheduleEntry Window of a schedule, either a cron expression (start of the window) with a duration
+// or a daily time range (start to end, over midnight when the end is before the start) on some days of the week
+type ScheduleEntry struct {
+	Cron     string   `yaml:"cron,omitempty" json:"cron,omitempty"`
+	Duration string   `yaml:"duration,omitempty" json:"duration,omitempty"`
+	Days     []string `yaml:"days,omitempty" json:"days,omitempty"`
+	Start    string   `yaml:"start,omitempty" json:"start,omitempty"`
+	End      string   `yaml:"end,omitempty" json:"end,omitempty"`
+	Timezone string   `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+// Schedule Windows during which a target is not probed (maintenance)
+type Schedule struct {
+	windows []scheduleWindow
+}
+
+type scheduleWindow struct {
+	loc *time.Location
+
+	// cron
+	minutes  [60]bool
+	hours    [24]bool
+	dom      [32]bool
+	month    [13]bool
+	dow      [7]bool
+	anyDom   bool
+	anyDow   bool
+	duration time.Duration
+
+	// weekday range
+	days  [7]bool
+	start time.Duration
+	end   time.Duration
+	cron  bool
+}
+
+// NewSchedule parses the entries of a schedule, nil without entries
+func NewSchedule(entries []ScheduleEntry) (*Schedule, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	s := &Schedule{}
+	for i, e := range entries {
+		w, err := parseScheduleEntry(e)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s", i+1, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+func parseScheduleEntry(e ScheduleEntry) (scheduleWindow, error) {
+	w := scheduleWindow{loc: time.Local}
+	if e.Timezone != "" {
+		loc, err := time.LoadLocation(e.Timezone)
+		if err != nil {
+			return w, fmt.Errorf("timezone: %s", err)
+		}
+		w.loc = loc
+	}
+
+	if e.Cron != "" {
+		if len(e.Days) > 0 || e.Start != "" || e.End != "" {
+			return w, fmt.Errorf("cron is exclusive with days/start/end")
+		}
+		d, err := time.ParseDuration(e.Duration)
+		if err != nil {
+			return w, fmt.Errorf("cron requires a duration: %s", err)
+		}
+		if d <= 0 || d > MaxScheduleDuration {
+			return w, fmt.Errorf("duration %s must be >0 and at most %s", d, MaxScheduleDuration)
+		}
+		w.cron, w.duration = true, d
+		return w, w.parseCron(e.Cron)
+	}
+
+	if e.Duration != "" {
+		return w, fmt.Errorf("duration requires a cron (use start and end)")
+	}
+	var err error
+	if w.start, err = parseClock(e.Start); err != nil {
+		return w, fmt.Errorf("start: %s", err)
+	}
+	if w.end, err = parseClock(e.End); err != nil {
+		return w, fmt.Errorf("end: %s", err)
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("start and end are the same")
+	}
+	if len(e.Days) == 0 {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	for _, d := range e.Days {
+		day := strings.ToLower(d)
+		n, found := weekdays[day]
+		if !found {
+			// Full names (sunday)
+			for i := time.Sunday; i <= time.Saturday; i++ {
+				if day == strings.ToLower(i.String()) {
+					n, found = int(i), true
+				}
+			}
+		}
+		if !found {
+			return w, fmt.Errorf("unknown day %q", d)
+		}
+		w.days[n] = true
+	}
+	return w, nil
+}
+
+// parseClock parses a HH:MM time of the day
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a HH:MM time", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseCron parses the minute, hour, day of month, month and day of week fields (lists, ranges, steps and names)
+func (w *scheduleWindow) parseCron(expr string) error {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return fmt.Errorf("cron %q must have 5 fields (minute hour day-of-month month day-of-week)", expr)
+	}
+	dow := make([]bool, 8)
+	for _, f := range []struct {
+		field string
+		set   []bool
+		lo    int
+		hi    int
+		names map[string]int
+	}{
+		{fields[0], w.minutes[:], 0, 59, nil},
+		{fields[1], w.hours[:], 0, 23, nil},
+		{fields[2], w.dom[:], 1, 31, nil},
+		{fields[3], w.month[:], 1, 12, months},
+		{fields[4], dow, 0, 7, weekdays},
+	} {
+		if err := cronField(f.field, f.set, f.lo, f.hi, f.names); err != nil {
+			return fmt.Errorf("cron %q: %s", expr, err)
+		}
+	}
+	// 7 is also sunday
+	for i := range w.dow {
+		w.dow[i] = dow[i]
+	}
+	w.dow[0] = w.dow[0] || dow[7]
+	w.anyDom = strings.HasPrefix(fields[2], "*")
+	w.anyDow = strings.HasPrefix(fields[4], "*")
+	return nil
+}
+
+// cronField sets the values of a cron field (*, a, a-b, */n, a-b/n, comma separated)
+func cronField(field string, set []bool, lo int, hi int, names map[string]int) error {
+	value := func(s string) (int, error) {
+		if n, found := names[strings.ToLower(s)]; found {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", s, lo, hi)
+		}
+		return n, nil
+	}
+
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return fmt.Errorf("step %q must be >0", stepStr)
+			}
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = value(a); err != nil {
+				return err
+			}
+			to = from
+			if isRange {
+				if to, err = value(b); err != nil {
+					return err
+				}
+			} else if hasStep {
+				to = hi
+			}
+			if from > to {
+				return fmt.Errorf("range %q start is above its end", rng)
+			}
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+// Active reports if t is within a window of the schedule (false for a nil schedule)
+func (s *Schedule) Active(t time.Time) bool {
+	if s == nil {
+		return false
+	}
+	for i := range s.windows {
+		if s.windows[i].active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *scheduleWindow) active(t time.Time) bool {
+	lt := t.In(w.loc)
+	if !w.cron {
+		// The window of the previous day may extend past midnight
+		for _, back := range []int{0, 1} {
+			day := time.Date(lt.Year(), lt.Month(), lt.Day()-back, 0, 0, 0, 0, w.loc)
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			start, end := day.Add(w.start), day.Add(w.end)
+			if w.end < w.start {
+				end = end.AddDate(0, 0, 1)
+			}
+			if !lt.Before(start) && lt.Before(end) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Latest matching start within the duration, the days and hours not matching are skipped at once
+	limit := lt.Add(-w.duration)
+	c := time.Date(lt.Year(), lt.Month(), lt.Day(), lt.Hour(), lt.Minute(), 0, 0, w.loc)
+	for c.After(limit) {
+		if !w.dayMatch(c) {
+			c = time.Date(c.Year(), c.Month(), c.Day(), 0, 0, 0, 0, w.loc).Add(-time.Minute)
+			continue
+		}
+		if !w.hours[c.Hour()] {
+			c = time.Date(c.Year(), c.Month(), c.Day(), c.Hour(), 0, 0, 0, w.loc).Add(-time.Minute)
+			continue
+		}
+		if w.minutes[c.Minute()] {
+			return true
+		}
+		c = c.Add(-time.Minute)
+	}
+	return false
+}
+
+// dayMatch cron semantics: with both the day of month and the day of week restricted either one matches
+func (w *scheduleWindow) dayMatch(t time.Time) bool {
+	if !w.month[t.Month()] {
+		return false
+	}
+	dom, dow := w.dom[t.Day()], w.dow[t.Weekday()]
+	switch {
+	case w.anyDom && w.anyDow:
+		return true
+	case w.anyDom:
+		return dow
+	case w.anyDow:
+		return dom
+	}
+	return dom || dow
+}
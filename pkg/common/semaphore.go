@@ -0,0 +1,146 @@
+package common
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// Semaphore Priority aware probe concurrency limiter shared by all the goroutines
+// When a slot frees up the waiter with the highest priority is woken first (FIFO within the same priority)
+type Semaphore struct {
+	mtx     sync.Mutex
+	size    int
+	active  int
+	seq     uint64
+	waiters waitQueue
+}
+
+type waiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	index    int
+}
+
+// NewSemaphore creates a limiter with size slots, a size <= 0 disables the limit
+func NewSemaphore(size int) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+// Acquire blocks until a slot is available or cancel is closed, returns false if it was cancelled
+func (s *Semaphore) Acquire(priority int, cancel <-chan struct{}) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mtx.Lock()
+	if s.size <= 0 || (s.active < s.size && len(s.waiters) == 0) {
+		s.active++
+		s.mtx.Unlock()
+		return true
+	}
+
+	s.seq++
+	w := &waiter{priority: priority, seq: s.seq, ready: make(chan struct{})}
+	heap.Push(&s.waiters, w)
+	s.mtx.Unlock()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-cancel:
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		select {
+		case <-w.ready:
+			// Slot was handed over while cancelling, give it back
+			s.release()
+		default:
+			heap.Remove(&s.waiters, w.index)
+		}
+		return false
+	}
+}
+
+// Release frees a slot previously obtained with Acquire
+func (s *Semaphore) Release() {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.release()
+}
+
+func (s *Semaphore) release() {
+	if s.size <= 0 {
+		if s.active > 0 {
+			s.active--
+		}
+		return
+	}
+
+	if len(s.waiters) > 0 && s.active <= s.size {
+		// Hand the slot over directly to the next waiter
+		w := heap.Pop(&s.waiters).(*waiter)
+		close(w.ready)
+		return
+	}
+	if s.active > 0 {
+		s.active--
+	}
+}
+
+// Active returns the number of slots in use
+func (s *Semaphore) Active() int {
+	if s == nil {
+		return 0
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.active
+}
+
+// Queued returns the number of waiting goroutines
+func (s *Semaphore) Queued() int {
+	if s == nil {
+		return 0
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.waiters)
+}
+
+// waitQueue heap ordered by priority (desc) and arrival (asc)
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority == q[j].priority {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].priority > q[j].priority
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
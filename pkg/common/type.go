@@ -1,6 +1,7 @@
 package common
 
 import (
+	"math/rand"
 	"sync/atomic"
 	"time"
 )
@@ -10,6 +11,11 @@ type IcmpID struct {
 	icmpID int32
 }
 
+// NewIcmpID returns a counter starting at a random ID, the echoes of a restarted instance don't collide with the late replies to the previous one
+func NewIcmpID() *IcmpID {
+	return &IcmpID{icmpID: int32(rand.Intn(65498) + 1)}
+}
+
 // Get ICMP Echo Unique ID
 func (c *IcmpID) Get() int32 {
 	for {
@@ -34,6 +40,30 @@ type IcmpReturn struct {
 	Success bool
 	Addr    string
 	Elapsed time.Duration
+
+	ReplyDSCP      int
+	ReplyDSCPValid bool
+	ReplyTTL       int
+	ReplyTTLValid  bool
+
+	// Echo replies discarded because of their source address
+	UnexpectedSources int
+}
+
+// IcmpTimestampReturn ICMP Timestamp exchange details
+type IcmpTimestampReturn struct {
+	Success bool
+	Elapsed time.Duration
+	Offset  time.Duration
+}
+
+// IcmpBurstReturn Echoes of a burst sent without waiting for the replies (in the send order)
+type IcmpBurstReturn struct {
+	Echoes []IcmpReturn
+
+	// Replies received after the reply of a later echo (RFC 4737)
+	OutOfOrder        int
+	UnexpectedSources int
 }
 
 // IcmpSummary ICMP HOP Summary
@@ -50,6 +80,7 @@ type IcmpHop struct {
 	Success              bool          `json:"success"`
 	AddressFrom          string        `json:"address_from"`
 	AddressTo            string        `json:"address_to"`
+	AddressName          string        `json:"address_name,omitempty"`
 	N                    int           `json:"n"`
 	TTL                  int           `json:"ttl"`
 	Snt                  int           `json:"snt"`
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxWindows Upper bound of the aggregation windows of a target
+const MaxWindows = 8
+
+// MaxWindow Upper bound of an aggregation window (the cycles of the window are kept in memory)
+const MaxWindow = 24 * time.Hour
+
+// WindowStats Loss and latency of a target aggregated over a window
+type WindowStats struct {
+	Window   time.Duration `json:"window"`
+	Cycles   int           `json:"cycles"`
+	Sent     int           `json:"sent"`
+	DropRate float64       `json:"drop_rate"`
+	RttEWMA  time.Duration `json:"rtt_ewma"`
+	RttValid bool          `json:"rtt_valid"`
+}
+
+// Windows Sliding windows of the cycles of a target, the drop rate is the one of the packets sent within each window
+// The latency is an exponentially weighted moving average whose time constant is the window (the cycles without reply are skipped)
+type Windows struct {
+	sizes   []time.Duration
+	cycles  []windowCycle
+	ewma    []float64
+	ewmaOk  bool
+	ewmaAt  time.Time
+	longest time.Duration
+}
+
+type windowCycle struct {
+	at   time.Time
+	sent int
+	lost int
+}
+
+// ValidateWindows checks the aggregation windows, they must be >0, unique and at most MaxWindow
+func ValidateWindows(sizes []time.Duration) error {
+	if len(sizes) > MaxWindows {
+		return fmt.Errorf("at most %d windows are supported", MaxWindows)
+	}
+	seen := map[time.Duration]bool{}
+	for _, s := range sizes {
+		if s <= 0 || s > MaxWindow {
+			return fmt.Errorf("window %s must be >0 and at most %s", s, MaxWindow)
+		}
+		if seen[s] {
+			return fmt.Errorf("window %s is defined twice", s)
+		}
+		seen[s] = true
+	}
+	return nil
+}
+
+// NewWindows returns the windows of a target, nil without sizes
+func NewWindows(sizes []time.Duration) *Windows {
+	if len(sizes) == 0 {
+		return nil
+	}
+	w := &Windows{sizes: sizes, ewma: make([]float64, len(sizes))}
+	for _, s := range sizes {
+		w.longest = max(w.longest, s)
+	}
+	return w
+}
+
+// Add accounts the packets of a cycle and returns the stats of each window (nil without windows)
+// rtt is the mean RTT of the replies of the cycle, ignored without reply
+func (w *Windows) Add(at time.Time, sent int, lost int, rtt time.Duration) []WindowStats {
+	if w == nil {
+		return nil
+	}
+
+	w.cycles = append(w.cycles, windowCycle{at: at, sent: sent, lost: lost})
+	drop := 0
+	for drop < len(w.cycles) && at.Sub(w.cycles[drop].at) >= w.longest {
+		drop++
+	}
+	w.cycles = w.cycles[drop:]
+
+	if sent > lost {
+		for i, s := range w.sizes {
+			if !w.ewmaOk {
+				w.ewma[i] = float64(rtt)
+				continue
+			}
+			alpha := 1 - math.Exp(-float64(at.Sub(w.ewmaAt))/float64(s))
+			w.ewma[i] += alpha * (float64(rtt) - w.ewma[i])
+		}
+		w.ewmaOk = true
+		w.ewmaAt = at
+	}
+
+	stats := make([]WindowStats, len(w.sizes))
+	for i, s := range w.sizes {
+		st := WindowStats{Window: s, RttValid: w.ewmaOk, RttEWMA: time.Duration(w.ewma[i])}
+		lost := 0
+		for _, c := range w.cycles {
+			if at.Sub(c.at) < s {
+				st.Cycles++
+				st.Sent += c.sent
+				lost += c.lost
+			}
+		}
+		if st.Sent > 0 {
+			st.DropRate = float64(lost) / float64(st.Sent)
+		}
+		stats[i] = st
+	}
+	return stats
+}
@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultServer Nameserver used when /etc/resolv.conf has none
+const DefaultServer = "127.0.0.1:53"
+
+var qTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"SRV":   dnsmessage.TypeSRV,
+}
+
+// ValidType reports if the record type can be queried (A, AAAA, CNAME, SRV), empty is A
+func ValidType(qtype string) bool {
+	_, found := qTypes[strings.ToUpper(qtype)]
+	return qtype == "" || found
+}
+
+// ServerAddr adds the default port (53) to the nameserver, an empty server is the first nameserver of /etc/resolv.conf
+func ServerAddr(server string) string {
+	if server == "" {
+		server = systemServer()
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	return server
+}
+
+func systemServer() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return DefaultServer
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1]
+		}
+	}
+	return DefaultServer
+}
+
+// Query sends a recursive query for the name to the nameserver (UDP, retried over TCP when the reply is truncated)
+func Query(name string, qtype string, server string, srcAddr string, timeout time.Duration) (*DNSReturn, error) {
+	if qtype == "" {
+		qtype = "A"
+	}
+	qtype = strings.ToUpper(qtype)
+	server = ServerAddr(server)
+	out := &DNSReturn{DestAddr: name, Server: server, QType: qtype, SrcIp: "0.0.0.0", Rcode: -1}
+
+	t, found := qTypes[qtype]
+	if !found {
+		return out, fmt.Errorf("unsupported record type %s", qtype)
+	}
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return out, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	req := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: t, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := req.Pack()
+	if err != nil {
+		return out, err
+	}
+
+	d := net.Dialer{Timeout: timeout}
+	if srcAddr != "" {
+		srcIp := net.ParseIP(srcAddr)
+		if srcIp == nil {
+			return out, fmt.Errorf("source ip: %v is invalid, DNS target: %v", srcAddr, name)
+		}
+		d.LocalAddr = &net.UDPAddr{IP: srcIp}
+		out.SrcIp = srcAddr
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	resp, err := exchange(d, "udp", server, packed, id, deadline)
+	if err == nil && resp.Truncated {
+		out.Truncated = true
+		if d.LocalAddr != nil {
+			d.LocalAddr = &net.TCPAddr{IP: d.LocalAddr.(*net.UDPAddr).IP}
+		}
+		resp, err = exchange(d, "tcp", server, packed, id, deadline)
+	}
+	out.Rtt = time.Since(start)
+	if err != nil {
+		return out, err
+	}
+
+	out.Rcode = int(resp.RCode)
+	out.RcodeName = strings.TrimPrefix(resp.RCode.String(), "RCode")
+	for _, a := range resp.Answers {
+		if a.Header.Type == t {
+			out.Answers++
+		}
+	}
+	if resp.RCode != dnsmessage.RCodeSuccess {
+		return out, fmt.Errorf("query %s %s returned %s", qtype, name, out.RcodeName)
+	}
+	if out.Answers == 0 {
+		return out, fmt.Errorf("query %s %s returned no answer", qtype, name)
+	}
+	out.Success = true
+	return out, nil
+}
+
+// exchange sends the query and waits for the reply with the same id
+func exchange(d net.Dialer, network string, server string, packed []byte, id uint16, deadline time.Time) (*dnsmessage.Message, error) {
+	conn, err := d.Dial(network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		packed = append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...)
+	}
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		var n int
+		if network == "tcp" {
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return nil, err
+			}
+			n = int(binary.BigEndian.Uint16(buf[:2]))
+			if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+				return nil, err
+			}
+		} else if n, err = conn.Read(buf); err != nil {
+			return nil, err
+		}
+
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf[:n]); err != nil || resp.ID != id || !resp.Response {
+			continue
+		}
+		return &resp, nil
+	}
+}
+
+// fqdn absolute form of the name (trailing dot)
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,17 @@
+package dns
+
+import "time"
+
+// DNSReturn Calculated results of a query
+type DNSReturn struct {
+	Success   bool          `json:"success"`
+	DestAddr  string        `json:"dest_address"`
+	Server    string        `json:"server"`
+	SrcIp     string        `json:"src_ip"`
+	QType     string        `json:"qtype"`
+	Rcode     int           `json:"rcode"`
+	RcodeName string        `json:"rcode_name"`
+	Answers   int           `json:"answers"`
+	Truncated bool          `json:"truncated"`
+	Rtt       time.Duration `json:"rtt"`
+}
@@ -11,8 +11,8 @@ import (
 	"time"
 )
 
-// HTTPGet Http Get Trace Operation
-func HTTPGet(destURL string, srcAddr string, timeout time.Duration) (*HTTPReturn, error) {
+// HTTPGet Http Get Trace Operation (the method defaults to GET)
+func HTTPGet(destURL string, method string, srcAddr string, timeout time.Duration) (*HTTPReturn, error) {
 	var out HTTPReturn
 	var err error
 	out.DestAddr = destURL
@@ -47,7 +47,10 @@ func HTTPGet(destURL string, srcAddr string, timeout time.Duration) (*HTTPReturn
 		}
 	}
 
-	req, err := http.NewRequest("GET", dURL.String(), nil)
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, dURL.String(), nil)
 	if err != nil {
 		out.Success = false
 		return &out, err
@@ -82,10 +85,16 @@ func HTTPGet(destURL string, srcAddr string, timeout time.Duration) (*HTTPReturn
 	out.TLSHandshake = stats.TLSHandshake
 	if resp.TLS != nil {
 		out.TLSVersion = getTLSVersion(resp.TLS)
+		out.TLSCipher = tls.CipherSuiteName(resp.TLS.CipherSuite)
+		out.TLSServerName = resp.TLS.ServerName
+		if out.TLSServerName == "" {
+			out.TLSServerName = dURL.Hostname()
+		}
 		out.TLSEarliestCertExpiry = getEarliestCertExpiry(resp.TLS)
 		out.TLSLastChainExpiry = getLastChainExpiry(resp.TLS)
 	}
 	out.ServerProcessing = stats.ServerProcessing
+	out.TTFB = stats.TTFB
 	out.ContentTransfer = stats.ContentTransfer
 	out.Total = stats.Total
 
@@ -93,7 +102,7 @@ func HTTPGet(destURL string, srcAddr string, timeout time.Duration) (*HTTPReturn
 }
 
 // HTTPGetProxy Http Get Trace Operation with proxy
-func HTTPGetProxy(destURL string, timeout time.Duration, proxyURL string) (*HTTPReturn, error) {
+func HTTPGetProxy(destURL string, method string, timeout time.Duration, proxyURL string) (*HTTPReturn, error) {
 	var out HTTPReturn
 	var err error
 	out.DestAddr = destURL
@@ -119,7 +128,10 @@ func HTTPGetProxy(destURL string, timeout time.Duration, proxyURL string) (*HTTP
 		Timeout:   timeout,
 	}
 
-	req, err := http.NewRequest("GET", dURL.String(), nil)
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, dURL.String(), nil)
 	if err != nil {
 		out.Success = false
 		return &out, err
@@ -154,10 +166,16 @@ func HTTPGetProxy(destURL string, timeout time.Duration, proxyURL string) (*HTTP
 	out.TLSHandshake = stats.TLSHandshake
 	if resp.TLS != nil {
 		out.TLSVersion = getTLSVersion(resp.TLS)
+		out.TLSCipher = tls.CipherSuiteName(resp.TLS.CipherSuite)
+		out.TLSServerName = resp.TLS.ServerName
+		if out.TLSServerName == "" {
+			out.TLSServerName = dURL.Hostname()
+		}
 		out.TLSEarliestCertExpiry = getEarliestCertExpiry(resp.TLS)
 		out.TLSLastChainExpiry = getLastChainExpiry(resp.TLS)
 	}
 	out.ServerProcessing = stats.ServerProcessing
+	out.TTFB = stats.TTFB
 	out.ContentTransfer = stats.ContentTransfer
 	out.Total = stats.Total
 
@@ -257,6 +275,9 @@ func (ht *HTTPTrace) Stats() (stats *HTTPTimelineStats) {
 	if !ht.GotConnect.IsZero() && !ht.GotFirstResponseByte.IsZero() {
 		stats.ServerProcessing = ht.GotFirstResponseByte.Sub(ht.GotConnect)
 	}
+	if !ht.GotFirstResponseByte.IsZero() {
+		stats.TTFB = ht.GotFirstResponseByte.Sub(ht.Start)
+	}
 	if ht.Done.IsZero() {
 		ht.Done = time.Now()
 	}
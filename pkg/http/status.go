@@ -0,0 +1,47 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusSpec Expected HTTP status codes, an empty spec accepts any status
+type StatusSpec []statusRange
+
+type statusRange struct {
+	min int
+	max int
+}
+
+// ParseStatusSpec parses the expected status codes, either single codes (401) or classes (2xx)
+func ParseStatusSpec(spec []string) (StatusSpec, error) {
+	out := StatusSpec{}
+	for _, s := range spec {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+			c := int(s[0]-'0') * 100
+			out = append(out, statusRange{min: c, max: c + 99})
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status code '%s' (100-599 or 1xx-5xx)", s)
+		}
+		out = append(out, statusRange{min: code, max: code})
+	}
+	return out, nil
+}
+
+// Match reports if the status code is expected
+func (s StatusSpec) Match(code int) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, r := range s {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
@@ -15,9 +15,12 @@ type HTTPReturn struct {
 	TCPConnection         time.Duration `json:"tcpConnection,omitempty"`
 	TLSHandshake          time.Duration `json:"tlsHandshake,omitempty"`
 	TLSVersion            string        `json:"tlsVersion,omitempty"`
+	TLSCipher             string        `json:"tlsCipher,omitempty"`
+	TLSServerName         string        `json:"tlsServerName,omitempty"`
 	TLSEarliestCertExpiry time.Time     `json:"tlsEarliestCertExpiry,omitempty"`
 	TLSLastChainExpiry    time.Time     `json:"tlsLastChainExpiry,omitempty"`
 	ServerProcessing      time.Duration `json:"serverProcessing,omitempty"`
+	TTFB                  time.Duration `json:"ttfb,omitempty"`
 	ContentTransfer       time.Duration `json:"contentTransfer,omitempty"`
 	Total                 time.Duration `json:"total,omitempty"`
 }
@@ -28,6 +31,7 @@ type HTTPTimelineStats struct {
 	TCPConnection    time.Duration `json:"tcpConnection,omitempty"`
 	TLSHandshake     time.Duration `json:"tlsHandshake,omitempty"`
 	ServerProcessing time.Duration `json:"serverProcessing,omitempty"`
+	TTFB             time.Duration `json:"ttfb,omitempty"`
 	ContentTransfer  time.Duration `json:"contentTransfer,omitempty"`
 	Total            time.Duration `json:"total,omitempty"`
 }
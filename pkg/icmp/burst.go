@@ -0,0 +1,155 @@
+package icmp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Burst sends count echoes every interval without waiting for the replies, an echo is lost when it isn't answered within timeout
+// The replies are matched by seq while the echoes are still being sent, which reveals the replies received out of order
+func Burst(destAddr string, srcAddr string, pid int, count int, interval time.Duration, timeout time.Duration, randomPayload bool, dscp int, mark int, device string, limiter *common.RateLimiter) (burst common.IcmpBurstReturn, err error) {
+	dstIp := net.ParseIP(destAddr)
+	if dstIp == nil {
+		return burst, fmt.Errorf("destination ip: %v is invalid", destAddr)
+	}
+
+	v4 := len(dstIp.To4()) == net.IPv4len
+	localAddr, proto := "::", protocolIPv6ICMP
+	var request, reply icmp.Type = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	if v4 {
+		localAddr, proto = "0.0.0.0", protocolICMP
+		request, reply = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	}
+	if srcAddr != "" {
+		if net.ParseIP(srcAddr) == nil {
+			return burst, fmt.Errorf("source ip: %v is invalid, target: %v", srcAddr, destAddr)
+		}
+		localAddr = srcAddr
+	}
+
+	c, err := listen(v4, localAddr)
+	if err != nil {
+		return burst, err
+	}
+	defer c.Close()
+	id := replyID(c, pid&0xffff)
+
+	if v4 {
+		p4 := c.IPv4PacketConn()
+		if err = setSocket(p4.PacketConn, mark, device); err != nil {
+			return burst, err
+		}
+		if dscp > 0 {
+			if err = p4.SetTOS(dscp << 2); err != nil {
+				return burst, err
+			}
+		}
+	} else {
+		p6 := c.IPv6PacketConn()
+		if err = setSocket(p6.PacketConn, mark, device); err != nil {
+			return burst, err
+		}
+		if dscp > 0 {
+			if err = p6.SetTrafficClass(dscp << 2); err != nil {
+				return burst, err
+			}
+		}
+	}
+
+	burst.Echoes = make([]common.IcmpReturn, count)
+	sent := make([]time.Time, count)
+	payloads := make([][]byte, count)
+	var mtx sync.Mutex
+	done := make(chan struct{})
+
+	// The replies are read until the deadline set after the last echo
+	go func() {
+		defer close(done)
+		last := -1
+		b := make([]byte, 1500)
+		for {
+			n, peer, err := c.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			received := time.Now()
+
+			x, err := icmp.ParseMessage(proto, b[:n])
+			if err != nil || x.Type != reply {
+				continue
+			}
+			msg, ok := x.Body.(*icmp.Echo)
+			if !ok || msg.ID != id || msg.Seq >= count {
+				continue
+			}
+
+			mtx.Lock()
+			seq := msg.Seq
+			// Unsent, duplicated or foreign (same id/seq, other content) replies
+			if sent[seq].IsZero() || burst.Echoes[seq].Success || (!randomPayload && !bytes.Equal(msg.Data, payloads[seq])) {
+				mtx.Unlock()
+				continue
+			}
+			if !common.IsEqualIP(peerAddr(peer), destAddr) {
+				burst.UnexpectedSources++
+				mtx.Unlock()
+				continue
+			}
+			if elapsed := received.Sub(sent[seq]); elapsed <= timeout {
+				burst.Echoes[seq] = common.IcmpReturn{Success: true, Addr: peerAddr(peer), Elapsed: elapsed}
+				if seq < last {
+					burst.OutOfOrder++
+				} else {
+					last = seq
+				}
+			}
+			mtx.Unlock()
+		}
+	}()
+
+	dst := writeAddr(c, &net.IPAddr{IP: dstIp})
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			time.Sleep(interval)
+		}
+		limiter.Wait()
+
+		payload := echoPayload(seq, randomPayload)
+		wm := icmp.Message{
+			Type: request,
+			Code: 0,
+			Body: &icmp.Echo{
+				ID:   pid,
+				Seq:  seq,
+				Data: payload,
+			},
+		}
+		wb, werr := wm.Marshal(nil)
+		if werr != nil {
+			err = werr
+			break
+		}
+
+		mtx.Lock()
+		sent[seq] = time.Now()
+		payloads[seq] = payload
+		mtx.Unlock()
+		if _, err = c.WriteTo(wb, dst); err != nil {
+			break
+		}
+	}
+
+	if derr := c.SetReadDeadline(time.Now().Add(timeout)); derr != nil {
+		c.Close()
+	}
+	<-done
+	return burst, err
+}
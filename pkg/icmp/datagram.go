@@ -0,0 +1,59 @@
+package icmp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/syepes/network_exporter/pkg/common"
+	"golang.org/x/net/icmp"
+)
+
+// listen opens the ICMP socket of the family, an unprivileged ICMP datagram socket when the raw sockets are disabled
+func listen(v4 bool, localAddr string) (*icmp.PacketConn, error) {
+	network := "ip6:ipv6-icmp"
+	if v4 {
+		network = "ip4:icmp"
+	}
+	if !common.Privileged() {
+		network = "udp6"
+		if v4 {
+			network = "udp4"
+		}
+	}
+	return icmp.ListenPacket(network, localAddr)
+}
+
+// datagram reports if the socket is an unprivileged ICMP datagram socket
+func datagram(c *icmp.PacketConn) bool {
+	_, ok := c.LocalAddr().(*net.UDPAddr)
+	return ok
+}
+
+// writeAddr destination of the socket, the datagram sockets are written to an UDP address
+func writeAddr(c *icmp.PacketConn, dst net.Addr) net.Addr {
+	if ip, ok := dst.(*net.IPAddr); ok && datagram(c) {
+		return &net.UDPAddr{IP: ip.IP, Zone: ip.Zone}
+	}
+	return dst
+}
+
+// replyID id of the echo replies, the kernel replaces the id of the echoes sent on a datagram socket with its local port (Linux)
+func replyID(c *icmp.PacketConn, pid int) int {
+	if a, ok := c.LocalAddr().(*net.UDPAddr); ok && a.Port != 0 {
+		return a.Port
+	}
+	return pid
+}
+
+// peerAddr address of the sender of a reply (without the port of the datagram sockets)
+func peerAddr(peer net.Addr) string {
+	if a, ok := peer.(*net.UDPAddr); ok {
+		return (&net.IPAddr{IP: a.IP, Zone: a.Zone}).String()
+	}
+	return peer.String()
+}
+
+// errPrivileged the ICMP errors and the other ICMP messages than the echoes are not available on the datagram sockets
+func errPrivileged(feature string) error {
+	return fmt.Errorf("%s requires the raw ICMP sockets (privileged mode)", feature)
+}
@@ -0,0 +1,102 @@
+package icmp
+
+import (
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// icmpIpv4DSCP sends the echo with the given DSCP and reads the TOS of the reply from its IP header
+// Without DSCP (or when the raw IP header can't be read, unprivileged) the regular echo is used
+func icmpIpv4DSCP(localAddr string, dst net.Addr, ttl int, pid int, timeout time.Duration, seq int, randomPayload bool, dscp int, mark int, device string) (hop common.IcmpReturn, err error) {
+	if dscp <= 0 || runtime.GOOS == "windows" || !common.Privileged() {
+		return icmpIpv4(localAddr, dst, ttl, pid, timeout, seq, randomPayload, dscp, mark, device)
+	}
+
+	hop.Success = false
+	start := time.Now()
+	c, err := net.ListenIP("ip4:icmp", &net.IPAddr{IP: net.ParseIP(localAddr)})
+	if err != nil {
+		return hop, err
+	}
+	defer c.Close()
+
+	if err = setSocket(c, mark, device); err != nil {
+		return hop, err
+	}
+
+	p := ipv4.NewPacketConn(c)
+	if err = p.SetTTL(ttl); err != nil {
+		return hop, err
+	}
+	if err = p.SetTOS(dscp << 2); err != nil {
+		return hop, err
+	}
+
+	if err = c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return hop, err
+	}
+
+	payload := echoPayload(seq, randomPayload)
+	wm := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   pid,
+			Seq:  seq,
+			Data: payload,
+		},
+	}
+
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		return hop, err
+	}
+
+	if _, err := c.WriteTo(wb, dst); err != nil {
+		return hop, err
+	}
+
+	// The raw reads keep the IP header, the TOS is taken from it before stripping it
+	tos, ttl := -1, -1
+	read := func(b []byte) (int, net.Addr, error) {
+		n, _, _, peer, err := c.ReadMsgIP(b, nil)
+		if err != nil {
+			return n, peer, err
+		}
+		if n < ipv4.HeaderLen {
+			return 0, peer, nil
+		}
+		hl := int(b[0]&0x0f) << 2
+		if hl < ipv4.HeaderLen || n < hl {
+			return 0, peer, nil
+		}
+		tos = int(b[1])
+		ttl = int(b[8])
+		return copy(b, b[hl:n]), peer, nil
+	}
+
+	peer, _, unexpected, err := listenForSpecific4(read, expectedBody(payload, randomPayload), pid, seq, wb, dst.String())
+	hop.UnexpectedSources = unexpected
+	if err != nil {
+		return hop, err
+	}
+
+	elapsed := time.Since(start)
+	hop.Elapsed = elapsed
+	hop.Addr = peer
+	hop.Success = true
+	if tos >= 0 {
+		hop.ReplyDSCP = tos >> 2
+		hop.ReplyDSCPValid = true
+	}
+	if ttl >= 0 {
+		hop.ReplyTTL = ttl
+		hop.ReplyTTLValid = true
+	}
+	return hop, err
+}
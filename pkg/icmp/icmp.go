@@ -2,9 +2,11 @@ package icmp
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"net"
+	"syscall"
 	"time"
 
 	"github.com/syepes/network_exporter/pkg/common"
@@ -19,8 +21,12 @@ const (
 	protocolIPv6ICMP = 58 // ICMP for IPv6
 )
 
+// randomPayloadSize Echo payload size when the payload is randomized
+const randomPayloadSize = 56
+
 // Icmp Validate IP and check the version
-func Icmp(destAddr string, srcAddr string, ttl int, pid int, timeout time.Duration, seq int) (hop common.IcmpReturn, err error) {
+// A non zero mark sets the firewall mark of the socket (Linux only)
+func Icmp(destAddr string, srcAddr string, ttl int, pid int, timeout time.Duration, seq int, randomPayload bool, dscp int, mark int, device string) (hop common.IcmpReturn, err error) {
 	dstIp := net.ParseIP(destAddr)
 	if dstIp == nil {
 		return hop, fmt.Errorf("destination ip: %v is invalid", destAddr)
@@ -35,43 +41,64 @@ func Icmp(destAddr string, srcAddr string, ttl int, pid int, timeout time.Durati
 		}
 
 		if p4 := dstIp.To4(); len(p4) == net.IPv4len {
-			return icmpIpv4(srcAddr, &ipAddr, ttl, pid, timeout, seq)
+			return icmpIpv4DSCP(srcAddr, &ipAddr, ttl, pid, timeout, seq, randomPayload, dscp, mark, device)
 		}
-		return icmpIpv6(srcAddr, &ipAddr, ttl, pid, timeout, seq)
+		return icmpIpv6(srcAddr, &ipAddr, ttl, pid, timeout, seq, randomPayload, dscp, mark, device)
 	}
 
 	if p4 := dstIp.To4(); len(p4) == net.IPv4len {
-		return icmpIpv4("0.0.0.0", &ipAddr, ttl, pid, timeout, seq)
+		return icmpIpv4DSCP("0.0.0.0", &ipAddr, ttl, pid, timeout, seq, randomPayload, dscp, mark, device)
 	}
-	return icmpIpv6("::", &ipAddr, ttl, pid, timeout, seq)
+	return icmpIpv6("::", &ipAddr, ttl, pid, timeout, seq, randomPayload, dscp, mark, device)
 }
 
-func icmpIpv4(localAddr string, dst net.Addr, ttl int, pid int, timeout time.Duration, seq int) (hop common.IcmpReturn, err error) {
+func icmpIpv4(localAddr string, dst net.Addr, ttl int, pid int, timeout time.Duration, seq int, randomPayload bool, dscp int, mark int, device string) (hop common.IcmpReturn, err error) {
 	hop.Success = false
 	start := time.Now()
-	c, err := icmp.ListenPacket("ip4:icmp", localAddr)
+	c, err := listen(true, localAddr)
 	if err != nil {
 		return hop, err
 	}
 	defer c.Close()
 
+	if err = setSocket(c.IPv4PacketConn().PacketConn, mark, device); err != nil {
+		return hop, err
+	}
 	if err = c.IPv4PacketConn().SetTTL(ttl); err != nil {
 		return hop, err
 	}
 
+	if dscp > 0 {
+		if err = c.IPv4PacketConn().SetTOS(dscp << 2); err != nil {
+			return hop, err
+		}
+	}
+
+	// The TTL of the reply is read from the control messages when supported
+	read := c.ReadFrom
+	replyTTL := -1
+	if p4 := c.IPv4PacketConn(); p4.SetControlMessage(ipv4.FlagTTL, true) == nil {
+		read = func(b []byte) (int, net.Addr, error) {
+			n, cm, peer, err := p4.ReadFrom(b)
+			if cm != nil {
+				replyTTL = cm.TTL
+			}
+			return n, peer, err
+		}
+	}
+
 	if err = c.SetDeadline(time.Now().Add(timeout)); err != nil {
 		return hop, err
 	}
 
-	bs := make([]byte, 4)
-	binary.LittleEndian.PutUint32(bs, uint32(seq))
+	payload := echoPayload(seq, randomPayload)
 	wm := icmp.Message{
 		Type: ipv4.ICMPTypeEcho,
 		Code: 0,
 		Body: &icmp.Echo{
 			ID:   pid,
 			Seq:  seq,
-			Data: append(bs, 'x'),
+			Data: payload,
 		},
 	}
 
@@ -80,11 +107,12 @@ func icmpIpv4(localAddr string, dst net.Addr, ttl int, pid int, timeout time.Dur
 		return hop, err
 	}
 
-	if _, err := c.WriteTo(wb, dst); err != nil {
+	if _, err := c.WriteTo(wb, writeAddr(c, dst)); err != nil {
 		return hop, err
 	}
 
-	peer, _, err := listenForSpecific4(c, append(bs, 'x'), pid, seq, wb)
+	peer, _, unexpected, err := listenForSpecific4(read, expectedBody(payload, randomPayload), replyID(c, pid), seq, wb, dst.String())
+	hop.UnexpectedSources = unexpected
 	if err != nil {
 		return hop, err
 	}
@@ -93,35 +121,63 @@ func icmpIpv4(localAddr string, dst net.Addr, ttl int, pid int, timeout time.Dur
 	hop.Elapsed = elapsed
 	hop.Addr = peer
 	hop.Success = true
+	if replyTTL >= 0 {
+		hop.ReplyTTL = replyTTL
+		hop.ReplyTTLValid = true
+	}
 	return hop, err
 }
 
-func icmpIpv6(localAddr string, dst net.Addr, ttl, pid int, timeout time.Duration, seq int) (hop common.IcmpReturn, err error) {
+func icmpIpv6(localAddr string, dst net.Addr, ttl, pid int, timeout time.Duration, seq int, randomPayload bool, dscp int, mark int, device string) (hop common.IcmpReturn, err error) {
 	hop.Success = false
 	start := time.Now()
-	c, err := icmp.ListenPacket("ip6:ipv6-icmp", localAddr)
+	c, err := listen(false, localAddr)
 	if err != nil {
 		return hop, err
 	}
 	defer c.Close()
 
+	if err = setSocket(c.IPv6PacketConn().PacketConn, mark, device); err != nil {
+		return hop, err
+	}
 	if err = c.IPv6PacketConn().SetHopLimit(ttl); err != nil {
 		return hop, err
 	}
 
+	// The traffic class and hop limit of the reply are read from the control messages when supported
+	p6 := c.IPv6PacketConn()
+	flags := ipv6.FlagHopLimit
+	if dscp > 0 {
+		if err = p6.SetTrafficClass(dscp << 2); err != nil {
+			return hop, err
+		}
+		flags |= ipv6.FlagTrafficClass
+	}
+	read := c.ReadFrom
+	tclass, hopLimit := -1, -1
+	if p6.SetControlMessage(flags, true) == nil {
+		read = func(b []byte) (int, net.Addr, error) {
+			n, cm, peer, err := p6.ReadFrom(b)
+			if cm != nil {
+				tclass = cm.TrafficClass
+				hopLimit = cm.HopLimit
+			}
+			return n, peer, err
+		}
+	}
+
 	if err = c.SetDeadline(time.Now().Add(timeout)); err != nil {
 		return hop, err
 	}
 
-	bs := make([]byte, 4)
-	binary.LittleEndian.PutUint32(bs, uint32(seq))
+	payload := echoPayload(seq, randomPayload)
 	wm := icmp.Message{
 		Type: ipv6.ICMPTypeEchoRequest,
 		Code: 0,
 		Body: &icmp.Echo{
 			ID:   pid,
 			Seq:  seq,
-			Data: append(bs, 'x'),
+			Data: payload,
 		},
 	}
 	wb, err := wm.Marshal(nil)
@@ -129,11 +185,12 @@ func icmpIpv6(localAddr string, dst net.Addr, ttl, pid int, timeout time.Duratio
 		return hop, err
 	}
 
-	if _, err := c.WriteTo(wb, dst); err != nil {
+	if _, err := c.WriteTo(wb, writeAddr(c, dst)); err != nil {
 		return hop, err
 	}
 
-	peer, _, err := listenForSpecific6(c, append(bs, 'x'), pid, seq)
+	peer, _, unexpected, err := listenForSpecific6(read, expectedBody(payload, randomPayload), replyID(c, pid), seq, dst.String())
+	hop.UnexpectedSources = unexpected
 	if err != nil {
 		return hop, err
 	}
@@ -142,17 +199,49 @@ func icmpIpv6(localAddr string, dst net.Addr, ttl, pid int, timeout time.Duratio
 	hop.Elapsed = elapsed
 	hop.Addr = peer
 	hop.Success = true
+	if dscp > 0 && tclass >= 0 {
+		hop.ReplyDSCP = tclass >> 2
+		hop.ReplyDSCPValid = true
+	}
+	if hopLimit >= 0 {
+		hop.ReplyTTL = hopLimit
+		hop.ReplyTTLValid = true
+	}
 	return hop, err
 }
 
+// setSocket sets the firewall mark and the interface of the ICMP socket
+func setSocket(c net.PacketConn, mark int, device string) error {
+	if mark == 0 && device == "" {
+		return nil
+	}
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("fwmark and interface can't be set on %T", c)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if err = common.SetMark(rc, mark); err != nil {
+		return err
+	}
+	return common.SetDevice(rc, device)
+}
+
+// readFunc reads a single ICMP message (without the IP header)
+type readFunc func(b []byte) (int, net.Addr, error)
+
 // Listen IPv4 icmp returned packet and verify the content
-func listenForSpecific4(conn *icmp.PacketConn, neededBody []byte, needID int, needSeq int, sent []byte) (string, []byte, error) {
+// Echo replies from another source than dst are discarded and counted (spoofing, NAT, cross-matched ids)
+func listenForSpecific4(read readFunc, neededBody []byte, needID int, needSeq int, sent []byte, dst string) (string, []byte, int, error) {
+	unexpected := 0
 	for {
 		b := make([]byte, 1500)
-		n, peer, err := conn.ReadFrom(b)
+		n, peer, err := read(b)
 		if err != nil {
 			if neterr, ok := err.(*net.OpError); ok || neterr.Temporary() {
-				return "", []byte{}, neterr
+				return "", []byte{}, unexpected, neterr
 			}
 		}
 		if n == 0 {
@@ -174,7 +263,7 @@ func listenForSpecific4(conn *icmp.PacketConn, neededBody []byte, needID int, ne
 					// Verification
 					msg := x.Body.(*icmp.Echo)
 					if msg.ID == needID && msg.Seq == needSeq {
-						return peer.String(), []byte{}, nil
+						return peerAddr(peer), []byte{}, unexpected, nil
 					}
 				default:
 					// ignore
@@ -184,23 +273,29 @@ func listenForSpecific4(conn *icmp.PacketConn, neededBody []byte, needID int, ne
 
 		if x.Type.(ipv4.ICMPType) == ipv4.ICMPTypeEchoReply {
 			b, _ := x.Body.Marshal(protocolICMP)
-			if string(b[4:]) != string(neededBody) || x.Body.(*icmp.Echo).ID != needID {
+			if !echoMatches(x.Body.(*icmp.Echo), b[4:], neededBody, needID, needSeq) {
+				continue
+			}
+			if !common.IsEqualIP(peerAddr(peer), dst) {
+				unexpected++
 				continue
 			}
 
-			return peer.String(), b[4:], nil
+			return peerAddr(peer), b[4:], unexpected, nil
 		}
 	}
 }
 
 // Listen IPv6 icmp returned packet and verify the content
-func listenForSpecific6(conn *icmp.PacketConn, neededBody []byte, needID int, needSeq int) (string, []byte, error) {
+// Echo replies from another source than dst are discarded and counted (spoofing, NAT, cross-matched ids)
+func listenForSpecific6(read readFunc, neededBody []byte, needID int, needSeq int, dst string) (string, []byte, int, error) {
+	unexpected := 0
 	for {
 		b := make([]byte, 1500)
-		n, peer, err := conn.ReadFrom(b)
+		n, peer, err := read(b)
 		if err != nil {
 			if neterr, ok := err.(*net.OpError); ok {
-				return "", []byte{}, neterr
+				return "", []byte{}, unexpected, neterr
 			}
 		}
 		if n == 0 {
@@ -220,7 +315,7 @@ func listenForSpecific6(conn *icmp.PacketConn, neededBody []byte, needID int, ne
 				// Verification
 				msg := x.Body.(*icmp.Echo)
 				if msg.ID == needID && msg.Seq == needSeq {
-					return peer.String(), []byte{}, nil
+					return peerAddr(peer), []byte{}, unexpected, nil
 				}
 			default:
 				// ignore
@@ -229,11 +324,47 @@ func listenForSpecific6(conn *icmp.PacketConn, neededBody []byte, needID int, ne
 
 		if x.Type.(ipv6.ICMPType) == ipv6.ICMPTypeEchoReply {
 			b, _ := x.Body.Marshal(protocolICMP)
-			if string(b[4:]) != string(neededBody) || x.Body.(*icmp.Echo).ID != needID {
+			if !echoMatches(x.Body.(*icmp.Echo), b[4:], neededBody, needID, needSeq) {
+				continue
+			}
+			if !common.IsEqualIP(peerAddr(peer), dst) {
+				unexpected++
 				continue
 			}
 
-			return peer.String(), b[4:], nil
+			return peerAddr(peer), b[4:], unexpected, nil
 		}
 	}
 }
+
+// echoPayload builds the echo data, the seq followed by a fixed marker or random bytes (defeats WAN compression/dedup)
+func echoPayload(seq int, random bool) []byte {
+	bs := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bs, uint32(seq))
+	if !random {
+		return append(bs, 'x')
+	}
+
+	pad := make([]byte, randomPayloadSize-len(bs))
+	_, _ = rand.Read(pad)
+	return append(bs, pad...)
+}
+
+// expectedBody returns the reply content to verify (nil when only the id/seq are verified)
+func expectedBody(payload []byte, random bool) []byte {
+	if random {
+		return nil
+	}
+	return payload
+}
+
+// echoMatches verifies an echo reply by content or, without content, by id/seq
+func echoMatches(msg *icmp.Echo, body []byte, neededBody []byte, needID int, needSeq int) bool {
+	if msg.ID != needID {
+		return false
+	}
+	if neededBody == nil {
+		return msg.Seq == needSeq
+	}
+	return string(body) == string(neededBody)
+}
@@ -0,0 +1,111 @@
+package icmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// fakeReply ICMP message returned by the fake socket after a delay
+type fakeReply struct {
+	delay time.Duration
+	from  string
+	msg   icmp.Message
+}
+
+// fakeRead replays the replies in order then times out like the socket deadline
+func fakeRead(t *testing.T, replies []fakeReply) readFunc {
+	t.Helper()
+	return func(b []byte) (int, net.Addr, error) {
+		if len(replies) == 0 {
+			return 0, nil, &net.OpError{Op: "read", Net: "ip", Err: errTimeout{}}
+		}
+		r := replies[0]
+		replies = replies[1:]
+		time.Sleep(r.delay)
+		wb, err := r.msg.Marshal(nil)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return copy(b, wb), &net.IPAddr{IP: net.ParseIP(r.from)}, nil
+	}
+}
+
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "i/o timeout" }
+func (errTimeout) Timeout() bool   { return true }
+func (errTimeout) Temporary() bool { return true }
+
+func TestListenForSpecificUnexpectedSource(t *testing.T) {
+	const id, seq = 4242, 7
+	payload := echoPayload(seq, false)
+	spoofDelay, replyDelay := 5*time.Millisecond, 60*time.Millisecond
+
+	for _, tc := range []struct {
+		family string
+		dst    string
+		spoof  string
+		reply  icmp.Type
+	}{
+		{"ipv4", "192.0.2.10", "198.51.100.1", ipv4.ICMPTypeEchoReply},
+		{"ipv6", "2001:db8::10", "2001:db8::66", ipv6.ICMPTypeEchoReply},
+	} {
+		echo := &icmp.Echo{ID: id, Seq: seq, Data: payload}
+		read := fakeRead(t, []fakeReply{
+			{delay: spoofDelay, from: tc.spoof, msg: icmp.Message{Type: tc.reply, Body: echo}},
+			{delay: replyDelay - spoofDelay, from: tc.dst, msg: icmp.Message{Type: tc.reply, Body: echo}},
+		})
+
+		start := time.Now()
+		var peer string
+		var unexpected int
+		var err error
+		if tc.family == "ipv4" {
+			wm := icmp.Message{Type: ipv4.ICMPTypeEcho, Body: echo}
+			sent, _ := wm.Marshal(nil)
+			peer, _, unexpected, err = listenForSpecific4(read, payload, id, seq, sent, tc.dst)
+		} else {
+			peer, _, unexpected, err = listenForSpecific6(read, payload, id, seq, tc.dst)
+		}
+		elapsed := time.Since(start)
+
+		if err != nil {
+			t.Fatalf("%s: %v", tc.family, err)
+		}
+		if unexpected != 1 {
+			t.Errorf("%s: unexpected sources = %d, want 1", tc.family, unexpected)
+		}
+		if !net.ParseIP(peer).Equal(net.ParseIP(tc.dst)) {
+			t.Errorf("%s: reply accepted from %s, want %s", tc.family, peer, tc.dst)
+		}
+		// The RTT ends with the reply of the destination, not with the earlier spoofed one
+		if elapsed < replyDelay {
+			t.Errorf("%s: returned after %s on the spoofed reply, the destination replied after %s", tc.family, elapsed, replyDelay)
+		}
+	}
+}
+
+func TestListenForSpecificOnlyUnexpectedSources(t *testing.T) {
+	const id, seq = 4242, 8
+	payload := echoPayload(seq, false)
+	echo := &icmp.Echo{ID: id, Seq: seq, Data: payload}
+	read := fakeRead(t, []fakeReply{
+		{from: "198.51.100.1", msg: icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: echo}},
+		{from: "198.51.100.2", msg: icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: echo}},
+	})
+	wm := icmp.Message{Type: ipv4.ICMPTypeEcho, Body: echo}
+	sent, _ := wm.Marshal(nil)
+
+	_, _, unexpected, err := listenForSpecific4(read, payload, id, seq, sent, "192.0.2.10")
+	if err == nil {
+		t.Fatalf("spoofed replies accepted as a reply of the destination")
+	}
+	if unexpected != 2 {
+		t.Errorf("unexpected sources = %d, want 2", unexpected)
+	}
+}
@@ -0,0 +1,181 @@
+package icmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Lower bound of the path MTU search, the minimum MTU of the family (RFC 791, RFC 8200)
+const (
+	minMTU4 = 68
+	minMTU6 = 1280
+)
+
+// PMTU discovers the path MTU towards the target with a binary search on the size of echoes sent with the DF bit (Linux only)
+// The echoes lost or answered by a fragmentation needed/packet too big are too large, the minimum MTU of the family must be answered
+func PMTU(destAddr string, srcAddr string, pid int, timeout time.Duration, maxMTU int, mark int, device string, limiter *common.RateLimiter) (mtu int, err error) {
+	dstIp := net.ParseIP(destAddr)
+	if dstIp == nil {
+		return 0, fmt.Errorf("destination ip: %v is invalid", destAddr)
+	}
+
+	v4 := len(dstIp.To4()) == net.IPv4len
+	network, localAddr, header, lo := "ip6:ipv6-icmp", "::", ipv6.HeaderLen, minMTU6
+	if v4 {
+		network, localAddr, header, lo = "ip4:icmp", "0.0.0.0", ipv4.HeaderLen, minMTU4
+	}
+	if srcAddr != "" {
+		if net.ParseIP(srcAddr) == nil {
+			return 0, fmt.Errorf("source ip: %v is invalid, target: %v", srcAddr, destAddr)
+		}
+		localAddr = srcAddr
+	}
+	// The fragmentation needed/packet too big errors are not delivered to the datagram sockets
+	if !common.Privileged() {
+		return 0, errPrivileged("path mtu discovery")
+	}
+	if maxMTU < lo {
+		return 0, fmt.Errorf("pmtu-max %d is below the minimum MTU %d, target: %v", maxMTU, lo, destAddr)
+	}
+
+	c, err := icmp.ListenPacket(network, localAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	var pc net.PacketConn
+	if v4 {
+		pc = c.IPv4PacketConn().PacketConn
+	} else {
+		pc = c.IPv6PacketConn().PacketConn
+	}
+	if err = setSocket(pc, mark, device); err != nil {
+		return 0, err
+	}
+	if err = setDontFragment(pc, v4); err != nil {
+		return 0, err
+	}
+
+	seq := 0
+	probe := func(size int) (bool, error) {
+		seq++
+		limiter.Wait()
+		return pmtuEcho(c, v4, dstIp, pid&0xffff, seq, size-header-8, timeout)
+	}
+
+	// A lost echo at the minimum MTU is a loss and not an MTU
+	ok, err := probe(lo)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("no echo reply at the minimum MTU %d, target: %v", lo, destAddr)
+	}
+	if ok, err = probe(maxMTU); err != nil || ok {
+		return maxMTU, err
+	}
+
+	hi := maxMTU
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if ok, err = probe(mid); err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// pmtuEcho sends an echo with a payload of size bytes and reports if it was answered
+func pmtuEcho(c *icmp.PacketConn, v4 bool, dst net.IP, pid int, seq int, size int, timeout time.Duration) (bool, error) {
+	var request, reply icmp.Type = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	proto := protocolIPv6ICMP
+	if v4 {
+		request, reply, proto = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply, protocolICMP
+	}
+
+	data := bytes.Repeat([]byte{'x'}, size)
+	binary.LittleEndian.PutUint32(data, uint32(seq))
+	wm := icmp.Message{
+		Type: request,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   pid,
+			Seq:  seq,
+			Data: data,
+		},
+	}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+
+	if err = c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return false, err
+	}
+	if _, err := c.WriteTo(wb, &net.IPAddr{IP: dst}); err != nil {
+		// Larger than the MTU of the outgoing interface
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	b := make([]byte, len(wb)+512)
+	for {
+		n, peer, err := c.ReadFrom(b)
+		if err != nil {
+			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+
+		x, err := icmp.ParseMessage(proto, b[:n])
+		if err != nil {
+			continue
+		}
+		switch body := x.Body.(type) {
+		case *icmp.Echo:
+			if x.Type == reply && body.ID == pid && body.Seq == seq && len(body.Data) == size && common.IsEqualIP(peer.String(), dst.String()) {
+				return true, nil
+			}
+		case *icmp.DstUnreach:
+			// Fragmentation needed and DF set
+			if x.Code == 4 && quotesEcho(body.Data, v4, pid, seq) {
+				return false, nil
+			}
+		case *icmp.PacketTooBig:
+			if quotesEcho(body.Data, v4, pid, seq) {
+				return false, nil
+			}
+		}
+	}
+}
+
+// quotesEcho reports if the original datagram quoted by an ICMP error is the echo request
+func quotesEcho(data []byte, v4 bool, pid int, seq int) bool {
+	hl, request := ipv6.HeaderLen, byte(ipv6.ICMPTypeEchoRequest)
+	if v4 {
+		if len(data) < ipv4.HeaderLen {
+			return false
+		}
+		hl, request = int(data[0]&0x0f)<<2, byte(ipv4.ICMPTypeEcho)
+	}
+	return len(data) >= hl+8 && data[hl] == request && int(binary.BigEndian.Uint16(data[hl+4:])) == pid && int(binary.BigEndian.Uint16(data[hl+6:])) == seq
+}
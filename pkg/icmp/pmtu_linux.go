@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package icmp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDontFragment sets the DF bit of the echoes and disables the local fragmentation, the path MTU known by the kernel is ignored (IP_PMTUDISC_PROBE)
+func setDontFragment(c net.PacketConn, v4 bool) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("don't fragment can't be set on %T", c)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		if v4 {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		} else {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE)
+		}
+	}); err != nil {
+		return err
+	}
+	return serr
+}
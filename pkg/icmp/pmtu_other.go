@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package icmp
+
+import (
+	"fmt"
+	"net"
+)
+
+// setDontFragment the path MTU discovery is only implemented on Linux
+func setDontFragment(c net.PacketConn, v4 bool) error {
+	return fmt.Errorf("path mtu discovery is not supported on this platform")
+}
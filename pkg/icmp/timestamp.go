@@ -0,0 +1,127 @@
+package icmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Timestamps are milliseconds since midnight UT, the high-order bit flags a non-standard value (RFC 792)
+const (
+	msPerDay             = 24 * 60 * 60 * 1000
+	nonStandardTimestamp = 1 << 31
+)
+
+// Timestamp sends an ICMP Timestamp request (type 13) and computes the clock offset of the target from its reply (IPv4 only)
+func Timestamp(destAddr string, srcAddr string, pid int, timeout time.Duration, seq int, mark int, device string) (ts common.IcmpTimestampReturn, err error) {
+	dstIp := net.ParseIP(destAddr)
+	if dstIp == nil {
+		return ts, fmt.Errorf("destination ip: %v is invalid", destAddr)
+	}
+	if p4 := dstIp.To4(); len(p4) != net.IPv4len {
+		return ts, fmt.Errorf("icmp timestamp is only available on IPv4, target: %v", destAddr)
+	}
+
+	// Only the echoes can be sent on the datagram sockets
+	if !common.Privileged() {
+		return ts, errPrivileged("icmp timestamp")
+	}
+
+	localAddr := "0.0.0.0"
+	if srcAddr != "" {
+		if net.ParseIP(srcAddr) == nil {
+			return ts, fmt.Errorf("source ip: %v is invalid, target: %v", srcAddr, destAddr)
+		}
+		localAddr = srcAddr
+	}
+
+	c, err := icmp.ListenPacket("ip4:icmp", localAddr)
+	if err != nil {
+		return ts, err
+	}
+	defer c.Close()
+
+	if err = setSocket(c.IPv4PacketConn().PacketConn, mark, device); err != nil {
+		return ts, err
+	}
+	if err = c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return ts, err
+	}
+
+	start := time.Now()
+	originate := msSinceMidnight(start)
+	body := make([]byte, 16)
+	binary.BigEndian.PutUint16(body[0:], uint16(pid))
+	binary.BigEndian.PutUint16(body[2:], uint16(seq))
+	binary.BigEndian.PutUint32(body[4:], originate)
+
+	wm := icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Code: 0,
+		Body: &icmp.RawBody{Data: body},
+	}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		return ts, err
+	}
+
+	if _, err := c.WriteTo(wb, &net.IPAddr{IP: dstIp}); err != nil {
+		return ts, err
+	}
+
+	for {
+		b := make([]byte, 1500)
+		n, _, err := c.ReadFrom(b)
+		if err != nil {
+			return ts, err
+		}
+
+		x, err := icmp.ParseMessage(protocolICMP, b[:n])
+		if err != nil || x.Type != ipv4.ICMPTypeTimestampReply {
+			continue
+		}
+		raw, ok := x.Body.(*icmp.RawBody)
+		if !ok || len(raw.Data) < 16 {
+			continue
+		}
+		if int(binary.BigEndian.Uint16(raw.Data[0:])) != pid&0xffff || int(binary.BigEndian.Uint16(raw.Data[2:])) != seq&0xffff {
+			continue
+		}
+
+		received := time.Now()
+		receive := binary.BigEndian.Uint32(raw.Data[8:])
+		transmit := binary.BigEndian.Uint32(raw.Data[12:])
+		ts.Elapsed = received.Sub(start)
+		if receive&nonStandardTimestamp != 0 || transmit&nonStandardTimestamp != 0 {
+			return ts, fmt.Errorf("non-standard timestamp in the reply, target: %v", destAddr)
+		}
+
+		// offset = ((receive - originate) + (transmit - received)) / 2
+		offset := (msDiff(receive, originate) + msDiff(transmit, msSinceMidnight(received))) / 2
+		ts.Offset = time.Duration(offset) * time.Millisecond
+		ts.Success = true
+		return ts, nil
+	}
+}
+
+// msSinceMidnight milliseconds since midnight UT
+func msSinceMidnight(t time.Time) uint32 {
+	t = t.UTC()
+	return uint32(t.Sub(t.Truncate(24*time.Hour)) / time.Millisecond)
+}
+
+// msDiff difference of two timestamps taking care of the wrap around midnight
+func msDiff(a uint32, b uint32) int64 {
+	d := int64(a) - int64(b)
+	if d > msPerDay/2 {
+		d -= msPerDay
+	} else if d < -msPerDay/2 {
+		d += msPerDay
+	}
+	return d
+}
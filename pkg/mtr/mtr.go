@@ -2,7 +2,11 @@ package mtr
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/syepes/network_exporter/pkg/common"
@@ -10,7 +14,8 @@ import (
 )
 
 // Mtr Return traceroute object
-func Mtr(addr string, srcAddr string, maxHops int, count int, timeout time.Duration, icmpID int) (*MtrResult, error) {
+// The hops are probed with ICMP echoes, TCP SYNs or UDP datagrams (protocol) sent to port, stopped (with the context error) once ctx is done
+func Mtr(ctx context.Context, addr string, srcAddr string, maxHops int, count int, timeout time.Duration, icmpID int, dscp int, mark int, device string, protocol string, port int, limiter *common.RateLimiter) (*MtrResult, error) {
 	var out MtrResult
 	var err error
 
@@ -18,6 +23,13 @@ func Mtr(addr string, srcAddr string, maxHops int, count int, timeout time.Durat
 	options.SetMaxHops(maxHops)
 	options.SetCount(count)
 	options.SetTimeout(timeout)
+	options.SetDSCP(dscp)
+	options.SetMark(mark)
+	options.SetDevice(device)
+	options.SetProtocol(protocol)
+	options.SetPort(port)
+	options.SetRateLimiter(limiter)
+	options.SetContext(ctx)
 
 	out, err = runMtr(addr, srcAddr, icmpID, &options)
 
@@ -84,8 +96,14 @@ func MtrString(addr string, srcAddr string, maxHops int, count int, timeout time
 
 // MTR
 func runMtr(destAddr string, srcAddr string, icmpID int, options *MtrOptions) (result MtrResult, err error) {
+	// The ICMP errors quoting the echoes and SYNs are not delivered to the unprivileged sockets, the hops are then probed with UDP
+	if !common.Privileged() && options.Protocol() != ProtocolUDP {
+		options.SetProtocol(ProtocolUDP)
+		options.SetPort(defaultUDPPort)
+	}
 	result.Hops = []common.IcmpHop{}
 	result.DestAddr = destAddr
+	result.Protocol = options.Protocol()
 
 	// Avoid collisions/interference caused by multiple coroutines initiating mtr
 	pid := icmpID
@@ -96,11 +114,20 @@ func runMtr(destAddr string, srcAddr string, icmpID int, options *MtrOptions) (r
 	seq := 0
 	for snt := 0; snt < options.Count(); snt++ {
 		for ttl := 1; ttl < options.MaxHops(); ttl++ {
+			if err := options.Context().Err(); err != nil {
+				return result, err
+			}
 			if mtrReturns[ttl] == nil {
 				mtrReturns[ttl] = &MtrReturn{ttl: ttl, host: "unknown", succSum: 0, success: false, lastTime: time.Duration(0), sumTime: time.Duration(0), bestTime: time.Duration(0), worstTime: time.Duration(0), avgTime: time.Duration(0)}
 			}
 
-			hopReturn, err := icmp.Icmp(destAddr, srcAddr, ttl, pid, timeout, seq)
+			options.RateLimiter().Wait()
+			var hopReturn common.IcmpReturn
+			if options.Protocol() == ProtocolICMP {
+				hopReturn, err = icmp.Icmp(destAddr, srcAddr, ttl, pid, timeout, seq, false, options.DSCP(), options.Mark(), options.Device())
+			} else {
+				hopReturn, err = transportHop(options.Protocol(), destAddr, srcAddr, ttl, options.Port(), timeout, options.DSCP(), options.Mark(), options.Device())
+			}
 			if err != nil || !hopReturn.Success {
 				continue
 			}
@@ -167,3 +194,41 @@ func runMtr(destAddr string, srcAddr string, icmpID int, options *MtrOptions) (r
 	// fmt.Printf("Mtr.result %+v\n", result)
 	return result, nil
 }
+
+// ResolveHops sets the hop names with a reverse (PTR) lookup, each lookup is bounded by timeout
+// names caches the successful lookups (ip -> name) between the cycles
+func ResolveHops(result *MtrResult, resolver *net.Resolver, timeout time.Duration, names map[string]string) {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	for i := range result.Hops {
+		ip := result.Hops[i].AddressTo
+		if net.ParseIP(ip) == nil {
+			continue
+		}
+		mtx.Lock()
+		name, found := names[ip]
+		mtx.Unlock()
+		if found {
+			result.Hops[i].AddressName = name
+			continue
+		}
+
+		wg.Add(1)
+		go func(hop *common.IcmpHop, ip string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			ptr, err := resolver.LookupAddr(ctx, ip)
+			if err != nil || len(ptr) == 0 {
+				return
+			}
+			hop.AddressName = strings.TrimSuffix(ptr[0], ".")
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			names[ip] = hop.AddressName
+		}(&result.Hops[i], ip)
+	}
+	wg.Wait()
+}
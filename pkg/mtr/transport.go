@@ -0,0 +1,126 @@
+package mtr
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Probe protocols of the hops
+const (
+	ProtocolICMP = "icmp"
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+)
+
+const defaultTCPPort = 80
+const defaultUDPPort = 33434
+
+// transport IP protocol numbers of the quoted probes
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// ValidProtocol the hop probe protocol is known (empty is ICMP)
+func ValidProtocol(protocol string) bool {
+	switch protocol {
+	case "", ProtocolICMP, ProtocolTCP, ProtocolUDP:
+		return true
+	}
+	return false
+}
+
+// transportResult answer of the destination itself (TCP connect/reset, UDP response)
+type transportResult struct {
+	reached bool
+	at      time.Time
+	err     error
+}
+
+// listenErrors reads the ICMP errors (time exceeded, unreachable) quoting the probe sent from srcPort until the deadline
+// The destination answering (done) ends the wait, the hop is then the destination
+func listenErrors(c *icmp.PacketConn, v4 bool, proto int, dst net.IP, srcPort int, start time.Time, deadline time.Time, done <-chan transportResult) (hop common.IcmpReturn, err error) {
+	b := make([]byte, 1500)
+	for {
+		select {
+		case r := <-done:
+			if r.reached {
+				hop.Success = true
+				hop.Addr = dst.String()
+				hop.Elapsed = r.at.Sub(start)
+				return hop, nil
+			}
+			done = nil
+		default:
+		}
+		if time.Now().After(deadline) {
+			return hop, nil
+		}
+
+		// Short reads so the answer of the destination is not delayed
+		if err := c.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+			return hop, err
+		}
+		n, peer, rerr := c.ReadFrom(b)
+		if rerr != nil {
+			if ne, ok := rerr.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return hop, rerr
+		}
+		at := time.Now()
+
+		icmpProto := 1
+		if !v4 {
+			icmpProto = 58
+		}
+		m, perr := icmp.ParseMessage(icmpProto, b[:n])
+		if perr != nil {
+			continue
+		}
+		var quoted []byte
+		switch body := m.Body.(type) {
+		case *icmp.TimeExceeded:
+			quoted = body.Data
+		case *icmp.DstUnreach:
+			quoted = body.Data
+		default:
+			continue
+		}
+		if !quotesProbe(quoted, v4, proto, dst, srcPort) {
+			continue
+		}
+
+		hop.Success = true
+		hop.Addr = peer.String()
+		hop.Elapsed = at.Sub(start)
+		return hop, nil
+	}
+}
+
+// quotesProbe the quoted packet (IP header and the start of the transport header) is the probe sent to dst from srcPort
+func quotesProbe(b []byte, v4 bool, proto int, dst net.IP, srcPort int) bool {
+	var l4 []byte
+	if v4 {
+		if len(b) < ipv4.HeaderLen {
+			return false
+		}
+		hl := int(b[0]&0x0f) * 4
+		if len(b) < hl+4 || int(b[9]) != proto || !net.IP(b[16:20]).Equal(dst) {
+			return false
+		}
+		l4 = b[hl:]
+	} else {
+		if len(b) < ipv6.HeaderLen+4 || int(b[6]) != proto || !net.IP(b[24:40]).Equal(dst) {
+			return false
+		}
+		l4 = b[ipv6.HeaderLen:]
+	}
+	return int(binary.BigEndian.Uint16(l4[0:2])) == srcPort
+}
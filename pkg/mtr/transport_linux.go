@@ -0,0 +1,279 @@
+//go:build linux
+// +build linux
+
+package mtr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/syepes/network_exporter/pkg/common"
+	"golang.org/x/net/icmp"
+	"golang.org/x/sys/unix"
+)
+
+// transportHop sends a TCP SYN or UDP datagram with the TTL to destAddr:port, the hop is the sender of the ICMP error quoting it
+// The destination is reached when it answers (TCP connect or reset, UDP response or port unreachable)
+func transportHop(protocol string, destAddr string, srcAddr string, ttl int, port int, timeout time.Duration, dscp int, mark int, device string) (hop common.IcmpReturn, err error) {
+	dstIp := net.ParseIP(destAddr)
+	if dstIp == nil {
+		return hop, fmt.Errorf("destination ip: %v is invalid", destAddr)
+	}
+	v4 := dstIp.To4() != nil
+	network, listen, local := "4", "ip4:icmp", "0.0.0.0"
+	if !v4 {
+		network, listen, local = "6", "ip6:ipv6-icmp", "::"
+	}
+	var srcIp net.IP
+	if srcAddr != "" {
+		if srcIp = net.ParseIP(srcAddr); srcIp == nil {
+			return hop, fmt.Errorf("source ip: %v is invalid, target: %v", srcAddr, destAddr)
+		}
+		local = srcIp.String()
+	}
+	if protocol == ProtocolUDP && !common.Privileged() {
+		return errQueueHop(network, local, dstIp, srcIp, ttl, port, timeout, dscp, mark, device)
+	}
+
+	c, err := icmp.ListenPacket(listen, local)
+	if err != nil {
+		return hop, err
+	}
+	defer c.Close()
+
+	// The probe socket is bound before sending so the ICMP errors can be matched by its source port
+	// The dialed TCP sockets are bound by the control (unbound until the connect otherwise), the UDP ones by the listen
+	bound := make(chan int, 1)
+	control := func(network, _ string, rc syscall.RawConn) error {
+		var port int
+		var serr error
+		if err := rc.Control(func(fd uintptr) {
+			port, serr = bindTTL(int(fd), v4, srcIp, ttl, network[:3] == "tcp")
+		}); err != nil {
+			return err
+		}
+		if serr != nil {
+			return serr
+		}
+		if err := common.SetDSCP(rc, v4, dscp); err != nil {
+			return err
+		}
+		if err := common.SetMark(rc, mark); err != nil {
+			return err
+		}
+		if err := common.SetDevice(rc, device); err != nil {
+			return err
+		}
+		if port > 0 {
+			bound <- port
+		}
+		return nil
+	}
+
+	done := make(chan transportResult, 1)
+	start := time.Now()
+	deadline := start.Add(timeout)
+	proto := protoTCP
+
+	switch protocol {
+	case ProtocolTCP:
+		d := net.Dialer{Timeout: timeout, Control: control}
+		go func() {
+			conn, err := d.Dial("tcp"+network, net.JoinHostPort(destAddr, strconv.Itoa(port)))
+			if err == nil {
+				conn.Close()
+			}
+			// A reset (port closed) is also an answer of the destination
+			done <- transportResult{reached: err == nil || errors.Is(err, syscall.ECONNREFUSED), at: time.Now(), err: err}
+		}()
+	case ProtocolUDP:
+		proto = protoUDP
+		lc := net.ListenConfig{Control: control}
+		pc, err := lc.ListenPacket(context.Background(), "udp"+network, net.JoinHostPort(local, "0"))
+		if err != nil {
+			return hop, err
+		}
+		defer pc.Close()
+		bound <- pc.LocalAddr().(*net.UDPAddr).Port
+		start = time.Now()
+		if _, err := pc.WriteTo([]byte("network_exporter"), &net.UDPAddr{IP: dstIp, Port: port}); err != nil {
+			return hop, err
+		}
+		go func() {
+			b := make([]byte, 1500)
+			_ = pc.SetReadDeadline(deadline)
+			_, _, err := pc.ReadFrom(b)
+			done <- transportResult{reached: err == nil, at: time.Now(), err: err}
+		}()
+	default:
+		return hop, fmt.Errorf("unknown mtr protocol %s", protocol)
+	}
+
+	var srcPort int
+	select {
+	case srcPort = <-bound:
+	case r := <-done:
+		// Failed before the socket was bound
+		return hop, r.err
+	}
+	return listenErrors(c, v4, proto, dstIp, srcPort, start, deadline, done)
+}
+
+// bindTTL sets the TTL (hop limit) of the socket and (with bind) binds it to an ephemeral port of srcIp, returns the port
+func bindTTL(fd int, v4 bool, srcIp net.IP, ttl int, bind bool) (int, error) {
+	var err error
+	if v4 {
+		err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TTL, ttl)
+	} else {
+		err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, ttl)
+	}
+	if err != nil || !bind {
+		return 0, err
+	}
+
+	var sa unix.Sockaddr
+	if v4 {
+		a := &unix.SockaddrInet4{}
+		if srcIp != nil {
+			copy(a.Addr[:], srcIp.To4())
+		}
+		sa = a
+	} else {
+		a := &unix.SockaddrInet6{}
+		if srcIp != nil {
+			copy(a.Addr[:], srcIp.To16())
+		}
+		sa = a
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		return 0, err
+	}
+	bound, err := unix.Getsockname(fd)
+	if err != nil {
+		return 0, err
+	}
+	switch a := bound.(type) {
+	case *unix.SockaddrInet4:
+		return a.Port, nil
+	case *unix.SockaddrInet6:
+		return a.Port, nil
+	}
+	return 0, fmt.Errorf("unexpected socket address %T", bound)
+}
+
+// errQueueHop sends an UDP datagram with the TTL to dst:port without raw socket, the ICMP errors are read from the error queue of the socket (IP_RECVERR)
+// The hop is the offender of the time exceeded, the destination is reached when it answers (UDP response or port unreachable)
+func errQueueHop(network string, local string, dst net.IP, srcIp net.IP, ttl int, port int, timeout time.Duration, dscp int, mark int, device string) (hop common.IcmpReturn, err error) {
+	v4 := network == "4"
+	lc := net.ListenConfig{Control: func(_, _ string, rc syscall.RawConn) error {
+		var serr error
+		if err := rc.Control(func(fd uintptr) {
+			if _, serr = bindTTL(int(fd), v4, srcIp, ttl, false); serr != nil {
+				return
+			}
+			if v4 {
+				serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVERR, 1)
+			} else {
+				serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVERR, 1)
+			}
+		}); err != nil {
+			return err
+		}
+		if serr != nil {
+			return serr
+		}
+		if err := common.SetDSCP(rc, v4, dscp); err != nil {
+			return err
+		}
+		if err := common.SetMark(rc, mark); err != nil {
+			return err
+		}
+		return common.SetDevice(rc, device)
+	}}
+	pc, err := lc.ListenPacket(context.Background(), "udp"+network, net.JoinHostPort(local, "0"))
+	if err != nil {
+		return hop, err
+	}
+	defer pc.Close()
+	uc := pc.(*net.UDPConn)
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return hop, err
+	}
+
+	start := time.Now()
+	if err := uc.SetReadDeadline(start.Add(timeout)); err != nil {
+		return hop, err
+	}
+	if _, err := uc.WriteTo([]byte("network_exporter"), &net.UDPAddr{IP: dst, Port: port}); err != nil {
+		return hop, err
+	}
+
+	b := make([]byte, 1500)
+	oob := make([]byte, 512)
+	var offender net.IP
+	var rerr error
+	// The error queue is polled with the regular reads (POLLERR), an answer of the destination is read from the socket
+	err = rc.Read(func(fd uintptr) bool {
+		for {
+			_, oobn, _, _, err := unix.Recvmsg(int(fd), b, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+			if err != nil {
+				break
+			}
+			if ip := errQueueOffender(oob[:oobn], v4); ip != nil {
+				offender = ip
+				return true
+			}
+		}
+		if _, _, err := unix.Recvfrom(int(fd), b, unix.MSG_DONTWAIT); err != unix.EAGAIN {
+			offender, rerr = dst, err
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return hop, nil
+		}
+		return hop, err
+	}
+	if rerr != nil {
+		return hop, rerr
+	}
+
+	hop.Success = true
+	hop.Addr = offender.String()
+	hop.Elapsed = time.Since(start)
+	return hop, nil
+}
+
+// sizeofSockExtendedErr size of the sock_extended_err of the IP_RECVERR control messages
+const sizeofSockExtendedErr = int(unsafe.Sizeof(unix.SockExtendedErr{}))
+
+// errQueueOffender returns the sender of the ICMP error of the IP_RECVERR control message (sock_extended_err followed by the offender address)
+func errQueueOffender(oob []byte, v4 bool) net.IP {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, m := range msgs {
+		if len(m.Data) < sizeofSockExtendedErr {
+			continue
+		}
+		ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+		sa := m.Data[sizeofSockExtendedErr:]
+		switch {
+		case v4 && m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_RECVERR && ee.Origin == unix.SO_EE_ORIGIN_ICMP && len(sa) >= unix.SizeofSockaddrInet4:
+			return net.IP(append([]byte{}, sa[4:8]...))
+		case !v4 && m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_RECVERR && ee.Origin == unix.SO_EE_ORIGIN_ICMP6 && len(sa) >= unix.SizeofSockaddrInet6:
+			return net.IP(append([]byte{}, sa[8:24]...))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package mtr
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+// transportHop the TCP/UDP hop probes are only implemented on Linux
+func transportHop(protocol string, destAddr string, srcAddr string, ttl int, port int, timeout time.Duration, dscp int, mark int, device string) (hop common.IcmpReturn, err error) {
+	return hop, fmt.Errorf("mtr protocol %s is not supported on this platform", protocol)
+}
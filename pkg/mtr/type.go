@@ -1,6 +1,7 @@
 package mtr
 
 import (
+	"context"
 	"time"
 
 	"github.com/syepes/network_exporter/pkg/common"
@@ -11,11 +12,17 @@ const defaultTimeout = 5 * time.Second
 const defaultPackerSize = 56
 const defaultCount = 10
 
+// MaxWindow Upper bound of the runs averaged per target
+const MaxWindow = 100
+
 // MtrResult Calculated results
 type MtrResult struct {
 	DestAddr string           `json:"dest_address"`
+	Protocol string           `json:"protocol"`
 	Hops     []common.IcmpHop `json:"hops"`
 	HopSummaryMap map[string]*common.IcmpSummary `json:"hop_summary_map"`
+	WindowRuns int `json:"window_runs,omitempty"`
+	WindowHops []common.IcmpHop `json:"window_hops,omitempty"`
 }
 
 // MtrReturn MTR Response
@@ -41,6 +48,13 @@ type MtrOptions struct {
 	timeout    time.Duration
 	packetSize int
 	count      int
+	dscp       int
+	mark       int
+	device     string
+	protocol   string
+	port       int
+	limiter    *common.RateLimiter
+	ctx        context.Context
 }
 
 // MaxHops Getter
@@ -94,3 +108,86 @@ func (options *MtrOptions) PacketSize() int {
 func (options *MtrOptions) SetPacketSize(packetSize int) {
 	options.packetSize = packetSize
 }
+
+// DSCP Getter
+func (options *MtrOptions) DSCP() int {
+	return options.dscp
+}
+
+// SetDSCP Setter
+func (options *MtrOptions) SetDSCP(dscp int) {
+	options.dscp = dscp
+}
+
+// Mark Getter
+func (options *MtrOptions) Mark() int {
+	return options.mark
+}
+
+// SetMark Setter
+func (options *MtrOptions) SetMark(mark int) {
+	options.mark = mark
+}
+
+// Device Getter
+func (options *MtrOptions) Device() string {
+	return options.device
+}
+
+// SetDevice Setter
+func (options *MtrOptions) SetDevice(device string) {
+	options.device = device
+}
+
+// Protocol Getter
+func (options *MtrOptions) Protocol() string {
+	if options.protocol == "" {
+		options.protocol = ProtocolICMP
+	}
+	return options.protocol
+}
+
+// SetProtocol Setter
+func (options *MtrOptions) SetProtocol(protocol string) {
+	options.protocol = protocol
+}
+
+// Port Getter (destination port of the TCP/UDP probes)
+func (options *MtrOptions) Port() int {
+	if options.port == 0 {
+		if options.Protocol() == ProtocolUDP {
+			options.port = defaultUDPPort
+		} else {
+			options.port = defaultTCPPort
+		}
+	}
+	return options.port
+}
+
+// SetPort Setter
+func (options *MtrOptions) SetPort(port int) {
+	options.port = port
+}
+
+// RateLimiter Getter
+func (options *MtrOptions) RateLimiter() *common.RateLimiter {
+	return options.limiter
+}
+
+// SetRateLimiter Setter
+func (options *MtrOptions) SetRateLimiter(limiter *common.RateLimiter) {
+	options.limiter = limiter
+}
+
+// Context Getter
+func (options *MtrOptions) Context() context.Context {
+	if options.ctx == nil {
+		options.ctx = context.Background()
+	}
+	return options.ctx
+}
+
+// SetContext Setter, no hop is probed once the context is done
+func (options *MtrOptions) SetContext(ctx context.Context) {
+	options.ctx = ctx
+}
@@ -0,0 +1,114 @@
+package owd
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// PacketSpacing Delay between the probes of a cycle
+const PacketSpacing = 100 * time.Millisecond
+
+// Probe sends count probes to the responder and computes the one-way delays of the replies
+// Each probe waits up to timeout for its reply (a cycle takes at most count * (timeout + PacketSpacing)), the late replies are ignored
+func Probe(destAddr string, ip string, srcAddr string, port string, count int, timeout time.Duration) (*OwdResult, error) {
+	out := &OwdResult{DestAddr: destAddr, DestIp: ip, DestPort: port, SrcIp: "0.0.0.0"}
+
+	var laddr *net.UDPAddr
+	if srcAddr != "" {
+		srcIp := net.ParseIP(srcAddr)
+		if srcIp == nil {
+			return out, fmt.Errorf("source ip: %v is invalid, OWD target: %v", srcAddr, destAddr)
+		}
+		laddr = &net.UDPAddr{IP: srcIp}
+	}
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return out, err
+	}
+	conn, err := net.DialUDP("udp", laddr, raddr)
+	if err != nil {
+		return out, err
+	}
+	defer conn.Close()
+	out.SrcIp = conn.LocalAddr().(*net.UDPAddr).IP.String()
+
+	id := rand.Uint32()
+	buf := make([]byte, 1500)
+	var fwdSum, revSum, rttSum, offSum time.Duration
+	var lastErr error
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			time.Sleep(PacketSpacing)
+		}
+		req := packet{kind: kindReq, id: id, seq: uint32(seq), t1: time.Now().UnixNano()}
+		req.marshal(buf[:PacketSize])
+		out.Sent++
+		if _, err := conn.Write(buf[:PacketSize]); err != nil {
+			lastErr = err
+			continue
+		}
+
+		reply, t4, err := readReply(conn, buf, id, uint32(seq), time.Now().Add(timeout))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		fwd := time.Duration(reply.t2 - reply.t1)
+		rev := time.Duration(t4 - reply.t3)
+		if out.Received == 0 || fwd < out.ForwardBest {
+			out.ForwardBest = fwd
+		}
+		if out.Received == 0 || fwd > out.ForwardWorst {
+			out.ForwardWorst = fwd
+		}
+		if out.Received == 0 || rev < out.ReverseBest {
+			out.ReverseBest = rev
+		}
+		if out.Received == 0 || rev > out.ReverseWorst {
+			out.ReverseWorst = rev
+		}
+		out.Received++
+		fwdSum += fwd
+		revSum += rev
+		rttSum += fwd + rev
+		offSum += (fwd - rev) / 2
+	}
+
+	if out.Sent > 0 {
+		out.DropRate = float64(out.Sent-out.Received) / float64(out.Sent)
+	}
+	if out.Received == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no reply from %s", raddr)
+		}
+		return out, lastErr
+	}
+	n := time.Duration(out.Received)
+	out.ForwardAvg = fwdSum / n
+	out.ReverseAvg = revSum / n
+	out.RttAvg = rttSum / n
+	out.ClockOffset = offSum / n
+	out.Success = true
+	return out, nil
+}
+
+// readReply waits for the reply of the probe, returns it with its receive time (t4)
+func readReply(conn *net.UDPConn, buf []byte, id uint32, seq uint32, deadline time.Time) (*packet, int64, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, 0, err
+	}
+	for {
+		n, err := conn.Read(buf)
+		t4 := time.Now().UnixNano()
+		if err != nil {
+			return nil, 0, err
+		}
+		var p packet
+		if p.unmarshal(buf[:n]) != nil || p.kind != kindReply || p.id != id || p.seq != seq {
+			continue
+		}
+		return &p, t4, nil
+	}
+}
@@ -0,0 +1,82 @@
+package owd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Responder Answers the one-way delay probes of the cooperating exporters
+type Responder struct {
+	logger   log.Logger
+	conn     net.PacketConn
+	requests uint64
+	wg       sync.WaitGroup
+}
+
+// NewResponder listens on the UDP address and starts answering the probes
+func NewResponder(logger log.Logger, address string) (*Responder, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	r := &Responder{logger: logger, conn: conn}
+	r.wg.Add(1)
+	go r.serve()
+	return r, nil
+}
+
+func (r *Responder) serve() {
+	defer r.wg.Done()
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := r.conn.ReadFrom(buf)
+		t2 := time.Now().UnixNano()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		var p packet
+		if err := p.unmarshal(buf[:n]); err != nil || p.kind != kindReq {
+			level.Debug(r.logger).Log("type", "OWD", "func", "serve", "msg", fmt.Sprintf("Ignoring packet from %s", addr), "err", err)
+			continue
+		}
+		p.kind = kindReply
+		p.t2 = t2
+		p.t3 = time.Now().UnixNano()
+		p.marshal(buf[:PacketSize])
+		if _, err := r.conn.WriteTo(buf[:PacketSize], addr); err != nil {
+			level.Debug(r.logger).Log("type", "OWD", "func", "serve", "msg", fmt.Sprintf("Replying to %s", addr), "err", err)
+			continue
+		}
+		atomic.AddUint64(&r.requests, 1)
+	}
+}
+
+// Requests returns the number of answered probes
+func (r *Responder) Requests() uint64 {
+	if r == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&r.requests)
+}
+
+// Stop closes the listener
+func (r *Responder) Stop() {
+	if r == nil {
+		return
+	}
+	r.conn.Close()
+	r.wg.Wait()
+}
@@ -0,0 +1,95 @@
+package owd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Wire format of the one-way delay probes, a single UDP datagram (big endian):
+//
+//	 0  magic "NEOW"
+//	 4  version (1)
+//	 5  kind (1 request, 2 reply)
+//	 6  reserved (0)
+//	 8  id uint32, identifies the prober of the exchange
+//	12  seq uint32
+//	16  t1 int64, request sent (prober clock, unix nanoseconds)
+//	24  t2 int64, request received (responder clock, 0 in the requests)
+//	32  t3 int64, reply sent (responder clock, 0 in the requests)
+//
+// The responder stamps t2/t3 and sends the packet back, the prober stamps t4 on receipt
+// The one-way delays (t2-t1 forward, t4-t3 reverse) are only meaningful with synchronized clocks (NTP/PTP)
+const (
+	PacketSize = 40
+	version    = 1
+	kindReq    = 1
+	kindReply  = 2
+)
+
+var magic = [4]byte{'N', 'E', 'O', 'W'}
+
+// packet Decoded probe
+type packet struct {
+	kind byte
+	id   uint32
+	seq  uint32
+	t1   int64
+	t2   int64
+	t3   int64
+}
+
+func (p *packet) marshal(b []byte) {
+	copy(b[0:4], magic[:])
+	b[4] = version
+	b[5] = p.kind
+	b[6], b[7] = 0, 0
+	binary.BigEndian.PutUint32(b[8:12], p.id)
+	binary.BigEndian.PutUint32(b[12:16], p.seq)
+	binary.BigEndian.PutUint64(b[16:24], uint64(p.t1))
+	binary.BigEndian.PutUint64(b[24:32], uint64(p.t2))
+	binary.BigEndian.PutUint64(b[32:40], uint64(p.t3))
+}
+
+func (p *packet) unmarshal(b []byte) error {
+	if len(b) < PacketSize || [4]byte{b[0], b[1], b[2], b[3]} != magic {
+		return fmt.Errorf("not a one-way delay probe")
+	}
+	if b[4] != version {
+		return fmt.Errorf("unsupported probe version %d", b[4])
+	}
+	p.kind = b[5]
+	p.id = binary.BigEndian.Uint32(b[8:12])
+	p.seq = binary.BigEndian.Uint32(b[12:16])
+	p.t1 = int64(binary.BigEndian.Uint64(b[16:24]))
+	p.t2 = int64(binary.BigEndian.Uint64(b[24:32]))
+	p.t3 = int64(binary.BigEndian.Uint64(b[32:40]))
+	return nil
+}
+
+// OwdResult Calculated results of a probe cycle
+type OwdResult struct {
+	Success  bool   `json:"success"`
+	DestAddr string `json:"dest_address"`
+	DestIp   string `json:"dest_ip"`
+	DestPort string `json:"dest_port"`
+	SrcIp    string `json:"src_ip"`
+
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	DropRate float64 `json:"drop_rate"`
+
+	// Request (prober to responder) and reply (responder to prober) delays
+	ForwardBest  time.Duration `json:"forward_best"`
+	ForwardAvg   time.Duration `json:"forward_avg"`
+	ForwardWorst time.Duration `json:"forward_worst"`
+	ReverseBest  time.Duration `json:"reverse_best"`
+	ReverseAvg   time.Duration `json:"reverse_avg"`
+	ReverseWorst time.Duration `json:"reverse_worst"`
+
+	// Round trip without the responder processing time, independent of the clocks
+	RttAvg time.Duration `json:"rtt_avg"`
+
+	// Clock offset of the responder estimated like NTP (assumes symmetric delays)
+	ClockOffset time.Duration `json:"clock_offset"`
+}
@@ -2,21 +2,32 @@ package ping
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/syepes/network_exporter/pkg/common"
 	"github.com/syepes/network_exporter/pkg/icmp"
 )
 
-// Ping ICMP Operation
-func Ping(addr string, ip string, srcAddr string, count int, interval time.Duration, timeout time.Duration, icmpID int) (*PingResult, error) {
+// Ping ICMP Operation, stopped (with the context error) once ctx is done
+func Ping(ctx context.Context, addr string, ip string, srcAddr string, count int, interval time.Duration, timeout time.Duration, icmpID int, randomPayload bool, dscp int, mark int, device string, burst bool, limiter *common.RateLimiter) (*PingResult, error) {
 	var out PingResult
 
 	pingOptions := &PingOptions{}
 	pingOptions.SetCount(count)
 	pingOptions.SetTimeout(timeout)
 	pingOptions.SetInterval(interval)
+	pingOptions.SetRandomPayload(randomPayload)
+	pingOptions.SetDSCP(dscp)
+	pingOptions.SetMark(mark)
+	pingOptions.SetDevice(device)
+	pingOptions.SetBurst(burst)
+	pingOptions.SetRateLimiter(limiter)
+	pingOptions.SetContext(ctx)
 
 	out, err := runPing(addr, ip, srcAddr, icmpID, pingOptions)
 	if err != nil {
@@ -54,40 +65,231 @@ func runPing(ipAddr string, ip string, srcAddr string, icmpID int, option *PingO
 	pingResult.DestAddr = ipAddr
 	pingResult.DestIp = ip
 
+	pingReturn := sendPing(ip, srcAddr, icmpID, option)
+	summarize(&pingResult, option.Count(), pingReturn)
+
+	return pingResult, option.Context().Err()
+}
+
+// PingFlows ICMP Operation spreading the echoes across several flows (distinct ICMP ids) running in parallel
+// ECMP members hashing on the ICMP id are exercised independently and reported per flow
+func PingFlows(ctx context.Context, addr string, ip string, srcAddr string, count int, interval time.Duration, timeout time.Duration, icmpIDs []int, randomPayload bool, dscp int, mark int, device string, burst bool, limiter *common.RateLimiter) (*PingResult, error) {
+	var out PingResult
+	out.DestAddr = addr
+	out.DestIp = ip
+
+	flows := len(icmpIDs)
+	if flows == 0 {
+		return &out, fmt.Errorf("at least one flow is required")
+	}
+	if flows > count {
+		flows = count
+	}
+
+	returns := make([]PingReturn, flows)
+	counts := make([]int, flows)
+	var wg sync.WaitGroup
+	for f := 0; f < flows; f++ {
+		// Count echoes distributed across the flows
+		counts[f] = count / flows
+		if f < count%flows {
+			counts[f]++
+		}
+
+		wg.Add(1)
+		go func(f int) {
+			defer wg.Done()
+			pingOptions := &PingOptions{}
+			pingOptions.SetCount(counts[f])
+			pingOptions.SetTimeout(timeout)
+			pingOptions.SetInterval(interval)
+			pingOptions.SetRandomPayload(randomPayload)
+			pingOptions.SetDSCP(dscp)
+			pingOptions.SetMark(mark)
+			pingOptions.SetDevice(device)
+			pingOptions.SetBurst(burst)
+			pingOptions.SetRateLimiter(limiter)
+			pingOptions.SetContext(ctx)
+			returns[f] = sendPing(ip, srcAddr, icmpIDs[f], pingOptions)
+		}(f)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return &out, err
+	}
+
+	total := PingReturn{}
+	for f := 0; f < flows; f++ {
+		flow := PingResult{DestAddr: addr, DestIp: ip}
+		summarize(&flow, counts[f], returns[f])
+		out.Flows = append(out.Flows, flow)
+		total.merge(returns[f])
+	}
+	summarize(&out, count, total)
+
+	return &out, nil
+}
+
+// PingClasses ICMP Operation sending a batch of count echoes per DSCP class, the batches run in parallel (distinct ICMP ids)
+// Each class is reported separately to compare their latency/loss, the overall result covers all the echoes
+func PingClasses(ctx context.Context, addr string, ip string, srcAddr string, count int, interval time.Duration, timeout time.Duration, icmpIDs []int, randomPayload bool, dscps []int, mark int, device string, burst bool, limiter *common.RateLimiter) (*PingResult, error) {
+	var out PingResult
+	out.DestAddr = addr
+	out.DestIp = ip
+
+	if len(dscps) == 0 || len(icmpIDs) != len(dscps) {
+		return &out, fmt.Errorf("one icmp id is required per dscp class")
+	}
+
+	returns := make([]PingReturn, len(dscps))
+	var wg sync.WaitGroup
+	for c := range dscps {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			pingOptions := &PingOptions{}
+			pingOptions.SetCount(count)
+			pingOptions.SetTimeout(timeout)
+			pingOptions.SetInterval(interval)
+			pingOptions.SetRandomPayload(randomPayload)
+			pingOptions.SetDSCP(dscps[c])
+			pingOptions.SetMark(mark)
+			pingOptions.SetDevice(device)
+			pingOptions.SetBurst(burst)
+			pingOptions.SetRateLimiter(limiter)
+			pingOptions.SetContext(ctx)
+			returns[c] = sendPing(ip, srcAddr, icmpIDs[c], pingOptions)
+		}(c)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return &out, err
+	}
+
+	total := PingReturn{}
+	for c := range dscps {
+		class := PingResult{DestAddr: addr, DestIp: ip, DSCP: dscps[c]}
+		summarize(&class, count, returns[c])
+		out.Classes = append(out.Classes, class)
+		total.merge(returns[c])
+	}
+	summarize(&out, count*len(dscps), total)
+	// The reply DSCP is only meaningful per class
+	out.ReplyDSCPValid = false
+
+	return &out, nil
+}
+
+// sendPing sends the echoes of a single flow
+func sendPing(ip string, srcAddr string, icmpID int, option *PingOptions) (pingReturn PingReturn) {
 	// Avoid collisions/interference caused by multiple coroutines initiating mtr
 	pid := icmpID
 	timeout := option.Timeout()
 	interval := option.Interval()
 	ttl := defaultTTL
-	pingReturn := PingReturn{}
+	if option.Burst() {
+		return sendBurst(ip, srcAddr, pid, option)
+	}
 
 	seq := 0
-	for cnt := 0; cnt < option.Count(); cnt++ {
-		icmpReturn, err := icmp.Icmp(ip, srcAddr, ttl, pid, timeout, seq)
+	for cnt := 0; cnt < option.Count() && option.Context().Err() == nil; cnt++ {
+		option.RateLimiter().Wait()
+		icmpReturn, err := icmp.Icmp(ip, srcAddr, ttl, pid, timeout, seq, option.RandomPayload(), option.DSCP(), option.Mark(), option.Device())
+		pingReturn.unexpected += icmpReturn.UnexpectedSources
 
+		pingReturn.lastAt = time.Now()
 		if err != nil || !icmpReturn.Success || !common.IsEqualIP(ip, icmpReturn.Addr) {
+			pingReturn.lastLost = true
 			continue
 		}
 
-		pingReturn.allTime = append(pingReturn.allTime, icmpReturn.Elapsed)
-
-		pingReturn.succSum++
-		if pingReturn.worstTime == time.Duration(0) || icmpReturn.Elapsed > pingReturn.worstTime {
-			pingReturn.worstTime = icmpReturn.Elapsed
+		pingReturn.add(icmpReturn.Elapsed)
+		pingReturn.lastTime = icmpReturn.Elapsed
+		pingReturn.lastLost = false
+		if icmpReturn.ReplyDSCPValid {
+			pingReturn.replyDSCP = icmpReturn.ReplyDSCP
+			pingReturn.replyDSCPValid = true
 		}
-		if pingReturn.bestTime == time.Duration(0) || icmpReturn.Elapsed < pingReturn.bestTime {
-			pingReturn.bestTime = icmpReturn.Elapsed
+		if icmpReturn.ReplyTTLValid {
+			pingReturn.replyTTL = icmpReturn.ReplyTTL
+			pingReturn.replyTTLValid = true
 		}
-		pingReturn.sumTime += icmpReturn.Elapsed
-		pingReturn.avgTime = time.Duration((int64)(pingReturn.sumTime/time.Microsecond)/(int64)(pingReturn.succSum)) * time.Microsecond
-		pingReturn.success = true
 
 		seq++
-		time.Sleep(interval)
+		select {
+		case <-time.After(interval):
+		case <-option.Context().Done():
+		}
 	}
 
+	return pingReturn
+}
+
+// sendBurst sends the echoes of a single flow without waiting for the replies
+func sendBurst(ip string, srcAddr string, icmpID int, option *PingOptions) (pingReturn PingReturn) {
+	if option.Context().Err() != nil {
+		return pingReturn
+	}
+	// The echoes not sent because of an error are lost
+	burst, _ := icmp.Burst(ip, srcAddr, icmpID, option.Count(), option.Interval(), option.Timeout(), option.RandomPayload(), option.DSCP(), option.Mark(), option.Device(), option.RateLimiter())
+	pingReturn.unexpected = burst.UnexpectedSources
+	pingReturn.outOfOrder = burst.OutOfOrder
+	pingReturn.lastAt = time.Now()
+	pingReturn.lastLost = true
+	for _, echo := range burst.Echoes {
+		pingReturn.lastLost = !echo.Success
+		if !echo.Success {
+			continue
+		}
+		pingReturn.add(echo.Elapsed)
+		pingReturn.lastTime = echo.Elapsed
+	}
+	return pingReturn
+}
+
+// add accounts a successful echo
+func (pingReturn *PingReturn) add(elapsed time.Duration) {
+	pingReturn.allTime = append(pingReturn.allTime, elapsed)
+
+	pingReturn.succSum++
+	if pingReturn.worstTime == time.Duration(0) || elapsed > pingReturn.worstTime {
+		pingReturn.worstTime = elapsed
+	}
+	if pingReturn.bestTime == time.Duration(0) || elapsed < pingReturn.bestTime {
+		pingReturn.bestTime = elapsed
+	}
+	pingReturn.sumTime += elapsed
+	pingReturn.avgTime = time.Duration((int64)(pingReturn.sumTime/time.Microsecond)/(int64)(pingReturn.succSum)) * time.Microsecond
+	pingReturn.success = true
+}
+
+// merge accounts the echoes of another flow
+func (pingReturn *PingReturn) merge(other PingReturn) {
+	pingReturn.unexpected += other.unexpected
+	pingReturn.outOfOrder += other.outOfOrder
+	if other.replyDSCPValid {
+		pingReturn.replyDSCP = other.replyDSCP
+		pingReturn.replyDSCPValid = true
+	}
+	if other.replyTTLValid {
+		pingReturn.replyTTL = other.replyTTL
+		pingReturn.replyTTLValid = true
+	}
+	// The most recent echo of all the flows
+	if other.lastAt.After(pingReturn.lastAt) {
+		pingReturn.lastTime = other.lastTime
+		pingReturn.lastLost = other.lastLost
+		pingReturn.lastAt = other.lastAt
+	}
+	for _, elapsed := range other.allTime {
+		pingReturn.add(elapsed)
+	}
+}
+
+// summarize calculates the results of count sent echoes
+func summarize(pingResult *PingResult, count int, pingReturn PingReturn) {
 	pingResult.Success = pingReturn.success
-	pingResult.DropRate = float64(option.Count()-pingReturn.succSum) / float64(option.Count())
+	pingResult.DropRate = float64(count-pingReturn.succSum) / float64(count)
 	pingResult.SumTime = pingReturn.sumTime
 	pingResult.AvgTime = pingReturn.avgTime
 	pingResult.BestTime = pingReturn.bestTime
@@ -96,9 +298,60 @@ func runPing(ipAddr string, ip string, srcAddr string, icmpID int, option *PingO
 	pingResult.UncorrectedSDTime = time.Duration(common.TimeUncorrectedDeviation(pingReturn.allTime))
 	pingResult.CorrectedSDTime = time.Duration(common.TimeCorrectedDeviation(pingReturn.allTime))
 	pingResult.RangeTime = time.Duration(common.TimeRange(pingReturn.allTime))
-	pingResult.SntSummary = option.Count()
-	pingResult.SntFailSummary = option.Count() - pingReturn.succSum
+	pingResult.SntSummary = count
+	pingResult.SntFailSummary = count - pingReturn.succSum
 	pingResult.SntTimeSummary = time.Duration(common.TimeRange(pingReturn.allTime))
+	pingResult.ReplyDSCP = pingReturn.replyDSCP
+	pingResult.ReplyDSCPValid = pingReturn.replyDSCPValid
+	pingResult.ReplyTTL = pingReturn.replyTTL
+	pingResult.ReplyTTLValid = pingReturn.replyTTLValid
+	pingResult.LastTime = pingReturn.lastTime
+	pingResult.LastLost = pingReturn.lastLost
+	pingResult.UnexpectedSources = pingReturn.unexpected
+	pingResult.OutOfOrder = pingReturn.outOfOrder
+	pingResult.Rtts = pingReturn.allTime
+}
+
+// HopCount estimates the hops to the target from the reply TTL (the target counts as a hop, directly connected = 1)
+// Without initialTTL the smallest common initial TTL >= ttl is assumed, 0 is returned when the reply doesn't fit
+func HopCount(ttl int, initialTTL int) int {
+	if initialTTL <= 0 {
+		for _, i := range initialTTLs {
+			if ttl <= i {
+				initialTTL = i
+				break
+			}
+		}
+	}
+	if ttl <= 0 || ttl > initialTTL {
+		return 0
+	}
+	return initialTTL - ttl + 1
+}
+
+// UpPolicy Loss tolerated by an up target in percent, negative = up with any reply
+type UpPolicy float64
+
+// ParseUpPolicy parses an up policy: any (default, at least one reply), all (no loss) or the max loss percentage (e.g. 20%)
+func ParseUpPolicy(spec string) (UpPolicy, error) {
+	switch spec {
+	case "", "any":
+		return -1, nil
+	case "all":
+		return 0, nil
+	}
+	p, err := strconv.ParseFloat(strings.TrimSuffix(spec, "%"), 64)
+	if err != nil || !strings.HasSuffix(spec, "%") || p < 0 || p >= 100 {
+		return 0, fmt.Errorf("up policy %q must be one of (any|all|<max loss>%%) with a max loss between 0%% and 100%%", spec)
+	}
+	return UpPolicy(p), nil
+}
 
-	return pingResult, nil
+// Up reports if the target is up given the result of a cycle
+func (p UpPolicy) Up(r *PingResult) bool {
+	if p < 0 {
+		return r.Success
+	}
+	// Tolerance of the float rounding (2 lost of 10 is 20.000000000000004%)
+	return r.SntSummary > 0 && r.DropRate*100 <= float64(p)+1e-9
 }
@@ -1,6 +1,11 @@
 package ping
 
-import "time"
+import (
+	"context"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+)
 
 const defaultTimeout = 5 * time.Second
 const defaultInterval = 10 * time.Millisecond
@@ -8,23 +13,57 @@ const defaultPackerSize = 56
 const defaultCount = 10
 const defaultTTL = 128
 
+// initialTTLs Common initial TTL/hop limit of the operating systems (the smallest one above the reply TTL is assumed)
+var initialTTLs = []int{32, 64, 128, 255}
+
+// MaxFlows Upper bound of parallel flows per target
+const MaxFlows = 16
+
+// MaxDSCPClasses Upper bound of the DSCP classes probed per target
+const MaxDSCPClasses = 8
+
+// MaxClassPackets Upper bound of the echoes per cycle of a target probing several DSCP classes (count * classes)
+const MaxClassPackets = 256
+
 // PingResult Calculated results
 type PingResult struct {
-	Success              bool          `json:"success"`
-	DestAddr             string        `json:"dest_address"`
-	DestIp               string        `json:"dest_ip"`
-	DropRate             float64       `json:"drop_rate"`
-	SumTime              time.Duration `json:"sum"`
-	BestTime             time.Duration `json:"best"`
-	AvgTime              time.Duration `json:"avg"`
-	WorstTime            time.Duration `json:"worst"`
-	SquaredDeviationTime time.Duration `json:"sd"`
-	UncorrectedSDTime    time.Duration `json:"usd"`
-	CorrectedSDTime      time.Duration `json:"csd"`
-	RangeTime            time.Duration `json:"range"`
-	SntSummary           int           `json:"snt_summary"`
-	SntFailSummary       int           `json:"snt_fail_summary"`
-	SntTimeSummary       time.Duration `json:"snt_time_summary"`
+	Success              bool                 `json:"success"`
+	DestAddr             string               `json:"dest_address"`
+	DestIp               string               `json:"dest_ip"`
+	DropRate             float64              `json:"drop_rate"`
+	SumTime              time.Duration        `json:"sum"`
+	BestTime             time.Duration        `json:"best"`
+	AvgTime              time.Duration        `json:"avg"`
+	WorstTime            time.Duration        `json:"worst"`
+	SquaredDeviationTime time.Duration        `json:"sd"`
+	UncorrectedSDTime    time.Duration        `json:"usd"`
+	CorrectedSDTime      time.Duration        `json:"csd"`
+	RangeTime            time.Duration        `json:"range"`
+	SntSummary           int                  `json:"snt_summary"`
+	SntFailSummary       int                  `json:"snt_fail_summary"`
+	SntTimeSummary       time.Duration        `json:"snt_time_summary"`
+	Flows                []PingResult         `json:"flows,omitempty"`
+	Classes              []PingResult         `json:"classes,omitempty"`
+	DSCP                 int                  `json:"dscp,omitempty"`
+	ReplyDSCP            int                  `json:"reply_dscp"`
+	ReplyDSCPValid       bool                 `json:"reply_dscp_valid"`
+	ReplyTTL             int                  `json:"reply_ttl"`
+	ReplyTTLValid        bool                 `json:"reply_ttl_valid"`
+	Hops                 int                  `json:"hops,omitempty"`
+	ExpectedHops         int                  `json:"expected_hops,omitempty"`
+	EffectiveTimeout     time.Duration        `json:"effective_timeout,omitempty"`
+	ClockOffset          time.Duration        `json:"clock_offset"`
+	ClockOffsetValid     bool                 `json:"clock_offset_valid"`
+	PathMTU              int                  `json:"path_mtu,omitempty"`
+	Jitter               time.Duration        `json:"jitter"`
+	Burst                bool                 `json:"burst,omitempty"`
+	OutOfOrder           int                  `json:"out_of_order"`
+	LastTime             time.Duration        `json:"last"`
+	LastLost             bool                 `json:"last_lost"`
+	UnexpectedSources    int                  `json:"unexpected_sources"`
+	Rtts                 []time.Duration      `json:"-"`
+	RttHistogram         *common.Histogram    `json:"rtt_histogram,omitempty"`
+	Windows              []common.WindowStats `json:"windows,omitempty"`
 }
 
 // PingReturn ICMP Response
@@ -36,6 +75,18 @@ type PingReturn struct {
 	bestTime  time.Duration
 	avgTime   time.Duration
 	worstTime time.Duration
+
+	replyDSCP      int
+	replyDSCPValid bool
+	replyTTL       int
+	replyTTLValid  bool
+
+	lastTime time.Duration
+	lastLost bool
+	lastAt   time.Time
+
+	unexpected int
+	outOfOrder int
 }
 
 // PingOptions ICMP Options
@@ -44,6 +95,13 @@ type PingOptions struct {
 	timeout    time.Duration
 	interval   time.Duration
 	packetSize int
+	random     bool
+	dscp       int
+	mark       int
+	device     string
+	burst      bool
+	limiter    *common.RateLimiter
+	ctx        context.Context
 }
 
 // Count Getter
@@ -97,3 +155,76 @@ func (options *PingOptions) PacketSize() int {
 func (options *PingOptions) SetPacketSize(packetSize int) {
 	options.packetSize = packetSize
 }
+
+// RandomPayload Getter
+func (options *PingOptions) RandomPayload() bool {
+	return options.random
+}
+
+// SetRandomPayload Setter
+func (options *PingOptions) SetRandomPayload(random bool) {
+	options.random = random
+}
+
+// DSCP Getter
+func (options *PingOptions) DSCP() int {
+	return options.dscp
+}
+
+// SetDSCP Setter
+func (options *PingOptions) SetDSCP(dscp int) {
+	options.dscp = dscp
+}
+
+// Mark Getter
+func (options *PingOptions) Mark() int {
+	return options.mark
+}
+
+// SetMark Setter
+func (options *PingOptions) SetMark(mark int) {
+	options.mark = mark
+}
+
+// Device Getter
+func (options *PingOptions) Device() string {
+	return options.device
+}
+
+// SetDevice Setter
+func (options *PingOptions) SetDevice(device string) {
+	options.device = device
+}
+
+// Burst Getter
+func (options *PingOptions) Burst() bool {
+	return options.burst
+}
+
+// SetBurst Setter
+func (options *PingOptions) SetBurst(burst bool) {
+	options.burst = burst
+}
+
+// RateLimiter Getter
+func (options *PingOptions) RateLimiter() *common.RateLimiter {
+	return options.limiter
+}
+
+// SetRateLimiter Setter
+func (options *PingOptions) SetRateLimiter(limiter *common.RateLimiter) {
+	options.limiter = limiter
+}
+
+// Context Getter
+func (options *PingOptions) Context() context.Context {
+	if options.ctx == nil {
+		options.ctx = context.Background()
+	}
+	return options.ctx
+}
+
+// SetContext Setter, no echo is sent once the context is done
+func (options *PingOptions) SetContext(ctx context.Context) {
+	options.ctx = ctx
+}
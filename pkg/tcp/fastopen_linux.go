@@ -0,0 +1,101 @@
+//go:build linux
+// +build linux
+
+package tcp
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fastOpenControl enables TCP Fast Open on the client socket before connecting
+func fastOpenControl(network, address string, c syscall.RawConn) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// fastOpenSupported TFO can be requested on this platform
+func fastOpenSupported() bool {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(fd)
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1) == nil
+}
+
+// tcpiOptSynData TCPI_OPT_SYN_DATA of the tcp_info options (linux/tcp.h): the data sent in the SYN was acknowledged by the peer
+const tcpiOptSynData = 0x20
+
+// fastOpenHandshake completes a deferred TFO connect (a cookie was cached so the kernel postponed the SYN)
+// The data (the tcp-send request) is sent in the SYN, otherwise a zero length send generates the SYN carrying the cookie
+// Returns if the peer acknowledged data in the SYN (TCP_INFO) and the number of bytes of data sent
+func fastOpenHandshake(conn net.Conn, data []byte) (bool, int, error) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return false, 0, nil
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return false, 0, err
+	}
+
+	written := 0
+	triggered := false
+	var serr error
+	err = rc.Write(func(fd uintptr) bool {
+		info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+		if err != nil {
+			serr = err
+			return true
+		}
+		if info.State != unix.BPF_TCP_SYN_SENT {
+			return true
+		}
+		if !triggered {
+			triggered = true
+			if len(data) > 0 {
+				n, err := unix.Write(int(fd), data)
+				if err != nil && err != unix.EINPROGRESS && err != unix.EAGAIN {
+					serr = err
+					return true
+				}
+				if n > 0 {
+					written = n
+				}
+			} else if err := unix.Sendto(int(fd), nil, 0, nil); err != nil && err != unix.EINPROGRESS && err != unix.EAGAIN {
+				serr = err
+				return true
+			}
+		}
+		// Wait until the socket becomes writable (established)
+		return false
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return false, written, err
+	}
+
+	used := false
+	err = rc.Control(func(fd uintptr) {
+		info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+		if err != nil {
+			serr = err
+			return
+		}
+		used = info.Options&tcpiOptSynData != 0
+	})
+	if err == nil {
+		err = serr
+	}
+	return used, written, err
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// fastOpenControl TFO is only implemented on Linux
+func fastOpenControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("tcp fast open is not supported on this platform")
+}
+
+// fastOpenSupported TFO can be requested on this platform
+func fastOpenSupported() bool {
+	return false
+}
+
+// fastOpenHandshake TFO is only implemented on Linux
+func fastOpenHandshake(conn net.Conn, data []byte) (bool, int, error) {
+	return false, 0, nil
+}
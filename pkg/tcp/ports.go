@@ -0,0 +1,44 @@
+package tcp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxPorts Upper bound of the ports probed by a single target
+const MaxPorts = 64
+
+// ParsePorts parses a port (443) or a comma separated list of ports and inclusive ranges (22,80,8000-8010)
+func ParsePorts(spec string) ([]string, error) {
+	ports := []string{}
+	seen := map[int]bool{}
+	for _, item := range strings.Split(spec, ",") {
+		lo, hi, found := strings.Cut(item, "-")
+		if !found {
+			hi = lo
+		}
+		min, err := parsePort(lo)
+		if err != nil {
+			return nil, err
+		}
+		max, err := parsePort(hi)
+		if err != nil {
+			return nil, err
+		}
+		if min > max {
+			return nil, fmt.Errorf("port range %s is reversed", item)
+		}
+		for p := min; p <= max; p++ {
+			if seen[p] {
+				continue
+			}
+			if len(ports) == MaxPorts {
+				return nil, fmt.Errorf("ports %s exceed the maximum of %d ports per target", spec, MaxPorts)
+			}
+			seen[p] = true
+			ports = append(ports, strconv.Itoa(p))
+		}
+	}
+	return ports, nil
+}
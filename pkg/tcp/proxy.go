@@ -0,0 +1,196 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// socks5Replies SOCKS5 (RFC 1928) reply codes
+var socks5Replies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// ParseProxy parses a socks5://[user:password@]host:port or http://[user:password@]host:port (CONNECT) proxy URL
+func ParseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("proxy %s: unsupported scheme %q (socks5 or http)", proxy, u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("proxy %s: host:port required", proxy)
+	}
+	return u, nil
+}
+
+// dialProxy connects to the proxy with the dialer then opens the tunnel to addr (host:port, the host is resolved by the proxy)
+// Returns the tunneled connection and the time of the connect to the proxy, the handshakes must complete within timeout
+func dialProxy(ctx context.Context, d *net.Dialer, proxy *url.URL, addr string, timeout time.Duration) (net.Conn, time.Duration, error) {
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", proxy.Host)
+	proxyTime := time.Since(start)
+	if err != nil {
+		return nil, proxyTime, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+	}
+	if err := conn.SetDeadline(start.Add(timeout)); err != nil {
+		conn.Close()
+		return nil, proxyTime, err
+	}
+
+	var tunnel net.Conn
+	if proxy.Scheme == "http" {
+		tunnel, err = httpConnect(conn, proxy, addr)
+	} else {
+		tunnel, err = socks5Connect(conn, proxy, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, proxyTime, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+	}
+	return tunnel, proxyTime, nil
+}
+
+// socks5Connect runs the SOCKS5 CONNECT handshake, with the user:password authentication when the proxy URL has them
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	method := byte(0)
+	if proxy.User != nil {
+		method = 2
+	}
+	if _, err := conn.Write([]byte{5, 1, method}); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	if b[0] != 5 || b[1] != method {
+		return nil, fmt.Errorf("socks5 authentication method refused")
+	}
+	if method == 2 {
+		user := proxy.User.Username()
+		pass, _ := proxy.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return nil, fmt.Errorf("socks5 user or password too long")
+		}
+		req := append([]byte{1, byte(len(user))}, user...)
+		req = append(append(req, byte(len(pass))), pass...)
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		if b[1] != 0 {
+			return nil, fmt.Errorf("socks5 authentication failed")
+		}
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("socks5 host name too long")
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	// Reply: version, code, reserved, bound address (type, address, port)
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if reply[1] != 0 {
+		if msg, found := socks5Replies[reply[1]]; found {
+			return nil, fmt.Errorf("socks5 connect: %s", msg)
+		}
+		return nil, fmt.Errorf("socks5 connect: reply %d", reply[1])
+	}
+	var skip int
+	switch reply[3] {
+	case 1:
+		skip = net.IPv4len - 1 + 2
+	case 4:
+		skip = net.IPv6len - 1 + 2
+	case 3:
+		skip = int(reply[4]) + 2
+	default:
+		return nil, fmt.Errorf("socks5 connect: address type %d", reply[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip)); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// httpConnect opens the tunnel with an HTTP CONNECT request, with the basic authentication when the proxy URL has a user:password
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if proxy.User != nil {
+		pass, _ := proxy.User.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(proxy.User.Username()+":"+pass)) + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http connect: %s", resp.Status)
+	}
+	// A server speaking first (banners) may already be buffered
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn Connection whose first bytes were read ahead
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
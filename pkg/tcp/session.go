@@ -0,0 +1,110 @@
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// anyResponse matches the first byte of a response (round trips without an expect pattern)
+var anyResponse = regexp.MustCompile(`(?s).`)
+
+// Session Persistent connection of a target port (reuse-connection), the probes measure a round trip on it
+// The connection is dropped after a failed round trip and re-established by the next probe
+type Session struct {
+	mtx     sync.Mutex
+	conn    net.Conn
+	conTime time.Duration
+	prxTime time.Duration
+	tls     *TLSReturn
+}
+
+// Close closes the persistent connection
+func (s *Session) Close() {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.drop()
+}
+
+func (s *Session) drop() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// keep holds the connection of a successful probe, it is closed otherwise
+func (s *Session) keep(conn net.Conn, out *TCPPortReturn) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !out.Success {
+		conn.Close()
+		return
+	}
+	s.drop()
+	s.conn = conn
+	s.conTime = out.ConTime
+	s.prxTime = out.ProxyConTime
+	s.tls = out.TLS
+}
+
+// reuse runs the round trip on the held connection, false when there is no connection or the round trip failed (the connection is then dropped)
+func (s *Session) reuse(out *TCPPortReturn, timeout time.Duration, send string, expect *regexp.Regexp) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.conn == nil {
+		return false
+	}
+
+	out.Reused = true
+	out.ConTime = s.conTime
+	out.ProxyConTime = s.prxTime
+	out.TLS = s.tls
+	addr := s.conn.LocalAddr().(*net.TCPAddr)
+	out.SrcIp = addr.IP.String()
+	out.SrcPort = addr.Port
+	if err := s.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		s.drop()
+		return false
+	}
+	if err := roundTrip(s.conn, out, send, expect); err != nil {
+		out.Error = err.Error()
+		s.drop()
+		return false
+	}
+	out.Success = true
+	return true
+}
+
+// roundTrip writes the send payload and reads the response until it matches expect (or any response without expect)
+func roundTrip(conn net.Conn, out *TCPPortReturn, send string, expect *regexp.Regexp) error {
+	out.RoundTripChecked = true
+	re := expect
+	if re == nil {
+		re = anyResponse
+	}
+
+	start := time.Now()
+	if _, err := conn.Write([]byte(send)); err != nil {
+		return err
+	}
+	var matched bool
+	out.ResponseBytes, matched = readExpect(conn, re)
+	out.RoundTrip = time.Since(start)
+	if expect != nil {
+		out.ExpectChecked = true
+		out.ExpectMatched = matched
+	}
+	if !matched {
+		if expect == nil {
+			return fmt.Errorf("no response on the connection")
+		}
+		return fmt.Errorf("response (%d bytes) doesn't match the expected pattern", out.ResponseBytes)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// maxSourcePortAttempts Upper bound of the source ports tried per connect when they are in use
+const maxSourcePortAttempts = 4
+
+// SourcePorts Source ports of the probes, rotated on every connect (spreads the TIME_WAIT sockets across the range)
+type SourcePorts struct {
+	min  int
+	max  int
+	next uint32
+}
+
+// ParseSourcePorts parses a source port (40000) or an inclusive range (40000-40100), an empty spec returns nil (ephemeral port)
+func ParseSourcePorts(spec string) (*SourcePorts, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	lo, hi, found := strings.Cut(spec, "-")
+	if !found {
+		hi = lo
+	}
+	min, err := parsePort(lo)
+	if err != nil {
+		return nil, err
+	}
+	max, err := parsePort(hi)
+	if err != nil {
+		return nil, err
+	}
+	if min > max {
+		return nil, fmt.Errorf("source port range %s is reversed", spec)
+	}
+	// Random start so the targets sharing the range don't compete for the same ports
+	return &SourcePorts{min: min, max: max, next: uint32(rand.Intn(max - min + 1))}, nil
+}
+
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("port %q must be between 1 and 65535", s)
+	}
+	return p, nil
+}
+
+// Next returns the next source port of the range (0 = ephemeral port)
+func (s *SourcePorts) Next() int {
+	if s == nil {
+		return 0
+	}
+	n := atomic.AddUint32(&s.next, 1) - 1
+	return s.min + int(n%uint32(s.max-s.min+1))
+}
+
+// attempts number of ports tried per connect
+func (s *SourcePorts) attempts() int {
+	if s == nil {
+		return 1
+	}
+	if n := s.max - s.min + 1; n < maxSourcePortAttempts {
+		return n
+	}
+	return maxSourcePortAttempts
+}
+
+// String returns the spec of the range
+func (s *SourcePorts) String() string {
+	if s == nil {
+		return ""
+	}
+	if s.min == s.max {
+		return strconv.Itoa(s.min)
+	}
+	return fmt.Sprintf("%d-%d", s.min, s.max)
+}
+
+// addrInUse the source port is taken (bound by another socket or the same connection is in TIME_WAIT)
+func addrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, syscall.EADDRNOTAVAIL)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package tcp
+
+import (
+	"syscall"
+)
+
+// reuseAddrControl the source ports held by TIME_WAIT sockets are skipped by the rotation
+func reuseAddrControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
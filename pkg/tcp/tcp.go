@@ -1,13 +1,28 @@
 package tcp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
+	"regexp"
+	"syscall"
 	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
 )
 
 // Port TCP Operation
-func Port(destAddr string, ip string, srcAddr string, port string, interval time.Duration, timeout time.Duration) (*TCPPortReturn, error) {
+// With srcPorts the connect is bound to the next source port of the range, the following ports are tried while they are in use
+// A non zero dscp marks the packets, a non zero mark sets the firewall mark of the socket and a device binds it to the network interface (Linux only)
+// Once connected the send payload is written and the response is read until it matches expect (the probe fails otherwise)
+// With a session the round trip runs on its persistent connection, a new connection is only made when it's missing or broken
+// With tlsOpts the TLS handshake runs once connected, the payload and the response are then exchanged over TLS
+// With a proxy (SOCKS5 or HTTP CONNECT) the socket options apply to the connection to the proxy, which connects to ip (an address or a host name)
+// The connect is aborted once ctx is done
+func Port(ctx context.Context, destAddr string, ip string, srcAddr string, srcPorts *SourcePorts, dscp int, mark int, device string, port string, interval time.Duration, timeout time.Duration, fastOpen bool, resetWait time.Duration, send string, expect *regexp.Regexp, tlsOpts *TLSOptions, proxy *url.URL, session *Session) (*TCPPortReturn, error) {
 	var out TCPPortReturn
 	var d net.Dialer
 	var err error
@@ -15,35 +30,103 @@ func Port(destAddr string, ip string, srcAddr string, port string, interval time
 	tcpOptions := &TCPPortOptions{}
 	tcpOptions.SetInterval(interval)
 	tcpOptions.SetTimeout(timeout)
+	tcpOptions.SetFastOpen(fastOpen)
 
 	out.DestAddr = destAddr
 	out.DestIp = ip
 	out.DestPort = port
+	out.Proxied = proxy != nil
 
+	if session != nil {
+		out.Reuse = true
+		reused := out
+		if session.reuse(&reused, tcpOptions.Timeout(), send, expect) {
+			return &reused, nil
+		}
+	}
+
+	var srcIp net.IP
 	if srcAddr != "" {
-		srcIp := net.ParseIP(srcAddr)
+		srcIp = net.ParseIP(srcAddr)
 		if srcIp == nil {
 			out.Success = false
 			return &out, fmt.Errorf("source ip: %v is invalid, TCP target: %v", srcAddr, destAddr)
 		}
-		d = net.Dialer{
-			LocalAddr: &net.TCPAddr{
-				IP:   srcIp,
-				Port: 0,
-			},
-			Timeout: tcpOptions.Timeout(),
-		}
-	} else {
-		d = net.Dialer{
-			Timeout: tcpOptions.Timeout(),
+	}
+	d = net.Dialer{
+		Timeout: tcpOptions.Timeout(),
+	}
+
+	// Fallback to a regular connect when TFO can not be requested
+	out.FastOpenRequested = tcpOptions.FastOpen()
+	if tcpOptions.FastOpen() && fastOpenSupported() && proxy == nil {
+		out.FastOpenAvailable = true
+		d.Control = fastOpenControl
+	}
+	if srcPorts != nil || dscp != 0 || mark != 0 || device != "" {
+		control := d.Control
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			if control != nil {
+				if err := control(network, address, c); err != nil {
+					return err
+				}
+			}
+			if err := common.SetDSCP(c, network == "tcp4", dscp); err != nil {
+				return err
+			}
+			if err := common.SetMark(c, mark); err != nil {
+				return err
+			}
+			if err := common.SetDevice(c, device); err != nil {
+				return err
+			}
+			if srcPorts == nil {
+				return nil
+			}
+			return reuseAddrControl(network, address, c)
 		}
 	}
 
-	start := time.Now()
-	conn, err := d.Dial("tcp", net.JoinHostPort(ip, port))
+	var conn net.Conn
+	var start time.Time
+	for attempt := 0; attempt < srcPorts.attempts(); attempt++ {
+		if srcIp != nil || srcPorts != nil {
+			out.SrcPort = srcPorts.Next()
+			d.LocalAddr = &net.TCPAddr{IP: srcIp, Port: out.SrcPort}
+		}
+		start = time.Now()
+		if proxy != nil {
+			conn, out.ProxyConTime, err = dialProxy(ctx, &d, proxy, net.JoinHostPort(ip, port), tcpOptions.Timeout())
+		} else {
+			conn, err = d.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		}
+		if err == nil || srcPorts == nil || !addrInUse(err) {
+			break
+		}
+	}
+	// The request of the plain (no TLS, no persistent connection) targets is sent in the SYN
+	sent := 0
+	if err == nil && out.FastOpenAvailable {
+		var data []byte
+		if tlsOpts == nil && session == nil {
+			data = []byte(send)
+		}
+		if err := conn.SetDeadline(start.Add(tcpOptions.Timeout())); err == nil {
+			out.FastOpenUsed, sent, err = fastOpenHandshake(conn, data)
+			if err != nil {
+				conn.Close()
+				out.ConTime = time.Since(start)
+				out.SrcIp = "0.0.0.0"
+				out.Error = err.Error()
+				out.Success = false
+				return &out, nil
+			}
+		}
+	}
 	out.ConTime = time.Since(start)
 	if err != nil {
 		out.SrcIp = "0.0.0.0"
+		out.Error = err.Error()
 	} else {
 		out.SrcIp = conn.LocalAddr().(*net.TCPAddr).IP.String()
 	}
@@ -51,7 +134,12 @@ func Port(destAddr string, ip string, srcAddr string, port string, interval time
 	if err != nil {
 		out.Success = false
 	} else {
-		defer conn.Close()
+		// conn is replaced by the TLS connection once established
+		if session != nil {
+			defer func() { session.keep(conn, &out) }()
+		} else {
+			defer func() { conn.Close() }()
+		}
 
 		// Set Deadline timeout
 		if err := conn.SetDeadline(time.Now().Add(tcpOptions.Timeout())); err != nil {
@@ -64,7 +152,82 @@ func Port(destAddr string, ip string, srcAddr string, port string, interval time
 		} else {
 			out.Success = false
 		}
+
+		if out.Success && tlsOpts != nil {
+			if conn, err = tlsHandshake(conn, tlsOpts, &out); err != nil {
+				out.Success = false
+				out.Error = err.Error()
+				return &out, nil
+			}
+		}
+
+		if out.Success && session != nil {
+			if err := roundTrip(conn, &out, send, expect); err != nil {
+				out.Success = false
+				out.Error = err.Error()
+			}
+			return &out, nil
+		}
+		if out.Success && len(send) > sent {
+			if _, err := conn.Write([]byte(send)[sent:]); err != nil {
+				out.Success = false
+				out.Error = err.Error()
+				return &out, nil
+			}
+		}
+		if out.Success && expect != nil {
+			out.ExpectChecked = true
+			out.ResponseBytes, out.ExpectMatched = readExpect(conn, expect)
+			if !out.ExpectMatched {
+				out.Success = false
+				out.Error = fmt.Sprintf("response (%d bytes) doesn't match the expected pattern", out.ResponseBytes)
+				return &out, nil
+			}
+		}
+
+		// Accepting but resetting (SYN-cookies/firewall/LB without a listening backend)
+		if out.Success && resetWait > 0 {
+			out.ResetChecked = true
+			out.ResetAfterConnect = resetAfterConnect(conn, resetWait)
+		}
 	}
 
 	return &out, nil
 }
+
+// readExpect reads the response until it matches expect, the peer closes or the deadline/MaxResponseBytes is reached
+// Returns the number of bytes read (a short read hints at a truncated response)
+func readExpect(conn net.Conn, expect *regexp.Regexp) (int, bool) {
+	buf := make([]byte, 0, 4096)
+	for len(buf) < MaxResponseBytes {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := conn.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if expect.Match(buf) {
+			return len(buf), true
+		}
+		if err != nil {
+			break
+		}
+	}
+	return len(buf), false
+}
+
+// resetAfterConnect waits up to wait for the peer to close (FIN) or reset (RST) the established connection
+// Data or silence until the deadline means that something is really serving the port
+func resetAfterConnect(conn net.Conn, wait time.Duration) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return false
+	}
+	b := make([]byte, 1)
+	_, err := conn.Read(b)
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	return false
+}
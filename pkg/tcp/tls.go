@@ -0,0 +1,63 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TLSOptions TLS handshake of the connection (targets with tls: true)
+type TLSOptions struct {
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// TLSReturn Results of the TLS handshake
+type TLSReturn struct {
+	Handshake  time.Duration `json:"handshake"`
+	Version    string        `json:"version"`
+	Cipher     string        `json:"cipher"`
+	ServerName string        `json:"server_name"`
+	Verified   bool          `json:"verified"`
+	NotBefore  time.Time     `json:"cert_not_before"`
+	NotAfter   time.Time     `json:"cert_not_after"`
+}
+
+// tlsHandshake runs the TLS handshake on the established connection
+// The chain is verified after the handshake so the certificate validity is reported even when the verification fails
+func tlsHandshake(conn net.Conn, opts *TLSOptions, out *TCPPortReturn) (net.Conn, error) {
+	out.TLS = &TLSReturn{ServerName: opts.ServerName}
+	tc := tls.Client(conn, &tls.Config{ServerName: opts.ServerName, InsecureSkipVerify: true})
+
+	start := time.Now()
+	err := tc.Handshake()
+	out.TLS.Handshake = time.Since(start)
+	if err != nil {
+		return tc, fmt.Errorf("tls handshake: %w", err)
+	}
+
+	state := tc.ConnectionState()
+	out.TLS.Version = tls.VersionName(state.Version)
+	out.TLS.Cipher = tls.CipherSuiteName(state.CipherSuite)
+	if len(state.PeerCertificates) == 0 {
+		return tc, fmt.Errorf("tls handshake: no peer certificate")
+	}
+	cert := state.PeerCertificates[0]
+	out.TLS.NotBefore = cert.NotBefore
+	out.TLS.NotAfter = cert.NotAfter
+
+	if opts.InsecureSkipVerify {
+		return tc, nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: opts.ServerName, Intermediates: intermediates}); err != nil {
+		return tc, fmt.Errorf("tls verify: %w", err)
+	}
+	out.TLS.Verified = true
+	return tc, nil
+}
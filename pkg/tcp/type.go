@@ -1,10 +1,20 @@
 package tcp
 
-import "time"
+import (
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+)
 
 const defaultTimeout = 5 * time.Second
 const defaultInterval = 10 * time.Millisecond
 
+// MaxWarmup Upper bound of warm-up connects per cycle
+const MaxWarmup = 10
+
+// MaxResponseBytes Upper bound of the response read while matching the expect pattern
+const MaxResponseBytes = 64 * 1024
+
 // TCPPortReturn Calculated results
 type TCPPortReturn struct {
 	Success  bool          `json:"success"`
@@ -12,13 +22,49 @@ type TCPPortReturn struct {
 	DestIp   string        `json:"dest_ip"`
 	DestPort string        `json:"dest_port"`
 	SrcIp    string        `json:"src_ip"`
+	SrcPort  int           `json:"src_port,omitempty"`
 	ConTime  time.Duration `json:"connection_time"`
+	Error    string        `json:"error,omitempty"`
+
+	// Connections through a proxy: ConTime includes the connect to the proxy (ProxyConTime) and the tunnel setup
+	Proxied      bool          `json:"proxied"`
+	ProxyConTime time.Duration `json:"proxy_connection_time,omitempty"`
+
+	FastOpenRequested bool `json:"fast_open_requested"`
+	FastOpenAvailable bool `json:"fast_open_available"`
+	FastOpenUsed      bool `json:"fast_open_used"`
+
+	ResetChecked      bool `json:"reset_checked"`
+	ResetAfterConnect bool `json:"reset_after_connect"`
+
+	ExpectChecked bool `json:"expect_checked"`
+	ExpectMatched bool `json:"expect_matched"`
+	ResponseBytes int  `json:"response_bytes"`
+
+	Reuse            bool          `json:"reuse"`
+	Reused           bool          `json:"reused"`
+	RoundTripChecked bool          `json:"round_trip_checked"`
+	RoundTrip        time.Duration `json:"round_trip"`
+
+	// TLS handshake of the connection (targets with tls: true)
+	TLS *TLSReturn `json:"tls,omitempty"`
+
+	Warmup       int `json:"warmup"`
+	WarmupFailed int `json:"warmup_failed"`
+
+	// Cumulative connects of the target port (since the target was started)
+	Attempts  uint64 `json:"attempts"`
+	Successes uint64 `json:"successes"`
+
+	// Cumulative connection times of the successful connects (tcp.histogram-buckets)
+	ConHistogram *common.Histogram `json:"connection_histogram,omitempty"`
 }
 
 // TCPPortOptions ICMP Options
 type TCPPortOptions struct {
 	timeout  time.Duration
 	interval time.Duration
+	fastOpen bool
 }
 
 // Timeout Getter
@@ -46,3 +92,13 @@ func (options *TCPPortOptions) Interval() time.Duration {
 func (options *TCPPortOptions) SetInterval(interval time.Duration) {
 	options.interval = interval
 }
+
+// FastOpen Getter
+func (options *TCPPortOptions) FastOpen() bool {
+	return options.fastOpen
+}
+
+// SetFastOpen Setter
+func (options *TCPPortOptions) SetFastOpen(fastOpen bool) {
+	options.fastOpen = fastOpen
+}
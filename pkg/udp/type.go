@@ -0,0 +1,30 @@
+package udp
+
+import "time"
+
+// MaxResponseBytes Upper bound of a response datagram
+const MaxResponseBytes = 64 * 1024
+
+// Failure reasons of a check
+const (
+	ReasonTimeout  = "timeout"  // No response within the timeout
+	ReasonRefused  = "refused"  // ICMP port unreachable received
+	ReasonMismatch = "mismatch" // Responses received, none matched the expected pattern
+	ReasonError    = "error"    // Socket or send error
+)
+
+// Reasons failure reasons exported by the UDP targets
+var Reasons = []string{ReasonTimeout, ReasonRefused, ReasonMismatch, ReasonError}
+
+// UDPReturn Calculated results
+type UDPReturn struct {
+	Success       bool          `json:"success"`
+	DestAddr      string        `json:"dest_address"`
+	DestIp        string        `json:"dest_ip"`
+	DestPort      string        `json:"dest_port"`
+	SrcIp         string        `json:"src_ip"`
+	Rtt           time.Duration `json:"rtt"`
+	ResponseBytes int           `json:"response_bytes"`
+	ExpectChecked bool          `json:"expect_checked"`
+	Reason        string        `json:"reason,omitempty"`
+}
@@ -0,0 +1,94 @@
+package udp
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"syscall"
+	"time"
+)
+
+// Payload returns the datagram sent to the target, the hex one (udp-send-hex) takes precedence
+func Payload(send string, sendHex string) ([]byte, error) {
+	if sendHex == "" {
+		return []byte(send), nil
+	}
+	b, err := hex.DecodeString(sendHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex payload: %w", err)
+	}
+	return b, nil
+}
+
+// Check sends the payload to ip:port and waits for a response (matching expect when set) until the timeout
+// The datagrams not matching expect are skipped, the rtt is the time to the first accepted response
+func Check(host string, ip string, srcAddr string, port string, payload []byte, expect *regexp.Regexp, timeout time.Duration) (*UDPReturn, error) {
+	out := &UDPReturn{DestAddr: host, DestIp: ip, DestPort: port, SrcIp: "0.0.0.0", ExpectChecked: expect != nil}
+
+	d := net.Dialer{Timeout: timeout}
+	if srcAddr != "" {
+		srcIp := net.ParseIP(srcAddr)
+		if srcIp == nil {
+			out.Reason = ReasonError
+			return out, fmt.Errorf("source ip: %v is invalid, UDP target: %v", srcAddr, host)
+		}
+		d.LocalAddr = &net.UDPAddr{IP: srcIp}
+	}
+
+	// Connected socket, the ICMP port unreachable of the target is reported on the read
+	conn, err := d.Dial("udp", net.JoinHostPort(ip, port))
+	if err != nil {
+		out.Reason = ReasonError
+		return out, err
+	}
+	defer conn.Close()
+	if a, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		out.SrcIp = a.IP.String()
+	}
+
+	start := time.Now()
+	if err := conn.SetDeadline(start.Add(timeout)); err != nil {
+		out.Reason = ReasonError
+		return out, err
+	}
+	if _, err := conn.Write(payload); err != nil {
+		out.Reason = reason(err)
+		return out, err
+	}
+
+	buf := make([]byte, MaxResponseBytes)
+	mismatched := 0
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			out.Reason = reason(err)
+			if out.Reason == ReasonTimeout && mismatched > 0 {
+				out.Reason = ReasonMismatch
+				return out, fmt.Errorf("%d response(s) not matching %s", mismatched, expect)
+			}
+			return out, err
+		}
+		out.ResponseBytes = n
+		if expect != nil && !expect.Match(buf[:n]) {
+			mismatched++
+			continue
+		}
+		out.Rtt = time.Since(start)
+		out.Success = true
+		return out, nil
+	}
+}
+
+// reason failure reason of a socket error
+func reason(err error) string {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ReasonTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReasonRefused
+	}
+	return ReasonError
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/syepes/network_exporter/collector"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/monitor"
+	"github.com/syepes/network_exporter/target"
+)
+
+// probeTimeoutOffset Margin left on the scrape timeout to render the response
+const probeTimeoutOffset = 500 * time.Millisecond
+
+// probeModules target types of the /probe modules
+var probeModules = map[string]string{"icmp": "ICMP", "mtr": "MTR", "icmp+mtr": "ICMP+MTR", "tcp": "TCP"}
+
+// probeHandler runs a single check of the target on demand (/probe?target=<host>&module=<icmp|mtr|icmp+mtr|tcp>) with the settings of the module protocol section
+// The check shares the concurrency and pacing limits of the scheduled targets and is bounded by the Prometheus scrape timeout
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("target")
+	module := strings.ToLower(r.URL.Query().Get("module"))
+	if module == "" {
+		module = "icmp"
+	}
+	typ, found := probeModules[module]
+	if !found {
+		http.Error(w, fmt.Sprintf("unknown module %q must be one of (icmp|mtr|icmp+mtr|tcp)", module), http.StatusBadRequest)
+		return
+	}
+	if host == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := net.SplitHostPort(host); typ == "TCP" && err != nil {
+		http.Error(w, "tcp target must be host:port", http.StatusBadRequest)
+		return
+	}
+
+	// A single cycle right away, the echoes keep the configured inter-packet-interval (otherwise back to back)
+	sc.RLock()
+	cfg := *sc.Cfg
+	sc.RUnlock()
+	cfg.Targets = config.Targets{{Name: host, Host: host, Type: typ, Resolve: config.IPVersionResolve(cfg.Conf.IPVersion)}}
+	cfg.ICMP.Interval.Set(0)
+	cfg.MTR.Interval.Set(0)
+	cfg.TCP.Interval.Set(0)
+	psc := &config.SafeConfig{Cfg: &cfg}
+	// The lookups of the on-demand targets are not kept in the refresh pass of the scheduled ones
+	pres := &config.Resolver{Resolver: resolver.Resolver, HostsFile: resolver.HostsFile, Breaker: resolver.Breaker, GeoIP: resolver.GeoIP, Timeout: resolver.Timeout}
+
+	reg := prometheus.NewRegistry()
+	monitors := []probeMonitor{}
+	if typ == "ICMP" || typ == "ICMP+MTR" {
+		m := monitor.NewPing(logger, psc, pres, icmpID, rateLimiter, probeSem, probePools, loadShedder)
+		reg.MustRegister(&collector.PING{SC: psc, Monitor: m})
+		monitors = append(monitors, m)
+	}
+	if typ == "MTR" || typ == "ICMP+MTR" {
+		m := monitor.NewMTR(logger, psc, pres, icmpID, rateLimiter, probeSem, probePools, loadShedder)
+		reg.MustRegister(&collector.MTR{SC: psc, Monitor: m, GeoIP: pres.GeoIP})
+		monitors = append(monitors, m)
+	}
+	if typ == "TCP" {
+		m := monitor.NewTCPPort(logger, psc, pres, probeSem, probePools, loadShedder)
+		reg.MustRegister(&collector.TCP{SC: psc, Monitor: m})
+		monitors = append(monitors, m)
+	}
+
+	start := time.Now()
+	for _, m := range monitors {
+		m.AddTargets()
+	}
+	// Stopping waits for the running cycle (timed out check), the response doesn't wait for it
+	defer func() {
+		go func() {
+			for _, m := range monitors {
+				m.Stop()
+			}
+		}()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, m := range monitors {
+			m.Wait()
+		}
+	}()
+	var deadline <-chan time.Time
+	if t := probeTimeout(r); t > 0 {
+		timer := time.NewTimer(t)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	finished := true
+	select {
+	case <-done:
+	case <-deadline:
+		finished = false
+		level.Warn(logger).Log("msg", "On-demand probe timed out", "target", host, "module", module)
+	case <-r.Context().Done():
+		return
+	}
+
+	// Successful when every sub-probe of the target was started and completed its cycle
+	ok := finished
+	for _, m := range monitors {
+		stats := m.ExportStats()
+		if len(stats) == 0 {
+			ok = false
+		}
+		for _, st := range stats {
+			if st.LastSuccess.IsZero() {
+				ok = false
+			}
+		}
+	}
+	success := prometheus.NewGauge(prometheus.GaugeOpts{Name: "probe_success", Help: "The on-demand check of the target succeeded"})
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{Name: "probe_duration_seconds", Help: "Duration of the on-demand check"})
+	success.Set(bool2Float(ok))
+	duration.Set(time.Since(start).Seconds())
+	reg.MustRegister(success, duration)
+
+	promhttp.HandlerFor(relabelGatherer(reg), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probeMonitor monitor running an on-demand check
+type probeMonitor interface {
+	AddTargets()
+	Wait()
+	Stop()
+	ExportStats() map[string]target.ProbeStats
+}
+
+// probeTimeout returns the check deadline, the Prometheus scrape timeout (X-Prometheus-Scrape-Timeout-Seconds) minus probeTimeoutOffset, 0 without header
+func probeTimeout(r *http.Request) time.Duration {
+	s, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || s <= 0 {
+		return 0
+	}
+	t := time.Duration(s * float64(time.Second))
+	if t > 2*probeTimeoutOffset {
+		t -= probeTimeoutOffset
+	}
+	return t
+}
+
+// bool2Float converts a bool to 0/1
+func bool2Float(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/target"
+)
+
+// shutdownSignal shuts down gracefully on SIGTERM/SIGINT, a second signal exits right away
+func shutdownSignal() {
+	term := make(chan os.Signal, 2)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-term
+		go shutdown(sig)
+		sig = <-term
+		level.Warn(logger).Log("msg", "Signal: "+sig.String()+", exiting without draining the probes")
+		os.Exit(1)
+	}()
+}
+
+// shutdown stops starting probe cycles, waits up to --shutdown.timeout for the running ones (cancelled past it)
+// then writes the state file, stops the targets (their sockets are closed), closes the result sinks and exits
+func shutdown(sig os.Signal) {
+	level.Info(logger).Log("msg", "Signal: "+sig.String()+", shutting down", "timeout", *shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if !target.Drain(ctx) {
+		level.Warn(logger).Log("msg", "Running probes cancelled after the shutdown timeout")
+	}
+
+	// The outcome of the drained cycles is persisted before the targets are removed
+	if *stateFile != "" {
+		if err := saveState(*stateFile); err != nil {
+			level.Error(logger).Log("msg", "Saving state", "file", *stateFile, "err", err)
+		}
+	}
+
+	// No reload re-creates the targets while they are stopped
+	reloadMtx.Lock()
+	monitorPING.Stop()
+	monitorMTR.Stop()
+	monitorTCP.Stop()
+	monitorUDP.Stop()
+	monitorHTTPGet.Stop()
+	monitorARP.Stop()
+	monitorOWD.Stop()
+	monitorDNS.Stop()
+	selfProbe.Stop()
+	owdResponder.Stop()
+
+	resultsSink.Close()
+	if err := resultsLog.Close(); err != nil {
+		level.Error(logger).Log("msg", "Closing results file", "err", err)
+	}
+	if err := eventLog.Close(); err != nil {
+		level.Error(logger).Log("msg", "Closing event log", "err", err)
+	}
+
+	level.Info(logger).Log("msg", "Shutdown complete")
+	os.Exit(0)
+}
@@ -25,28 +25,19 @@ func reloadSignal() {
 			case <-hup:
 				level.Debug(logger).Log("msg", "Signal: HUP")
 				level.Info(logger).Log("msg", "ReLoading config")
-				if err := sc.ReloadConfig(logger, *configFile); err != nil {
+				if err := reloadConfig(); err != nil {
 					level.Error(logger).Log("msg", "Reloading config skipped", "err", err)
-					continue
-				} else {
-					monitorPING.DelTargets()
-					_ = monitorPING.CheckActiveTargets()
-					monitorPING.AddTargets()
-					monitorMTR.DelTargets()
-					_ = monitorMTR.CheckActiveTargets()
-					monitorMTR.AddTargets()
-					monitorTCP.DelTargets()
-					_ = monitorTCP.CheckActiveTargets()
-					monitorTCP.AddTargets()
-					monitorHTTPGet.DelTargets()
-					monitorHTTPGet.AddTargets()
 				}
 			case <-susr:
 				level.Debug(logger).Log("msg", "Signal: USR1")
 				fmt.Printf("PING: %+v\n", monitorPING)
 				fmt.Printf("MTR: %+v\n", monitorMTR)
 				fmt.Printf("TCP: %+v\n", monitorTCP)
+				fmt.Printf("UDP: %+v\n", monitorUDP)
 				fmt.Printf("HTTPGet: %+v\n", monitorHTTPGet)
+				fmt.Printf("ARP: %+v\n", monitorARP)
+				fmt.Printf("OWD: %+v\n", monitorOWD)
+				fmt.Printf("DNS: %+v\n", monitorDNS)
 			}
 		}
 	}()
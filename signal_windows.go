@@ -22,21 +22,8 @@ func reloadSignal() {
 			case <-hup:
 				level.Debug(logger).Log("msg", "Signal: HUP")
 				level.Info(logger).Log("msg", "ReLoading config")
-				if err := sc.ReloadConfig(logger, *configFile); err != nil {
+				if err := reloadConfig(); err != nil {
 					level.Error(logger).Log("msg", "Reloading config skipped", "err", err)
-					continue
-				} else {
-					monitorPING.DelTargets()
-					_ = monitorPING.CheckActiveTargets()
-					monitorPING.AddTargets()
-					monitorMTR.DelTargets()
-					_ = monitorMTR.CheckActiveTargets()
-					monitorMTR.AddTargets()
-					monitorTCP.DelTargets()
-					_ = monitorTCP.CheckActiveTargets()
-					monitorTCP.AddTargets()
-					monitorHTTPGet.DelTargets()
-					monitorHTTPGet.AddTargets()
 				}
 			}
 		}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/target"
+)
+
+// loadState restores the per target state of the previous run, a missing or corrupt file starts fresh
+func loadState(path string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			level.Warn(logger).Log("msg", "Loading state skipped", "file", path, "err", err)
+		}
+		return
+	}
+
+	states := map[string]map[string]target.SavedState{}
+	if err := json.Unmarshal(b, &states); err != nil {
+		level.Warn(logger).Log("msg", "Loading state skipped, the file is corrupt", "file", path, "err", err)
+		return
+	}
+	target.RestoreStates(states)
+	level.Info(logger).Log("msg", "Loaded state", "file", path)
+}
+
+// saveState writes the per target state (through a temporary file so a crash never leaves a truncated file)
+func saveState(path string) error {
+	states := map[string]map[string]target.SavedState{}
+	for _, stats := range []map[string]target.ProbeStats{monitorPING.ExportStats(), monitorMTR.ExportStats(), monitorTCP.ExportStats(), monitorUDP.ExportStats(), monitorHTTPGet.ExportStats(), monitorARP.ExportStats(), monitorOWD.ExportStats(), monitorDNS.ExportStats()} {
+		for key, st := range stats {
+			if states[st.Type] == nil {
+				states[st.Type] = map[string]target.SavedState{}
+			}
+			states[st.Type][key] = target.SaveState(st)
+		}
+	}
+
+	b, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
+
+// startStateFlush periodically persists the per target state
+func startStateFlush(path string, interval time.Duration) {
+	if path == "" || interval <= 0 {
+		return
+	}
+
+	for range time.NewTicker(interval).C {
+		if err := saveState(path); err != nil {
+			level.Error(logger).Log("msg", "Saving state", "file", path, "err", err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package target
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/pkg/arp"
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+// ARP Object
+type ARP struct {
+	logger   log.Logger
+	name     string
+	host     string
+	ip       string
+	device   string
+	srcAddr  string
+	interval time.Duration
+	jitter   time.Duration
+	timeout  time.Duration
+	labels   map[string]string
+	result   *arp.ARPReturn
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	probeState
+	sync.RWMutex
+}
+
+// ARPOptions Settings of an ARP target
+type ARPOptions struct {
+	Options
+	Device string // Interface of the requests
+}
+
+// NewARP starts a new monitoring goroutine
+func NewARP(logger log.Logger, sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, startupDelay time.Duration, o ARPOptions) (*ARP, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	t := &ARP{
+		logger:     logger,
+		name:       o.Name,
+		host:       o.Host,
+		ip:         o.IP,
+		device:     o.Device,
+		srcAddr:    o.SrcAddr,
+		interval:   o.Interval,
+		jitter:     o.Jitter,
+		timeout:    o.Timeout,
+		labels:     o.Labels,
+		stop:       make(chan struct{}),
+		probeState: newProbeState(sem, pool, shedder, "ARP", o.Name, o.Host, o.IP, o.Priority, o.DependsOn),
+	}
+	t.compute = func() interface{} { return t.Compute() }
+	t.wg.Add(1)
+	go t.run(startupDelay)
+	return t, nil
+}
+
+func (t *ARP) run(startupDelay time.Duration) {
+	t.schedule(startupDelay, t.interval, t.jitter, t.stop, t.arp)
+	t.wg.Done()
+}
+
+// Stop gracefully stops the monitoring
+func (t *ARP) Stop() {
+	t.cancel()
+	close(t.stop)
+	t.wg.Wait()
+	t.forget()
+}
+
+// Wait blocks until the monitoring goroutine returns (after the single cycle in one-shot mode)
+func (t *ARP) Wait() {
+	t.wg.Wait()
+}
+
+func (t *ARP) arp() bool {
+	data, err := arp.Arp(t.host, t.ip, t.device, t.srcAddr, t.timeout)
+	t.setError(err)
+	if err != nil {
+		level.Debug(t.logger).Log("type", "ARP", "func", "arp", "msg", fmt.Sprintf("%s", err))
+	}
+
+	bytes, err2 := json.Marshal(data)
+	if err2 != nil {
+		level.Error(t.logger).Log("type", "ARP", "func", "arp", "msg", fmt.Sprintf("%s", err2))
+	}
+	level.Debug(t.logger).Log("type", "ARP", "func", "arp", "msg", bytes)
+
+	t.Lock()
+	defer t.Unlock()
+	t.result = data
+	return data.Success
+}
+
+// Compute returns the results of the ARP metrics
+func (t *ARP) Compute() *arp.ARPReturn {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.result == nil || t.skipped() {
+		return nil
+	}
+	return t.result
+}
+
+// Name returns name
+func (t *ARP) Name() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.name
+}
+
+// Host returns host
+func (t *ARP) Host() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.host
+}
+
+// Ip returns ip
+func (t *ARP) Ip() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.ip
+}
+
+// Labels returns labels
+func (t *ARP) Labels() map[string]string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.labels
+}
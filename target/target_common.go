@@ -0,0 +1,318 @@
+package target
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+// ProbeStats Scheduling details of a target
+type ProbeStats struct {
+	Type     string        `json:"type"`
+	Host     string        `json:"host"`
+	Ip       string        `json:"ip"`
+	Priority int           `json:"priority"`
+	Wait     time.Duration `json:"wait"`
+	Skipped  int           `json:"overlap_skipped"`
+	Shed     int           `json:"load_shed"`
+
+	LastProbe           time.Time `json:"last_probe"`
+	NextProbe           time.Time `json:"next_probe"`
+	LastSuccess         time.Time `json:"last_success"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+
+	DependsOn         string `json:"depends_on,omitempty"`
+	DependencySkipped bool   `json:"dependency_skipped"`
+	FamilyUnavailable bool   `json:"family_unavailable"`
+	Scheduled         bool   `json:"scheduled"`
+	Suppressed        bool   `json:"suppressed"`
+	Adaptive          bool   `json:"adaptive"`
+	Rechecking        bool   `json:"rechecking"`
+
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Options Settings shared by the targets of all the probe types
+type Options struct {
+	Name      string        // Target key, the configured name followed by the address of the per address targets
+	Host      string        // Configured host (the URL of the HTTPGet targets)
+	IP        string        // Probed address (unset for the DNS and HTTPGet targets)
+	SrcAddr   string        // Source address of the probes
+	Interval  time.Duration // Interval of the cycles (the minimum of a range)
+	Jitter    time.Duration // Random delay added to the interval (the rest of a range)
+	Timeout   time.Duration // Timeout of a probe
+	Priority  int           // Probe slot priority (conf.max-concurrency)
+	DependsOn string        // Target whose failure skips the probes
+	Labels    map[string]string
+}
+
+// upStates Outcome of the last cycle of the running targets by name (depends-on)
+var upStates = struct {
+	sync.RWMutex
+	m map[string]map[*probeState]bool
+}{m: map[string]map[*probeState]bool{}}
+
+// probeState Scheduling state shared by all the target types
+type probeState struct {
+	sem     *common.Semaphore
+	pool    *common.Semaphore
+	shedder *common.LoadShedder
+	name    string
+	key     string
+	stats   ProbeStats
+	state   string
+	since   time.Time
+	mtx     sync.RWMutex
+	compute func() interface{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	recheck time.Duration
+	resched chan struct{}
+}
+
+// The ICMP/TCP target names include the IP, the dependencies are tracked by the configured name
+// The targets whose address family is not usable on the host are never probed, they are marked once with the family_unavailable error
+func newProbeState(sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, probeType string, name string, host string, ip string, priority int, dependsOn string) probeState {
+	stats := ProbeStats{Type: probeType, Host: host, Ip: ip, Priority: priority, DependsOn: dependsOn}
+	if !common.FamilyUsable(ip) {
+		stats.FamilyUnavailable = true
+		stats.LastError = common.FamilyError(ip).Error()
+	}
+	ctx, cancel := context.WithCancel(inflight.ctx)
+	return probeState{sem: sem, pool: pool, shedder: shedder, name: strings.SplitN(name, " ", 2)[0], key: name, stats: stats, ctx: ctx, cancel: cancel, resched: make(chan struct{}, 1)}
+}
+
+// schedule drives the probe cycles until stop is closed
+// A tick is skipped (and counted) when the previous cycle of the same target is still running
+// Without interval (one-shot mode) a single cycle is run right away
+// With jitter each cycle waits a random interval between interval and interval+jitter (keeps the targets desynchronized)
+// While re-checking (adaptive probing) the cycles run at the re-check interval without jitter
+func (s *probeState) schedule(startupDelay time.Duration, interval time.Duration, jitter time.Duration, stop chan struct{}, probe func() bool) {
+	s.restore()
+	if startupDelay > 0 {
+		s.setNextProbe(startupDelay)
+		select {
+		case <-time.After(startupDelay):
+		case <-stop:
+		}
+	}
+
+	if interval <= 0 {
+		s.cycle(time.Now(), stop, probe)
+		return
+	}
+
+	next := func() time.Duration {
+		s.mtx.RLock()
+		d := s.recheck
+		s.mtx.RUnlock()
+		if d > 0 {
+			s.setNextProbe(d)
+			return d
+		}
+		d = interval
+		if jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(jitter) + 1))
+		}
+		s.setNextProbe(d)
+		return d
+	}
+
+	running := make(chan struct{}, 1)
+	tick := time.NewTimer(next())
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.resched:
+			if !tick.Stop() {
+				select {
+				case <-tick.C:
+				default:
+				}
+			}
+			tick.Reset(next())
+		case due := <-tick.C:
+			tick.Reset(next())
+			select {
+			case running <- struct{}{}:
+				go func() {
+					s.cycle(due, stop, probe)
+					<-running
+				}()
+			default:
+				s.mtx.Lock()
+				s.stats.Skipped++
+				s.mtx.Unlock()
+			}
+		}
+	}
+}
+
+// cycle runs a probe cycle due at the given time, skipped within a maintenance window or while the depends-on target is down
+// Under load shedding, once draining (shutdown) or when the probe is cancelled (target stopped) the cycle is dropped without recording an outcome
+func (s *probeState) cycle(due time.Time, stop chan struct{}, probe func() bool) {
+	if !inflight.begin() {
+		return
+	}
+	defer inflight.end()
+
+	if s.stats.FamilyUnavailable {
+		s.cycleDone(false)
+		return
+	}
+
+	scheduled, suppressed := s.suppressed(due)
+	s.mtx.Lock()
+	s.stats.Scheduled, s.stats.Suppressed = scheduled, suppressed
+	s.mtx.Unlock()
+	if suppressed {
+		// Neither are the dependents probed during the maintenance
+		s.setUp(false)
+		return
+	}
+
+	if s.shedder.Shed(s.stats.Priority) {
+		s.mtx.Lock()
+		s.stats.Shed++
+		s.mtx.Unlock()
+		return
+	}
+
+	skip := !dependencyUp(s.stats.DependsOn)
+	s.mtx.Lock()
+	s.stats.DependencySkipped = skip
+	s.mtx.Unlock()
+	if skip {
+		// The skipped target is unknown, neither are its own dependents probed
+		s.setUp(false)
+		return
+	}
+
+	if s.acquire(stop) {
+		s.shedder.Observe(time.Since(due))
+		s.mtx.Lock()
+		start := time.Now()
+		s.stats.LastProbe = start
+		s.mtx.Unlock()
+		success := probe()
+		if s.ctx.Err() == nil {
+			s.cycleDone(success)
+			s.record(start, success)
+		}
+		s.release()
+	}
+}
+
+// setNextProbe records when the next cycle is due
+func (s *probeState) setNextProbe(d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stats.NextProbe = time.Now().Add(d)
+}
+
+// cycleDone records the outcome of a probe cycle
+func (s *probeState) cycleDone(success bool) {
+	s.setUp(success)
+	s.transition(success)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if success {
+		s.stats.LastSuccess = time.Now()
+		s.stats.ConsecutiveFailures = 0
+	} else {
+		s.stats.ConsecutiveFailures++
+	}
+}
+
+// setUp records the outcome of the last cycle for the targets depending on this one
+func (s *probeState) setUp(up bool) {
+	upStates.Lock()
+	defer upStates.Unlock()
+	if upStates.m[s.name] == nil {
+		upStates.m[s.name] = map[*probeState]bool{}
+	}
+	upStates.m[s.name][s] = up
+}
+
+// forget drops the outcome of the stopped target
+func (s *probeState) forget() {
+	upStates.Lock()
+	defer upStates.Unlock()
+	delete(upStates.m[s.name], s)
+	if len(upStates.m[s.name]) == 0 {
+		delete(upStates.m, s.name)
+	}
+}
+
+// dependencyUp reports if any of the targets with the given name is up
+// Without dependency or before the dependency has completed a cycle the target is probed
+func dependencyUp(name string) bool {
+	if name == "" {
+		return true
+	}
+	upStates.RLock()
+	defer upStates.RUnlock()
+	states, found := upStates.m[name]
+	if !found {
+		return true
+	}
+	for _, up := range states {
+		if up {
+			return true
+		}
+	}
+	return false
+}
+
+// setError records the error of the last probe cycle (nil clears it)
+func (s *probeState) setError(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stats.LastError = ""
+	if err != nil {
+		s.stats.LastError = err.Error()
+	}
+}
+
+// skipped reports if the last cycle was suppressed by a maintenance window or skipped because the depends-on target is down
+func (s *probeState) skipped() bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.stats.Suppressed || s.stats.DependencySkipped
+}
+
+// acquire waits for a free slot of the pool then of the global limit and records the time spent in the queues
+// The pool slot is taken first so a starved pool never holds the global slots
+func (s *probeState) acquire(stop chan struct{}) bool {
+	start := time.Now()
+	ok := s.pool.Acquire(s.stats.Priority, stop)
+	if ok {
+		if ok = s.sem.Acquire(s.stats.Priority, stop); !ok {
+			s.pool.Release()
+		}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stats.Wait = time.Since(start)
+	return ok
+}
+
+// release frees the probe slots
+func (s *probeState) release() {
+	s.sem.Release()
+	s.pool.Release()
+}
+
+// Stats returns the scheduling details
+func (s *probeState) Stats() ProbeStats {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.stats
+}
@@ -0,0 +1,140 @@
+package target
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/dns"
+)
+
+// DNS Object
+type DNS struct {
+	logger   log.Logger
+	name     string
+	host     string
+	server   string
+	qtype    string
+	srcAddr  string
+	interval time.Duration
+	jitter   time.Duration
+	timeout  time.Duration
+	labels   map[string]string
+	result   *dns.DNSReturn
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	probeState
+	sync.RWMutex
+}
+
+// DNSOptions Settings of a DNS target
+type DNSOptions struct {
+	Options
+	Server string // Queried nameserver
+	QType  string // Record type of the queries
+}
+
+// NewDNS starts a new monitoring goroutine
+func NewDNS(logger log.Logger, sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, startupDelay time.Duration, o DNSOptions) (*DNS, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	t := &DNS{
+		logger:     logger,
+		name:       o.Name,
+		host:       o.Host,
+		server:     o.Server,
+		qtype:      o.QType,
+		srcAddr:    o.SrcAddr,
+		interval:   o.Interval,
+		jitter:     o.Jitter,
+		timeout:    o.Timeout,
+		labels:     o.Labels,
+		stop:       make(chan struct{}),
+		probeState: newProbeState(sem, pool, shedder, "DNS", o.Name, o.Host, "", o.Priority, o.DependsOn),
+	}
+	t.compute = func() interface{} { return t.Compute() }
+	t.wg.Add(1)
+	go t.run(startupDelay)
+	return t, nil
+}
+
+func (t *DNS) run(startupDelay time.Duration) {
+	t.schedule(startupDelay, t.interval, t.jitter, t.stop, t.probe)
+	t.wg.Done()
+}
+
+// Stop gracefully stops the monitoring
+func (t *DNS) Stop() {
+	t.cancel()
+	close(t.stop)
+	t.wg.Wait()
+	t.forget()
+}
+
+// Wait blocks until the monitoring goroutine returns (after the single cycle in one-shot mode)
+func (t *DNS) Wait() {
+	t.wg.Wait()
+}
+
+func (t *DNS) probe() bool {
+	data, err := dns.Query(t.host, t.qtype, t.server, t.srcAddr, t.timeout)
+	t.setError(err)
+	if err != nil {
+		level.Debug(t.logger).Log("type", "DNS", "func", "probe", "msg", fmt.Sprintf("%s", err))
+	}
+
+	bytes, err2 := json.Marshal(data)
+	if err2 != nil {
+		level.Error(t.logger).Log("type", "DNS", "func", "probe", "msg", fmt.Sprintf("%s", err2))
+	}
+	level.Debug(t.logger).Log("type", "DNS", "func", "probe", "msg", bytes)
+
+	t.Lock()
+	defer t.Unlock()
+	t.result = data
+	return data.Success
+}
+
+// Compute returns the results of the DNS metrics
+func (t *DNS) Compute() *dns.DNSReturn {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.result == nil || t.skipped() {
+		return nil
+	}
+	return t.result
+}
+
+// Name returns name
+func (t *DNS) Name() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.name
+}
+
+// Host returns host
+func (t *DNS) Host() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.host
+}
+
+// Server returns the nameserver
+func (t *DNS) Server() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.server
+}
+
+// Labels returns labels
+func (t *DNS) Labels() map[string]string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.labels
+}
@@ -0,0 +1,67 @@
+package target
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// drainCancelWait Time the cancelled probes have to return after the drain deadline
+const drainCancelWait = 5 * time.Second
+
+// inflight Probe cycles running, the probes of all the targets are cancelled by the end of the drain
+var inflight = func() *drainState {
+	d := &drainState{}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	return d
+}()
+
+type drainState struct {
+	mtx      sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// begin registers a probe cycle, false once draining (no new cycle is started)
+func (d *drainState) begin() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.draining {
+		return false
+	}
+	d.wg.Add(1)
+	return true
+}
+
+// end unregisters a probe cycle
+func (d *drainState) end() {
+	d.wg.Done()
+}
+
+// Drain stops starting probe cycles and waits for the running ones until ctx is done (shutdown)
+// The probes still running are then cancelled, returns false when some had to be cancelled or didn't return in time
+func Drain(ctx context.Context) bool {
+	inflight.mtx.Lock()
+	inflight.draining = true
+	inflight.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		inflight.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+	}
+	inflight.cancel()
+	select {
+	case <-done:
+	case <-time.After(drainCancelWait):
+	}
+	return false
+}
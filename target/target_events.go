@@ -0,0 +1,65 @@
+package target
+
+import (
+	"sync"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+// Transition Up/down state change of a target recorded in the event log
+type Transition struct {
+	Time      time.Time `json:"time"`
+	Name      string    `json:"name"`
+	Target    string    `json:"target"`
+	Type      string    `json:"type"`
+	Host      string    `json:"host"`
+	Ip        string    `json:"ip,omitempty"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Duration  float64   `json:"duration_seconds"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// events Event log of the state transitions (nil disables it)
+var events = struct {
+	sync.RWMutex
+	log *common.EventLog
+}{}
+
+// SetEventLog sets the event log receiving the up/down transitions of all the targets
+func SetEventLog(l *common.EventLog) {
+	events.Lock()
+	defer events.Unlock()
+	events.log = l
+}
+
+// transition records the state change of the target, the first outcome only sets the state
+// The duration in the previous state is 0 when it's unknown (state restored from the state file)
+func (s *probeState) transition(up bool) {
+	state := "down"
+	if up {
+		state = "up"
+	}
+
+	s.mtx.Lock()
+	from, since := s.state, s.since
+	if from == state {
+		s.mtx.Unlock()
+		return
+	}
+	now := time.Now()
+	s.state, s.since = state, now
+	t := Transition{Time: now, Name: s.name, Target: s.key, Type: s.stats.Type, Host: s.stats.Host, Ip: s.stats.Ip, From: from, To: state, LastError: s.stats.LastError}
+	s.mtx.Unlock()
+
+	if from == "" {
+		return
+	}
+	if !since.IsZero() {
+		t.Duration = now.Sub(since).Seconds()
+	}
+	events.RLock()
+	defer events.RUnlock()
+	events.log.Write(t)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/pkg/common"
 	"github.com/syepes/network_exporter/pkg/http"
 )
 
@@ -18,85 +19,96 @@ type HTTPGet struct {
 	url      string
 	srcAddr  string
 	proxy    string
+	method   string
+	expected http.StatusSpec
 	interval time.Duration
+	jitter   time.Duration
 	timeout  time.Duration
 	labels   map[string]string
 	result   *http.HTTPReturn
 	stop     chan struct{}
 	wg       sync.WaitGroup
+	probeState
 	sync.RWMutex
 }
 
+// HTTPGetOptions Settings of an HTTPGet target (the URL is the Host)
+type HTTPGetOptions struct {
+	Options
+	Proxy    string          // Proxy URL of the requests
+	Method   string          // Method of the requests
+	Expected http.StatusSpec // Status codes of a successful probe
+}
+
 // NewHTTPGet starts a new monitoring goroutine
-func NewHTTPGet(logger log.Logger, startupDelay time.Duration, name string, url string, srcAddr string, proxy string, interval time.Duration, timeout time.Duration, labels map[string]string) (*HTTPGet, error) {
+func NewHTTPGet(logger log.Logger, sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, startupDelay time.Duration, o HTTPGetOptions) (*HTTPGet, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 	t := &HTTPGet{
-		logger:   logger,
-		name:     name,
-		url:      url,
-		srcAddr:  srcAddr,
-		proxy:    proxy,
-		interval: interval,
-		timeout:  timeout,
-		labels:   labels,
-		stop:     make(chan struct{}),
+		logger:     logger,
+		name:       o.Name,
+		url:        o.Host,
+		srcAddr:    o.SrcAddr,
+		proxy:      o.Proxy,
+		method:     o.Method,
+		expected:   o.Expected,
+		interval:   o.Interval,
+		jitter:     o.Jitter,
+		timeout:    o.Timeout,
+		labels:     o.Labels,
+		stop:       make(chan struct{}),
+		probeState: newProbeState(sem, pool, shedder, "HTTPGet", o.Name, o.Host, "", o.Priority, o.DependsOn),
 	}
+	t.compute = func() interface{} { return t.Compute() }
 	t.wg.Add(1)
 	go t.run(startupDelay)
 	return t, nil
 }
 
 func (t *HTTPGet) run(startupDelay time.Duration) {
-	if startupDelay > 0 {
-		select {
-		case <-time.After(startupDelay):
-		case <-t.stop:
-		}
-	}
-
-	waitChan := make(chan struct{}, MaxConcurrentJobs)
-	tick := time.NewTicker(t.interval)
-	for {
-		select {
-		case <-t.stop:
-			tick.Stop()
-			t.wg.Done()
-			return
-		case <-tick.C:
-			waitChan <- struct{}{}
-			go func() {
-				t.httpGetCheck()
-				<-waitChan
-			}()
-		}
-	}
+	t.schedule(startupDelay, t.interval, t.jitter, t.stop, t.httpGetCheck)
+	t.wg.Done()
 }
 
 // Stop gracefully stops the monitoring
 func (t *HTTPGet) Stop() {
+	t.cancel()
 	close(t.stop)
 	t.wg.Wait()
+	t.forget()
+}
+
+// Wait blocks until the monitoring goroutine returns (after the single cycle in one-shot mode)
+func (t *HTTPGet) Wait() {
+	t.wg.Wait()
 }
 
-func (t *HTTPGet) httpGetCheck() {
+func (t *HTTPGet) httpGetCheck() bool {
 	var data *http.HTTPReturn
 	var err error
 
 	if t.proxy != "" {
-		data, err = http.HTTPGetProxy(t.url, t.timeout, t.proxy)
+		data, err = http.HTTPGetProxy(t.url, t.method, t.timeout, t.proxy)
 		if err != nil {
 			level.Error(t.logger).Log("type", "HTTPGet", "func", "httpGetCheck", "msg", fmt.Sprintf("%s", err))
 		}
 
 	} else {
-		data, err = http.HTTPGet(t.url, t.srcAddr, t.timeout)
+		data, err = http.HTTPGet(t.url, t.method, t.srcAddr, t.timeout)
 		if err != nil {
 			level.Error(t.logger).Log("type", "HTTPGet", "func", "httpGetCheck", "msg", fmt.Sprintf("%s", err))
 		}
 	}
 
+	// A response with an unexpected status code is a failed probe, the status is still reported
+	if data != nil && data.Success && !t.expected.Match(data.Status) {
+		err = fmt.Errorf("unexpected status code %d", data.Status)
+		level.Debug(t.logger).Log("type", "HTTPGet", "func", "httpGetCheck", "msg", fmt.Sprintf("%s %s", t.url, err))
+		data.Success = false
+	}
+	t.setError(err)
+
 	bytes, err2 := json.Marshal(data)
 	if err2 != nil {
 		level.Error(t.logger).Log("type", "HTTPGet", "func", "httpGetCheck", "msg", fmt.Sprintf("%s", err2))
@@ -106,6 +118,7 @@ func (t *HTTPGet) httpGetCheck() {
 	t.Lock()
 	defer t.Unlock()
 	t.result = data
+	return data != nil && data.Success
 }
 
 // Compute returns the results of the HTTP metrics
@@ -113,7 +126,7 @@ func (t *HTTPGet) Compute() *http.HTTPReturn {
 	t.RLock()
 	defer t.RUnlock()
 
-	if t.result == nil {
+	if t.result == nil || t.skipped() {
 		return nil
 	}
 	return t.result
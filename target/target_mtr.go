@@ -3,6 +3,7 @@ package target
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"strconv"
 	"sync"
 	"time"
@@ -17,81 +18,119 @@ import (
 type MTR struct {
 	logger   log.Logger
 	icmpID   *common.IcmpID
+	limiter  *common.RateLimiter
 	name     string
 	host     string
 	srcAddr  string
+	dscp     int
+	mark     int
+	device   string
 	interval time.Duration
+	jitter   time.Duration
 	timeout  time.Duration
 	maxHops  int
 	count    int
+	protocol string
+	port     int
+	resolver *net.Resolver
+	rTimeout time.Duration
+	names    map[string]string
+	window   int
+	runs     [][]common.IcmpHop
 	labels   map[string]string
 	result   *mtr.MtrResult
 	stop     chan struct{}
 	wg       sync.WaitGroup
+	probeState
 	sync.RWMutex
 }
 
+// MTROptions Settings of an MTR target (the path to its IP is traced)
+type MTROptions struct {
+	Options
+	DSCP           int           // DSCP of the probes
+	Mark           int           // Firewall mark of the socket
+	Device         string        // Interface the socket is bound to
+	MaxHops        int           // Hops traced at most
+	Count          int           // Probes per hop of a cycle
+	Protocol       string        // Protocol of the probes (icmp, udp or tcp)
+	Port           int           // Destination port of the udp/tcp probes
+	Resolver       *net.Resolver // Reverse lookups of the hops (nil disables)
+	ResolveTimeout time.Duration // Timeout of the reverse lookups
+	Window         int           // Cycles of the rolling hop statistics
+}
+
 // NewMTR starts a new monitoring goroutine
-func NewMTR(logger log.Logger, icmpID *common.IcmpID, startupDelay time.Duration, name string, host string, srcAddr string, interval time.Duration, timeout time.Duration, maxHops int, count int, labels map[string]string) (*MTR, error) {
+func NewMTR(logger log.Logger, icmpID *common.IcmpID, limiter *common.RateLimiter, sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, startupDelay time.Duration, o MTROptions) (*MTR, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 	t := &MTR{
-		logger:   logger,
-		icmpID:   icmpID,
-		name:     name,
-		host:     host,
-		srcAddr:  srcAddr,
-		interval: interval,
-		timeout:  timeout,
-		maxHops:  maxHops,
-		count:    count,
-		labels:   labels,
-		stop:     make(chan struct{}),
-		result:   &mtr.MtrResult{HopSummaryMap: map[string]*common.IcmpSummary{}},
+		logger:     logger,
+		icmpID:     icmpID,
+		limiter:    limiter,
+		name:       o.Name,
+		host:       o.IP,
+		srcAddr:    o.SrcAddr,
+		dscp:       o.DSCP,
+		mark:       o.Mark,
+		device:     o.Device,
+		interval:   o.Interval,
+		jitter:     o.Jitter,
+		timeout:    o.Timeout,
+		maxHops:    o.MaxHops,
+		count:      o.Count,
+		protocol:   o.Protocol,
+		port:       o.Port,
+		resolver:   o.Resolver,
+		rTimeout:   o.ResolveTimeout,
+		names:      map[string]string{},
+		window:     o.Window,
+		labels:     o.Labels,
+		stop:       make(chan struct{}),
+		probeState: newProbeState(sem, pool, shedder, "MTR", o.Name, o.IP, o.IP, o.Priority, o.DependsOn),
+		result:     &mtr.MtrResult{DestAddr: o.IP, Protocol: o.Protocol, HopSummaryMap: map[string]*common.IcmpSummary{}},
 	}
+	t.compute = func() interface{} { return t.Compute() }
 	t.wg.Add(1)
 	go t.run(startupDelay)
 	return t, nil
 }
 
 func (t *MTR) run(startupDelay time.Duration) {
-	if startupDelay > 0 {
-		select {
-		case <-time.After(startupDelay):
-		case <-t.stop:
-		}
-	}
-
-	waitChan := make(chan struct{}, MaxConcurrentJobs)
-	tick := time.NewTicker(t.interval)
-	for {
-		select {
-		case <-t.stop:
-			tick.Stop()
-			t.wg.Done()
-			return
-		case <-tick.C:
-			waitChan <- struct{}{}
-			go func() {
-				t.mtr()
-				<-waitChan
-			}()
-		}
-	}
+	t.schedule(startupDelay, t.interval, t.jitter, t.stop, t.mtr)
+	t.wg.Done()
 }
 
 // Stop gracefully stops the monitoring
 func (t *MTR) Stop() {
+	t.cancel()
 	close(t.stop)
 	t.wg.Wait()
+	t.forget()
 }
 
-func (t *MTR) mtr() {
+// Wait blocks until the monitoring goroutine returns (after the single cycle in one-shot mode)
+func (t *MTR) Wait() {
+	t.wg.Wait()
+}
+
+func (t *MTR) mtr() bool {
 	icmpID := int(t.icmpID.Get())
-	data, err := mtr.Mtr(t.host, t.srcAddr, t.maxHops, t.count, t.timeout, icmpID)
+	data, err := mtr.Mtr(t.ctx, t.host, t.srcAddr, t.maxHops, t.count, t.timeout, icmpID, t.dscp, t.mark, t.device, t.protocol, t.port, t.limiter)
+	// Cancelled (target stopped, shutdown)
+	if t.ctx.Err() != nil {
+		return false
+	}
+	t.setError(err)
 	if err != nil {
 		level.Error(t.logger).Log("type", "MTR", "func", "mtr", "msg", fmt.Sprintf("%s", err))
+		return false
+	}
+
+	// Hop names (PTR) are only resolved when enabled
+	if t.resolver != nil {
+		mtr.ResolveHops(data, t.resolver, t.rTimeout, t.names)
 	}
 
 	t.Lock()
@@ -111,12 +150,73 @@ func (t *MTR) mtr() {
 		summary.SntFail += hop.SntFail
 	}
 	t.result.HopSummaryMap = summaryMap
+	if t.window > 1 {
+		t.result.WindowRuns, t.result.WindowHops = t.windowHops(data.Hops)
+	}
 
 	bytes, err2 := json.Marshal(t.result)
 	if err2 != nil {
 		level.Error(t.logger).Log("type", "MTR", "func", "mtr", "msg", fmt.Sprintf("%s", err2))
 	}
 	level.Debug(t.logger).Log("type", "MTR", "func", "mtr", "msg", bytes)
+
+	// Destination reached
+	if len(data.Hops) == 0 {
+		return false
+	}
+	last := data.Hops[len(data.Hops)-1]
+	return last.Success && common.IsEqualIP(last.AddressTo, t.host)
+}
+
+// windowHops adds the run to the sliding window and returns the hops averaged over the runs of the window
+// The window restarts when the path (TTL/address of the hops) changes, different paths are never averaged
+func (t *MTR) windowHops(hops []common.IcmpHop) (int, []common.IcmpHop) {
+	if len(t.runs) > 0 && !samePath(t.runs[len(t.runs)-1], hops) {
+		t.runs = nil
+	}
+	t.runs = append(t.runs, hops)
+	if len(t.runs) > t.window {
+		t.runs = append([][]common.IcmpHop{}, t.runs[len(t.runs)-t.window:]...)
+	}
+
+	avg := make([]common.IcmpHop, len(hops))
+	for i, hop := range hops {
+		w := common.IcmpHop{Success: hop.Success, AddressFrom: hop.AddressFrom, AddressTo: hop.AddressTo, AddressName: hop.AddressName, N: hop.N, TTL: hop.TTL, LastTime: hop.LastTime}
+		for _, run := range t.runs {
+			h := run[i]
+			if h.Snt > h.SntFail && (w.BestTime == 0 || h.BestTime < w.BestTime) {
+				w.BestTime = h.BestTime
+			}
+			if h.WorstTime > w.WorstTime {
+				w.WorstTime = h.WorstTime
+			}
+			w.Snt += h.Snt
+			w.SntFail += h.SntFail
+			w.SumTime += h.SumTime
+		}
+		if w.Snt > w.SntFail {
+			w.AvgTime = w.SumTime / time.Duration(w.Snt-w.SntFail)
+		}
+		if w.Snt > 0 {
+			w.Loss = float64(w.SntFail) / float64(w.Snt)
+		}
+		w.RangeTime = w.WorstTime - w.BestTime
+		avg[i] = w
+	}
+	return len(t.runs), avg
+}
+
+// samePath reports if both runs went through the same hops
+func samePath(a []common.IcmpHop, b []common.IcmpHop) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].TTL != b[i].TTL || a[i].AddressTo != b[i].AddressTo {
+			return false
+		}
+	}
+	return true
 }
 
 // Compute returns the results of the MTR metrics
@@ -124,7 +224,7 @@ func (t *MTR) Compute() *mtr.MtrResult {
 	t.RLock()
 	defer t.RUnlock()
 
-	if t.result == nil {
+	if t.result == nil || t.skipped() {
 		return nil
 	}
 	return t.result
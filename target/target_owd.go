@@ -0,0 +1,142 @@
+package target
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/owd"
+)
+
+// OWD Object
+type OWD struct {
+	logger   log.Logger
+	name     string
+	host     string
+	ip       string
+	port     string
+	srcAddr  string
+	count    int
+	interval time.Duration
+	jitter   time.Duration
+	timeout  time.Duration
+	labels   map[string]string
+	result   *owd.OwdResult
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	probeState
+	sync.RWMutex
+}
+
+// OWDOptions Settings of an OWD target
+type OWDOptions struct {
+	Options
+	Port  string // Port of the responder
+	Count int    // Probes of a cycle
+}
+
+// NewOWD starts a new monitoring goroutine
+func NewOWD(logger log.Logger, sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, startupDelay time.Duration, o OWDOptions) (*OWD, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	t := &OWD{
+		logger:     logger,
+		name:       o.Name,
+		host:       o.Host,
+		ip:         o.IP,
+		port:       o.Port,
+		srcAddr:    o.SrcAddr,
+		count:      o.Count,
+		interval:   o.Interval,
+		jitter:     o.Jitter,
+		timeout:    o.Timeout,
+		labels:     o.Labels,
+		stop:       make(chan struct{}),
+		probeState: newProbeState(sem, pool, shedder, "OWD", o.Name, o.Host, o.IP, o.Priority, o.DependsOn),
+	}
+	t.compute = func() interface{} { return t.Compute() }
+	t.wg.Add(1)
+	go t.run(startupDelay)
+	return t, nil
+}
+
+func (t *OWD) run(startupDelay time.Duration) {
+	t.schedule(startupDelay, t.interval, t.jitter, t.stop, t.probe)
+	t.wg.Done()
+}
+
+// Stop gracefully stops the monitoring
+func (t *OWD) Stop() {
+	t.cancel()
+	close(t.stop)
+	t.wg.Wait()
+	t.forget()
+}
+
+// Wait blocks until the monitoring goroutine returns (after the single cycle in one-shot mode)
+func (t *OWD) Wait() {
+	t.wg.Wait()
+}
+
+func (t *OWD) probe() bool {
+	data, err := owd.Probe(t.host, t.ip, t.srcAddr, t.port, t.count, t.timeout)
+	t.setError(err)
+	if err != nil {
+		level.Debug(t.logger).Log("type", "OWD", "func", "probe", "msg", fmt.Sprintf("%s", err))
+	}
+
+	bytes, err2 := json.Marshal(data)
+	if err2 != nil {
+		level.Error(t.logger).Log("type", "OWD", "func", "probe", "msg", fmt.Sprintf("%s", err2))
+	}
+	level.Debug(t.logger).Log("type", "OWD", "func", "probe", "msg", bytes)
+
+	t.Lock()
+	defer t.Unlock()
+	t.result = data
+	return data.Success
+}
+
+// Compute returns the results of the OWD metrics
+func (t *OWD) Compute() *owd.OwdResult {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.result == nil || t.skipped() {
+		return nil
+	}
+	return t.result
+}
+
+// Name returns name
+func (t *OWD) Name() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.name
+}
+
+// Host returns host
+func (t *OWD) Host() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.host
+}
+
+// Ip returns ip
+func (t *OWD) Ip() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.ip
+}
+
+// Labels returns labels
+func (t *OWD) Labels() map[string]string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.labels
+}
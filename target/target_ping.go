@@ -9,97 +9,234 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/icmp"
 	"github.com/syepes/network_exporter/pkg/ping"
 )
 
-const MaxConcurrentJobs = 3
-
 // PING Object
 type PING struct {
 	logger   log.Logger
 	icmpID   *common.IcmpID
+	limiter  *common.RateLimiter
 	name     string
 	host     string
 	ip       string
 	srcAddr  string
 	interval time.Duration
+	jitter   time.Duration
+	ipi      time.Duration
 	timeout  time.Duration
+	maxTmout time.Duration
+	curTmout time.Duration
 	count    int
+	flows    int
+	random   bool
+	dscp     int
+	classes  []int
+	mark     int
+	device   string
+	tstamp   bool
+	pmtu     int
+	pmtuTout time.Duration
+	burst    bool
+	hops     int
+	initTTL  int
+	upPolicy ping.UpPolicy
+	buckets  []float64
+	windows  *common.Windows
+	recheck  *recheckState
 	labels   map[string]string
 	result   *ping.PingResult
 	stop     chan struct{}
 	wg       sync.WaitGroup
+	probeState
 	sync.RWMutex
 }
 
+// PingOptions Settings of an ICMP target
+type PingOptions struct {
+	Options
+	IPI             time.Duration   // Spacing of the echoes of a cycle
+	AdaptiveTimeout time.Duration   // Upper bound of the adaptive echo timeout (0 disables)
+	Count           int             // Echoes of a cycle
+	Flows           int             // ICMP ids (flows) the echoes are spread over
+	RandomPayload   bool            // Random echo payloads
+	DSCP            int             // DSCP of the echoes
+	DSCPClasses     []int           // DSCP classes each probed by a batch of echoes
+	Mark            int             // Firewall mark of the socket
+	Device          string          // Interface the socket is bound to
+	Timestamp       bool            // ICMP timestamp requests instead of the echoes
+	PMTU            int             // Largest size of the path MTU search (0 disables)
+	PMTUTimeout     time.Duration   // Timeout of the path MTU probes
+	Burst           bool            // Echoes sent back to back
+	ExpectedHops    int             // Hops derived from the reply TTL expected (0 disables)
+	InitialTTL      int             // Initial TTL of the target replies (0 guesses it)
+	UpPolicy        ping.UpPolicy   // Replies of a cycle for the target to be up
+	Buckets         []float64       // RTT histogram buckets
+	Windows         []time.Duration // Rolling windows of the availability
+	Recheck         Recheck         // Faster re-checks after a lossy cycle
+}
+
 // NewPing starts a new monitoring goroutine
-func NewPing(logger log.Logger, icmpID *common.IcmpID, startupDelay time.Duration, name string, host string, ip string, srcAddr string, interval time.Duration, timeout time.Duration, count int, labels map[string]string) (*PING, error) {
+func NewPing(logger log.Logger, icmpID *common.IcmpID, limiter *common.RateLimiter, sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, startupDelay time.Duration, o PingOptions) (*PING, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 	t := &PING{
-		logger:   logger,
-		icmpID:   icmpID,
-		name:     name,
-		host:     host,
-		ip:       ip,
-		srcAddr:  srcAddr,
-		interval: interval,
-		timeout:  timeout,
-		count:    count,
-		labels:   labels,
-		stop:     make(chan struct{}),
-		result:   &ping.PingResult{},
+		logger:     logger,
+		icmpID:     icmpID,
+		limiter:    limiter,
+		name:       o.Name,
+		host:       o.Host,
+		ip:         o.IP,
+		srcAddr:    o.SrcAddr,
+		interval:   o.Interval,
+		jitter:     o.Jitter,
+		ipi:        o.IPI,
+		timeout:    o.Timeout,
+		maxTmout:   o.AdaptiveTimeout,
+		curTmout:   o.Timeout,
+		count:      o.Count,
+		flows:      o.Flows,
+		random:     o.RandomPayload,
+		dscp:       o.DSCP,
+		classes:    o.DSCPClasses,
+		mark:       o.Mark,
+		device:     o.Device,
+		tstamp:     o.Timestamp,
+		pmtu:       o.PMTU,
+		pmtuTout:   o.PMTUTimeout,
+		burst:      o.Burst,
+		hops:       o.ExpectedHops,
+		initTTL:    o.InitialTTL,
+		upPolicy:   o.UpPolicy,
+		buckets:    o.Buckets,
+		windows:    common.NewWindows(o.Windows),
+		recheck:    newRecheckState(o.Recheck),
+		labels:     o.Labels,
+		stop:       make(chan struct{}),
+		probeState: newProbeState(sem, pool, shedder, "ICMP", o.Name, o.Host, o.IP, o.Priority, o.DependsOn),
+		result:     &ping.PingResult{DestAddr: o.Host, DestIp: o.IP},
 	}
+	t.stats.Adaptive = t.recheck != nil
+	t.compute = func() interface{} { return t.Compute() }
 	t.wg.Add(1)
 	go t.run(startupDelay)
 	return t, nil
 }
 
 func (t *PING) run(startupDelay time.Duration) {
-	if startupDelay > 0 {
-		select {
-		case <-time.After(startupDelay):
-		case <-t.stop:
-		}
-	}
-
-	waitChan := make(chan struct{}, MaxConcurrentJobs)
-	tick := time.NewTicker(t.interval)
-	for {
-		select {
-		case <-t.stop:
-			tick.Stop()
-			t.wg.Done()
-			return
-		case <-tick.C:
-			waitChan <- struct{}{}
-			go func() {
-				t.ping()
-				<-waitChan
-			}()
-		}
-	}
+	t.schedule(startupDelay, t.interval, t.jitter, t.stop, t.ping)
+	t.wg.Done()
 }
 
 // Stop gracefully stops the monitoring
 func (t *PING) Stop() {
+	t.cancel()
 	close(t.stop)
 	t.wg.Wait()
+	t.forget()
 }
 
-func (t *PING) ping() {
-	icmpID := int(t.icmpID.Get())
-	data, err := ping.Ping(t.host, t.ip, t.srcAddr, t.count, t.interval, t.timeout, icmpID)
+// Wait blocks until the monitoring goroutine returns (after the single cycle in one-shot mode)
+func (t *PING) Wait() {
+	t.wg.Wait()
+}
+
+func (t *PING) ping() bool {
+	var data *ping.PingResult
+	var err error
+	timeout := t.curTmout
+	count, ipi := t.recheck.count(t.count), t.recheck.spacing(t.ipi)
+	if len(t.classes) > 0 {
+		icmpIDs := make([]int, len(t.classes))
+		for i := range icmpIDs {
+			icmpIDs[i] = int(t.icmpID.Get())
+		}
+		data, err = ping.PingClasses(t.ctx, t.host, t.ip, t.srcAddr, count, ipi, timeout, icmpIDs, t.random, t.classes, t.mark, t.device, t.burst, t.limiter)
+	} else if t.flows > 1 {
+		icmpIDs := make([]int, t.flows)
+		for i := range icmpIDs {
+			icmpIDs[i] = int(t.icmpID.Get())
+		}
+		data, err = ping.PingFlows(t.ctx, t.host, t.ip, t.srcAddr, count, ipi, timeout, icmpIDs, t.random, t.dscp, t.mark, t.device, t.burst, t.limiter)
+	} else {
+		icmpID := int(t.icmpID.Get())
+		data, err = ping.Ping(t.ctx, t.host, t.ip, t.srcAddr, count, ipi, timeout, icmpID, t.random, t.dscp, t.mark, t.device, t.burst, t.limiter)
+	}
+	// Cancelled (target stopped, shutdown)
+	if t.ctx.Err() != nil {
+		return false
+	}
+	t.setError(err)
 	if err != nil {
 		level.Error(t.logger).Log("type", "ICMP", "func", "ping", "msg", fmt.Sprintf("%s", err))
 	}
+	data.Success = t.upPolicy.Up(data)
+	data.Burst = t.burst
+
+	// The clock offset stays unavailable when the target doesn't answer the timestamp requests
+	if t.tstamp {
+		t.limiter.Wait()
+		ts, err := icmp.Timestamp(t.ip, t.srcAddr, int(t.icmpID.Get()), t.timeout, 0, t.mark, t.device)
+		if err != nil {
+			level.Debug(t.logger).Log("type", "ICMP", "func", "ping", "msg", fmt.Sprintf("timestamp %s", err))
+		} else {
+			data.ClockOffset = ts.Offset
+			data.ClockOffsetValid = ts.Success
+		}
+	}
+
+	// The path MTU stays unknown when the target doesn't answer the echoes of the minimum MTU
+	if t.pmtu > 0 {
+		mtu, err := icmp.PMTU(t.ip, t.srcAddr, int(t.icmpID.Get()), t.pmtuTout, t.pmtu, t.mark, t.device, t.limiter)
+		if err != nil {
+			level.Debug(t.logger).Log("type", "ICMP", "func", "ping", "msg", fmt.Sprintf("pmtu %s", err))
+		} else {
+			data.PathMTU = mtu
+		}
+	}
+
+	// Adaptive timeout: doubled after a lossy cycle (up to adaptive-timeout), halved back towards the timeout after a clean one
+	if t.maxTmout > 0 {
+		data.EffectiveTimeout = timeout
+		if data.DropRate > 0 {
+			t.curTmout = min(timeout*2, t.maxTmout)
+		} else {
+			t.curTmout = max(timeout/2, t.timeout)
+		}
+	}
+
+	// Adaptive probing: faster cycles with more echoes from a lossy cycle until the loss recovers
+	if t.recheck.observe(data.DropRate) {
+		interval := time.Duration(0)
+		if t.recheck.active {
+			interval = t.recheck.Interval
+		}
+		t.setRecheck(interval)
+		level.Info(t.logger).Log("type", "ICMP", "func", "ping", "msg", fmt.Sprintf("Target %s re-checking: %t (loss %.2f)", t.name, t.recheck.active, data.DropRate))
+	}
+
+	// The hop count is derived from the TTL of the last reply (route change detection)
+	if t.hops > 0 && data.ReplyTTLValid {
+		data.Hops = ping.HopCount(data.ReplyTTL, t.initTTL)
+		data.ExpectedHops = t.hops
+	}
 
 	t.Lock()
 	defer t.Unlock()
+	// The windows account the packets of this cycle only
+	data.Windows = t.windows.Add(time.Now(), data.SntSummary, data.SntFailSummary, data.AvgTime)
 	data.SntSummary += t.result.SntSummary
 	data.SntFailSummary += t.result.SntFailSummary
 	data.SntTimeSummary += t.result.SntTimeSummary
+	data.UnexpectedSources += t.result.UnexpectedSources
+	// The jitter estimate carries on across the cycles
+	data.Jitter = common.TimeJitter(t.result.Jitter, data.Rtts)
+	data.RttHistogram = t.result.RttHistogram.Next(t.buckets)
+	for _, rtt := range data.Rtts {
+		data.RttHistogram.Observe(rtt)
+	}
 	t.result = data
 
 	bytes, err2 := json.Marshal(t.result)
@@ -107,6 +244,7 @@ func (t *PING) ping() {
 		level.Error(t.logger).Log("type", "ICMP", "func", "ping", "msg", fmt.Sprintf("%s", err2))
 	}
 	level.Debug(t.logger).Log("type", "ICMP", "func", "ping", "msg", bytes)
+	return data.Success
 }
 
 // Compute returns the results of the Ping metrics
@@ -114,7 +252,7 @@ func (t *PING) Compute() *ping.PingResult {
 	t.RLock()
 	defer t.RUnlock()
 
-	if t.result == nil {
+	if t.result == nil || t.skipped() {
 		return nil
 	}
 	return t.result
@@ -141,6 +279,13 @@ func (t *PING) Ip() string {
 	return t.ip
 }
 
+// SetBuckets replaces the histogram buckets from the next cycle (reloaded configuration)
+func (t *PING) SetBuckets(buckets []float64) {
+	t.Lock()
+	defer t.Unlock()
+	t.buckets = buckets
+}
+
 // Labels returns labels
 func (t *PING) Labels() map[string]string {
 	t.RLock()
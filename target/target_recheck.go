@@ -0,0 +1,79 @@
+package target
+
+import "time"
+
+// Recheck Adaptive probing of a target: after a lossy cycle it is probed faster with more echoes until the loss recovers
+type Recheck struct {
+	Loss     float64       // Loss ratio of a cycle switching to the re-checks (0 disables)
+	Interval time.Duration // Interval of the re-check cycles
+	Count    int           // Echoes of the re-check cycles
+	Recover  int           // Clean cycles before switching back to the regular interval (flap damping)
+}
+
+// recheckState Current probe mode of an adaptive target
+type recheckState struct {
+	Recheck
+	active bool
+	clean  int
+}
+
+// newRecheckState returns nil when the adaptive probing is disabled
+func newRecheckState(r Recheck) *recheckState {
+	if r.Loss <= 0 {
+		return nil
+	}
+	return &recheckState{Recheck: r}
+}
+
+// count echoes of the next cycle
+func (r *recheckState) count(regular int) int {
+	if r == nil || !r.active {
+		return regular
+	}
+	return r.Count
+}
+
+// spacing inter-packet interval of the next cycle, the echoes of a re-check cycle are spread across the re-check interval
+func (r *recheckState) spacing(regular time.Duration) time.Duration {
+	if r == nil || !r.active {
+		return regular
+	}
+	return r.Interval / time.Duration(r.Count)
+}
+
+// observe accounts the loss of a cycle and reports if the probe mode changed
+// The re-checks start on the first cycle reaching the loss threshold and stop after Recover consecutive cycles below it
+func (r *recheckState) observe(loss float64) bool {
+	if r == nil {
+		return false
+	}
+	if loss >= r.Loss {
+		r.clean = 0
+		if !r.active {
+			r.active = true
+			return true
+		}
+		return false
+	}
+	if r.active {
+		r.clean++
+		if r.clean >= r.Recover {
+			r.active, r.clean = false, 0
+			return true
+		}
+	}
+	return false
+}
+
+// setRecheck switches the schedule of the target between the regular interval and the re-check interval (0)
+// The pending cycle is rescheduled right away so the first re-check doesn't wait for the regular interval
+func (s *probeState) setRecheck(interval time.Duration) {
+	s.mtx.Lock()
+	s.recheck = interval
+	s.stats.Rechecking = interval > 0
+	s.mtx.Unlock()
+	select {
+	case s.resched <- struct{}{}:
+	default:
+	}
+}
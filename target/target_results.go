@@ -0,0 +1,55 @@
+package target
+
+import (
+	"sync"
+	"time"
+)
+
+// Result Outcome of a probe cycle written to the result sinks, the data is the raw result of the probe type (as in the one-shot JSON output)
+type Result struct {
+	Time     time.Time   `json:"time"`
+	Name     string      `json:"name"`
+	Target   string      `json:"target"`
+	Type     string      `json:"type"`
+	Host     string      `json:"host"`
+	Ip       string      `json:"ip,omitempty"`
+	Success  bool        `json:"success"`
+	Duration float64     `json:"duration_seconds"`
+	Error    string      `json:"error,omitempty"`
+	Data     interface{} `json:"data"`
+}
+
+// ResultSink Receiver of the probe results, the writes must not block the probes
+type ResultSink interface {
+	Write(event interface{})
+}
+
+// results Sinks of the probe results (none disables them)
+var results = struct {
+	sync.RWMutex
+	sinks []ResultSink
+}{}
+
+// SetResultSinks sets the sinks receiving the result of every probe cycle of all the targets
+func SetResultSinks(sinks ...ResultSink) {
+	results.Lock()
+	defer results.Unlock()
+	results.sinks = sinks
+}
+
+// record writes the result of the probe cycle started at start to the sinks
+func (s *probeState) record(start time.Time, success bool) {
+	results.RLock()
+	defer results.RUnlock()
+	if len(results.sinks) == 0 || s.compute == nil {
+		return
+	}
+
+	s.mtx.RLock()
+	r := Result{Time: start, Name: s.name, Target: s.key, Type: s.stats.Type, Host: s.stats.Host, Ip: s.stats.Ip, Success: success, Duration: time.Since(start).Seconds(), Error: s.stats.LastError}
+	s.mtx.RUnlock()
+	r.Data = s.compute()
+	for _, sink := range results.sinks {
+		sink.Write(r)
+	}
+}
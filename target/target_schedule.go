@@ -0,0 +1,29 @@
+package target
+
+import (
+	"sync"
+	"time"
+
+	"github.com/syepes/network_exporter/pkg/common"
+)
+
+// schedules Maintenance windows of the targets by type and configured name (TYPE/name)
+var schedules = struct {
+	sync.RWMutex
+	m map[string]*common.Schedule
+}{m: map[string]*common.Schedule{}}
+
+// SetSchedules sets the maintenance windows of all the targets, applied from their next cycle
+func SetSchedules(m map[string]*common.Schedule) {
+	schedules.Lock()
+	defer schedules.Unlock()
+	schedules.m = m
+}
+
+// suppressed reports if the target has a maintenance schedule and if t is within one of its windows
+func (s *probeState) suppressed(t time.Time) (scheduled bool, active bool) {
+	schedules.RLock()
+	defer schedules.RUnlock()
+	sched, found := schedules.m[s.stats.Type+"/"+s.name]
+	return found, sched.Active(t)
+}
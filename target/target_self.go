@@ -0,0 +1,103 @@
+package target
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/icmp"
+)
+
+// selfTimer Sleep of the timer probe, the latency is the oversleep
+const selfTimer = 10 * time.Millisecond
+
+// selfTimeout Timeout of the loopback echo
+const selfTimeout = time.Second
+
+// SelfResult Latencies of the probe box itself (scheduling/CPU pressure)
+type SelfResult struct {
+	Timer     time.Duration `json:"timer"`
+	ICMP      time.Duration `json:"icmp"`
+	ICMPValid bool          `json:"icmp_valid"`
+}
+
+// Self Internal probe of the probe box, not scheduled with the targets (no concurrency slot nor pacing)
+type Self struct {
+	logger   log.Logger
+	icmpID   *common.IcmpID
+	interval time.Duration
+	result   *SelfResult
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	sync.RWMutex
+}
+
+// NewSelf starts the self-probe goroutine
+func NewSelf(logger log.Logger, icmpID *common.IcmpID, interval time.Duration) *Self {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	t := &Self{
+		logger:   logger,
+		icmpID:   icmpID,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+func (t *Self) run() {
+	defer t.wg.Done()
+	tick := time.NewTicker(t.interval)
+	defer tick.Stop()
+	for {
+		t.probe()
+		select {
+		case <-t.stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Stop gracefully stops the self-probe
+func (t *Self) Stop() {
+	close(t.stop)
+	t.wg.Wait()
+}
+
+// probe measures the timer oversleep and the loopback echo RTT
+func (t *Self) probe() {
+	data := &SelfResult{}
+
+	start := time.Now()
+	time.Sleep(selfTimer)
+	data.Timer = time.Since(start) - selfTimer
+
+	ret, err := icmp.Icmp("127.0.0.1", "", 64, int(t.icmpID.Get()), selfTimeout, 0, false, 0, 0, "")
+	if err != nil {
+		level.Debug(t.logger).Log("type", "Self", "func", "probe", "msg", fmt.Sprintf("loopback echo %s", err))
+	} else if ret.Success {
+		data.ICMP = ret.Elapsed
+		data.ICMPValid = true
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	t.result = data
+}
+
+// Compute returns the results of the last self-probe
+func (t *Self) Compute() *SelfResult {
+	if t == nil {
+		return nil
+	}
+	t.RLock()
+	defer t.RUnlock()
+	return t.result
+}
@@ -0,0 +1,56 @@
+package target
+
+import (
+	"sync"
+	"time"
+)
+
+// SavedState Per target counters persisted across the restarts
+type SavedState struct {
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastSuccess         time.Time `json:"last_success"`
+	Up                  bool      `json:"up"`
+}
+
+// restored States loaded at startup by type and target name, consumed by the first start of the target
+var restored = struct {
+	sync.Mutex
+	m map[string]map[string]SavedState
+}{m: map[string]map[string]SavedState{}}
+
+// RestoreStates sets the states applied to the targets started afterwards (the unknown targets are ignored)
+func RestoreStates(states map[string]map[string]SavedState) {
+	restored.Lock()
+	defer restored.Unlock()
+	restored.m = states
+}
+
+// SaveState returns the state to persist from the scheduling details
+func SaveState(st ProbeStats) SavedState {
+	return SavedState{ConsecutiveFailures: st.ConsecutiveFailures, LastSuccess: st.LastSuccess, Up: st.ConsecutiveFailures == 0 && !st.LastSuccess.IsZero()}
+}
+
+// restore applies the saved state of the target, once (a re-created target starts fresh)
+func (s *probeState) restore() {
+	restored.Lock()
+	saved, found := restored.m[s.stats.Type][s.key]
+	if found {
+		delete(restored.m[s.stats.Type], s.key)
+	}
+	restored.Unlock()
+	if !found {
+		return
+	}
+
+	s.mtx.Lock()
+	s.stats.ConsecutiveFailures = saved.ConsecutiveFailures
+	s.stats.LastSuccess = saved.LastSuccess
+	if saved.Up || saved.ConsecutiveFailures > 0 {
+		s.state = "down"
+		if saved.Up {
+			s.state = "up"
+		}
+	}
+	s.mtx.Unlock()
+	s.setUp(saved.Up)
+}
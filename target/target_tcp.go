@@ -3,14 +3,20 @@ package target
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
 	"sync"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/pkg/common"
 	"github.com/syepes/network_exporter/pkg/tcp"
 )
 
+// portConcurrency Upper bound of the parallel connects of a multi-port target (within its concurrency slot)
+const portConcurrency = 8
+
 // TCPPort Object
 type TCPPort struct {
 	logger   log.Logger
@@ -18,96 +24,211 @@ type TCPPort struct {
 	host     string
 	ip       string
 	srcAddr  string
-	port     string
+	srcPorts *tcp.SourcePorts
+	dscp     int
+	mark     int
+	device   string
+	ports    []string
 	interval time.Duration
+	jitter   time.Duration
 	timeout  time.Duration
+	fastOpen bool
+	warmup   int
+	rstWait  time.Duration
+	send     string
+	expect   *regexp.Regexp
+	tlsOpts  *tcp.TLSOptions
+	proxy    *url.URL
+	buckets  []float64
+	sessions map[string]*tcp.Session
 	labels   map[string]string
-	result   *tcp.TCPPortReturn
+	results  []*tcp.TCPPortReturn
 	stop     chan struct{}
 	wg       sync.WaitGroup
+	probeState
 	sync.RWMutex
 }
 
+// TCPOptions Settings of a TCP target
+type TCPOptions struct {
+	Options
+	SrcPorts  *tcp.SourcePorts // Source ports of the connections (nil: ephemeral)
+	DSCP      int              // DSCP of the connections
+	Mark      int              // Firewall mark of the sockets
+	Device    string           // Interface the sockets are bound to
+	Ports     []string         // Destination ports, each connected every cycle
+	FastOpen  bool             // TCP Fast Open connects
+	Warmup    int              // Discarded connects before each measured one
+	ResetWait time.Duration    // Wait for a reset of the server after connecting (0 disables)
+	Send      string           // Request sent once connected
+	Expect    *regexp.Regexp   // Pattern the response must match (nil: not checked)
+	TLS       *tcp.TLSOptions  // TLS handshake once connected (nil disables)
+	Proxy     *url.URL         // SOCKS5/HTTP CONNECT proxy of the connections
+	Reuse     bool             // Persistent connection per port
+	Buckets   []float64        // Connection time histogram buckets
+}
+
 // NewTCPPort starts a new monitoring goroutine
-func NewTCPPort(logger log.Logger, startupDelay time.Duration, name string, host string, ip string, srcAddr string, port string, interval time.Duration, timeout time.Duration, labels map[string]string) (*TCPPort, error) {
+func NewTCPPort(logger log.Logger, sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, startupDelay time.Duration, o TCPOptions) (*TCPPort, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 	t := &TCPPort{
-		logger:   logger,
-		name:     name,
-		host:     host,
-		ip:       ip,
-		srcAddr:  srcAddr,
-		port:     port,
-		interval: interval,
-		timeout:  timeout,
-		labels:   labels,
-		stop:     make(chan struct{}),
+		logger:     logger,
+		name:       o.Name,
+		host:       o.Host,
+		ip:         o.IP,
+		srcAddr:    o.SrcAddr,
+		srcPorts:   o.SrcPorts,
+		dscp:       o.DSCP,
+		mark:       o.Mark,
+		device:     o.Device,
+		ports:      o.Ports,
+		interval:   o.Interval,
+		jitter:     o.Jitter,
+		timeout:    o.Timeout,
+		fastOpen:   o.FastOpen,
+		warmup:     o.Warmup,
+		rstWait:    o.ResetWait,
+		send:       o.Send,
+		expect:     o.Expect,
+		tlsOpts:    o.TLS,
+		proxy:      o.Proxy,
+		buckets:    o.Buckets,
+		labels:     o.Labels,
+		stop:       make(chan struct{}),
+		probeState: newProbeState(sem, pool, shedder, "TCP", o.Name, o.Host, o.IP, o.Priority, o.DependsOn),
 	}
+	// One persistent connection per port
+	if o.Reuse {
+		t.sessions = make(map[string]*tcp.Session, len(o.Ports))
+		for _, port := range o.Ports {
+			t.sessions[port] = &tcp.Session{}
+		}
+	}
+	t.compute = func() interface{} { return t.Compute() }
 	t.wg.Add(1)
 	go t.run(startupDelay)
 	return t, nil
 }
 
 func (t *TCPPort) run(startupDelay time.Duration) {
-	if startupDelay > 0 {
-		select {
-		case <-time.After(startupDelay):
-		case <-t.stop:
-		}
-	}
-
-	waitChan := make(chan struct{}, MaxConcurrentJobs)
-	tick := time.NewTicker(t.interval)
-	for {
-		select {
-		case <-t.stop:
-			tick.Stop()
-			t.wg.Done()
-			return
-		case <-tick.C:
-			waitChan <- struct{}{}
-			go func() {
-				t.portCheck()
-				<-waitChan
-			}()
-		}
-	}
+	t.schedule(startupDelay, t.interval, t.jitter, t.stop, t.portCheck)
+	t.wg.Done()
 }
 
 // Stop gracefully stops the monitoring
 func (t *TCPPort) Stop() {
+	t.cancel()
 	close(t.stop)
 	t.wg.Wait()
+	t.forget()
+	for _, s := range t.sessions {
+		s.Close()
+	}
+}
+
+// Wait blocks until the monitoring goroutine returns (after the single cycle in one-shot mode)
+func (t *TCPPort) Wait() {
+	t.wg.Wait()
 }
 
-func (t *TCPPort) portCheck() {
-	data, err := tcp.Port(t.host, t.ip, t.srcAddr, t.port, t.interval, t.timeout)
-	if err != nil {
+// portCheck probes all the ports of the target, the cycle succeeds when all of them are reachable
+func (t *TCPPort) portCheck() bool {
+	results := make([]*tcp.TCPPortReturn, len(t.ports))
+	errs := make([]error, len(t.ports))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, portConcurrency)
+	for i, port := range t.ports {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, port string) {
+			defer func() { <-slots; wg.Done() }()
+			results[i], errs[i] = t.check(port)
+		}(i, port)
+	}
+	wg.Wait()
+	// Cancelled (target stopped, shutdown)
+	if t.ctx.Err() != nil {
+		return false
+	}
+
+	success := true
+	var cycleErr error
+	for i, data := range results {
+		if cycleErr == nil {
+			if errs[i] == nil && data.Error != "" {
+				cycleErr = fmt.Errorf("%s", data.Error)
+			} else {
+				cycleErr = errs[i]
+			}
+			if cycleErr != nil && len(t.ports) > 1 {
+				cycleErr = fmt.Errorf("port %s: %w", t.ports[i], cycleErr)
+			}
+		}
+		success = success && data.Success
+	}
+	t.setError(cycleErr)
+
+	t.Lock()
+	defer t.Unlock()
+	for i, data := range results {
+		var prev *common.Histogram
+		if i < len(t.results) && t.results[i] != nil {
+			data.Attempts = t.results[i].Attempts
+			data.Successes = t.results[i].Successes
+			prev = t.results[i].ConHistogram
+		}
+		data.Attempts++
+		data.ConHistogram = prev.Next(t.buckets)
+		if data.Success {
+			data.Successes++
+		}
+		// The round trips on the persistent connection keep the time of its last connect
+		if data.Success && !data.Reused {
+			data.ConHistogram.Observe(data.ConTime)
+		}
+	}
+	t.results = results
+	return success
+}
+
+// check probes a single port of the target
+func (t *TCPPort) check(port string) (*tcp.TCPPortReturn, error) {
+	// Warm-up connects (cold caches, expensive first connection) are discarded, only their failures are accounted
+	warmupFailed := 0
+	for i := 0; i < t.warmup; i++ {
+		w, err := tcp.Port(t.ctx, t.host, t.ip, t.srcAddr, t.srcPorts, t.dscp, t.mark, t.device, port, t.interval, t.timeout, t.fastOpen, 0, "", nil, t.tlsOpts, t.proxy, nil)
+		if err != nil || !w.Success {
+			warmupFailed++
+		}
+	}
+
+	data, err := tcp.Port(t.ctx, t.host, t.ip, t.srcAddr, t.srcPorts, t.dscp, t.mark, t.device, port, t.interval, t.timeout, t.fastOpen, t.rstWait, t.send, t.expect, t.tlsOpts, t.proxy, t.sessions[port])
+	if err != nil && t.ctx.Err() == nil {
 		level.Error(t.logger).Log("type", "TCP", "func", "port", "msg", fmt.Sprintf("%s", err))
 	}
+	data.Warmup = t.warmup
+	data.WarmupFailed = warmupFailed
 
 	bytes, err2 := json.Marshal(data)
 	if err2 != nil {
 		level.Error(t.logger).Log("type", "TCP", "func", "port", "msg", fmt.Sprintf("%s", err2))
 	}
 	level.Debug(t.logger).Log("type", "TCP", "func", "port", "msg", bytes)
-
-	t.Lock()
-	defer t.Unlock()
-	t.result = data
+	return data, err
 }
 
-// Compute returns the results of the TCP metrics
-func (t *TCPPort) Compute() *tcp.TCPPortReturn {
+// Compute returns the results of the TCP metrics (one per port)
+func (t *TCPPort) Compute() []*tcp.TCPPortReturn {
 	t.RLock()
 	defer t.RUnlock()
 
-	if t.result == nil {
+	if t.results == nil || t.skipped() {
 		return nil
 	}
-	return t.result
+	return t.results
 }
 
 // Name returns name
@@ -131,6 +252,20 @@ func (t *TCPPort) Ip() string {
 	return t.ip
 }
 
+// Ports returns the probed ports
+func (t *TCPPort) Ports() []string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.ports
+}
+
+// SetBuckets replaces the histogram buckets from the next cycle (reloaded configuration)
+func (t *TCPPort) SetBuckets(buckets []float64) {
+	t.Lock()
+	defer t.Unlock()
+	t.buckets = buckets
+}
+
 // Labels returns labels
 func (t *TCPPort) Labels() map[string]string {
 	t.RLock()
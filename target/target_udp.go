@@ -0,0 +1,153 @@
+package target
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/syepes/network_exporter/pkg/common"
+	"github.com/syepes/network_exporter/pkg/udp"
+)
+
+// UDP Object
+type UDP struct {
+	logger   log.Logger
+	name     string
+	host     string
+	ip       string
+	srcAddr  string
+	port     string
+	payload  []byte
+	expect   *regexp.Regexp
+	interval time.Duration
+	jitter   time.Duration
+	timeout  time.Duration
+	labels   map[string]string
+	result   *udp.UDPReturn
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	probeState
+	sync.RWMutex
+}
+
+// UDPOptions Settings of a UDP target
+type UDPOptions struct {
+	Options
+	Port    string         // Destination port
+	Payload []byte         // Datagram sent by the probes
+	Expect  *regexp.Regexp // Pattern the response must match (nil: not checked)
+}
+
+// NewUDP starts a new monitoring goroutine
+func NewUDP(logger log.Logger, sem *common.Semaphore, pool *common.Semaphore, shedder *common.LoadShedder, startupDelay time.Duration, o UDPOptions) (*UDP, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	t := &UDP{
+		logger:     logger,
+		name:       o.Name,
+		host:       o.Host,
+		ip:         o.IP,
+		srcAddr:    o.SrcAddr,
+		port:       o.Port,
+		payload:    o.Payload,
+		expect:     o.Expect,
+		interval:   o.Interval,
+		jitter:     o.Jitter,
+		timeout:    o.Timeout,
+		labels:     o.Labels,
+		stop:       make(chan struct{}),
+		probeState: newProbeState(sem, pool, shedder, "UDP", o.Name, o.Host, o.IP, o.Priority, o.DependsOn),
+	}
+	t.compute = func() interface{} { return t.Compute() }
+	t.wg.Add(1)
+	go t.run(startupDelay)
+	return t, nil
+}
+
+func (t *UDP) run(startupDelay time.Duration) {
+	t.schedule(startupDelay, t.interval, t.jitter, t.stop, t.probe)
+	t.wg.Done()
+}
+
+// Stop gracefully stops the monitoring
+func (t *UDP) Stop() {
+	t.cancel()
+	close(t.stop)
+	t.wg.Wait()
+	t.forget()
+}
+
+// Wait blocks until the monitoring goroutine returns (after the single cycle in one-shot mode)
+func (t *UDP) Wait() {
+	t.wg.Wait()
+}
+
+func (t *UDP) probe() bool {
+	data, err := udp.Check(t.host, t.ip, t.srcAddr, t.port, t.payload, t.expect, t.timeout)
+	t.setError(err)
+	if err != nil {
+		level.Debug(t.logger).Log("type", "UDP", "func", "probe", "msg", fmt.Sprintf("%s", err))
+	}
+
+	bytes, err2 := json.Marshal(data)
+	if err2 != nil {
+		level.Error(t.logger).Log("type", "UDP", "func", "probe", "msg", fmt.Sprintf("%s", err2))
+	}
+	level.Debug(t.logger).Log("type", "UDP", "func", "probe", "msg", bytes)
+
+	t.Lock()
+	defer t.Unlock()
+	t.result = data
+	return data.Success
+}
+
+// Compute returns the results of the UDP metrics
+func (t *UDP) Compute() *udp.UDPReturn {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.result == nil || t.skipped() {
+		return nil
+	}
+	return t.result
+}
+
+// Name returns name
+func (t *UDP) Name() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.name
+}
+
+// Host returns host
+func (t *UDP) Host() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.host
+}
+
+// Ip returns ip
+func (t *UDP) Ip() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.ip
+}
+
+// Port returns the port
+func (t *UDP) Port() string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.port
+}
+
+// Labels returns labels
+func (t *UDP) Labels() map[string]string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.labels
+}
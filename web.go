@@ -0,0 +1,507 @@
+package main
+
+import (
+	"compress/flate"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/syepes/network_exporter/config"
+	"github.com/syepes/network_exporter/target"
+)
+
+// deflateWriter http.ResponseWriter compressing the body with deflate
+type deflateWriter struct {
+	http.ResponseWriter
+	fw *flate.Writer
+}
+
+func (w *deflateWriter) Write(b []byte) (int, error) {
+	return w.fw.Write(b)
+}
+
+// compressHandler adds deflate content encoding when the client does not accept gzip (gzip is negotiated by promhttp)
+func compressHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if acceptsEncoding(r, "gzip") || !acceptsEncoding(r, "deflate") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fw, err := flate.NewWriter(w, flate.DefaultCompression)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer fw.Close()
+
+		w.Header().Set("Content-Encoding", "deflate")
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Del("Content-Length")
+		next.ServeHTTP(&deflateWriter{ResponseWriter: w, fw: fw}, r)
+	})
+}
+
+// acceptsEncoding checks the Accept-Encoding header for the given encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, h := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(h, ",") {
+			part = strings.TrimSpace(part)
+			if part == encoding || strings.HasPrefix(part, encoding+";") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// targetHandler serves the metrics of a single target (/metrics/<target name>) from the shared registry
+func targetHandler(prefix string, g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		if name == "" || strings.Contains(name, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		mfs, err := g.Gather()
+		if err != nil && len(mfs) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The known targets without series yet (first probe pending, suppressed or shed) are served empty
+		if !knownTarget(name) {
+			http.NotFound(w, r)
+			return
+		}
+		filtered := filterFamilies(mfs, "name", name)
+
+		gf := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return filtered, nil })
+		compressHandler(promhttp.HandlerFor(gf, promhttp.HandlerOpts{})).ServeHTTP(w, r)
+	})
+}
+
+// knownTarget the name is one of the targets of the current configuration
+func knownTarget(name string) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	for _, t := range sc.Cfg.Targets {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// filterFamilies keeps only the metrics having the label with the given value
+func filterFamilies(mfs []*dto.MetricFamily, label string, value string) []*dto.MetricFamily {
+	filtered := []*dto.MetricFamily{}
+	for _, mf := range mfs {
+		metrics := []*dto.Metric{}
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == label && lp.GetValue() == value {
+					metrics = append(metrics, m)
+					break
+				}
+			}
+		}
+		if len(metrics) > 0 {
+			filtered = append(filtered, &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Metric: metrics})
+		}
+	}
+	return filtered
+}
+
+// relabelGatherer applies the metric_relabel rules of the current config to the gathered metrics
+// The series made identical by the rules are merged (the first one is kept)
+func relabelGatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+
+		sc.RLock()
+		rules := sc.Cfg.MetricRelabel
+		sc.RUnlock()
+		if len(rules) == 0 {
+			return mfs, err
+		}
+
+		relabeled := []*dto.MetricFamily{}
+		for _, mf := range mfs {
+			metrics := []*dto.Metric{}
+			seen := map[string]bool{}
+			for _, m := range mf.GetMetric() {
+				labels := map[string]string{"__name__": mf.GetName()}
+				for _, lp := range m.GetLabel() {
+					labels[lp.GetName()] = lp.GetValue()
+				}
+				if !config.Relabel(rules, labels) {
+					continue
+				}
+
+				names := make([]string, 0, len(labels))
+				for k := range labels {
+					names = append(names, k)
+				}
+				sort.Strings(names)
+				pairs := make([]*dto.LabelPair, 0, len(names))
+				var key strings.Builder
+				for _, name := range names {
+					k, v := name, labels[name]
+					pairs = append(pairs, &dto.LabelPair{Name: &k, Value: &v})
+					key.WriteString(k + "\xff" + v + "\xff")
+				}
+				if seen[key.String()] {
+					continue
+				}
+				seen[key.String()] = true
+				m.Label = pairs
+				metrics = append(metrics, m)
+			}
+			if len(metrics) > 0 {
+				relabeled = append(relabeled, &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Metric: metrics})
+			}
+		}
+		return relabeled, err
+	})
+}
+
+// tokenAuthHandler requires the bearer token of the token file on the on-demand probe (/probe) and reload (/-/reload) endpoints (/metrics is not affected)
+// The file is read on each request so the token can be rotated without a restart, without file the endpoint is open
+func tokenAuthHandler(tokenFile string, next http.Handler) http.Handler {
+	if tokenFile == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := readToken(tokenFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "Reading token", "err", err)
+			http.Error(w, "token unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="network_exporter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readToken reads the (whitespace trimmed) token of the token file
+func readToken(tokenFile string) (string, error) {
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("empty token file: %s", tokenFile)
+	}
+	return token, nil
+}
+
+// targetRow probe state of a target on the /targets page and API
+type targetRow struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Host        string     `json:"host"`
+	Ip          string     `json:"ip"`
+	State       string     `json:"state"`
+	Rtt         *float64   `json:"rtt_seconds,omitempty"`
+	Loss        *float64   `json:"loss_ratio,omitempty"`
+	LastProbe   *time.Time `json:"last_probe,omitempty"`
+	NextProbe   *time.Time `json:"next_probe,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Failures    int        `json:"consecutive_failures"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// RttText returns the RTT of the last result for the page
+func (r targetRow) RttText() string {
+	if r.Rtt == nil {
+		return ""
+	}
+	return time.Duration(*r.Rtt * float64(time.Second)).String()
+}
+
+// LossText returns the loss of the last result for the page
+func (r targetRow) LossText() string {
+	if r.Loss == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*r.Loss*100, 'f', 1, 64) + "%"
+}
+
+// timeText formats the optional times of the page
+func timeText(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// optionalTime returns nil for the zero time (never probed)
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+var targetsTemplate = template.Must(template.New("targets").Funcs(template.FuncMap{"time": timeText}).Parse(`<!doctype html><html><head><meta charset="UTF-8"><meta http-equiv="refresh" content="{{.Refresh}}"><title>Network Exporter Targets</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:2px 6px;text-align:left}.up{background:#d4f7d4}.down{background:#f7d4d4}.skipped,.suppressed,.pending{background:#eee}</style></head>
+<body><h1>Targets ({{len .Rows}})</h1><p>Refreshed every {{.Refresh}}s, generated {{.Now}} (<a href="?format=json">json</a>)</p>
+<table><tr><th>Name</th><th>Type</th><th>Host</th><th>IP</th><th>State</th><th>RTT</th><th>Loss</th><th>Last probe</th><th>Last success</th><th>Consecutive failures</th><th>Last error</th></tr>
+{{range .Rows}}<tr class="{{.State}}"><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Host}}</td><td>{{.Ip}}</td><td>{{.State}}</td><td>{{.RttText}}</td><td>{{.LossText}}</td><td>{{time .LastProbe}}</td><td>{{time .LastSuccess}}</td><td>{{.Failures}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table></body></html>`))
+
+// targetsHandler renders the probe state of all the targets (same in-memory state as the collectors), ?refresh=<seconds> sets the auto-refresh
+// The state is served as JSON with ?format=json or an Accept: application/json header, the targets filtered out of the config are not listed
+func targetsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refresh := 10
+		if v, err := strconv.Atoi(r.URL.Query().Get("refresh")); err == nil && v > 0 {
+			refresh = v
+		}
+
+		rows := []targetRow{}
+		add := func(stats map[string]target.ProbeStats, rtt func(key string) (time.Duration, float64, bool)) {
+			for key, st := range stats {
+				row := targetRow{Name: strings.SplitN(key, " ", 2)[0], Type: st.Type, Host: st.Host, Ip: st.Ip, Failures: st.ConsecutiveFailures, LastError: st.LastError}
+				switch {
+				case st.FamilyUnavailable:
+					row.State = "unavailable"
+				case st.Suppressed:
+					row.State = "suppressed"
+				case st.DependencySkipped:
+					row.State = "skipped"
+				case st.ConsecutiveFailures > 0:
+					row.State = "down"
+				case st.LastSuccess.IsZero():
+					row.State = "pending"
+				default:
+					row.State = "up"
+				}
+				row.LastProbe = optionalTime(st.LastProbe)
+				row.NextProbe = optionalTime(st.NextProbe)
+				row.LastSuccess = optionalTime(st.LastSuccess)
+				if d, loss, ok := rtt(key); ok {
+					secs := d.Seconds()
+					row.Rtt = &secs
+					if loss >= 0 {
+						row.Loss = &loss
+					}
+				}
+				rows = append(rows, row)
+			}
+		}
+
+		pings := monitorPING.ExportMetrics()
+		add(monitorPING.ExportStats(), func(key string) (time.Duration, float64, bool) {
+			if m, found := pings[key]; found {
+				return m.AvgTime, m.DropRate, true
+			}
+			return 0, 0, false
+		})
+		mtrs := monitorMTR.ExportMetrics()
+		add(monitorMTR.ExportStats(), func(key string) (time.Duration, float64, bool) {
+			if m, found := mtrs[key]; found && len(m.Hops) > 0 {
+				last := m.Hops[len(m.Hops)-1]
+				return last.AvgTime, last.Loss, true
+			}
+			return 0, 0, false
+		})
+		tcps := monitorTCP.ExportMetrics()
+		add(monitorTCP.ExportStats(), func(key string) (time.Duration, float64, bool) {
+			if m, found := tcps[key]; found {
+				return m.ConTime, -1, true
+			}
+			return 0, 0, false
+		})
+		udps := monitorUDP.ExportMetrics()
+		add(monitorUDP.ExportStats(), func(key string) (time.Duration, float64, bool) {
+			if m, found := udps[key]; found {
+				return m.Rtt, -1, true
+			}
+			return 0, 0, false
+		})
+		https := monitorHTTPGet.ExportMetrics()
+		add(monitorHTTPGet.ExportStats(), func(key string) (time.Duration, float64, bool) {
+			if m, found := https[key]; found {
+				return m.Total, -1, true
+			}
+			return 0, 0, false
+		})
+		arps := monitorARP.ExportMetrics()
+		add(monitorARP.ExportStats(), func(key string) (time.Duration, float64, bool) {
+			if m, found := arps[key]; found {
+				return m.Rtt, -1, true
+			}
+			return 0, 0, false
+		})
+		owds := monitorOWD.ExportMetrics()
+		add(monitorOWD.ExportStats(), func(key string) (time.Duration, float64, bool) {
+			if m, found := owds[key]; found {
+				return m.RttAvg, m.DropRate, true
+			}
+			return 0, 0, false
+		})
+		dnss := monitorDNS.ExportMetrics()
+		add(monitorDNS.ExportStats(), func(key string) (time.Duration, float64, bool) {
+			if m, found := dnss[key]; found {
+				return m.Rtt, -1, true
+			}
+			return 0, 0, false
+		})
+
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].Name != rows[j].Name {
+				return rows[i].Name < rows[j].Name
+			}
+			if rows[i].Type != rows[j].Type {
+				return rows[i].Type < rows[j].Type
+			}
+			return rows[i].Ip < rows[j].Ip
+		})
+
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			if err := json.NewEncoder(w).Encode(rows); err != nil {
+				level.Error(logger).Log("msg", "Encoding targets", "err", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := targetsTemplate.Execute(w, struct {
+			Refresh int
+			Now     string
+			Rows    []targetRow
+		}{refresh, time.Now().Format(time.RFC3339), rows}); err != nil {
+			level.Error(logger).Log("msg", "Rendering targets page", "err", err)
+		}
+	})
+}
+
+// reloadHandler reloads the configuration (POST /-/reload) like the SIGHUP, the removed targets are stopped and the added ones started
+func reloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		level.Info(logger).Log("msg", "Reload requested with POST /-/reload, ReLoading config")
+		if err := reloadConfig(); err != nil {
+			level.Error(logger).Log("msg", "Reloading config skipped", "err", err)
+			http.Error(w, fmt.Sprintf("config not reloaded: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// maxConfigSize Upper bound of a configuration uploaded with PUT /config
+const maxConfigSize = 4 << 20
+
+// configHandler serves the configuration file (GET) and replaces it (PUT)
+// The proposed content goes through the full config validation before being written, the previous file is kept as .bak
+func configHandler(confFile string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			b, err := os.ReadFile(confFile)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/yaml")
+			_, _ = w.Write(b)
+		case http.MethodPut:
+			b, err := io.ReadAll(io.LimitReader(r.Body, maxConfigSize+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(b) > maxConfigSize {
+				http.Error(w, "config too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			// Validated, written and reloaded as one step: a concurrent reload or discovery refresh can't apply another file in between
+			reloadMtx.Lock()
+			defer reloadMtx.Unlock()
+			if err := sc.ValidateConfig(logger, confFile, b); err != nil {
+				http.Error(w, fmt.Sprintf("invalid config: %s", err), http.StatusBadRequest)
+				return
+			}
+			if err := writeConfigFile(confFile, b); err != nil {
+				level.Error(logger).Log("msg", "Writing config", "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			level.Info(logger).Log("msg", "Config replaced with PUT /config, ReLoading config")
+			if err := loadConfig(); err != nil {
+				level.Error(logger).Log("msg", "Reloading config skipped", "err", err)
+				http.Error(w, fmt.Sprintf("config written but not reloaded: %s", err), http.StatusInternalServerError)
+				return
+			}
+			applyTargets()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// writeConfigFile backs up the current file as .bak and atomically replaces it (the content is written as-is, comments included)
+func writeConfigFile(confFile string, b []byte) error {
+	mode := os.FileMode(0644)
+	if old, err := os.ReadFile(confFile); err == nil {
+		if fi, err := os.Stat(confFile); err == nil {
+			mode = fi.Mode().Perm()
+		}
+		if err := os.WriteFile(confFile+".bak", old, mode); err != nil {
+			return fmt.Errorf("writing backup: %s", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(confFile), filepath.Base(confFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), confFile)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/syepes/network_exporter/config"
+)
+
+func TestCompressHandlerEncodings(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "compress_test_gauge", Help: "Test gauge"})
+	g.Set(1)
+	reg.MustRegister(g)
+	srv := httptest.NewServer(compressHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+	defer srv.Close()
+
+	// The transport must not negotiate (and transparently decode) gzip itself
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	for _, tc := range []struct {
+		accept   string
+		encoding string
+		decode   func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate, gzip;q=0.5", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate", "deflate", func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil }},
+		{"", "", func(r io.Reader) (io.Reader, error) { return r, nil }},
+	} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.accept != "" {
+			req.Header.Set("Accept-Encoding", tc.accept)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.accept, err)
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != tc.encoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", tc.accept, got, tc.encoding)
+			resp.Body.Close()
+			continue
+		}
+		r, err := tc.decode(resp.Body)
+		if err != nil {
+			t.Fatalf("%q: decode: %v", tc.accept, err)
+		}
+		body, err := io.ReadAll(r)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%q: read: %v", tc.accept, err)
+		}
+		if !strings.Contains(string(body), "compress_test_gauge 1") {
+			t.Errorf("Accept-Encoding %q: metric missing from the decoded body: %q", tc.accept, body)
+		}
+	}
+}
+
+func TestTargetHandler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "target_test_gauge", Help: "Test gauge"}, []string{"name"})
+	g.WithLabelValues("probed").Set(1)
+	g.WithLabelValues("removed").Set(1)
+	reg.MustRegister(g)
+
+	prev := sc.Cfg
+	defer func() { sc.Cfg = prev }()
+	sc.Cfg = &config.Config{Targets: config.Targets{{Name: "probed"}, {Name: "pending"}}}
+
+	srv := httptest.NewServer(targetHandler("/metrics/", reg))
+	defer srv.Close()
+	for _, tc := range []struct {
+		path   string
+		status int
+		series bool
+	}{
+		{"/metrics/probed", http.StatusOK, true},
+		// Configured but not probed yet
+		{"/metrics/pending", http.StatusOK, false},
+		// Series left but not in the configuration
+		{"/metrics/removed", http.StatusNotFound, false},
+		{"/metrics/unknown", http.StatusNotFound, false},
+		{"/metrics/probed/x", http.StatusNotFound, false},
+	} {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: status = %d, want %d", tc.path, resp.StatusCode, tc.status)
+		}
+		if got := strings.Contains(string(body), "target_test_gauge"); got != tc.series {
+			t.Errorf("%s: series served = %t, want %t: %q", tc.path, got, tc.series, body)
+		}
+	}
+}
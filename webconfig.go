@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// webConfig web configuration file (same format as the Prometheus web-config)
+type webConfig struct {
+	TLSServerConfig *tlsServerConfig  `yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]string `yaml:"basic_auth_users"`
+}
+
+// tlsServerConfig TLS settings of the HTTP server, the certificate and key files are read again when they change (rotation)
+type tlsServerConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientAuth   string `yaml:"client_auth_type"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	MinVersion   string `yaml:"min_version"`
+}
+
+var (
+	tlsClientAuth = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+	tlsVersions = map[string]uint16{
+		"":      tls.VersionTLS12,
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+)
+
+// loadWebConfig reads and validates the web configuration file
+func loadWebConfig(file string) (*webConfig, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &webConfig{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", file, err)
+	}
+	if _, err := c.tlsConfig(); err != nil {
+		return nil, fmt.Errorf("tls_server_config: %s", err)
+	}
+	for user, hash := range c.BasicAuthUsers {
+		if user == "" {
+			return nil, fmt.Errorf("basic_auth_users: empty username")
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("basic_auth_users: user %s: password must be a bcrypt hash: %s", user, err)
+		}
+	}
+	return c, nil
+}
+
+// tlsConfig returns the TLS configuration of the HTTP server (nil without tls_server_config)
+// The client certificates are verified against client_ca_file with the Verify* client_auth_type
+func (c *webConfig) tlsConfig() (*tls.Config, error) {
+	if c == nil || c.TLSServerConfig == nil {
+		return nil, nil
+	}
+	t := c.TLSServerConfig
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, fmt.Errorf("cert_file and key_file are required")
+	}
+	clientAuth, found := tlsClientAuth[t.ClientAuth]
+	if !found {
+		return nil, fmt.Errorf("client_auth_type %s must be one of (NoClientCert|RequestClientCert|RequireAnyClientCert|VerifyClientCertIfGiven|RequireAndVerifyClientCert)", t.ClientAuth)
+	}
+	minVersion, found := tlsVersions[t.MinVersion]
+	if !found {
+		return nil, fmt.Errorf("min_version %s must be one of (TLS10|TLS11|TLS12|TLS13)", t.MinVersion)
+	}
+
+	certs := &certReloader{certFile: t.CertFile, keyFile: t.KeyFile}
+	if _, err := certs.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{GetCertificate: certs.GetCertificate, ClientAuth: clientAuth, MinVersion: minVersion}
+
+	if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		if t.ClientCAFile == "" {
+			return nil, fmt.Errorf("client_auth_type %s requires client_ca_file", t.ClientAuth)
+		}
+	}
+	if t.ClientCAFile != "" {
+		b, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("client_ca_file %s: no PEM certificate found", t.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, nil
+}
+
+// certReloader serves the certificate of the files, loaded again when their modification time changes
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mtx      sync.Mutex
+	cert     *tls.Certificate
+	modTime  [2]time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate, a failed reload keeps serving the previous certificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	var modTime [2]time.Time
+	for i, f := range []string{r.certFile, r.keyFile} {
+		st, err := os.Stat(f)
+		if err != nil {
+			if r.cert != nil {
+				return r.cert, nil
+			}
+			return nil, err
+		}
+		modTime[i] = st.ModTime()
+	}
+	if r.cert != nil && modTime == r.modTime {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
+
+// basicAuthHandler requires one of the basic_auth_users credentials on all the endpoints, without users the endpoints are open
+// The successful checks are cached (bcrypt is purposely slow), unknown users are compared against a dummy hash to not leak their existence
+func basicAuthHandler(c *webConfig, next http.Handler) http.Handler {
+	if c == nil || len(c.BasicAuthUsers) == 0 {
+		return next
+	}
+
+	dummy, _ := bcrypt.GenerateFromPassword([]byte("network_exporter"), bcrypt.DefaultCost)
+	var mtx sync.Mutex
+	cache := map[[sha256.Size]byte]bool{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if ok {
+			key := sha256.Sum256([]byte(user + "\x00" + pass))
+			mtx.Lock()
+			valid := cache[key]
+			mtx.Unlock()
+
+			if !valid {
+				hash, found := c.BasicAuthUsers[user]
+				if !found {
+					hash = string(dummy)
+				}
+				valid = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil && found
+				if valid {
+					mtx.Lock()
+					cache[key] = true
+					mtx.Unlock()
+				}
+			}
+			if valid {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="network_exporter"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}